@@ -10,4 +10,5 @@ require (
 
 require (
 	golang.org/x/text v0.3.3 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
 )
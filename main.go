@@ -19,8 +19,10 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
 	"os/exec"
 
+	"buzzer/pkg/ebpf/ebpf"
 	"buzzer/pkg/strategies/strategies"
 	"buzzer/pkg/units/units"
 )
@@ -34,12 +36,26 @@ var (
 	sourceFilesPath    = flag.String("src_path", "/root/sourceFiles", "The fuzzer will look for source files to visualize the coverage at this path")
 	metricsServerAddr  = flag.String("metrics_server_addr", "0.0.0.0", "Address that the metrics server will listen to at")
 	metricsServerPort  = flag.Uint("metrics_server_port", 8080, "Port that the metrics server will listen to at")
+	interestingImmPct  = flag.Uint64("interesting_imm_percent", 50, "Percentage of random immediates that are picked from a pool of boundary values instead of uniformly")
+	invalidShiftPct    = flag.Uint64("invalid_shift_percent", 0, "Percentage of random shifts by an immediate that use an out of range amount, which the verifier rejects")
+	numberedPocs       = flag.Bool("numbered_pocs", false, "Prefix every instruction of the generated pocs with its index, as printed in verifier logs")
+	selfComparePct     = flag.Uint64("self_compare_percent", 0, "Percentage of random jumps between two registers that compare a register with itself, which always go the same way")
+	recordTimings      = flag.Bool("record_timings", false, "Measure how long generating, encoding and verifying each program takes, exposed by the control unit as LastGenerationTimings")
+	recordDecisions    = flag.Bool("record_decisions", false, "Record the random decisions made while generating each program and print them with the poc of programs that produce unexpected results")
+	labeledCorpusPath  = flag.String("labeled_corpus_path", "", "If set, append every generated eBPF program and the verdict of the verifier to this file, readable with units.LoadLabeledProgram")
+	recordOrigins      = flag.Bool("record_origins", false, "Remember where every instruction was built and print it next to the instruction in the generated pocs, to debug the generators")
+	prefer32Bit        = flag.Bool("prefer_32bit", false, "Make random ALU and jump instructions use the 32-bit classes most of the time to exercise subregister zero extension")
 )
 
 var (
 	strats = []units.Strategy{
 		strategies.NewLoopPointerArithmeticStrategy(),
 		strategies.NewPointerArithmeticStrategy(),
+		strategies.NewPointerCompareStrategy(),
+		strategies.NewSubregisterStrategy(),
+		strategies.NewConvergentBranchStrategy(),
+		strategies.NewMapBoundsStrategy(),
+		strategies.NewSpillReloadStrategy(),
 		strategies.NewPlaygroundStrategy(),
 		strategies.NewCoverageBasedStrategy(),
 		strategies.NewCbpfPlaygroundStrategy(),
@@ -49,6 +65,12 @@ var (
 
 func main() {
 	flag.Parse()
+	ebpf.InterestingImmediatePercent = *interestingImmPct
+	ebpf.NumberedPocs = *numberedPocs
+	ebpf.InvalidShiftPercent = *invalidShiftPct
+	ebpf.Prefer32Bit = *prefer32Bit
+	ebpf.SelfComparePercent = *selfComparePct
+	ebpf.RecordOrigins = *recordOrigins
 	var strategy units.Strategy = nil
 	for _, s := range strats {
 		if s.Name() == *strategyName {
@@ -76,7 +98,15 @@ func main() {
 		return string(outBytes), err
 	})
 
-	controlUnit := units.Control{}
+	controlUnit := units.Control{RecordTimings: *recordTimings, RecordDecisions: *recordDecisions}
+	if *labeledCorpusPath != "" {
+		corpus, err := os.OpenFile(*labeledCorpusPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatalf("failed to open labeled corpus: %v", err)
+		}
+		defer corpus.Close()
+		controlUnit.LabeledCorpus = corpus
+	}
 	metricsUnit := units.NewMetricsUnit(*metricsThreshold, *coverageBufferSize, *vmLinuxPath, *sourceFilesPath, *metricsServerAddr, uint16(*metricsServerPort), coverageManager)
 
 	if err := controlUnit.Init(&units.FFI{
@@ -16,6 +16,7 @@ package ebpf
 
 import (
 	pb "buzzer/proto/ebpf_go_proto"
+	"fmt"
 )
 
 func newAluInstruction[T Src](oc pb.AluOperationCode, insclass pb.InsClass, dst pb.Reg, src T) *pb.Instruction {
@@ -41,7 +42,7 @@ func newAluInstruction[T Src](oc pb.AluOperationCode, insclass pb.InsClass, dst
 	case int64:
 		if oc == pb.AluOperationCode_AluMov {
 			upper := int32(src >> 32)
-			return &pb.Instruction{
+			return withOrigin(&pb.Instruction{
 				Opcode: &pb.Instruction_MemOpcode{
 					MemOpcode: &pb.MemOpcode{
 						Mode:             pb.StLdMode_StLdModeIMM,
@@ -71,7 +72,7 @@ func newAluInstruction[T Src](oc pb.AluOperationCode, insclass pb.InsClass, dst
 						},
 					},
 				},
-			}
+			})
 		} else {
 			srcType = pb.SrcOperand_Immediate
 			srcReg = pb.Reg_R0
@@ -83,7 +84,7 @@ func newAluInstruction[T Src](oc pb.AluOperationCode, insclass pb.InsClass, dst
 		imm = any(src).(int32)
 	}
 
-	return &pb.Instruction{
+	return withOrigin(&pb.Instruction{
 		Opcode: &pb.Instruction_AluOpcode{
 			AluOpcode: &pb.AluOpcode{
 				OperationCode:    oc,
@@ -98,7 +99,7 @@ func newAluInstruction[T Src](oc pb.AluOperationCode, insclass pb.InsClass, dst
 		PseudoInstruction: &pb.Instruction_Empty{
 			Empty: &pb.Empty{},
 		},
-	}
+	})
 }
 
 // Add64 Creates a new 64 bit Add instruction that is either imm or reg depending
@@ -274,3 +275,30 @@ func End64[T Src](dstReg pb.Reg, src T) *pb.Instruction {
 func End[T Src](dstReg pb.Reg, src T) *pb.Instruction {
 	return newAluInstruction(pb.AluOperationCode_AluEnd, pb.InsClass_InsClassAlu, dstReg, src)
 }
+
+// Add128 adds the 128-bit value held in the register pair srcHi:srcLo to the
+// one in dstHi:dstLo, propagating the carry of the low halves with a branch:
+//
+//	dstLo += srcLo
+//	if dstLo >= srcLo goto +1
+//	dstHi += 1
+//	dstHi += srcHi
+//
+// The destination registers can't be any of the source ones, as they are
+// modified while the sources are still being read.
+func Add128(dstHi, dstLo, srcHi, srcLo pb.Reg) ([]*pb.Instruction, error) {
+	if dstHi == dstLo {
+		return nil, fmt.Errorf("Add128 destination halves must be different registers, got %v for both", dstHi)
+	}
+	for _, dst := range []pb.Reg{dstHi, dstLo} {
+		if dst == srcHi || dst == srcLo {
+			return nil, fmt.Errorf("Add128 destination %v is also a source", dst)
+		}
+	}
+	return InstructionSequence(
+		Add64(dstLo, srcLo),
+		JmpGE(dstLo, srcLo, 1),
+		Add64(dstHi, 1),
+		Add64(dstHi, srcHi),
+	)
+}
@@ -855,3 +855,29 @@ func TestMov64(t *testing.T) {
 		}
 	})
 }
+
+func TestAdd128(t *testing.T) {
+	got, err := Add128(R1, R2, R3, R4)
+	if err != nil {
+		t.Fatalf("Add128() unexpected error: %v", err)
+	}
+	want := []*pb.Instruction{
+		Add64(R2, R4),
+		JmpGE(R2, R4, 1),
+		Add64(R1, 1),
+		Add64(R1, R3),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Add128() = %v, want %v", got, want)
+	}
+
+	for _, regs := range [][4]pb.Reg{
+		{R1, R1, R3, R4},
+		{R1, R2, R1, R4},
+		{R1, R2, R3, R2},
+	} {
+		if _, err := Add128(regs[0], regs[1], regs[2], regs[3]); err == nil {
+			t.Errorf("Add128(%v) with overlapping registers expected error, got nil", regs)
+		}
+	}
+}
@@ -0,0 +1,924 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+// instructionSlots returns the number of 64-bit words `i` takes once
+// encoded. Wide instructions (e.g. 64-bit immediate loads) carry a pseudo
+// instruction and take two slots, everything else takes one.
+func instructionSlots(i *pb.Instruction) int {
+	if _, ok := i.PseudoInstruction.(*pb.Instruction_PseudoValue); ok {
+		return 2
+	}
+	return 1
+}
+
+// slotIndexes returns the slot at which each instruction starts once
+// the sequence is encoded. Jump offsets and verifier log instruction numbers
+// refer to slots, not to indexes in the instruction array.
+func slotIndexes(instructions []*pb.Instruction) []int {
+	slots := make([]int, len(instructions))
+	current := 0
+	for index, inst := range instructions {
+		slots[index] = current
+		current += instructionSlots(inst)
+	}
+	return slots
+}
+
+// isJump returns true if `i` is a conditional jump or a JA.
+func isJump(i *pb.Instruction) bool {
+	jmp, ok := i.Opcode.(*pb.Instruction_JmpOpcode)
+	if !ok {
+		return false
+	}
+	op := jmp.JmpOpcode.OperationCode
+	return op != pb.JmpOperationCode_JmpCALL && op != pb.JmpOperationCode_JmpExit
+}
+
+// isExit returns true if `i` is an exit instruction.
+func isExit(i *pb.Instruction) bool {
+	jmp, ok := i.Opcode.(*pb.Instruction_JmpOpcode)
+	return ok && jmp.JmpOpcode.OperationCode == pb.JmpOperationCode_JmpExit
+}
+
+// isCall returns true if `i` is a call instruction.
+func isCall(i *pb.Instruction) bool {
+	jmp, ok := i.Opcode.(*pb.Instruction_JmpOpcode)
+	return ok && jmp.JmpOpcode.OperationCode == pb.JmpOperationCode_JmpCALL
+}
+
+// jumpTargets returns, for every instruction, the index in `instructions`
+// that it jumps to. Instructions that are not jumps, or whose target does not
+// land at the start of an instruction within the sequence, get -1.
+func jumpTargets(instructions []*pb.Instruction) []int {
+	slots := slotIndexes(instructions)
+	indexForSlot := make(map[int]int, len(slots))
+	for index, slot := range slots {
+		indexForSlot[slot] = index
+	}
+
+	targets := make([]int, len(instructions))
+	for index, inst := range instructions {
+		targets[index] = -1
+		if !isJump(inst) {
+			continue
+		}
+		targetSlot := slots[index] + instructionSlots(inst) + int(inst.Offset)
+		if target, ok := indexForSlot[targetSlot]; ok {
+			targets[index] = target
+		}
+	}
+	return targets
+}
+
+// blockStart returns the index of the first instruction of the basic block
+// that contains the instruction at `index`.
+func blockStart(instructions []*pb.Instruction, index int) int {
+	targets := jumpTargets(instructions)
+	isTarget := make(map[int]bool)
+	for _, target := range targets {
+		if target >= 0 {
+			isTarget[target] = true
+		}
+	}
+
+	start := index
+	for start > 0 {
+		if isTarget[start] {
+			break
+		}
+		prev := instructions[start-1]
+		if isJump(prev) || isExit(prev) {
+			break
+		}
+		start--
+	}
+	return start
+}
+
+// evalAlu folds the ALU operation `op` over the known values `dst` and
+// `src`. The second return value is false if the operation cannot be
+// folded.
+func evalAlu(op pb.AluOperationCode, is64 bool, dst, src int64) (int64, bool) {
+	shiftMask := int64(31)
+	if is64 {
+		shiftMask = 63
+	}
+	if !is64 {
+		dst = int64(uint32(dst))
+		src = int64(uint32(src))
+	}
+
+	var result int64
+	switch op {
+	case pb.AluOperationCode_AluAdd:
+		result = dst + src
+	case pb.AluOperationCode_AluSub:
+		result = dst - src
+	case pb.AluOperationCode_AluMul:
+		result = dst * src
+	case pb.AluOperationCode_AluDiv:
+		// Division by zero sets the destination to zero in ebpf.
+		if src == 0 {
+			result = 0
+		} else {
+			result = int64(uint64(dst) / uint64(src))
+		}
+	case pb.AluOperationCode_AluMod:
+		// Modulo by zero leaves the destination untouched in ebpf.
+		if src == 0 {
+			result = dst
+		} else {
+			result = int64(uint64(dst) % uint64(src))
+		}
+	case pb.AluOperationCode_AluOr:
+		result = dst | src
+	case pb.AluOperationCode_AluAnd:
+		result = dst & src
+	case pb.AluOperationCode_AluXor:
+		result = dst ^ src
+	case pb.AluOperationCode_AluLsh:
+		result = dst << (src & shiftMask)
+	case pb.AluOperationCode_AluRsh:
+		result = int64(uint64(dst) >> (src & shiftMask))
+	case pb.AluOperationCode_AluArsh:
+		if is64 {
+			result = dst >> (src & shiftMask)
+		} else {
+			result = int64(int32(dst) >> (src & shiftMask))
+		}
+	case pb.AluOperationCode_AluNeg:
+		result = -dst
+	case pb.AluOperationCode_AluMov:
+		result = src
+	default:
+		return 0, false
+	}
+
+	if !is64 {
+		// 32 bit operations zero extend the result.
+		result = int64(uint32(result))
+	}
+	return result, true
+}
+
+// constantRegisters propagates constant register values through the
+// instructions in [start, end) and returns the registers whose value is
+// known right before `end` executes. Registers are assumed unknown at
+// `start`.
+func constantRegisters(instructions []*pb.Instruction, start, end int) map[pb.Reg]int64 {
+	known := make(map[pb.Reg]int64)
+	for _, inst := range instructions[start:end] {
+		switch c := inst.Opcode.(type) {
+		case *pb.Instruction_AluOpcode:
+			op := c.AluOpcode
+			is64 := op.InstructionClass == pb.InsClass_InsClassAlu64
+			var src int64
+			if op.Source == pb.SrcOperand_RegSrc {
+				value, ok := known[inst.SrcReg]
+				if !ok {
+					delete(known, inst.DstReg)
+					continue
+				}
+				src = value
+			} else {
+				// Immediates are sign extended to 64 bits.
+				src = int64(inst.Immediate)
+			}
+
+			dst, ok := known[inst.DstReg]
+			if !ok && op.OperationCode != pb.AluOperationCode_AluMov {
+				continue
+			}
+			if value, ok := evalAlu(op.OperationCode, is64, dst, src); ok {
+				known[inst.DstReg] = value
+			} else {
+				delete(known, inst.DstReg)
+			}
+		case *pb.Instruction_MemOpcode:
+			op := c.MemOpcode
+			switch {
+			case op.InstructionClass == pb.InsClass_InsClassLd && op.Mode == pb.StLdMode_StLdModeIMM:
+				p, ok := inst.PseudoInstruction.(*pb.Instruction_PseudoValue)
+				if ok && inst.SrcReg == pb.Reg_R0 {
+					known[inst.DstReg] = int64(uint64(uint32(inst.Immediate)) | uint64(p.PseudoValue.Immediate)<<32)
+				} else {
+					delete(known, inst.DstReg)
+				}
+			case op.InstructionClass == pb.InsClass_InsClassLd:
+				// Legacy packet loads put the result in R0 and
+				// clobber the caller saved registers.
+				for reg := pb.Reg_R0; reg <= pb.Reg_R5; reg++ {
+					delete(known, reg)
+				}
+			case op.InstructionClass == pb.InsClass_InsClassLdx:
+				delete(known, inst.DstReg)
+			case op.Mode == pb.StLdMode_StLdModeATOMIC:
+				// Fetching atomics write to the src register, be
+				// conservative and forget about it.
+				delete(known, inst.SrcReg)
+				delete(known, pb.Reg_R0)
+			}
+		case *pb.Instruction_JmpOpcode:
+			if c.JmpOpcode.OperationCode == pb.JmpOperationCode_JmpCALL {
+				// Helper calls clobber the caller saved registers.
+				for reg := pb.Reg_R0; reg <= pb.Reg_R5; reg++ {
+					delete(known, reg)
+				}
+			}
+		}
+	}
+	return known
+}
+
+// ExitValue tries to statically determine the value R0 holds when the exit
+// instruction at `exitIndex` executes. It only looks at the basic block that
+// contains the exit, so the second return value is false whenever R0 is not
+// defined with a constant inside that block.
+func ExitValue(instructions []*pb.Instruction, exitIndex int) (int64, bool) {
+	if exitIndex < 0 || exitIndex >= len(instructions) || !isExit(instructions[exitIndex]) {
+		return 0, false
+	}
+	start := blockStart(instructions, exitIndex)
+	value, ok := constantRegisters(instructions, start, exitIndex)[pb.Reg_R0]
+	return value, ok
+}
+
+// programInstructions returns the instructions of all the functions in
+// `program` in the order they are going to be loaded.
+func programInstructions(program *pb.Program) []*pb.Instruction {
+	instructions := []*pb.Instruction{}
+	for _, function := range program.Functions {
+		instructions = append(instructions, function.Instructions...)
+	}
+	return instructions
+}
+
+// registerDefs returns the registers that `i` writes to.
+func registerDefs(i *pb.Instruction) []pb.Reg {
+	switch c := i.Opcode.(type) {
+	case *pb.Instruction_AluOpcode:
+		return []pb.Reg{i.DstReg}
+	case *pb.Instruction_JmpOpcode:
+		if c.JmpOpcode.OperationCode == pb.JmpOperationCode_JmpCALL {
+			return []pb.Reg{pb.Reg_R0, pb.Reg_R1, pb.Reg_R2, pb.Reg_R3, pb.Reg_R4, pb.Reg_R5}
+		}
+	case *pb.Instruction_MemOpcode:
+		op := c.MemOpcode
+		switch op.InstructionClass {
+		case pb.InsClass_InsClassLd:
+			if op.Mode == pb.StLdMode_StLdModeIMM {
+				return []pb.Reg{i.DstReg}
+			}
+			return []pb.Reg{pb.Reg_R0, pb.Reg_R1, pb.Reg_R2, pb.Reg_R3, pb.Reg_R4, pb.Reg_R5}
+		case pb.InsClass_InsClassLdx:
+			return []pb.Reg{i.DstReg}
+		case pb.InsClass_InsClassStx:
+			if op.Mode != pb.StLdMode_StLdModeATOMIC {
+				return nil
+			}
+			// BPF_CMPXCHG always writes R0, the rest of the fetching
+			// operations write the src register.
+			if i.Immediate&^0x01 == 0xf0 {
+				return []pb.Reg{pb.Reg_R0}
+			}
+			if i.Immediate&0x01 != 0 || i.Immediate == 0xe0 {
+				return []pb.Reg{i.SrcReg}
+			}
+		}
+	}
+	return nil
+}
+
+// readsRegister returns true if `i` reads `reg`.
+func readsRegister(i *pb.Instruction, reg pb.Reg) bool {
+	for _, use := range registerUses(i) {
+		if use == reg {
+			return true
+		}
+	}
+	return false
+}
+
+// registerUses returns the registers that `i` reads from.
+func registerUses(i *pb.Instruction) []pb.Reg {
+	switch c := i.Opcode.(type) {
+	case *pb.Instruction_AluOpcode:
+		op := c.AluOpcode
+		uses := []pb.Reg{}
+		if op.OperationCode != pb.AluOperationCode_AluMov {
+			uses = append(uses, i.DstReg)
+		}
+		if op.Source == pb.SrcOperand_RegSrc {
+			uses = append(uses, i.SrcReg)
+		}
+		return uses
+	case *pb.Instruction_JmpOpcode:
+		op := c.JmpOpcode
+		switch op.OperationCode {
+		case pb.JmpOperationCode_JmpJA:
+			return nil
+		case pb.JmpOperationCode_JmpExit:
+			return []pb.Reg{pb.Reg_R0}
+		case pb.JmpOperationCode_JmpCALL:
+			return []pb.Reg{pb.Reg_R1, pb.Reg_R2, pb.Reg_R3, pb.Reg_R4, pb.Reg_R5}
+		}
+		uses := []pb.Reg{i.DstReg}
+		if op.Source == pb.SrcOperand_RegSrc {
+			uses = append(uses, i.SrcReg)
+		}
+		return uses
+	case *pb.Instruction_MemOpcode:
+		op := c.MemOpcode
+		switch op.InstructionClass {
+		case pb.InsClass_InsClassLd:
+			switch op.Mode {
+			case pb.StLdMode_StLdModeIMM:
+				return nil
+			case pb.StLdMode_StLdModeIND:
+				// Legacy packet loads implicitly use R6 as the skb.
+				return []pb.Reg{pb.Reg_R6, i.SrcReg}
+			default:
+				return []pb.Reg{pb.Reg_R6}
+			}
+		case pb.InsClass_InsClassLdx:
+			return []pb.Reg{i.SrcReg}
+		case pb.InsClass_InsClassSt:
+			return []pb.Reg{i.DstReg}
+		case pb.InsClass_InsClassStx:
+			uses := []pb.Reg{i.DstReg, i.SrcReg}
+			if op.Mode == pb.StLdMode_StLdModeATOMIC && i.Immediate&^0x01 == 0xf0 {
+				uses = append(uses, pb.Reg_R0)
+			}
+			return uses
+		}
+	}
+	return nil
+}
+
+// accessesMemory returns if `i` reads and/or writes memory.
+func accessesMemory(i *pb.Instruction) (reads bool, writes bool) {
+	mem, ok := i.Opcode.(*pb.Instruction_MemOpcode)
+	if !ok {
+		return false, false
+	}
+	switch mem.MemOpcode.InstructionClass {
+	case pb.InsClass_InsClassLdx:
+		return true, false
+	case pb.InsClass_InsClassLd:
+		return mem.MemOpcode.Mode != pb.StLdMode_StLdModeIMM, false
+	case pb.InsClass_InsClassSt:
+		return false, true
+	case pb.InsClass_InsClassStx:
+		return mem.MemOpcode.Mode == pb.StLdMode_StLdModeATOMIC, true
+	}
+	return false, false
+}
+
+// areIndependent returns true if executing `a` and `b` in any order yields
+// the same result, i.e. neither of them writes something the other one
+// reads or writes.
+func areIndependent(a, b *pb.Instruction) bool {
+	aDefs, bDefs := registerDefs(a), registerDefs(b)
+	aUses, bUses := registerUses(a), registerUses(b)
+	for _, def := range aDefs {
+		for _, reg := range append(bDefs, bUses...) {
+			if def == reg {
+				return false
+			}
+		}
+	}
+	for _, def := range bDefs {
+		for _, reg := range aUses {
+			if def == reg {
+				return false
+			}
+		}
+	}
+
+	// We don't track aliasing, so any memory write conflicts with any
+	// other memory access.
+	aReads, aWrites := accessesMemory(a)
+	bReads, bWrites := accessesMemory(b)
+	if (aWrites && (bReads || bWrites)) || (bWrites && aReads) {
+		return false
+	}
+	return true
+}
+
+// SlotCount returns the number of 64-bit words `instructions` take once
+// encoded. Jump offsets are expressed in words, so this is what should be
+// used to compute them instead of the number of instructions.
+func SlotCount(instructions []*pb.Instruction) int {
+	count := 0
+	for _, inst := range instructions {
+		count += instructionSlots(inst)
+	}
+	return count
+}
+
+// HelperHistogram counts how many times each helper function is called in
+// `program`, keyed by helper number. Calls to other bpf functions or to
+// kfuncs are not helper calls and are not counted.
+func HelperHistogram(program *pb.Program) map[int32]int {
+	histogram := make(map[int32]int)
+	for _, inst := range programInstructions(program) {
+		if fn, ok := HelperFunctionNumber(inst); ok {
+			histogram[fn]++
+		}
+	}
+	return histogram
+}
+
+// ClassHistogram counts the instructions of each instruction class in
+// `program`.
+func ClassHistogram(program *pb.Program) map[pb.InsClass]int {
+	histogram := make(map[pb.InsClass]int)
+	for _, inst := range programInstructions(program) {
+		switch c := inst.Opcode.(type) {
+		case *pb.Instruction_AluOpcode:
+			histogram[c.AluOpcode.InstructionClass]++
+		case *pb.Instruction_JmpOpcode:
+			histogram[c.JmpOpcode.InstructionClass]++
+		case *pb.Instruction_MemOpcode:
+			histogram[c.MemOpcode.InstructionClass]++
+		}
+	}
+	return histogram
+}
+
+// reachableInstructions returns which instructions can be reached from the
+// first one following the control flow within `instructions`.
+func reachableInstructions(instructions []*pb.Instruction) []bool {
+	reachable := make([]bool, len(instructions))
+	targets := jumpTargets(instructions)
+	pending := []int{}
+	if len(instructions) > 0 {
+		pending = append(pending, 0)
+	}
+	for len(pending) > 0 {
+		index := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if index >= len(instructions) || reachable[index] {
+			continue
+		}
+		reachable[index] = true
+
+		inst := instructions[index]
+		if targets[index] >= 0 {
+			pending = append(pending, targets[index])
+		}
+		if isExit(inst) || (isJump(inst) && !isConditionalJump(inst)) {
+			continue
+		}
+		pending = append(pending, index+1)
+	}
+	return reachable
+}
+
+// RegisterSet is a set of registers, bit N is set if RN is in the set.
+type RegisterSet uint16
+
+// Contains returns true if `reg` is in the set.
+func (s RegisterSet) Contains(reg pb.Reg) bool {
+	return s&(1<<reg) != 0
+}
+
+// Add returns the set with `reg` added to it.
+func (s RegisterSet) Add(reg pb.Reg) RegisterSet {
+	return s | 1<<reg
+}
+
+// Remove returns the set without `reg`.
+func (s RegisterSet) Remove(reg pb.Reg) RegisterSet {
+	return s &^ (1 << reg)
+}
+
+// Registers returns the registers in the set in ascending order.
+func (s RegisterSet) Registers() []pb.Reg {
+	regs := []pb.Reg{}
+	for reg := pb.Reg_R0; reg <= pb.Reg_R10; reg++ {
+		if s.Contains(reg) {
+			regs = append(regs, reg)
+		}
+	}
+	return regs
+}
+
+// definedAfter returns the registers that are initialized after `i` runs if
+// the ones in `defined` were initialized before it.
+func definedAfter(i *pb.Instruction, defined RegisterSet) RegisterSet {
+	clobbers := isCall(i)
+	if mem, ok := i.Opcode.(*pb.Instruction_MemOpcode); ok && mem.MemOpcode.InstructionClass == pb.InsClass_InsClassLd && !isLdImm64(i) {
+		// BPF_ABS and BPF_IND loads behave like a helper call.
+		clobbers = true
+	}
+	if clobbers {
+		for reg := pb.Reg_R1; reg <= pb.Reg_R5; reg++ {
+			defined = defined.Remove(reg)
+		}
+		return defined.Add(pb.Reg_R0)
+	}
+	for _, reg := range registerDefs(i) {
+		defined = defined.Add(reg)
+	}
+	return defined
+}
+
+// DefinedRegistersAt returns the registers that are initialized on every
+// path from the start of `instructions` to the instruction at `index`, right
+// before it runs. Generators can read from these registers without the
+// verifier complaining about uninitialized reads.
+//
+// At the start of the program only R1 (the context) and R10 (the frame
+// pointer) are initialized. The result is empty if `index` is out of range
+// or the instruction can't be reached.
+func DefinedRegistersAt(instructions []*pb.Instruction, index int) RegisterSet {
+	entry := RegisterSet(0).Add(pb.Reg_R1).Add(pb.Reg_R10)
+	return mustRegistersAt(instructions, index, entry, definedAfter)
+}
+
+// mustRegistersAt runs a classic must dataflow over the registers: the set
+// before an instruction is the intersection of the sets `transfer` returns
+// for each of its predecessors, iterated until nothing changes. `entry` is
+// the set at the start of the program. The result is empty if `index` is
+// out of range or the instruction can't be reached.
+func mustRegistersAt(instructions []*pb.Instruction, index int, entry RegisterSet, transfer func(*pb.Instruction, RegisterSet) RegisterSet) RegisterSet {
+	if index < 0 || index >= len(instructions) {
+		return 0
+	}
+	before, reached := mustRegisters(instructions, entry, transfer)
+	if !reached[index] {
+		return 0
+	}
+	return before[index]
+}
+
+// mustRegisters is like mustRegistersAt but returns the set before every
+// instruction, along with which instructions can be reached at all. The set
+// of an unreachable instruction is meaningless.
+func mustRegisters(instructions []*pb.Instruction, entry RegisterSet, transfer func(*pb.Instruction, RegisterSet) RegisterSet) ([]RegisterSet, []bool) {
+	return mustRegistersFollowing(instructions, entry, transfer, nil)
+}
+
+// mustRegistersFollowing is like mustRegisters but only follows the edges
+// between instructions for which `followed` returns true, or all of them if
+// it is nil.
+func mustRegistersFollowing(instructions []*pb.Instruction, entry RegisterSet, transfer func(*pb.Instruction, RegisterSet) RegisterSet, followed func(from, to int) bool) ([]RegisterSet, []bool) {
+	if len(instructions) == 0 {
+		return nil, nil
+	}
+
+	const all = RegisterSet(1<<(pb.Reg_R10+1) - 1)
+	targets := jumpTargets(instructions)
+	reached := make([]bool, len(instructions))
+	before := make([]RegisterSet, len(instructions))
+	for i := range before {
+		before[i] = all
+	}
+	reached[0] = true
+	before[0] = entry
+
+	pending := []int{0}
+	for len(pending) > 0 {
+		current := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		inst := instructions[current]
+		after := transfer(inst, before[current])
+		successors := []int{}
+		if targets[current] >= 0 {
+			successors = append(successors, targets[current])
+		}
+		if !isExit(inst) && !(isJump(inst) && !isConditionalJump(inst)) && current+1 < len(instructions) {
+			successors = append(successors, current+1)
+		}
+		for _, next := range successors {
+			if followed != nil && !followed(current, next) {
+				continue
+			}
+			merged := before[next] & after
+			if reached[next] && merged == before[next] {
+				continue
+			}
+			reached[next] = true
+			before[next] = merged
+			pending = append(pending, next)
+		}
+	}
+	return before, reached
+}
+
+// contextAfter returns the registers that hold the context pointer after `i`
+// runs if the ones in `ctx` held it before. Only plain 64-bit register
+// moves propagate it, any other write to a register loses it.
+func contextAfter(i *pb.Instruction, ctx RegisterSet) RegisterSet {
+	if alu, ok := i.Opcode.(*pb.Instruction_AluOpcode); ok {
+		op := alu.AluOpcode
+		if op.OperationCode == pb.AluOperationCode_AluMov && op.InstructionClass == pb.InsClass_InsClassAlu64 && op.Source == pb.SrcOperand_RegSrc && i.Offset == 0 {
+			if ctx.Contains(i.SrcReg) {
+				return ctx.Add(i.DstReg)
+			}
+			return ctx.Remove(i.DstReg)
+		}
+	}
+	// For calls this includes the clobbered R1-R5.
+	for _, reg := range registerDefs(i) {
+		ctx = ctx.Remove(reg)
+	}
+	return ctx
+}
+
+// ContextRegisters returns the registers that hold the context pointer on
+// every path from the start of `instructions` to the instruction at `index`,
+// right before it runs. At the start of the program only R1 does, whatever
+// the program type, and copies made with Mov64 are followed.
+//
+// The result is empty if `index` is out of range or the instruction can't
+// be reached.
+func ContextRegisters(instructions []*pb.Instruction, index int) RegisterSet {
+	return mustRegistersAt(instructions, index, RegisterSet(0).Add(pb.Reg_R1), contextAfter)
+}
+
+// ContextRegister returns a register that holds the context pointer right
+// before the instruction at `index` runs, to pass to helpers that take it.
+// Callee saved registers (R6-R9) are preferred as they survive calls. The
+// second return value is false if the context was lost on some path, in
+// which case generators should save R1 before clobbering it, e.g. with
+// Mov64(R6, R1) at the start of the program.
+func ContextRegister(instructions []*pb.Instruction, index int) (pb.Reg, bool) {
+	ctx := ContextRegisters(instructions, index)
+	for _, reg := range []pb.Reg{pb.Reg_R6, pb.Reg_R7, pb.Reg_R8, pb.Reg_R9} {
+		if ctx.Contains(reg) {
+			return reg, true
+		}
+	}
+	if regs := ctx.Registers(); len(regs) > 0 {
+		return regs[0], true
+	}
+	return pb.Reg_R1, false
+}
+
+// pointerAfter returns the registers that hold a pointer after `i` runs if
+// the ones in `ptrs` did before it. Pointers come from 64-bit immediate
+// loads of maps and functions and from map lookups, and survive 64-bit
+// register moves and the addition or subtraction of an immediate.
+func pointerAfter(i *pb.Instruction, ptrs RegisterSet) RegisterSet {
+	switch c := i.Opcode.(type) {
+	case *pb.Instruction_AluOpcode:
+		op := c.AluOpcode
+		if op.InstructionClass == pb.InsClass_InsClassAlu64 && i.Offset == 0 {
+			switch {
+			case op.OperationCode == pb.AluOperationCode_AluMov && op.Source == pb.SrcOperand_RegSrc:
+				if ptrs.Contains(i.SrcReg) {
+					return ptrs.Add(i.DstReg)
+				}
+				return ptrs.Remove(i.DstReg)
+			case op.OperationCode == pb.AluOperationCode_AluAdd || op.OperationCode == pb.AluOperationCode_AluSub:
+				if op.Source == pb.SrcOperand_Immediate {
+					return ptrs
+				}
+			}
+		}
+	case *pb.Instruction_MemOpcode:
+		if isLdImm64(i) && (i.SrcReg == PseudoMapFD || i.SrcReg == PseudoMapValue || i.SrcReg == PseudoFunc) {
+			return ptrs.Add(i.DstReg)
+		}
+	}
+	for _, reg := range registerDefs(i) {
+		ptrs = ptrs.Remove(reg)
+	}
+	if number, ok := HelperFunctionNumber(i); ok && number == MapLookup {
+		ptrs = ptrs.Add(pb.Reg_R0)
+	}
+	return ptrs
+}
+
+// PointerRegisters returns the registers that hold a pointer on every path
+// from the start of `instructions` to the instruction at `index`, right
+// before it runs: R10, the context and copies of it, maps, map values,
+// functions and the result of map lookups, which might be NULL. Registers
+// that are initialized but not in the set hold a scalar on some path.
+//
+// The result is empty if `index` is out of range or the instruction can't
+// be reached.
+func PointerRegisters(instructions []*pb.Instruction, index int) RegisterSet {
+	entry := RegisterSet(0).Add(pb.Reg_R1).Add(pb.Reg_R10)
+	return mustRegistersAt(instructions, index, entry, pointerAfter)
+}
+
+// inescapableLoop returns the index of the first reachable instruction from
+// which no exit (or the end of `instructions`) can be reached, or -1 if there
+// is none. Such an instruction is part of, or leads into, a cycle of jumps
+// that can never be left.
+func inescapableLoop(instructions []*pb.Instruction) int {
+	targets := jumpTargets(instructions)
+	predecessors := make([][]int, len(instructions)+1)
+	for index, inst := range instructions {
+		if targets[index] >= 0 {
+			predecessors[targets[index]] = append(predecessors[targets[index]], index)
+		}
+		if !isExit(inst) && !(isJump(inst) && !isConditionalJump(inst)) {
+			predecessors[index+1] = append(predecessors[index+1], index)
+		}
+	}
+
+	// Walk the control flow backwards from every way out of the program.
+	escapes := make([]bool, len(instructions)+1)
+	pending := []int{len(instructions)}
+	for index, inst := range instructions {
+		if isExit(inst) {
+			pending = append(pending, index)
+		}
+	}
+	for len(pending) > 0 {
+		current := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if escapes[current] {
+			continue
+		}
+		escapes[current] = true
+		pending = append(pending, predecessors[current]...)
+	}
+
+	for index, reachable := range reachableInstructions(instructions) {
+		if reachable && !escapes[index] {
+			return index
+		}
+	}
+	return -1
+}
+
+// EnumeratePaths returns up to `maxPaths` paths from the first instruction
+// to an exit, each as the list of slots (the instruction numbers used by
+// the verifier) it goes through. Paths are returned in depth first order,
+// taking the fall through side of a branch before the jump.
+//
+// Loops are followed around at most once: a path never visits the same
+// instruction more than twice, paths that would have to are dropped.
+func EnumeratePaths(instructions []*pb.Instruction, maxPaths int) [][]int {
+	paths := [][]int{}
+	if len(instructions) == 0 || maxPaths <= 0 {
+		return paths
+	}
+
+	slots := slotIndexes(instructions)
+	targets := jumpTargets(instructions)
+	visits := make([]int, len(instructions))
+	current := []int{}
+
+	var walk func(index int)
+	walk = func(index int) {
+		if len(paths) >= maxPaths || index >= len(instructions) || visits[index] >= 2 {
+			return
+		}
+		visits[index]++
+		current = append(current, slots[index])
+		defer func() {
+			visits[index]--
+			current = current[:len(current)-1]
+		}()
+
+		inst := instructions[index]
+		if isExit(inst) {
+			paths = append(paths, append([]int{}, current...))
+			return
+		}
+		if !isJump(inst) || isConditionalJump(inst) {
+			walk(index + 1)
+		}
+		if targets[index] >= 0 {
+			walk(targets[index])
+		}
+	}
+	walk(0)
+	return paths
+}
+
+// stackDepth returns how many bytes below R10 the deepest access of
+// `instructions` that uses R10 as its base register reaches.
+func stackDepth(instructions []*pb.Instruction) int {
+	depth := 0
+	for _, i := range instructions {
+		mem, ok := i.Opcode.(*pb.Instruction_MemOpcode)
+		if !ok {
+			continue
+		}
+		base := i.DstReg
+		switch mem.MemOpcode.InstructionClass {
+		case pb.InsClass_InsClassLdx:
+			base = i.SrcReg
+		case pb.InsClass_InsClassLd:
+			continue
+		}
+		if base == pb.Reg_R10 && -int(i.Offset) > depth {
+			depth = -int(i.Offset)
+		}
+	}
+	return depth
+}
+
+// MaxStackDepth returns the number of bytes of stack `program` uses, to be
+// compared against MaxStackSize. The depth of each function is the deepest
+// R10 based load or store in it, and as the stack frames of bpf to bpf calls
+// add up, the result is the deepest sum along a chain of calls starting at
+// the first function. The verifier rounds every frame up before adding
+// them, see ExceedsStackLimit.
+//
+// Stack accessed through a copy of R10, e.g. passed to a helper, is not
+// accounted for.
+func MaxStackDepth(program *pb.Program) int {
+	return callChainStackDepth(program, func(depth int) int { return depth })
+}
+
+// ExceedsStackLimit returns true if the frames of a chain of calls of
+// `program` take more than MaxStackSize bytes once rounded up the way the
+// verifier does: to 16 bytes when a JIT is requested, otherwise to the
+// 32 bytes granularity of the interpreter stack, where a function that
+// uses no stack still takes a frame.
+func ExceedsStackLimit(program *pb.Program, jitRequested bool) bool {
+	return callChainStackDepth(program, func(depth int) int {
+		return roundUpStackDepth(depth, jitRequested)
+	}) > MaxStackSize
+}
+
+// callChainStackDepth is MaxStackDepth with the depth of every function
+// passed through `frame` before adding it to the chain.
+func callChainStackDepth(program *pb.Program, frame func(depth int) int) int {
+	if len(program.Functions) == 0 {
+		return 0
+	}
+
+	// Call offsets are relative to the whole program, find the slot at
+	// which every function starts.
+	functionAt := make(map[int]int)
+	depths := make([]int, len(program.Functions))
+	callees := make([][]int, len(program.Functions))
+	slot := 0
+	for function, f := range program.Functions {
+		functionAt[slot] = function
+		depths[function] = frame(stackDepth(f.Instructions))
+		for _, i := range f.Instructions {
+			slot += instructionSlots(i)
+		}
+	}
+	slot = 0
+	for function, f := range program.Functions {
+		for _, i := range f.Instructions {
+			if isCall(i) && i.SrcReg == PseudoCall {
+				if callee, ok := functionAt[slot+1+int(i.Immediate)]; ok {
+					callees[function] = append(callees[function], callee)
+				}
+			}
+			slot += instructionSlots(i)
+		}
+	}
+
+	// The verifier rejects recursion, stop following calls that would
+	// loop back.
+	visiting := make([]bool, len(program.Functions))
+	var deepest func(function int) int
+	deepest = func(function int) int {
+		if visiting[function] {
+			return 0
+		}
+		visiting[function] = true
+		defer func() { visiting[function] = false }()
+		calls := 0
+		for _, callee := range callees[function] {
+			if depth := deepest(callee); depth > calls {
+				calls = depth
+			}
+		}
+		return depths[function] + calls
+	}
+	return deepest(0)
+}
+
+// roundUpStackDepth rounds the stack `depth` of a function up the way the
+// verifier does when adding the frames of a chain of calls.
+func roundUpStackDepth(depth int, jitRequested bool) int {
+	if jitRequested {
+		return (depth + 15) / 16 * 16
+	}
+	if depth < 1 {
+		depth = 1
+	}
+	return (depth + 31) / 32 * 32
+}
@@ -0,0 +1,425 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"reflect"
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+func TestHelperHistogram(t *testing.T) {
+	pseudoCall := Call(2)
+	pseudoCall.SrcReg = pb.Reg_R1
+
+	program := &pb.Program{
+		Functions: []*pb.Functions{
+			{
+				Instructions: []*pb.Instruction{
+					Call(MapLookup),
+					Call(SkbLoadBytesRelative),
+					pseudoCall,
+					Exit(),
+				},
+			},
+			{
+				Instructions: []*pb.Instruction{
+					Call(MapLookup),
+					Exit(),
+				},
+			},
+		},
+	}
+
+	want := map[int32]int{
+		MapLookup:            2,
+		SkbLoadBytesRelative: 1,
+	}
+	if got := HelperHistogram(program); !reflect.DeepEqual(got, want) {
+		t.Errorf("HelperHistogram() = %v, want %v", got, want)
+	}
+}
+
+func TestClassHistogram(t *testing.T) {
+	program := &pb.Program{
+		Functions: []*pb.Functions{
+			{
+				Instructions: []*pb.Instruction{
+					Mov64(R0, 0),
+					Mov(R1, 0),
+					Add64(R0, R1),
+					LdDW(R2, R10, -8),
+					StW(R10, 0, -4),
+					JmpEQ32(R1, 0, 0),
+					Exit(),
+				},
+			},
+		},
+	}
+
+	want := map[pb.InsClass]int{
+		pb.InsClass_InsClassAlu64: 2,
+		pb.InsClass_InsClassAlu:   1,
+		pb.InsClass_InsClassLdx:   1,
+		pb.InsClass_InsClassSt:    1,
+		pb.InsClass_InsClassJmp32: 1,
+		pb.InsClass_InsClassJmp:   1,
+	}
+	if got := ClassHistogram(program); !reflect.DeepEqual(got, want) {
+		t.Errorf("ClassHistogram() = %v, want %v", got, want)
+	}
+}
+
+func TestDefinedRegistersAt(t *testing.T) {
+	instructions := []*pb.Instruction{
+		/* 0 */ Mov64(R6, R1),
+		/* 1 */ JmpEQ(R6, 0, 2),
+		/* 2 */ Mov64(R2, 1),
+		/* 3 */ Mov64(R7, 2),
+		/* 4 */ Mov64(R7, 3),
+		/* 5 */ Call(MapLookup),
+		/* 6 */ Mov64(R8, int64(1)<<40),
+		/* 7 */ Exit(),
+		/* 8 */ Exit(),
+	}
+
+	tests := []struct {
+		testName string
+		index    int
+		want     []pb.Reg
+	}{
+		{
+			testName: "Program start",
+			index:    0,
+			want:     []pb.Reg{R1, R10},
+		},
+		{
+			testName: "Inside a branch",
+			index:    3,
+			want:     []pb.Reg{R1, R2, R6, R10},
+		},
+		{
+			testName: "After the branches merge",
+			index:    4,
+			want:     []pb.Reg{R1, R6, R10},
+		},
+		{
+			testName: "After a call",
+			index:    6,
+			want:     []pb.Reg{R0, R6, R7, R10},
+		},
+		{
+			testName: "After a wide load",
+			index:    7,
+			want:     []pb.Reg{R0, R6, R7, R8, R10},
+		},
+		{
+			testName: "Unreachable",
+			index:    8,
+			want:     []pb.Reg{},
+		},
+		{
+			testName: "Out of range",
+			index:    9,
+			want:     []pb.Reg{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got := DefinedRegistersAt(instructions, tc.index).Registers()
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("DefinedRegistersAt(%d) = %v, want %v", tc.index, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDefinedRegistersAtLoop(t *testing.T) {
+	// R2 is only defined inside the loop body, so it is not defined at
+	// the loop header.
+	instructions := []*pb.Instruction{
+		/* 0 */ Mov64(R0, 0),
+		/* 1 */ JmpGT(R0, 10, 3),
+		/* 2 */ Mov64(R2, 1),
+		/* 3 */ Add64(R0, R2),
+		/* 4 */ Jmp(-4),
+		/* 5 */ Exit(),
+	}
+	want := []pb.Reg{R0, R1, R10}
+	if got := DefinedRegistersAt(instructions, 1).Registers(); !reflect.DeepEqual(got, want) {
+		t.Errorf("DefinedRegistersAt(1) = %v, want %v", got, want)
+	}
+	if got := DefinedRegistersAt(instructions, 3); !got.Contains(R2) {
+		t.Errorf("DefinedRegistersAt(3) = %v, want it to contain R2", got.Registers())
+	}
+}
+
+func TestEnumeratePaths(t *testing.T) {
+	diamond := []*pb.Instruction{
+		JmpEQ(R1, 0, 3),
+		Mov64(R0, int64(1)<<40),
+		Exit(),
+		Mov64(R0, 1),
+		Exit(),
+	}
+	tests := []struct {
+		testName     string
+		instructions []*pb.Instruction
+		maxPaths     int
+		want         [][]int
+	}{
+		{
+			testName:     "Straight line",
+			instructions: []*pb.Instruction{Mov64(R0, 0), Exit()},
+			maxPaths:     10,
+			want:         [][]int{{0, 1}},
+		},
+		{
+			testName:     "Branch over a wide instruction",
+			instructions: diamond,
+			maxPaths:     10,
+			want:         [][]int{{0, 1, 3}, {0, 4, 5}},
+		},
+		{
+			testName:     "Capped",
+			instructions: diamond,
+			maxPaths:     1,
+			want:         [][]int{{0, 1, 3}},
+		},
+		{
+			testName: "Loop is followed around once",
+			instructions: []*pb.Instruction{
+				Mov64(R0, 0),
+				Add64(R0, 1),
+				JmpLT(R0, 10, -2),
+				Exit(),
+			},
+			maxPaths: 10,
+			want:     [][]int{{0, 1, 2, 3}, {0, 1, 2, 1, 2, 3}},
+		},
+		{
+			testName:     "Loop without an exit",
+			instructions: []*pb.Instruction{Mov64(R0, 0), Jmp(-1), Exit()},
+			maxPaths:     10,
+			want:         [][]int{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got := EnumeratePaths(tc.instructions, tc.maxPaths)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("EnumeratePaths() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMaxStackDepth(t *testing.T) {
+	callTo := func(offset int32) *pb.Instruction {
+		call := Call(offset)
+		call.SrcReg = PseudoCall
+		return call
+	}
+	tests := []struct {
+		testName  string
+		functions [][]*pb.Instruction
+		want      int
+	}{
+		{
+			testName:  "No stack",
+			functions: [][]*pb.Instruction{{Mov64(R0, 0), Exit()}},
+			want:      0,
+		},
+		{
+			testName: "Loads and stores",
+			functions: [][]*pb.Instruction{{
+				StW(R10, 0, -4),
+				StDW(R10, R1, -16),
+				LdW(R0, R10, -24),
+				// Not relative to the frame pointer.
+				LdW(R0, R1, -64),
+				Exit(),
+			}},
+			want: 24,
+		},
+		{
+			testName: "Frames add up along calls",
+			functions: [][]*pb.Instruction{
+				{
+					StDW(R10, 0, -8),
+					callTo(2),
+					callTo(5),
+					Exit(),
+				},
+				{
+					LdDW(R0, R10, -32),
+					Mov64(R1, int64(1)<<40),
+					Exit(),
+				},
+				{
+					StB(R10, 0, -100),
+					Exit(),
+				},
+			},
+			want: 108,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			program := &pb.Program{}
+			for _, instructions := range tc.functions {
+				program.Functions = append(program.Functions, &pb.Functions{Instructions: instructions})
+			}
+			if got := MaxStackDepth(program); got != tc.want {
+				t.Errorf("MaxStackDepth() = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestExceedsStackLimit(t *testing.T) {
+	callTo := func(offset int32) *pb.Instruction {
+		call := Call(offset)
+		call.SrcReg = PseudoCall
+		return call
+	}
+	tests := []struct {
+		testName        string
+		functions       [][]*pb.Instruction
+		wantInterpreter bool
+		wantJit         bool
+	}{
+		{
+			testName:        "Small frames",
+			functions:       [][]*pb.Instruction{{StDW(R10, 0, -8), callTo(1), Exit()}, {StB(R10, 0, -100), Exit()}},
+			wantInterpreter: false,
+			wantJit:         false,
+		},
+		{
+			// 481 + 8 bytes fit, the interpreter frames take 512 + 32
+			// and the JIT ones 496 + 16.
+			testName:        "Rounded up frames",
+			functions:       [][]*pb.Instruction{{StB(R10, 0, -481), callTo(1), Exit()}, {StDW(R10, 0, -8), Exit()}},
+			wantInterpreter: true,
+			wantJit:         false,
+		},
+		{
+			// Only the interpreter gives a frame to functions that
+			// don't use the stack.
+			testName:        "Callee without stack",
+			functions:       [][]*pb.Instruction{{StDW(R10, 0, -512), callTo(1), Exit()}, {Mov64(R0, 0), Exit()}},
+			wantInterpreter: true,
+			wantJit:         false,
+		},
+		{
+			testName:        "Over the limit",
+			functions:       [][]*pb.Instruction{{StDW(R10, 0, -512), callTo(1), Exit()}, {StDW(R10, 0, -8), Exit()}},
+			wantInterpreter: true,
+			wantJit:         true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			program := &pb.Program{}
+			for _, instructions := range tc.functions {
+				program.Functions = append(program.Functions, &pb.Functions{Instructions: instructions})
+			}
+			if got := ExceedsStackLimit(program, false); got != tc.wantInterpreter {
+				t.Errorf("ExceedsStackLimit(jitRequested = false) = %v, want %v", got, tc.wantInterpreter)
+			}
+			if got := ExceedsStackLimit(program, true); got != tc.wantJit {
+				t.Errorf("ExceedsStackLimit(jitRequested = true) = %v, want %v", got, tc.wantJit)
+			}
+		})
+	}
+}
+
+func TestContextRegister(t *testing.T) {
+	tests := []struct {
+		testName     string
+		instructions []*pb.Instruction
+		index        int
+		wantReg      pb.Reg
+		wantOk       bool
+	}{
+		{
+			testName:     "Program entry",
+			instructions: []*pb.Instruction{Mov64(R0, 0), Exit()},
+			index:        0,
+			wantReg:      R1,
+			wantOk:       true,
+		},
+		{
+			testName: "Saved copy survives a call",
+			instructions: []*pb.Instruction{
+				Mov64(R7, R1),
+				Call(MapLookup),
+				Mov64(R0, 0),
+				Exit(),
+			},
+			index:   2,
+			wantReg: R7,
+			wantOk:  true,
+		},
+		{
+			testName: "Callee saved copy is preferred",
+			instructions: []*pb.Instruction{
+				Mov64(R2, R1),
+				Mov64(R9, R2),
+				Exit(),
+			},
+			index:   2,
+			wantReg: R9,
+			wantOk:  true,
+		},
+		{
+			testName: "Overwritten on one path",
+			instructions: []*pb.Instruction{
+				Mov64(R6, R1),
+				JmpEQ(R2, 0, 1),
+				Add64(R6, 8),
+				Mov64(R0, 0),
+				Exit(),
+			},
+			index:   3,
+			wantReg: R1,
+			wantOk:  true,
+		},
+		{
+			testName: "Lost after a call",
+			instructions: []*pb.Instruction{
+				Call(MapLookup),
+				Mov64(R0, 0),
+				Exit(),
+			},
+			index:  1,
+			wantOk: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			reg, ok := ContextRegister(tc.instructions, tc.index)
+			if ok != tc.wantOk || (ok && reg != tc.wantReg) {
+				t.Errorf("ContextRegister() = %v, %v, want %v, %v", reg, ok, tc.wantReg, tc.wantOk)
+			}
+		})
+	}
+}
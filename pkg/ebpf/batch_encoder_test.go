@@ -0,0 +1,88 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"reflect"
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+func batchEncoderTestProgram() *pb.Program {
+	return &pb.Program{
+		Functions: []*pb.Functions{
+			{
+				Instructions: []*pb.Instruction{
+					LdMapByFd(R1, 3),
+					StW(R10, 0, -4),
+					Mov64(R2, R10),
+					Add64(R2, -4),
+					Call(MapLookup),
+					JmpNE(R0, 0, 1),
+					Exit(),
+					LdDW(R0, R0, 0),
+					Mov64(R0, 0),
+					Exit(),
+				},
+			},
+		},
+	}
+}
+
+func TestBatchEncoder(t *testing.T) {
+	program := batchEncoderTestProgram()
+	want := []uint64{}
+	for _, instruction := range program.Functions[0].Instructions {
+		encoding, err := encodeInstruction(instruction)
+		if err != nil {
+			t.Fatalf("encodeInstruction() error = %v", err)
+		}
+		want = append(want, encoding...)
+	}
+
+	encoder := NewBatchEncoder(0)
+	// Encode twice to make sure the buffer is reset between calls.
+	for i := 0; i < 2; i++ {
+		got, err := encoder.Encode(program)
+		if err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Encode() = %x, want %x", got, want)
+		}
+	}
+}
+
+func BenchmarkEncodeInstructions(b *testing.B) {
+	program := batchEncoderTestProgram()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := EncodeInstructions(program); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBatchEncoder(b *testing.B) {
+	program := batchEncoderTestProgram()
+	encoder := NewBatchEncoder(0)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := encoder.Encode(program); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+// MaxBalancedTreeDepth is the deepest tree GenerateBalancedTree builds, the
+// jump at the root of a deeper one would skip more slots than fit in the
+// off field.
+const MaxBalancedTreeDepth = 14
+
+// balancedSubtree returns a subtree of `depth` levels branching on bit
+// `depth` of R6. Leaves set R0 to their index, counted from `firstLeaf`.
+func balancedSubtree(depth int, firstLeaf int32) []*pb.Instruction {
+	if depth == 0 {
+		return []*pb.Instruction{Mov64(R0, firstLeaf), Exit()}
+	}
+	left := balancedSubtree(depth-1, firstLeaf)
+	right := balancedSubtree(depth-1, firstLeaf+int32(1)<<(depth-1))
+	subtree := make([]*pb.Instruction, 0, 1+len(left)+len(right))
+	subtree = append(subtree, JmpSET(R6, int32(1)<<(depth-1), int16(len(left))))
+	subtree = append(subtree, left...)
+	return append(subtree, right...)
+}
+
+// GenerateBalancedTree returns a program that branches on a random number
+// `depth` times along every path, in a perfectly balanced binary tree of
+// conditional jumps whose 2^depth leaves each set R0 to a different value
+// and exit. Every level tests a different bit so the verifier can't tell
+// any two paths apart, which makes it a reproducible worst case for state
+// pruning and for anything whose cost grows with the number of branches.
+//
+// `depth` is clamped to [0, MaxBalancedTreeDepth].
+func GenerateBalancedTree(depth int) *pb.Program {
+	depth = max(0, min(depth, MaxBalancedTreeDepth))
+	instructions := []*pb.Instruction{Call(GetPrandomU32), Mov64(R6, R0)}
+	instructions = append(instructions, balancedSubtree(depth, 0)...)
+	return &pb.Program{
+		Functions: []*pb.Functions{{Instructions: instructions}},
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"testing"
+)
+
+func TestGenerateBalancedTree(t *testing.T) {
+	tests := []struct {
+		depth     int
+		wantDepth int
+	}{
+		{depth: -1, wantDepth: 0},
+		{depth: 0, wantDepth: 0},
+		{depth: 1, wantDepth: 1},
+		{depth: 3, wantDepth: 3},
+		{depth: MaxBalancedTreeDepth, wantDepth: MaxBalancedTreeDepth},
+		{depth: MaxBalancedTreeDepth + 1, wantDepth: MaxBalancedTreeDepth},
+	}
+
+	for _, tc := range tests {
+		program := GenerateBalancedTree(tc.depth)
+		instructions := program.Functions[0].Instructions
+		if err := Validate(instructions); err != nil {
+			t.Fatalf("GenerateBalancedTree(%d) is not valid: %v", tc.depth, err)
+		}
+
+		leaves := 1 << tc.wantDepth
+		jumps, exits := 0, 0
+		for _, inst := range instructions {
+			if isConditionalJump(inst) {
+				jumps++
+			}
+			if isExit(inst) {
+				exits++
+			}
+		}
+		if jumps != leaves-1 || exits != leaves {
+			t.Errorf("GenerateBalancedTree(%d) has %d jumps and %d exits, want %d and %d", tc.depth, jumps, exits, leaves-1, leaves)
+		}
+
+		// Walk every path, each should take a different leaf.
+		targets := jumpTargets(instructions)
+		seen := make(map[int32]bool)
+		var walk func(index int, depth int)
+		walk = func(index int, depth int) {
+			inst := instructions[index]
+			if !isConditionalJump(inst) {
+				if depth != tc.wantDepth {
+					t.Errorf("GenerateBalancedTree(%d) has a leaf at depth %d", tc.depth, depth)
+				}
+				seen[inst.Immediate] = true
+				return
+			}
+			walk(index+1, depth+1)
+			walk(targets[index], depth+1)
+		}
+		walk(2, 0)
+		if len(seen) != leaves {
+			t.Errorf("GenerateBalancedTree(%d) reaches %d distinct leaves, want %d", tc.depth, len(seen), leaves)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	btfpb "buzzer/proto/btf_go_proto"
+	pb "buzzer/proto/ebpf_go_proto"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrTruncatedCompactProgram is returned by DecodeCompact when the
+	// data ends in the middle of a program.
+	ErrTruncatedCompactProgram = errors.New("Truncated compact program")
+
+	// ErrUnknownCompactVersion is returned by DecodeCompact for data
+	// produced by a different version of the format.
+	ErrUnknownCompactVersion = errors.New("Unknown compact program version")
+)
+
+// compactVersion is the first byte of every compact program, bump it on any
+// change to the format.
+const compactVersion = 2
+
+// Flags stored in the first byte of every compact instruction.
+const (
+	compactWide               = 0x01
+	compactPinned             = 0x02
+	compactImmediateTruncated = 0x04
+)
+
+// EncodeCompact serializes `program` in a compact binary format meant to send
+// programs to remote executors, decode it with DecodeCompact. On typical
+// programs it is over ten times smaller than the JSON form and less than half
+// the size of the protobuf one.
+//
+// Every instruction slot is stored as its opcode and registers bytes
+// followed by the offset and the immediate as varints, so jump offsets
+// are kept as is. Programs are encoded losslessly: load flags, BTF, func
+// info, pinned instructions and truncated store immediates are kept.
+func EncodeCompact(program *pb.Program) ([]byte, error) {
+	data := []byte{compactVersion}
+	data = binary.AppendUvarint(data, uint64(program.LoadFlags))
+	data = binary.AppendUvarint(data, uint64(len(program.Btf)))
+	data = append(data, program.Btf...)
+	data = binary.AppendUvarint(data, uint64(len(program.Functions)))
+	for _, function := range program.Functions {
+		if function.FuncInfo == nil {
+			data = append(data, 0)
+		} else {
+			data = append(data, 1)
+			data = binary.AppendVarint(data, int64(function.FuncInfo.InsnOff))
+			data = binary.AppendVarint(data, int64(function.FuncInfo.TypeId))
+		}
+
+		data = binary.AppendUvarint(data, uint64(len(function.Instructions)))
+		for index, instruction := range function.Instructions {
+			if instruction == nil {
+				return nil, fmt.Errorf("%w at index %d", ErrNilInstruction, index)
+			}
+			words, err := encodeInstruction(instruction)
+			if err != nil {
+				return nil, fmt.Errorf("instruction %d: %w", index, err)
+			}
+			flags := byte(0)
+			if len(words) == 2 {
+				flags |= compactWide
+			}
+			if instruction.Pinned {
+				flags |= compactPinned
+			}
+			if instruction.ImmediateTruncated {
+				flags |= compactImmediateTruncated
+			}
+			data = append(data, flags)
+			for _, word := range words {
+				data = append(data, byte(word), byte(word>>8))
+				data = binary.AppendVarint(data, int64(int16(word>>16)))
+				data = binary.AppendVarint(data, int64(int32(word>>32)))
+			}
+		}
+	}
+	return data, nil
+}
+
+// compactReader reads the fields of a compact program, remembering the
+// first error so callers can check it once at the end.
+type compactReader struct {
+	data []byte
+	err  error
+}
+
+func (r *compactReader) byte() byte {
+	if r.err != nil || len(r.data) == 0 {
+		r.err = ErrTruncatedCompactProgram
+		return 0
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+
+func (r *compactReader) bytes(n uint64) []byte {
+	if r.err != nil || uint64(len(r.data)) < n {
+		r.err = ErrTruncatedCompactProgram
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return append([]byte{}, b...)
+}
+
+func (r *compactReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	value, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = ErrTruncatedCompactProgram
+		return 0
+	}
+	r.data = r.data[n:]
+	return value
+}
+
+func (r *compactReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	value, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.err = ErrTruncatedCompactProgram
+		return 0
+	}
+	r.data = r.data[n:]
+	return value
+}
+
+// word reads an instruction slot back into its kernel encoding.
+func (r *compactReader) word() uint64 {
+	word := uint64(r.byte())
+	word |= uint64(r.byte()) << 8
+	word |= uint64(uint16(r.varint())) << 16
+	word |= uint64(uint32(r.varint())) << 32
+	return word
+}
+
+// DecodeCompact parses a program serialized with EncodeCompact.
+func DecodeCompact(data []byte) (*pb.Program, error) {
+	r := &compactReader{data: data}
+	if version := r.byte(); r.err == nil && version != compactVersion {
+		return nil, fmt.Errorf("%w %d", ErrUnknownCompactVersion, version)
+	}
+
+	program := &pb.Program{}
+	program.LoadFlags = uint32(r.uvarint())
+	if btf := r.bytes(r.uvarint()); len(btf) != 0 {
+		program.Btf = btf
+	}
+	functions := r.uvarint()
+	for f := uint64(0); f < functions && r.err == nil; f++ {
+		function := &pb.Functions{}
+		if r.byte() != 0 {
+			function.FuncInfo = &btfpb.FuncInfo{
+				InsnOff: int32(r.varint()),
+				TypeId:  int32(r.varint()),
+			}
+		}
+		instructions := r.uvarint()
+		for index := uint64(0); index < instructions && r.err == nil; index++ {
+			flags := r.byte()
+			instruction := decodeWord(r.word())
+			if flags&compactWide != 0 {
+				instruction.PseudoInstruction = &pb.Instruction_PseudoValue{
+					PseudoValue: decodeWord(r.word()),
+				}
+			}
+			instruction.Pinned = flags&compactPinned != 0
+			instruction.ImmediateTruncated = flags&compactImmediateTruncated != 0
+			function.Instructions = append(function.Instructions, instruction)
+		}
+		program.Functions = append(program.Functions, function)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(r.data) != 0 {
+		return nil, fmt.Errorf("%d unexpected bytes after the compact program", len(r.data))
+	}
+	return program, nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	btfpb "buzzer/proto/btf_go_proto"
+	pb "buzzer/proto/ebpf_go_proto"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/jsonpb"
+	protobuf "github.com/golang/protobuf/proto"
+)
+
+func compactTestProgram() *pb.Program {
+	program := batchEncoderTestProgram()
+	program.LoadFlags = 1
+	program.Btf = []byte{0x9f, 0xeb, 0x01, 0x00}
+	program.Functions[0].FuncInfo = &btfpb.FuncInfo{InsnOff: 0, TypeId: 3}
+	program.Functions[0].Instructions[1] = Pin(StW(R10, 0, -4))
+	program.Functions[0].Instructions[2] = StDW(R10, int64(1)<<32, -8)
+	program.Functions = append(program.Functions, &pb.Functions{
+		FuncInfo:     &btfpb.FuncInfo{InsnOff: 11, TypeId: 4},
+		Instructions: []*pb.Instruction{Mov64(R0, int64(-1)<<40), Jmp(-2), Exit()},
+	})
+	return program
+}
+
+func TestCompactEncodingRoundtrip(t *testing.T) {
+	program := compactTestProgram()
+	data, err := EncodeCompact(program)
+	if err != nil {
+		t.Fatalf("EncodeCompact() unexpected error: %v", err)
+	}
+	got, err := DecodeCompact(data)
+	if err != nil {
+		t.Fatalf("DecodeCompact() unexpected error: %v", err)
+	}
+
+	if got.LoadFlags != program.LoadFlags || !reflect.DeepEqual(got.Btf, program.Btf) {
+		t.Errorf("DecodeCompact() flags and btf = %x, %x, want %x, %x", got.LoadFlags, got.Btf, program.LoadFlags, program.Btf)
+	}
+	if len(got.Functions) != len(program.Functions) {
+		t.Fatalf("DecodeCompact() has %d functions, want %d", len(got.Functions), len(program.Functions))
+	}
+	for f := range program.Functions {
+		if !protobuf.Equal(got.Functions[f].FuncInfo, program.Functions[f].FuncInfo) {
+			t.Errorf("function %d func info = %v, want %v", f, got.Functions[f].FuncInfo, program.Functions[f].FuncInfo)
+		}
+		for index, want := range program.Functions[f].Instructions {
+			if got.Functions[f].Instructions[index].Pinned != want.Pinned {
+				t.Errorf("function %d instruction %d pinned = %v, want %v", f, index, got.Functions[f].Instructions[index].Pinned, want.Pinned)
+			}
+			if got.Functions[f].Instructions[index].ImmediateTruncated != want.ImmediateTruncated {
+				t.Errorf("function %d instruction %d immediate truncated = %v, want %v", f, index, got.Functions[f].Instructions[index].ImmediateTruncated, want.ImmediateTruncated)
+			}
+		}
+	}
+
+	wantWords, err := NewBatchEncoder(0).Encode(program)
+	if err != nil {
+		t.Fatalf("Encode() unexpected error: %v", err)
+	}
+	gotWords, err := NewBatchEncoder(0).Encode(got)
+	if err != nil {
+		t.Fatalf("Encode(DecodeCompact()) unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(gotWords, wantWords) {
+		t.Errorf("Encode(DecodeCompact()) = %x, want %x", gotWords, wantWords)
+	}
+}
+
+func TestCompactEncodingSize(t *testing.T) {
+	program := compactTestProgram()
+	data, err := EncodeCompact(program)
+	if err != nil {
+		t.Fatalf("EncodeCompact() unexpected error: %v", err)
+	}
+	json, err := (&jsonpb.Marshaler{}).MarshalToString(program)
+	if err != nil {
+		t.Fatalf("MarshalToString() unexpected error: %v", err)
+	}
+	wire, err := protobuf.Marshal(program)
+	if err != nil {
+		t.Fatalf("Marshal() unexpected error: %v", err)
+	}
+	t.Logf("compact: %d bytes, protobuf: %d bytes, json: %d bytes", len(data), len(wire), len(json))
+	if len(data)*4 > len(json) {
+		t.Errorf("EncodeCompact() is %d bytes, want at most a quarter of the %d bytes of JSON", len(data), len(json))
+	}
+	if len(data) >= len(wire) {
+		t.Errorf("EncodeCompact() is %d bytes, want less than the %d bytes of protobuf", len(data), len(wire))
+	}
+}
+
+func TestDecodeCompactErrors(t *testing.T) {
+	data, err := EncodeCompact(compactTestProgram())
+	if err != nil {
+		t.Fatalf("EncodeCompact() unexpected error: %v", err)
+	}
+	for _, cut := range []int{0, 1, len(data) / 2, len(data) - 1} {
+		if _, err := DecodeCompact(data[:cut]); !errors.Is(err, ErrTruncatedCompactProgram) {
+			t.Errorf("DecodeCompact() of %d bytes error = %v, want %v", cut, err, ErrTruncatedCompactProgram)
+		}
+	}
+	if _, err := DecodeCompact(append(data, 0)); err == nil {
+		t.Errorf("DecodeCompact() with trailing data expected error, got nil")
+	}
+	data[0] = compactVersion + 1
+	if _, err := DecodeCompact(data); !errors.Is(err, ErrUnknownCompactVersion) {
+		t.Errorf("DecodeCompact() of a newer version error = %v, want %v", err, ErrUnknownCompactVersion)
+	}
+}
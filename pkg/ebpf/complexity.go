@@ -0,0 +1,65 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+const (
+	// loopIterationEstimate is the number of times we assume the verifier
+	// walks the body of a loop before it converges.
+	loopIterationEstimate = 8
+
+	// VerifierComplexityLimit is the maximum number of instructions the
+	// verifier processes before giving up (BPF_COMPLEXITY_LIMIT_INSNS).
+	VerifierComplexityLimit = 1000000
+)
+
+// isConditionalJump returns true if `i` is a jump with two possible
+// successors.
+func isConditionalJump(i *pb.Instruction) bool {
+	jmp, ok := i.Opcode.(*pb.Instruction_JmpOpcode)
+	return ok && IsConditional(jmp.JmpOpcode.OperationCode)
+}
+
+// EstimateComplexity approximates how many instructions the verifier will
+// process while exploring `program`.
+//
+// Every conditional jump leaves one more state the verifier may have to
+// walk the rest of the program with, so each instruction counts once plus
+// once per conditional jump before it, and every back edge makes it walk
+// the loop body loopIterationEstimate more times. This grows linearly with
+// the number of branches rather than doubling with each one, as state
+// pruning keeps most of them from multiplying, and it is far from exact,
+// but it is monotonic in both branches and back edges, which is enough to
+// tell apart programs that are likely to hit VerifierComplexityLimit.
+func EstimateComplexity(program *pb.Program) int {
+	instructions := programInstructions(program)
+	targets := jumpTargets(instructions)
+
+	complexity := 0
+	conditionals := 0
+	for index, inst := range instructions {
+		complexity += 1 + conditionals
+		if isConditionalJump(inst) {
+			conditionals++
+		}
+		if target := targets[index]; target >= 0 && target <= index {
+			complexity += (index - target + 1) * loopIterationEstimate
+		}
+	}
+	return complexity
+}
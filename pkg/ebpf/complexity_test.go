@@ -0,0 +1,67 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+// branchyProgram returns a program with `branches` conditional jumps that
+// fall through to each other, followed by `r0 = 0; exit`.
+func branchyProgram(branches int) *pb.Program {
+	instructions := []*pb.Instruction{}
+	for b := 0; b < branches; b++ {
+		instructions = append(instructions, JmpEQ(R1, 0, 0))
+	}
+	return singleFunctionProgram(append(instructions, Mov64(R0, 0), Exit())...)
+}
+
+func TestEstimateComplexityBranches(t *testing.T) {
+	previous := 0
+	for branches := 0; branches <= 64; branches++ {
+		got := EstimateComplexity(branchyProgram(branches))
+		if got <= previous {
+			t.Errorf("EstimateComplexity() with %d branches = %d, want more than %d with one less", branches, got, previous)
+		}
+		previous = got
+	}
+}
+
+func TestEstimateComplexityBackEdges(t *testing.T) {
+	straight := singleFunctionProgram(Mov64(R0, 0), Add64(R0, 1), JmpGT(R0, 10, 0), Exit())
+	loop := singleFunctionProgram(Mov64(R0, 0), Add64(R0, 1), JmpLT(R0, 10, -2), Exit())
+	nested := singleFunctionProgram(Mov64(R0, 0), Add64(R0, 1), JmpLT(R0, 5, -2), JmpLT(R0, 10, -3), Exit())
+
+	s, l, n := EstimateComplexity(straight), EstimateComplexity(loop), EstimateComplexity(nested)
+	if l <= s {
+		t.Errorf("EstimateComplexity() of a loop = %d, want more than %d without the back edge", l, s)
+	}
+	if n <= l {
+		t.Errorf("EstimateComplexity() with two back edges = %d, want more than %d with one", n, l)
+	}
+}
+
+func TestEstimateComplexityLimit(t *testing.T) {
+	// The dry run skips programs over the limit, a thousand branches in a
+	// row are still under it while one and a half thousand are not.
+	if got := EstimateComplexity(branchyProgram(1000)); got > VerifierComplexityLimit {
+		t.Errorf("EstimateComplexity() with 1000 branches = %d, want at most %d", got, VerifierComplexityLimit)
+	}
+	if got := EstimateComplexity(branchyProgram(1500)); got <= VerifierComplexityLimit {
+		t.Errorf("EstimateComplexity() with 1500 branches = %d, want more than %d", got, VerifierComplexityLimit)
+	}
+}
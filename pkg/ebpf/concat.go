@@ -0,0 +1,141 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrContextClobbered is returned by Concat when the second program
+	// reads the context from R1 but the first one overwrites R1.
+	ErrContextClobbered = errors.New("Second program reads the context from R1 but the first one overwrites it")
+)
+
+// singleFunction returns the instructions of `program`, which must have a
+// single function and no BTF.
+func singleFunction(program *pb.Program) ([]*pb.Instruction, error) {
+	if len(program.Functions) != 1 {
+		return nil, fmt.Errorf("Only programs with a single function can be concatenated, got %d", len(program.Functions))
+	}
+	if len(program.Btf) != 0 || program.Functions[0].FuncInfo != nil {
+		return nil, fmt.Errorf("Programs with BTF can't be concatenated")
+	}
+	instructions := program.Functions[0].Instructions
+	if len(instructions) == 0 {
+		return nil, ErrEmptySequence
+	}
+	if index := functionReference(instructions); index >= 0 {
+		return nil, fmt.Errorf("Instruction %d references another function, can't concatenate", index)
+	}
+	return instructions, nil
+}
+
+// readsContext returns true if R1 is read by `instructions` before being
+// written to, following them in order.
+func readsContext(instructions []*pb.Instruction) bool {
+	for _, inst := range instructions {
+		for _, reg := range registerUses(inst) {
+			if reg == pb.Reg_R1 {
+				return true
+			}
+		}
+		for _, reg := range registerDefs(inst) {
+			if reg == pb.Reg_R1 {
+				return false
+			}
+		}
+	}
+	return false
+}
+
+// Concat returns a program that runs `a` and then `b`: every exit of `a` is
+// turned into a jump to the first instruction of `b`, or removed if it is
+// the last instruction of `a`, and all the jumps are re-linked. The load
+// flags of the result are the ones of both programs.
+//
+// Both programs must have a single function without BTF. Concatenation is
+// rejected with ErrContextClobbered if `b` reads the context from R1 while
+// `a` writes to R1 or calls anything, which clobbers it.
+func Concat(a, b *pb.Program) (*pb.Program, error) {
+	first, err := singleFunction(a)
+	if err != nil {
+		return nil, fmt.Errorf("first program: %w", err)
+	}
+	second, err := singleFunction(b)
+	if err != nil {
+		return nil, fmt.Errorf("second program: %w", err)
+	}
+
+	if readsContext(second) {
+		for _, inst := range first {
+			for _, reg := range registerDefs(inst) {
+				if reg == pb.Reg_R1 {
+					return nil, ErrContextClobbered
+				}
+			}
+		}
+	}
+
+	labeled, err := ToLabeled(first)
+	if err != nil {
+		return nil, err
+	}
+	labeledSecond, err := ToLabeled(second)
+	if err != nil {
+		return nil, err
+	}
+	secondStart := Label(len(first))
+	for _, l := range labeledSecond {
+		l.Label += secondStart
+		if l.Target != NoLabel {
+			l.Target += secondStart
+		}
+		labeled = append(labeled, l)
+	}
+
+	last := len(first) - 1
+	for index := 0; index <= last; index++ {
+		if !isExit(labeled[index].Instruction) {
+			continue
+		}
+		labeled[index].Instruction = Jmp(0)
+		labeled[index].Target = secondStart
+	}
+	if isExit(first[last]) {
+		// The last exit just falls through, jumps to it go straight
+		// to the second program.
+		lastLabel := labeled[last].Label
+		labeled = append(labeled[:last], labeled[last+1:]...)
+		for index := range labeled {
+			if labeled[index].Target == lastLabel {
+				labeled[index].Target = secondStart
+			}
+		}
+	}
+
+	instructions, err := FromLabeled(labeled)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Program{
+		Functions: []*pb.Functions{
+			{Instructions: instructions},
+		},
+		LoadFlags: a.LoadFlags | b.LoadFlags,
+	}, nil
+}
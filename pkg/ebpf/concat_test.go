@@ -0,0 +1,140 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"errors"
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+	protobuf "github.com/golang/protobuf/proto"
+)
+
+func singleFunctionProgram(instructions ...*pb.Instruction) *pb.Program {
+	return &pb.Program{
+		Functions: []*pb.Functions{{Instructions: instructions}},
+	}
+}
+
+func TestConcat(t *testing.T) {
+	tests := []struct {
+		testName  string
+		a, b      *pb.Program
+		want      []*pb.Instruction
+		wantError error
+	}{
+		{
+			testName: "Trailing exit falls through",
+			a: singleFunctionProgram(
+				Mov64(R6, R1),
+				JmpEQ(R6, 0, 1),
+				Mov64(R0, 1),
+				Exit(),
+			),
+			b: singleFunctionProgram(
+				Mov64(R0, 2),
+				Exit(),
+			),
+			want: []*pb.Instruction{
+				Mov64(R6, R1),
+				JmpEQ(R6, 0, 1),
+				Mov64(R0, 1),
+				Mov64(R0, 2),
+				Exit(),
+			},
+		},
+		{
+			testName: "Early exit jumps to the second program",
+			a: singleFunctionProgram(
+				JmpEQ(R1, 0, 2),
+				Mov64(R0, 1),
+				Exit(),
+				Mov64(R0, int64(1)<<40),
+				Exit(),
+			),
+			b: singleFunctionProgram(
+				JmpGT(R0, 3, 0),
+				Exit(),
+			),
+			want: []*pb.Instruction{
+				JmpEQ(R1, 0, 2),
+				Mov64(R0, 1),
+				Jmp(2),
+				Mov64(R0, int64(1)<<40),
+				JmpGT(R0, 3, 0),
+				Exit(),
+			},
+		},
+		{
+			testName: "Second program needs the context",
+			a: singleFunctionProgram(
+				Call(MapLookup),
+				Exit(),
+			),
+			b: singleFunctionProgram(
+				LdW(R0, R1, 0),
+				Exit(),
+			),
+			wantError: ErrContextClobbered,
+		},
+		{
+			testName: "Second program overwrites the context",
+			a: singleFunctionProgram(
+				Mov64(R1, 0),
+				Exit(),
+			),
+			b: singleFunctionProgram(
+				Mov64(R1, 1),
+				Mov64(R0, R1),
+				Exit(),
+			),
+			want: []*pb.Instruction{
+				Mov64(R1, 0),
+				Mov64(R1, 1),
+				Mov64(R0, R1),
+				Exit(),
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got, err := Concat(tc.a, tc.b)
+			if tc.wantError != nil {
+				if !errors.Is(err, tc.wantError) {
+					t.Fatalf("Concat() error = %v, want %v", err, tc.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Concat() unexpected error: %v", err)
+			}
+			instructions := got.Functions[0].Instructions
+			if len(instructions) != len(tc.want) {
+				t.Fatalf("Concat() = %v, want %v", instructions, tc.want)
+			}
+			for i := range tc.want {
+				if !protobuf.Equal(instructions[i], tc.want[i]) {
+					t.Errorf("Concat()[%d] = %v, want %v", i, instructions[i], tc.want[i])
+				}
+			}
+		})
+	}
+
+	twoFunctions := &pb.Program{Functions: []*pb.Functions{{}, {}}}
+	if _, err := Concat(twoFunctions, singleFunctionProgram(Exit())); err == nil {
+		t.Errorf("Concat() with several functions expected error, got nil")
+	}
+}
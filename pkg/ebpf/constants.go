@@ -24,6 +24,25 @@ const (
 
 const (
 	PseudoMapFD = pb.Reg_R1
+	// PseudoMapValue makes a 64-bit immediate load produce a pointer into
+	// the value of the map whose fd is in the first immediate, at the
+	// offset in the second one.
+	PseudoMapValue = pb.Reg_R2
+	// PseudoCall marks a call instruction as a call to another bpf function
+	// instead of to a helper.
+	PseudoCall = pb.Reg_R1
+	// PseudoFunc makes a 64-bit immediate load produce a pointer to a bpf
+	// function, see LdFunctionPtr.
+	PseudoFunc = pb.Reg_R4
+	// PseudoKfuncCall marks a call instruction as a call to the kernel
+	// function whose BTF id is in the immediate, see KfuncCall.
+	PseudoKfuncCall = pb.Reg_R2
+)
+
+const (
+	// MaxKfuncArgs is MAX_BPF_FUNC_REG_ARGS, the verifier rejects kfuncs
+	// with more arguments than fit in R1-R5.
+	MaxKfuncArgs = 5
 )
 
 const (
@@ -45,4 +64,103 @@ const (
 	// MapLookup Map Lookup helper function.
 	MapLookup            = 0x01
 	SkbLoadBytesRelative = 0x44
+	// GetStackId bpf_get_stackid helper function.
+	GetStackId = 0x1b
+	// GetStack bpf_get_stack helper function.
+	GetStack = 0x43
+	// ForEachMapElem bpf_for_each_map_elem helper function.
+	ForEachMapElem = 0xa4
+	// Loop bpf_loop helper function.
+	Loop = 0xb5
+	// CloneRedirect bpf_clone_redirect helper function.
+	CloneRedirect = 0x0d
+	// Redirect bpf_redirect helper function.
+	Redirect = 0x17
+	// DynptrFromMem bpf_dynptr_from_mem helper function.
+	DynptrFromMem = 0xc5
+	// DynptrRead bpf_dynptr_read helper function.
+	DynptrRead = 0xc9
+	// DynptrWrite bpf_dynptr_write helper function.
+	DynptrWrite = 0xca
+	// SkbStoreBytes bpf_skb_store_bytes helper function.
+	SkbStoreBytes = 0x09
+	// L3CsumReplace bpf_l3_csum_replace helper function.
+	L3CsumReplace = 0x0a
+	// L4CsumReplace bpf_l4_csum_replace helper function.
+	L4CsumReplace = 0x0b
+	// PerfEventOutput bpf_perf_event_output helper function.
+	PerfEventOutput = 0x19
+	// GetFuncArg bpf_get_func_arg helper function.
+	GetFuncArg = 0xb7
+	// GetFuncRet bpf_get_func_ret helper function.
+	GetFuncRet = 0xb8
+	// CsumDiff bpf_csum_diff helper function.
+	CsumDiff = 0x1c
+	// GetPrandomU32 bpf_get_prandom_u32 helper function.
+	GetPrandomU32 = 0x07
+	// TracePrintk bpf_trace_printk helper function.
+	TracePrintk = 0x06
+)
+
+const (
+	// DynptrSize is the size of struct bpf_dynptr, the dynptr helpers
+	// expect a pointer to a stack slot of this size.
+	DynptrSize = 16
+)
+
+const (
+	// RedirectIngress is BPF_F_INGRESS, it makes the redirect helpers send
+	// the packet to the ingress path of the target device instead of to
+	// its egress path.
+	RedirectIngress = 0x01
+)
+
+const (
+	// StoreBytesRecomputeCsum is BPF_F_RECOMPUTE_CSUM, it makes
+	// skb_store_bytes update skb->csum for the written bytes.
+	StoreBytesRecomputeCsum = 0x01
+	// StoreBytesInvalidateHash is BPF_F_INVALIDATE_HASH, it clears
+	// skb->hash after the write.
+	StoreBytesInvalidateHash = 0x02
+
+	// CsumPseudoHdr is BPF_F_PSEUDO_HDR, it tells l4_csum_replace that the
+	// replaced field is part of the pseudo header.
+	CsumPseudoHdr = 0x10
+	// CsumMarkMangled0 is BPF_F_MARK_MANGLED_0, it makes l4_csum_replace
+	// keep a zero UDP checksum as is.
+	CsumMarkMangled0 = 0x20
+)
+
+const (
+	// PerfEventCurrentCpu is BPF_F_CURRENT_CPU, it makes perf_event_output
+	// write to the perf buffer of the current CPU instead of the one at a
+	// fixed index of the map.
+	PerfEventCurrentCpu = 0xffffffff
+)
+
+// XdpAction is the value a BPF_PROG_TYPE_XDP program returns in R0 to tell
+// the kernel what to do with the packet.
+type XdpAction int32
+
+const (
+	XdpAborted  XdpAction = 0
+	XdpDrop     XdpAction = 1
+	XdpPass     XdpAction = 2
+	XdpTx       XdpAction = 3
+	XdpRedirect XdpAction = 4
+)
+
+// TcAction is the value a tc classifier or action program returns in R0.
+type TcAction int32
+
+const (
+	TcActUnspec     TcAction = -1
+	TcActOk         TcAction = 0
+	TcActReclassify TcAction = 1
+	TcActShot       TcAction = 2
+	TcActPipe       TcAction = 3
+	TcActStolen     TcAction = 4
+	TcActQueued     TcAction = 5
+	TcActRepeat     TcAction = 6
+	TcActRedirect   TcAction = 7
 )
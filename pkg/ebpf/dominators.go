@@ -0,0 +1,145 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+// instructionSuccessors returns the indexes of the instructions that can run
+// right after the one at `index`, given the `targets` of the jumps.
+func instructionSuccessors(instructions []*pb.Instruction, targets []int, index int) []int {
+	successors := []int{}
+	inst := instructions[index]
+	if targets[index] >= 0 {
+		successors = append(successors, targets[index])
+	}
+	if !isExit(inst) && !(isJump(inst) && !isConditionalJump(inst)) && index+1 < len(instructions) {
+		successors = append(successors, index+1)
+	}
+	return successors
+}
+
+// ImmediateDominators returns, for every instruction, the index of its
+// immediate dominator: the closest instruction that runs on every path from
+// the start of the program to it. The first instruction is its own
+// immediate dominator and unreachable instructions have -1.
+//
+// This is the algorithm from "A Simple, Fast Dominance Algorithm" by Cooper,
+// Harvey and Kennedy, which is fast enough to run on every generated
+// program.
+func ImmediateDominators(instructions []*pb.Instruction) []int {
+	idom := make([]int, len(instructions))
+	for index := range idom {
+		idom[index] = -1
+	}
+	if len(instructions) == 0 {
+		return idom
+	}
+
+	targets := jumpTargets(instructions)
+	successors := make([][]int, len(instructions))
+	predecessors := make([][]int, len(instructions))
+	for index := range instructions {
+		successors[index] = instructionSuccessors(instructions, targets, index)
+		for _, next := range successors[index] {
+			predecessors[next] = append(predecessors[next], index)
+		}
+	}
+
+	// Number the reachable instructions in postorder.
+	postorder := []int{}
+	order := make([]int, len(instructions))
+	visited := make([]bool, len(instructions))
+	type frame struct{ index, next int }
+	stack := []frame{{0, 0}}
+	visited[0] = true
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if top.next < len(successors[top.index]) {
+			next := successors[top.index][top.next]
+			top.next++
+			if !visited[next] {
+				visited[next] = true
+				stack = append(stack, frame{next, 0})
+			}
+			continue
+		}
+		order[top.index] = len(postorder)
+		postorder = append(postorder, top.index)
+		stack = stack[:len(stack)-1]
+	}
+
+	intersect := func(a, b int) int {
+		for a != b {
+			for order[a] < order[b] {
+				a = idom[a]
+			}
+			for order[b] < order[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+
+	idom[0] = 0
+	for changed := true; changed; {
+		changed = false
+		// Reverse postorder, skipping the first instruction.
+		for i := len(postorder) - 2; i >= 0; i-- {
+			index := postorder[i]
+			newIdom := -1
+			for _, pred := range predecessors[index] {
+				if idom[pred] < 0 {
+					continue
+				}
+				if newIdom < 0 {
+					newIdom = pred
+				} else {
+					newIdom = intersect(pred, newIdom)
+				}
+			}
+			if idom[index] != newIdom {
+				idom[index] = newIdom
+				changed = true
+			}
+		}
+	}
+	return idom
+}
+
+// Dominators returns, for every reachable instruction of `instructions`, the
+// indexes of the instructions that dominate it, i.e. that run on every path
+// from the start of the program to it, from the first instruction down to
+// the instruction itself. An instruction that dominates another always runs
+// before it, e.g. anything it defines is initialized when the other runs.
+func Dominators(instructions []*pb.Instruction) map[int][]int {
+	idom := ImmediateDominators(instructions)
+	dominators := make(map[int][]int)
+	for index := range instructions {
+		if idom[index] < 0 {
+			continue
+		}
+		chain := []int{index}
+		for current := index; current != 0; current = idom[current] {
+			chain = append(chain, idom[current])
+		}
+		for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+			chain[i], chain[j] = chain[j], chain[i]
+		}
+		dominators[index] = chain
+	}
+	return dominators
+}
@@ -0,0 +1,90 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"reflect"
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+func TestDominators(t *testing.T) {
+	tests := []struct {
+		testName     string
+		instructions []*pb.Instruction
+		want         map[int][]int
+	}{
+		{
+			testName:     "Straight line",
+			instructions: []*pb.Instruction{Mov64(R0, 0), Mov64(R1, 1), Exit()},
+			want:         map[int][]int{0: {0}, 1: {0, 1}, 2: {0, 1, 2}},
+		},
+		{
+			testName: "Diamond",
+			instructions: []*pb.Instruction{
+				JmpEQ(R1, 0, 2),
+				Mov64(R0, 1),
+				Jmp(1),
+				Mov64(R0, 2),
+				Exit(),
+			},
+			want: map[int][]int{0: {0}, 1: {0, 1}, 2: {0, 1, 2}, 3: {0, 3}, 4: {0, 4}},
+		},
+		{
+			testName: "Loop",
+			instructions: []*pb.Instruction{
+				Mov64(R0, 0),
+				Add64(R0, 1),
+				JmpLT(R0, 10, -2),
+				Exit(),
+			},
+			want: map[int][]int{0: {0}, 1: {0, 1}, 2: {0, 1, 2}, 3: {0, 1, 2, 3}},
+		},
+		{
+			testName: "Unreachable code",
+			instructions: []*pb.Instruction{
+				Mov64(R0, int64(1)<<40),
+				Exit(),
+				Mov64(R0, 0),
+				Exit(),
+			},
+			want: map[int][]int{0: {0}, 1: {0, 1}},
+		},
+		{
+			testName: "Jump over a wide instruction",
+			instructions: []*pb.Instruction{
+				JmpEQ(R1, 0, 2),
+				Mov64(R0, int64(1)<<40),
+				Exit(),
+			},
+			want: map[int][]int{0: {0}, 1: {0, 1}, 2: {0, 2}},
+		},
+		{
+			testName:     "Empty program",
+			instructions: []*pb.Instruction{},
+			want:         map[int][]int{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got := Dominators(tc.instructions)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Dominators() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
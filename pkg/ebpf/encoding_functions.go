@@ -103,6 +103,12 @@ func EncodeInstructions(program *pb.Program) ([]byte, []byte, error) {
 // To understand what each part of the encoding mean, please refer to
 // http://shortn/_mFOBeQLg2s.
 func encodeInstruction(i *pb.Instruction) ([]uint64, error) {
+	return appendInstruction(nil, i)
+}
+
+// appendInstruction appends the encoding of `i` to `buf` and returns the
+// extended slice, like the builtin append.
+func appendInstruction(buf []uint64, i *pb.Instruction) ([]uint64, error) {
 	encoding := uint64(0)
 
 	opcode := uint8(0)
@@ -150,18 +156,162 @@ func encodeInstruction(i *pb.Instruction) ([]uint64, error) {
 
 	encoding |= (uint64(i.Immediate) << 32)
 
-	result := []uint64{encoding}
+	buf = append(buf, encoding)
 	switch p := i.PseudoInstruction.(type) {
 	// For instructions requiring wide encoding, like 64-bit immediates, we
 	// use PseudoValue
 	case *pb.Instruction_PseudoValue:
-		resultPseudoValue, err := encodeInstruction(p.PseudoValue)
+		// Only the first word of the pseudo value is used.
+		n := len(buf)
+		buf, err = appendInstruction(buf, p.PseudoValue)
 		if err != nil {
 			return nil, err
 		}
-		result = append(result, resultPseudoValue[0])
+		buf = buf[:n+1]
 	}
-	return result, nil
+	return buf, nil
+}
+
+// decodeWord is the inverse of encodeInstruction for a single word, it
+// ignores any pseudo instruction that might follow.
+func decodeWord(word uint64) *pb.Instruction {
+	opcode := uint8(word)
+	insClass := pb.InsClass(opcode & 0x07)
+	i := &pb.Instruction{
+		DstReg:    pb.Reg((word >> 8) & 0x0f),
+		SrcReg:    pb.Reg((word >> 12) & 0x0f),
+		Offset:    int32(int16(word >> 16)),
+		Immediate: int32(word >> 32),
+		PseudoInstruction: &pb.Instruction_Empty{
+			Empty: &pb.Empty{},
+		},
+	}
+	switch insClass {
+	case pb.InsClass_InsClassAlu, pb.InsClass_InsClassAlu64:
+		i.Opcode = &pb.Instruction_AluOpcode{
+			AluOpcode: &pb.AluOpcode{
+				OperationCode:    pb.AluOperationCode(opcode & 0xf0),
+				Source:           pb.SrcOperand(opcode & 0x08),
+				InstructionClass: insClass,
+			},
+		}
+	case pb.InsClass_InsClassJmp, pb.InsClass_InsClassJmp32:
+		i.Opcode = &pb.Instruction_JmpOpcode{
+			JmpOpcode: &pb.JmpOpcode{
+				OperationCode:    pb.JmpOperationCode(opcode & 0xf0),
+				Source:           pb.SrcOperand(opcode & 0x08),
+				InstructionClass: insClass,
+			},
+		}
+	default:
+		i.Opcode = &pb.Instruction_MemOpcode{
+			MemOpcode: &pb.MemOpcode{
+				Mode:             pb.StLdMode(opcode & 0xe0),
+				Size:             pb.StLdSize(opcode & 0x18),
+				InstructionClass: insClass,
+			},
+		}
+	}
+	return i
+}
+
+// RawInstructions turns already encoded instruction words, e.g. copied from
+// a kernel report, into instructions that can be spliced into a generated
+// program. The returned instructions encode back to exactly `words`, no
+// matter if the opcodes are valid or not.
+//
+// A `BPF_LD | BPF_IMM | BPF_DW` word takes the word after it as its pseudo
+// instruction, the same way the kernel decodes it.
+func RawInstructions(words ...uint64) ([]*pb.Instruction, error) {
+	instructions := []*pb.Instruction{}
+	for index := 0; index < len(words); index++ {
+		i := decodeWord(words[index])
+		if isLdImm64(i) {
+			if index+1 >= len(words) {
+				return nil, fmt.Errorf("Wide instruction at word %d is missing its second half", index)
+			}
+			index++
+			i.PseudoInstruction = &pb.Instruction_PseudoValue{
+				PseudoValue: decodeWord(words[index]),
+			}
+		}
+		instructions = append(instructions, i)
+	}
+	return InstructionSequence(instructions...)
+}
+
+// ProgramFromBytecode wraps encoded instruction words, e.g. produced by
+// another tool, in a program that can be analyzed and mutated like a
+// generated one. The words are decoded like in RawInstructions and split
+// into one function per target of a bpf to bpf call or function pointer
+// load, so the result encodes back to exactly `words`.
+//
+// ErrInvalidJumpTarget is returned if a jump or a function reference
+// doesn't land on an instruction of the program.
+func ProgramFromBytecode(words []uint64) (*pb.Program, error) {
+	instructions, err := RawInstructions(words...)
+	if err != nil {
+		return nil, err
+	}
+	for index, target := range jumpTargets(instructions) {
+		if target < 0 && isJump(instructions[index]) {
+			return nil, fmt.Errorf("instruction %d: %w", index, ErrInvalidJumpTarget)
+		}
+	}
+
+	slots := slotIndexes(instructions)
+	indexForSlot := make(map[int]int, len(slots))
+	for index, slot := range slots {
+		indexForSlot[slot] = index
+	}
+	starts := map[int]bool{0: true}
+	for index, i := range instructions {
+		if !(isCall(i) && i.SrcReg == PseudoCall) && !(isLdImm64(i) && i.SrcReg == PseudoFunc) {
+			continue
+		}
+		target, ok := indexForSlot[slots[index]+1+int(i.Immediate)]
+		if !ok {
+			return nil, fmt.Errorf("instruction %d: %w", index, ErrInvalidJumpTarget)
+		}
+		starts[target] = true
+	}
+
+	program := &pb.Program{}
+	for index, i := range instructions {
+		if starts[index] {
+			program.Functions = append(program.Functions, &pb.Functions{})
+		}
+		function := program.Functions[len(program.Functions)-1]
+		function.Instructions = append(function.Instructions, i)
+	}
+	return program, nil
+}
+
+// RawOpcodeInstruction returns an instruction that encodes to exactly the
+// given fields, `opcode` being the whole opcode byte (class, source or size
+// and operation or mode). Unlike the structured constructors no combination
+// is rejected, which allows generating reserved or undefined opcodes to
+// test how the verifier decodes them.
+//
+// Only the low 4 bits of the registers are encoded, like in the kernel.
+// Wide instructions need their second half, build them with RawInstructions.
+func RawOpcodeInstruction(opcode uint8, dst, src pb.Reg, offset int16, immediate int32) *pb.Instruction {
+	word := uint64(opcode)
+	word |= uint64(dst&0x0f) << 8
+	word |= uint64(src&0x0f) << 12
+	word |= uint64(uint16(offset)) << 16
+	word |= uint64(uint32(immediate)) << 32
+	return decodeWord(word)
+}
+
+// RegisterName returns the name the kernel disassembler uses for `reg`:
+// `wN` when only its lower 32 bits are used by the instruction, e.g. in
+// BPF_ALU and BPF_JMP32 instructions, and `rN` otherwise.
+func RegisterName(reg pb.Reg, is32 bool) string {
+	if is32 {
+		return fmt.Sprintf("w%d", reg)
+	}
+	return fmt.Sprintf("r%d", reg)
 }
 
 // GetBpfFuncName returns the C macro name of the provided bpf helper function.
@@ -169,6 +319,44 @@ func GetBpfFuncName(funcNumber int32) string {
 	switch funcNumber {
 	case MapLookup:
 		return "BPF_FUNC_map_lookup_elem"
+	case SkbLoadBytesRelative:
+		return "BPF_FUNC_skb_load_bytes_relative"
+	case GetStackId:
+		return "BPF_FUNC_get_stackid"
+	case GetStack:
+		return "BPF_FUNC_get_stack"
+	case ForEachMapElem:
+		return "BPF_FUNC_for_each_map_elem"
+	case Loop:
+		return "BPF_FUNC_loop"
+	case CloneRedirect:
+		return "BPF_FUNC_clone_redirect"
+	case Redirect:
+		return "BPF_FUNC_redirect"
+	case DynptrFromMem:
+		return "BPF_FUNC_dynptr_from_mem"
+	case DynptrRead:
+		return "BPF_FUNC_dynptr_read"
+	case DynptrWrite:
+		return "BPF_FUNC_dynptr_write"
+	case SkbStoreBytes:
+		return "BPF_FUNC_skb_store_bytes"
+	case L3CsumReplace:
+		return "BPF_FUNC_l3_csum_replace"
+	case L4CsumReplace:
+		return "BPF_FUNC_l4_csum_replace"
+	case PerfEventOutput:
+		return "BPF_FUNC_perf_event_output"
+	case GetFuncArg:
+		return "BPF_FUNC_get_func_arg"
+	case GetFuncRet:
+		return "BPF_FUNC_get_func_ret"
+	case CsumDiff:
+		return "BPF_FUNC_csum_diff"
+	case GetPrandomU32:
+		return "BPF_FUNC_get_prandom_u32"
+	case TracePrintk:
+		return "BPF_FUNC_trace_printk"
 	default:
 		return "unknown"
 	}
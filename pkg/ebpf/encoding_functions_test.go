@@ -0,0 +1,175 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"reflect"
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+func TestRawInstructions(t *testing.T) {
+	tests := []struct {
+		testName  string
+		words     []uint64
+		wantLen   int
+		wantError bool
+	}{
+		{
+			testName: "Valid instructions",
+			words:    []uint64{0x00000001000000b7, 0x0000000000000095},
+			wantLen:  2,
+		},
+		{
+			testName: "Fields unused by the opcode are preserved",
+			words:    []uint64{0x0000002a0005f1b7, 0x00000000ffff0095},
+			wantLen:  2,
+		},
+		{
+			testName: "Wide instruction",
+			words:    []uint64{0x0000000100000018, 0x0000000200000000, 0x0000000000000095},
+			wantLen:  2,
+		},
+		{
+			testName:  "Truncated wide instruction",
+			words:     []uint64{0x0000000100000018},
+			wantError: true,
+		},
+		{
+			testName:  "No words",
+			words:     []uint64{},
+			wantError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			instructions, err := RawInstructions(tc.words...)
+			if tc.wantError {
+				if err == nil {
+					t.Fatalf("RawInstructions() expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RawInstructions() unexpected error: %v", err)
+			}
+			if len(instructions) != tc.wantLen {
+				t.Errorf("len(RawInstructions()) = %d, want %d", len(instructions), tc.wantLen)
+			}
+
+			encoder := NewBatchEncoder(len(tc.words))
+			got, err := encoder.Encode(&pb.Program{Functions: []*pb.Functions{{Instructions: instructions}}})
+			if err != nil {
+				t.Fatalf("Encode() unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.words) {
+				t.Errorf("Encode(RawInstructions()) = %x, want %x", got, tc.words)
+			}
+		})
+	}
+}
+
+func TestProgramFromBytecode(t *testing.T) {
+	words := []uint64{
+		0x00000001000001b7, // r1 = 1
+		0x0000000200001085, // call pc+2
+		0x00000000000000b7, // r0 = 0
+		0x0000000000000095, // exit
+		0x0000000100000018, // r0 = 0x200000001
+		0x0000000200000000,
+		0x0000000000000095, // exit
+	}
+	program, err := ProgramFromBytecode(words)
+	if err != nil {
+		t.Fatalf("ProgramFromBytecode() unexpected error: %v", err)
+	}
+	if len(program.Functions) != 2 {
+		t.Fatalf("ProgramFromBytecode() has %d functions, want 2", len(program.Functions))
+	}
+	if got := len(program.Functions[1].Instructions); got != 2 {
+		t.Errorf("ProgramFromBytecode() second function has %d instructions, want 2", got)
+	}
+	got, err := NewBatchEncoder(len(words)).Encode(program)
+	if err != nil {
+		t.Fatalf("Encode() unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, words) {
+		t.Errorf("Encode(ProgramFromBytecode()) = %x, want %x", got, words)
+	}
+
+	invalid := map[string][]uint64{
+		"Jump past the end":     {0x0000000000010005, 0x0000000000000095},
+		"Call past the end":     {0x0000000500001085, 0x0000000000000095},
+		"Jump into a wide load": {0x0000000000010005, 0x0000000100000018, 0x0000000200000000, 0x0000000000000095},
+		"Truncated wide load":   {0x0000000100000018},
+	}
+	for name, words := range invalid {
+		if _, err := ProgramFromBytecode(words); err == nil {
+			t.Errorf("ProgramFromBytecode() of %s expected error, got nil", name)
+		}
+	}
+}
+
+func TestRawOpcodeInstruction(t *testing.T) {
+	tests := []struct {
+		testName    string
+		instruction *pb.Instruction
+		want        uint64
+	}{
+		{
+			testName:    "Same as a structured constructor",
+			instruction: RawOpcodeInstruction(0xb7, R1, R0, 0, 1),
+			want:        0x00000001000001b7,
+		},
+		{
+			testName:    "Undefined ALU operation",
+			instruction: RawOpcodeInstruction(0xe7, R2, R3, -1, -2),
+			want:        0xfffffffeffff32e7,
+		},
+		{
+			testName:    "Reserved memory mode",
+			instruction: RawOpcodeInstruction(0xe1, R10, R1, 8, 0),
+			want:        0x0000000000081ae1,
+		},
+		{
+			testName:    "Invalid register number",
+			instruction: RawOpcodeInstruction(0x95, pb.Reg(15), pb.Reg(11), 0, 0),
+			want:        0x000000000000bf95,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got, err := encodeInstruction(tc.instruction)
+			if err != nil {
+				t.Fatalf("encodeInstruction() unexpected error: %v", err)
+			}
+			if len(got) != 1 || got[0] != tc.want {
+				t.Errorf("encodeInstruction() = %x, want %x", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRegisterName(t *testing.T) {
+	if got := RegisterName(R1, false); got != "r1" {
+		t.Errorf("RegisterName(R1, false) = %q, want %q", got, "r1")
+	}
+	if got := RegisterName(R10, true); got != "w10" {
+		t.Errorf("RegisterName(R10, true) = %q, want %q", got, "w10")
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	btfpb "buzzer/proto/btf_go_proto"
+	pb "buzzer/proto/ebpf_go_proto"
+	"bytes"
+	"fmt"
+	"strings"
+
+	protobuf "github.com/golang/protobuf/proto"
+)
+
+// ProgramsEqual reports whether `a` and `b` are the same program: same
+// functions, func infos, BTF and load flags, and instructions that encode
+// to the same words. Comparing the encoding instead of the protos means
+// that metadata that never reaches the kernel, like pinning, is ignored.
+//
+// Jumps are compared by their offsets, so two programs with the same
+// branch structure are equal no matter how they were built.
+func ProgramsEqual(a, b *pb.Program) bool {
+	return ProgramDiff(a, b) == ""
+}
+
+// ProgramDiff returns a human readable description of the differences
+// between `got` and `want`, one per line, or an empty string if they are
+// equal according to ProgramsEqual. Instructions are printed like in
+// DumpXlated.
+func ProgramDiff(got, want *pb.Program) string {
+	var sb strings.Builder
+	if !bytes.Equal(got.Btf, want.Btf) {
+		sb.WriteString(fmt.Sprintf("btf: got %d bytes, want %d bytes\n", len(got.Btf), len(want.Btf)))
+	}
+	if got.LoadFlags != want.LoadFlags {
+		sb.WriteString(fmt.Sprintf("load flags: got %#x, want %#x\n", got.LoadFlags, want.LoadFlags))
+	}
+	if len(got.Functions) != len(want.Functions) {
+		sb.WriteString(fmt.Sprintf("got %d functions, want %d\n", len(got.Functions), len(want.Functions)))
+	}
+
+	for f := 0; f < len(got.Functions) && f < len(want.Functions); f++ {
+		gotFunction, wantFunction := got.Functions[f], want.Functions[f]
+		if !protobuf.Equal(gotFunction.FuncInfo, wantFunction.FuncInfo) {
+			sb.WriteString(fmt.Sprintf("function %d: got func info %s, want %s\n", f, funcInfoText(gotFunction.FuncInfo), funcInfoText(wantFunction.FuncInfo)))
+		}
+		gotInstructions, wantInstructions := gotFunction.Instructions, wantFunction.Instructions
+		for index := 0; index < len(gotInstructions) || index < len(wantInstructions); index++ {
+			switch {
+			case index >= len(wantInstructions):
+				sb.WriteString(fmt.Sprintf("function %d, instruction %d: got %s, want nothing\n", f, index, instructionText(gotInstructions[index])))
+			case index >= len(gotInstructions):
+				sb.WriteString(fmt.Sprintf("function %d, instruction %d: got nothing, want %s\n", f, index, instructionText(wantInstructions[index])))
+			case !instructionsEqual(gotInstructions[index], wantInstructions[index]):
+				sb.WriteString(fmt.Sprintf("function %d, instruction %d: got %s, want %s\n", f, index, instructionText(gotInstructions[index]), instructionText(wantInstructions[index])))
+			}
+		}
+	}
+	return sb.String()
+}
+
+func funcInfoText(info *btfpb.FuncInfo) string {
+	if info == nil {
+		return "none"
+	}
+	return fmt.Sprintf("{insn_off: %d, type_id: %d}", info.InsnOff, info.TypeId)
+}
+
+// instructionsEqual compares the encoding of `a` and `b`, falling back to
+// comparing the protos if either of them can't be encoded.
+func instructionsEqual(a, b *pb.Instruction) bool {
+	aWords, aErr := encodeInstruction(a)
+	bWords, bErr := encodeInstruction(b)
+	if aErr != nil || bErr != nil {
+		return protobuf.Equal(a, b)
+	}
+	if len(aWords) != len(bWords) {
+		return false
+	}
+	for index := range aWords {
+		if aWords[index] != bWords[index] {
+			return false
+		}
+	}
+	return true
+}
+
+// instructionText returns `i` as printed by DumpXlated, or the proto text
+// if it can't be encoded.
+func instructionText(i *pb.Instruction) string {
+	words, err := encodeInstruction(i)
+	if err != nil {
+		return i.String()
+	}
+	var next *xlatedWord
+	if len(words) > 1 {
+		w := newXlatedWord(words[1])
+		next = &w
+	}
+	return xlatedInstruction(newXlatedWord(words[0]), next)
+}
@@ -0,0 +1,90 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	btfpb "buzzer/proto/btf_go_proto"
+	pb "buzzer/proto/ebpf_go_proto"
+	"testing"
+)
+
+func TestProgramDiff(t *testing.T) {
+	newProgram := func(instructions ...*pb.Instruction) *pb.Program {
+		return &pb.Program{Functions: []*pb.Functions{{Instructions: instructions}}}
+	}
+	words, err := encodeInstruction(Mov64(R0, int64(1)<<40))
+	if err != nil {
+		t.Fatalf("encodeInstruction() unexpected error: %v", err)
+	}
+	raw, err := RawInstructions(words...)
+	if err != nil {
+		t.Fatalf("RawInstructions() unexpected error: %v", err)
+	}
+	withFuncInfo := newProgram(Mov64(R0, 0), Exit())
+	withFuncInfo.Functions[0].FuncInfo = &btfpb.FuncInfo{InsnOff: 0, TypeId: 3}
+
+	tests := []struct {
+		testName string
+		got      *pb.Program
+		want     *pb.Program
+		wantDiff string
+	}{
+		{
+			testName: "Same program",
+			got:      newProgram(JmpEQ(R1, 0, 1), Mov64(R0, 1), Exit()),
+			want:     newProgram(JmpEQ(R1, 0, 1), Mov64(R0, 1), Exit()),
+		},
+		{
+			testName: "Built differently",
+			got:      newProgram(raw[0], Pin(Exit())),
+			want:     newProgram(Mov64(R0, int64(1)<<40), Exit()),
+		},
+		{
+			testName: "Different jump offset",
+			got:      newProgram(JmpEQ(R1, 0, 0), Mov64(R0, 1), Exit()),
+			want:     newProgram(JmpEQ(R1, 0, 1), Mov64(R0, 1), Exit()),
+			wantDiff: "function 0, instruction 0: got (15) if r1 == 0x0 goto pc+0, want (15) if r1 == 0x0 goto pc+1\n",
+		},
+		{
+			testName: "Different class",
+			got:      newProgram(Mov(R0, 1), Exit()),
+			want:     newProgram(Mov64(R0, 1), Exit()),
+			wantDiff: "function 0, instruction 0: got (b4) w0 = 1, want (b7) r0 = 1\n",
+		},
+		{
+			testName: "Missing instruction",
+			got:      newProgram(Mov64(R0, 1)),
+			want:     newProgram(Mov64(R0, 1), Exit()),
+			wantDiff: "function 0, instruction 1: got nothing, want (95) exit\n",
+		},
+		{
+			testName: "Different metadata",
+			got:      &pb.Program{LoadFlags: 1, Functions: withFuncInfo.Functions},
+			want:     newProgram(Mov64(R0, 0), Exit()),
+			wantDiff: "load flags: got 0x1, want 0x0\nfunction 0: got func info {insn_off: 0, type_id: 3}, want none\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			if got := ProgramDiff(tc.got, tc.want); got != tc.wantDiff {
+				t.Errorf("ProgramDiff() = %q, want %q", got, tc.wantDiff)
+			}
+			if got := ProgramsEqual(tc.got, tc.want); got != (tc.wantDiff == "") {
+				t.Errorf("ProgramsEqual() = %v, want %v", got, tc.wantDiff == "")
+			}
+		})
+	}
+}
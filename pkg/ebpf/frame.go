@@ -0,0 +1,84 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+	"errors"
+	"fmt"
+
+	protobuf "github.com/golang/protobuf/proto"
+)
+
+var (
+	// ErrPrologueExits is returned by Frame.Wrap when the prologue ends
+	// with an exit, which would make the body unreachable.
+	ErrPrologueExits = errors.New("Prologue ends with an exit")
+)
+
+// Frame holds a fixed prologue and epilogue that generated bodies are
+// wrapped in, e.g. a map lookup and null check before and a store of the
+// result after, so fuzzing can focus on the code in between.
+type Frame struct {
+	prologue []*pb.Instruction
+	epilogue []*pb.Instruction
+}
+
+// SetPrologue sets the instructions that run before the body. Exits in the
+// prologue are kept, so it can bail out early, but it must fall through to
+// the body at its end.
+func (f *Frame) SetPrologue(seq []*pb.Instruction) {
+	f.prologue = seq
+}
+
+// SetEpilogue sets the instructions that run after the body, every exit of
+// the body is turned into a jump to them. An empty epilogue leaves the body
+// exits untouched.
+func (f *Frame) SetEpilogue(seq []*pb.Instruction) {
+	f.epilogue = seq
+}
+
+// pinnedCopy returns a pinned clone of every instruction in `seq`, so the
+// instructions of the frame can be shared between programs.
+func pinnedCopy(seq []*pb.Instruction) []*pb.Instruction {
+	copied := make([]*pb.Instruction, len(seq))
+	for index, inst := range seq {
+		copied[index] = Pin(protobuf.Clone(inst).(*pb.Instruction))
+	}
+	return copied
+}
+
+// Wrap returns `body` between the prologue and the epilogue, with the jumps
+// of the body re-linked the way Concat does it. The instructions of the
+// prologue and epilogue are pinned copies, so mutations only touch the
+// body.
+func (f *Frame) Wrap(body []*pb.Instruction) ([]*pb.Instruction, error) {
+	if len(f.prologue) > 0 && isExit(f.prologue[len(f.prologue)-1]) {
+		return nil, ErrPrologueExits
+	}
+
+	wrapped := body
+	if len(f.epilogue) > 0 {
+		program, err := Concat(
+			&pb.Program{Functions: []*pb.Functions{{Instructions: body}}},
+			&pb.Program{Functions: []*pb.Functions{{Instructions: pinnedCopy(f.epilogue)}}},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("appending the epilogue: %w", err)
+		}
+		wrapped = program.Functions[0].Instructions
+	}
+	return append(pinnedCopy(f.prologue), wrapped...), nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"errors"
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+func TestFrameWrap(t *testing.T) {
+	prologue := []*pb.Instruction{
+		Call(GetPrandomU32),
+		JmpNE(R0, 0, 1),
+		Exit(),
+		Mov64(R6, R0),
+	}
+	epilogue := []*pb.Instruction{
+		Add64(R0, R6),
+		Exit(),
+	}
+	body := []*pb.Instruction{
+		Mov64(R0, 1),
+		JmpEQ(R6, 1, 1),
+		Exit(),
+		Mov64(R0, 2),
+		Exit(),
+	}
+
+	frame := &Frame{}
+	frame.SetPrologue(prologue)
+	frame.SetEpilogue(epilogue)
+	got, err := frame.Wrap(body)
+	if err != nil {
+		t.Fatalf("Wrap() unexpected error: %v", err)
+	}
+
+	want := []*pb.Instruction{
+		Call(GetPrandomU32),
+		JmpNE(R0, 0, 1),
+		Exit(),
+		Mov64(R6, R0),
+		Mov64(R0, 1),
+		JmpEQ(R6, 1, 1),
+		Jmp(1),
+		Mov64(R0, 2),
+		Add64(R0, R6),
+		Exit(),
+	}
+	if diff := ProgramDiff(singleFunctionProgram(got...), singleFunctionProgram(want...)); diff != "" {
+		t.Fatalf("Wrap() returned a different program:\n%s", diff)
+	}
+	for index, inst := range got {
+		wantPinned := index < len(prologue) || index >= len(got)-len(epilogue)
+		if inst.Pinned != wantPinned {
+			t.Errorf("instruction %d pinned = %v, want %v", index, inst.Pinned, wantPinned)
+		}
+	}
+	if prologue[0].Pinned || epilogue[0].Pinned {
+		t.Errorf("Wrap() pinned the instructions passed to the frame")
+	}
+
+	// Without an epilogue the body keeps its exits.
+	frame.SetEpilogue(nil)
+	got, err = frame.Wrap(body)
+	if err != nil {
+		t.Fatalf("Wrap() unexpected error: %v", err)
+	}
+	want = append(append([]*pb.Instruction{}, prologue...), body...)
+	if diff := ProgramDiff(singleFunctionProgram(got...), singleFunctionProgram(want...)); diff != "" {
+		t.Errorf("Wrap() without an epilogue returned a different program:\n%s", diff)
+	}
+
+	frame.SetPrologue([]*pb.Instruction{Mov64(R0, 0), Exit()})
+	if _, err := frame.Wrap(body); !errors.Is(err, ErrPrologueExits) {
+		t.Errorf("Wrap() with an exiting prologue error = %v, want %v", err, ErrPrologueExits)
+	}
+}
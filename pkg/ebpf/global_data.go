@@ -0,0 +1,64 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+// globalDataAlignment is the alignment of every constant added to a
+// GlobalData section, so they can be read with any load size.
+const globalDataAlignment = 8
+
+// GlobalData models a global data section like .rodata or .data. In a
+// compiled program these live in the single value of an array map, and
+// instructions reference them through LdMapValue.
+//
+// Creating the backing map is up to the caller: it needs to be an array map
+// with one entry whose value size is len(Bytes()), initialized to Bytes()
+// and, for .rodata, frozen so the verifier treats it as read only. Its fd
+// then goes in Fd.
+type GlobalData struct {
+	// Fd is the fd of the map backing the section, used by Ref.
+	Fd int
+
+	data []byte
+}
+
+// AddGlobalConst appends `value` to the section and returns the offset at
+// which it was placed.
+func (g *GlobalData) AddGlobalConst(value []byte) int {
+	for len(g.data)%globalDataAlignment != 0 {
+		g.data = append(g.data, 0)
+	}
+	offset := len(g.data)
+	g.data = append(g.data, value...)
+	return offset
+}
+
+// Bytes returns a copy of the contents of the section, padded to its
+// alignment.
+func (g *GlobalData) Bytes() []byte {
+	for len(g.data)%globalDataAlignment != 0 {
+		g.data = append(g.data, 0)
+	}
+	return append([]byte{}, g.data...)
+}
+
+// Ref loads into `dst` a pointer to the constant at `offset` in the section,
+// once it has been loaded into the map in Fd.
+func (g *GlobalData) Ref(dst pb.Reg, offset int) *pb.Instruction {
+	return LdMapValue(dst, g.Fd, int32(offset))
+}
@@ -0,0 +1,52 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGlobalData(t *testing.T) {
+	g := &GlobalData{}
+	if offset := g.AddGlobalConst([]byte{1, 2, 3}); offset != 0 {
+		t.Errorf("AddGlobalConst() = %d, want 0", offset)
+	}
+	if offset := g.AddGlobalConst([]byte{4}); offset != 8 {
+		t.Errorf("AddGlobalConst() = %d, want 8", offset)
+	}
+
+	want := []byte{1, 2, 3, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0, 0, 0, 0}
+	if got := g.Bytes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Bytes() = %v, want %v", got, want)
+	}
+
+	// Changing what Bytes returned doesn't change the section.
+	g.Bytes()[0] = 9
+	if got := g.Bytes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Bytes() after changing a previous result = %v, want %v", got, want)
+	}
+
+	// ld_imm64 r3, map_value(fd 5) + 8
+	g.Fd = 5
+	encoding, err := encodeInstruction(g.Ref(R3, 8))
+	if err != nil {
+		t.Fatalf("encodeInstruction() error = %v", err)
+	}
+	wantEncoding := []uint64{0x0000000500002318, 0x0000000800000000}
+	if !reflect.DeepEqual(encoding, wantEncoding) {
+		t.Errorf("Ref() encoding = %x, want %x", encoding, wantEncoding)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+	"errors"
+	"fmt"
+)
+
+// ArgType is what the verifier expects in an argument register of a helper
+// call, it mirrors enum bpf_arg_type in the kernel.
+type ArgType int
+
+const (
+	// ArgAnything is any initialized scalar or pointer.
+	ArgAnything ArgType = iota
+	// ArgConstSize is the size of the memory passed in the previous
+	// argument, it has to be a known, bounded scalar.
+	ArgConstSize
+	// ArgPtrToCtx is the program context.
+	ArgPtrToCtx
+	// ArgConstMapPtr is a map, loaded with a 64-bit immediate load.
+	ArgConstMapPtr
+	// ArgPtrToMapKey points to a key of the map in the previous argument.
+	ArgPtrToMapKey
+	// ArgPtrToMem points to initialized memory the helper reads.
+	ArgPtrToMem
+	// ArgPtrToUninitMem points to memory the helper writes to.
+	ArgPtrToUninitMem
+	// ArgPtrToFunc is a callback, loaded with a PseudoFunc load.
+	ArgPtrToFunc
+	// ArgPtrToStack points to stack memory, or is NULL.
+	ArgPtrToStack
+	// ArgPtrToDynptr points to an initialized bpf_dynptr on the stack.
+	ArgPtrToDynptr
+	// ArgPtrToUninitDynptr points to DynptrSize bytes of stack the helper
+	// initializes as a bpf_dynptr.
+	ArgPtrToUninitDynptr
+)
+
+var (
+	// ErrUnknownHelper is returned when generating a call to a helper
+	// that has no entry in the signature table.
+	ErrUnknownHelper = errors.New("Unknown helper signature")
+
+	// ErrUnsupportedArgType is returned by HelperCall for arguments that
+	// can't be set up with a few instructions, like callbacks.
+	ErrUnsupportedArgType = errors.New("Unsupported helper argument type")
+)
+
+// helperSignatures holds the argument types of the helpers in constants.go,
+// taken from their bpf_func_proto in the kernel.
+var helperSignatures = map[int32][]ArgType{
+	MapLookup:            {ArgConstMapPtr, ArgPtrToMapKey},
+	SkbLoadBytesRelative: {ArgPtrToCtx, ArgAnything, ArgPtrToUninitMem, ArgConstSize, ArgAnything},
+	GetStackId:           {ArgPtrToCtx, ArgConstMapPtr, ArgAnything},
+	GetStack:             {ArgPtrToCtx, ArgPtrToUninitMem, ArgConstSize, ArgAnything},
+	ForEachMapElem:       {ArgConstMapPtr, ArgPtrToFunc, ArgPtrToStack, ArgAnything},
+	Loop:                 {ArgAnything, ArgPtrToFunc, ArgPtrToStack, ArgAnything},
+	CloneRedirect:        {ArgPtrToCtx, ArgAnything, ArgAnything},
+	Redirect:             {ArgAnything, ArgAnything},
+	DynptrFromMem:        {ArgPtrToUninitMem, ArgConstSize, ArgAnything, ArgPtrToUninitDynptr},
+	DynptrRead:           {ArgPtrToUninitMem, ArgConstSize, ArgPtrToDynptr, ArgAnything, ArgAnything},
+	DynptrWrite:          {ArgPtrToDynptr, ArgAnything, ArgPtrToMem, ArgConstSize, ArgAnything},
+	SkbStoreBytes:        {ArgPtrToCtx, ArgAnything, ArgPtrToMem, ArgConstSize, ArgAnything},
+	L3CsumReplace:        {ArgPtrToCtx, ArgAnything, ArgAnything, ArgAnything, ArgAnything},
+	L4CsumReplace:        {ArgPtrToCtx, ArgAnything, ArgAnything, ArgAnything, ArgAnything},
+	PerfEventOutput:      {ArgPtrToCtx, ArgConstMapPtr, ArgAnything, ArgPtrToMem, ArgConstSize},
+	GetFuncArg:           {ArgPtrToCtx, ArgAnything, ArgPtrToUninitMem},
+	GetFuncRet:           {ArgPtrToCtx, ArgPtrToUninitMem},
+	CsumDiff:             {ArgPtrToMem, ArgConstSize, ArgPtrToMem, ArgConstSize, ArgAnything},
+	GetPrandomU32:        {},
+	TracePrintk:          {ArgPtrToMem, ArgConstSize},
+}
+
+// HelperSignature returns the types of the arguments helper `fn` takes in
+// R1 onwards. The second return value is false if the helper is unknown.
+func HelperSignature(fn int32) ([]ArgType, bool) {
+	args, ok := helperSignatures[fn]
+	return args, ok
+}
+
+// helperStackSlot is the size of the stack region HelperCall sets aside for
+// every pointer argument, enough for a bpf_dynptr.
+const helperStackSlot = 16
+
+// helperMemSize is the size HelperCall passes for ArgConstSize arguments.
+const helperMemSize = 8
+
+// HelperCall initializes the argument registers of helper `fn` according to
+// its signature and calls it, so the call is not rejected for reading
+// uninitialized registers:
+//   - scalars get a RandomImmediate and sizes are helperMemSize,
+//   - the context is copied from `ctx`, which should be R1 or one of the
+//     callee saved R6-R9,
+//   - maps are loaded from `mapFd`,
+//   - memory arguments point to a zeroed region of the stack, one per
+//     argument starting right below R10.
+//
+// Map keys are zeroed too, so maps with keys larger than 16 bytes need a
+// hand written call. Callbacks and initialized dynptrs can't be produced
+// here and return ErrUnsupportedArgType.
+func HelperCall(fn int32, ctx pb.Reg, mapFd int) ([]*pb.Instruction, error) {
+	args, ok := HelperSignature(fn)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownHelper, GetBpfFuncName(fn))
+	}
+
+	// Stack regions are zeroed first, then registers are set from R5 down
+	// to R1 so a context in R1 survives until it is used.
+	setup := []*pb.Instruction{}
+	argSetup := []*pb.Instruction{}
+	for index := len(args) - 1; index >= 0; index-- {
+		reg := pb.Reg_R1 + pb.Reg(index)
+		offset := int16(-helperStackSlot * (index + 1))
+		switch args[index] {
+		case ArgAnything:
+			argSetup = append(argSetup, Mov64(reg, RandomImmediate()))
+		case ArgConstSize:
+			argSetup = append(argSetup, Mov64(reg, int32(helperMemSize)))
+		case ArgPtrToCtx:
+			argSetup = append(argSetup, Mov64(reg, ctx))
+		case ArgConstMapPtr:
+			argSetup = append(argSetup, LdMapByFd(reg, mapFd))
+		case ArgPtrToMapKey, ArgPtrToMem, ArgPtrToUninitMem, ArgPtrToStack, ArgPtrToUninitDynptr:
+			setup = append(setup, StDW(R10, 0, offset), StDW(R10, 0, offset+8))
+			argSetup = append(argSetup, Mov64(reg, R10), Add64(reg, int32(offset)))
+		default:
+			return nil, fmt.Errorf("%w: argument %d of %s", ErrUnsupportedArgType, index+1, GetBpfFuncName(fn))
+		}
+	}
+	setup = append(setup, argSetup...)
+	return InstructionSequence(append(setup, Call(fn))...)
+}
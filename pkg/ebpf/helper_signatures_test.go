@@ -0,0 +1,85 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+	"errors"
+	"testing"
+
+	protobuf "github.com/golang/protobuf/proto"
+)
+
+func TestHelperSignature(t *testing.T) {
+	args, ok := HelperSignature(MapLookup)
+	if !ok || len(args) != 2 || args[0] != ArgConstMapPtr || args[1] != ArgPtrToMapKey {
+		t.Errorf("HelperSignature(MapLookup) = %v, %v, want [ArgConstMapPtr ArgPtrToMapKey], true", args, ok)
+	}
+	if _, ok := HelperSignature(-1); ok {
+		t.Errorf("HelperSignature(-1) ok = true, want false")
+	}
+}
+
+func TestHelperCall(t *testing.T) {
+	got, err := HelperCall(MapLookup, R6, 3)
+	if err != nil {
+		t.Fatalf("HelperCall(MapLookup) unexpected error: %v", err)
+	}
+	want := []*pb.Instruction{
+		StDW(R10, 0, -32),
+		StDW(R10, 0, -24),
+		Mov64(R2, R10),
+		Add64(R2, -32),
+		LdMapByFd(R1, 3),
+		Call(MapLookup),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("HelperCall(MapLookup) = %v, want %v", got, want)
+	}
+	for i := range want {
+		if !protobuf.Equal(got[i], want[i]) {
+			t.Errorf("HelperCall(MapLookup)[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	// Every supported helper has to find its arguments initialized.
+	for fn, args := range helperSignatures {
+		call, err := HelperCall(fn, R6, 3)
+		if errors.Is(err, ErrUnsupportedArgType) {
+			continue
+		}
+		if err != nil {
+			t.Fatalf("HelperCall(%s) unexpected error: %v", GetBpfFuncName(fn), err)
+		}
+		program := append([]*pb.Instruction{Mov64(R6, R1)}, call...)
+		program = append(program, Mov64(R0, 0), Exit())
+		if err := Validate(program); err != nil {
+			t.Errorf("HelperCall(%s) is invalid: %v", GetBpfFuncName(fn), err)
+		}
+		defined := DefinedRegistersAt(program, len(call))
+		for index := range args {
+			if reg := R1 + pb.Reg(index); !defined.Contains(reg) {
+				t.Errorf("HelperCall(%s) leaves %v uninitialized", GetBpfFuncName(fn), reg)
+			}
+		}
+	}
+
+	if _, err := HelperCall(Loop, R6, 3); !errors.Is(err, ErrUnsupportedArgType) {
+		t.Errorf("HelperCall(Loop) error = %v, want %v", err, ErrUnsupportedArgType)
+	}
+	if _, err := HelperCall(-1, R6, 3); !errors.Is(err, ErrUnknownHelper) {
+		t.Errorf("HelperCall(-1) error = %v, want %v", err, ErrUnknownHelper)
+	}
+}
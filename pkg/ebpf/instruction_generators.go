@@ -17,22 +17,159 @@ package ebpf
 import (
 	"buzzer/pkg/rand"
 	pb "buzzer/proto/ebpf_go_proto"
+	"errors"
+	"fmt"
+	"math"
 )
 
+// InterestingImmediatePercent is the chance, out of 100, that RandomImmediate
+// picks a value from InterestingImmediates instead of a uniformly random one.
+var InterestingImmediatePercent uint64 = 50
+
+// InvalidShiftPercent is the chance, out of 100, that RandomShiftImmediate
+// returns an amount the verifier rejects, to exercise that check.
+var InvalidShiftPercent uint64 = 0
+
+// Prefer32Bit makes RandomAluInstruction and RandomConditionalJump pick the
+// 32-bit classes (BPF_ALU and BPF_JMP32) 3 out of 4 times instead of half of
+// the time, to concentrate on the zero extension of subregisters.
+var Prefer32Bit = false
+
+// SelfComparePercent is the chance, out of 100, that a random jump comparing
+// two registers compares a register with itself. Those jumps always go the
+// same way, so by default they are avoided.
+var SelfComparePercent uint64 = 0
+
+var (
+	ErrInvalidImmRange = errors.New("Invalid immediate range")
+)
+
+// immRanges holds the ranges set with SetImmRange, keyed by opcode.
+var immRanges = map[uint8][2]int32{}
+
+// SetImmRange makes the random instruction generators pick the immediate of
+// every instruction with `opcode`, the whole opcode byte, uniformly from
+// [min, max]. E.g. SetImmRange(0x65, 0, 3) keeps `if rX s> imm` comparisons,
+// like the bounds checks before an array access, within the first entries
+// of a map.
+//
+// Ranges are applied to the immediates of RandomAluInstruction,
+// RandomConditionalJump and RandomStoreInstruction, shift amounts included,
+// and override InterestingImmediatePercent and InvalidShiftPercent.
+func SetImmRange(opcode uint8, min, max int32) error {
+	if min > max {
+		return fmt.Errorf("%w: [%d, %d] for opcode %#02x", ErrInvalidImmRange, min, max, opcode)
+	}
+	immRanges[opcode] = [2]int32{min, max}
+	return nil
+}
+
+// ClearImmRanges removes all the ranges set with SetImmRange.
+func ClearImmRanges() {
+	immRanges = map[uint8][2]int32{}
+}
+
+// RandomImmediateFor returns a random immediate for an instruction with
+// `opcode`, within its range if one was set with SetImmRange or from
+// RandomImmediate otherwise.
+func RandomImmediateFor(opcode uint8) int32 {
+	r, ok := immRanges[opcode]
+	if !ok {
+		return RandomImmediate()
+	}
+	return int32(int64(r[0]) + int64(rand.SharedRNG.RandRange(0, uint64(int64(r[1])-int64(r[0])))))
+}
+
+// withImmRange replaces the immediate of `i` with one from the range set
+// for its opcode, if any. Generators call it on the instructions where the
+// immediate is an operand.
+func withImmRange(i *pb.Instruction) *pb.Instruction {
+	if len(immRanges) == 0 {
+		return i
+	}
+	words, err := encodeInstruction(i)
+	if err != nil {
+		return i
+	}
+	opcode := uint8(words[0])
+	if _, ok := immRanges[opcode]; ok {
+		i.Immediate = RandomImmediateFor(opcode)
+	}
+	return i
+}
+
+// interestingImmediates is the pool behind InterestingImmediates, each value
+// appears once so none of them is picked more often than the others.
+var interestingImmediates = func() []int32 {
+	var values []int32
+	seen := map[int32]bool{}
+	add := func(v int32) {
+		if !seen[v] {
+			seen[v] = true
+			values = append(values, v)
+		}
+	}
+	for _, v := range []int32{0, 1, -1, math.MinInt32, math.MaxInt32, math.MinInt32 + 1, math.MaxInt32 - 1} {
+		add(v)
+	}
+	for shift := 1; shift < 31; shift++ {
+		power := int32(1) << shift
+		add(power)
+		add(power - 1)
+		add(-power)
+	}
+	return values
+}()
+
+// InterestingImmediates returns the immediate values most likely to land on
+// the boundaries of the verifier range tracking: 0, +-1, the int32 limits and
+// powers of two together with their neighbours.
+func InterestingImmediates() []int32 {
+	return append([]int32{}, interestingImmediates...)
+}
+
+// RandomImmediate returns a random immediate value biased towards
+// InterestingImmediates.
+func RandomImmediate() int32 {
+	if rand.SharedRNG.RandRange(1, 100) <= InterestingImmediatePercent {
+		return interestingImmediates[rand.SharedRNG.RandRange(0, uint64(len(interestingImmediates)-1))]
+	}
+	return int32(rand.SharedRNG.RandRange(0, 0xffffffff))
+}
+
+// RandomShiftImmediate returns a random shift amount for an instruction of
+// class `insClass`: within [0, 32) for BPF_ALU and [0, 64) for BPF_ALU64,
+// except for InvalidShiftPercent of the time when it is one past the end of
+// the range or more.
+func RandomShiftImmediate(insClass pb.InsClass) int32 {
+	width := uint64(64)
+	if insClass == pb.InsClass_InsClassAlu {
+		width = 32
+	}
+	if rand.SharedRNG.RandRange(1, 100) <= InvalidShiftPercent {
+		return int32(rand.SharedRNG.RandRange(width, math.MaxInt32))
+	}
+	return int32(rand.SharedRNG.RandRange(0, width-1))
+}
+
 // GenerateRandomAluInstruction provides a random ALU operation with either
 // IMM or Reg src that will be applied to a random dst reg.
 func RandomAluInstruction() *pb.Instruction {
-	op := RandomAluOp()
-	dstReg := RandomRegister()
-	var insClass pb.InsClass
-	if rand.SharedRNG.RandRange(0, 1) == 0 {
+	insClass := pb.InsClass_InsClassAlu64
+	if random32Bit() {
 		insClass = pb.InsClass_InsClassAlu
-	} else {
-		insClass = pb.InsClass_InsClassAlu64
 	}
+	return RandomAluInstructionOfClass(insClass)
+}
+
+// RandomAluInstructionOfClass is like RandomAluInstruction but always uses
+// `insClass`, either BPF_ALU or BPF_ALU64.
+func RandomAluInstructionOfClass(insClass pb.InsClass) *pb.Instruction {
+	op := RandomAluOp()
+	dstReg := RandomRegister()
 
-	// Toss another coin to decide if we are going to do an imm alu
-	// operation or one that uses a src register.
+	// Toss a coin to decide if we are going to do an imm alu operation or
+	// one that uses a src register.
 	var instr *pb.Instruction
 	if rand.SharedRNG.RandRange(0, 1) == 0 {
 		instr = generateImmAluInstruction(op, insClass, dstReg)
@@ -47,6 +184,66 @@ func RandomAluInstruction() *pb.Instruction {
 // offset of at most `maxOffset` this is to minimize the possibility of a jmp
 // out of the bounds of a program.
 func RandomJmpInstruction(maxOffset uint64) *pb.Instruction {
+	dstReg := RandomRegister()
+	offset := int16(rand.SharedRNG.RandRange(1, maxOffset))
+	if rand.SharedRNG.OneOf(2) {
+		return RandomConditionalJump(dstReg, RandomImmediate(), offset)
+	}
+	return RandomConditionalJump(dstReg, randomJumpSrc(dstReg, generalRegisters()), offset)
+}
+
+// RandomRegisterJump returns a conditional jump comparing two registers, to
+// be placed right before the instruction at `index` of `instructions`. Both
+// registers are initialized at that point and, when possible, hold the same
+// kind of value according to PointerRegisters: two pointers or two scalars.
+// Comparing unrelated values rarely makes the verifier explore both
+// branches.
+//
+// If no register is initialized at `index` it falls back to
+// RandomJmpInstruction's choice of registers.
+func RandomRegisterJump(instructions []*pb.Instruction, index int, offset int16) *pb.Instruction {
+	defined := DefinedRegistersAt(instructions, index)
+	pointers := PointerRegisters(instructions, index) & defined
+	scalars := defined &^ pointers
+
+	candidates := defined.Registers()
+	if len(candidates) == 0 {
+		dstReg := RandomRegister()
+		return RandomConditionalJump(dstReg, randomJumpSrc(dstReg, generalRegisters()), offset)
+	}
+	dstReg := candidates[rand.SharedRNG.RandRange(0, uint64(len(candidates)-1))]
+	related := scalars
+	if pointers.Contains(dstReg) {
+		related = pointers
+	}
+	if len(related.Remove(dstReg).Registers()) == 0 {
+		related = defined
+	}
+	return RandomConditionalJump(dstReg, randomJumpSrc(dstReg, related.Registers()), offset)
+}
+
+// randomJumpSrc picks the register to compare `dstReg` against among
+// `candidates`, which is `dstReg` itself only SelfComparePercent of the time
+// or when there is nothing else to pick.
+func randomJumpSrc(dstReg pb.Reg, candidates []pb.Reg) pb.Reg {
+	if rand.SharedRNG.RandRange(1, 100) <= SelfComparePercent {
+		return dstReg
+	}
+	others := []pb.Reg{}
+	for _, reg := range candidates {
+		if reg != dstReg {
+			others = append(others, reg)
+		}
+	}
+	if len(others) == 0 {
+		return dstReg
+	}
+	return others[rand.SharedRNG.RandRange(0, uint64(len(others)-1))]
+}
+
+// RandomConditionalJump returns a conditional jump with a random operation
+// and class (BPF_JMP or BPF_JMP32) comparing `dstReg` against `src`.
+func RandomConditionalJump[T Src](dstReg pb.Reg, src T, offset int16) *pb.Instruction {
 	var op pb.JmpOperationCode
 
 	// Exit, Call or JA operations require special parameters (e.g an offset
@@ -58,22 +255,24 @@ func RandomJmpInstruction(maxOffset uint64) *pb.Instruction {
 		}
 	}
 
-	var insClass pb.InsClass
-	if rand.SharedRNG.OneOf(2) {
+	insClass := pb.InsClass_InsClassJmp
+	if random32Bit() {
 		insClass = pb.InsClass_InsClassJmp32
-	} else {
-		insClass = pb.InsClass_InsClassJmp
 	}
+	jmp := newJmpInstruction(op, insClass, dstReg, src, offset)
+	if _, ok := any(src).(pb.Reg); ok {
+		return jmp
+	}
+	return withImmRange(jmp)
+}
 
-	dstReg := RandomRegister()
-	offset := int16(rand.SharedRNG.RandRange(1, maxOffset))
-	if rand.SharedRNG.OneOf(2) {
-		src := int32(rand.SharedRNG.RandRange(0, 0xffffffff))
-		return newJmpInstruction(op, insClass, dstReg, src, offset)
-	} else {
-		src := RandomRegister()
-		return newJmpInstruction(op, insClass, dstReg, src, offset)
+// random32Bit decides if a random instruction should use a 32-bit class,
+// taking Prefer32Bit into account.
+func random32Bit() bool {
+	if Prefer32Bit {
+		return rand.SharedRNG.NOutOf(3, 4)
 	}
+	return rand.SharedRNG.OneOf(2)
 }
 
 // RandomSize is a helper function to be used in the RandomMemInstruction
@@ -167,8 +366,8 @@ func RandomStoreInstruction() *pb.Instruction {
 	// Decide if we are doing a Store from a register or a constant.
 	if rand.SharedRNG.OneOf(2) {
 		// Constant
-		imm := int32(rand.SharedRNG.RandInt())
-		return newStoreOperation(size, R10, imm, offset)
+		imm := RandomImmediate()
+		return withImmRange(newStoreOperation(size, R10, imm, offset))
 	}
 
 	// Register
@@ -201,28 +400,31 @@ func IsConditional(op pb.JmpOperationCode) bool {
 	return !(op == pb.JmpOperationCode_JmpExit || op == pb.JmpOperationCode_JmpCALL || op == pb.JmpOperationCode_JmpJA)
 }
 
+// generalRegisters returns R0 to R9, the registers RandomRegister picks
+// from.
+func generalRegisters() []pb.Reg {
+	regs := []pb.Reg{}
+	for reg := pb.Reg_R0; reg <= pb.Reg_R9; reg++ {
+		regs = append(regs, reg)
+	}
+	return regs
+}
+
 // RandomRegister returns a random register from R0 to R9.
 func RandomRegister() pb.Reg {
 	return pb.Reg(rand.SharedRNG.RandRange(0, 9))
 }
 
 func generateImmAluInstruction(op pb.AluOperationCode, insClass pb.InsClass, dstReg pb.Reg) *pb.Instruction {
-	value := int32(rand.SharedRNG.RandRange(0, 0xFFFFFFFF))
+	value := RandomImmediate()
 	switch op {
 	case pb.AluOperationCode_AluRsh, pb.AluOperationCode_AluLsh, pb.AluOperationCode_AluArsh:
-		var maxShift = int32(64)
-		if insClass == pb.InsClass_InsClassAlu {
-			maxShift = 32
-		}
-		value = value % maxShift
-		if value < 0 {
-			value *= -1
-		}
+		value = RandomShiftImmediate(insClass)
 	case pb.AluOperationCode_AluNeg:
 		value = 0
 	}
 
-	return newAluInstruction(op, insClass, dstReg, value)
+	return withImmRange(newAluInstruction(op, insClass, dstReg, value))
 }
 
 func generateRegAluInstruction(op pb.AluOperationCode, insClass pb.InsClass, dstReg pb.Reg) *pb.Instruction {
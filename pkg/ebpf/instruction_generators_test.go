@@ -0,0 +1,176 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"buzzer/pkg/rand"
+	pb "buzzer/proto/ebpf_go_proto"
+	"errors"
+	"math"
+	gorand "math/rand"
+	"testing"
+)
+
+func TestSetImmRange(t *testing.T) {
+	defer ClearImmRanges()
+
+	// if rX s> imm, rX s>>= imm and *(u32 *)(r10 + off) = imm.
+	ranges := map[uint8][2]int32{
+		0x65: {0, 3},
+		0xc7: {63, 63},
+		0x62: {math.MinInt32, math.MaxInt32},
+	}
+	for opcode, r := range ranges {
+		if err := SetImmRange(opcode, r[0], r[1]); err != nil {
+			t.Fatalf("SetImmRange(%#02x) unexpected error: %v", opcode, err)
+		}
+	}
+
+	seen := map[uint8]bool{}
+	for n := 0; n < 5000; n++ {
+		for _, i := range []*pb.Instruction{RandomAluInstruction(), RandomConditionalJump(R1, RandomImmediate(), 1), RandomStoreInstruction()} {
+			words, err := encodeInstruction(i)
+			if err != nil {
+				t.Fatalf("encodeInstruction() unexpected error: %v", err)
+			}
+			opcode := uint8(words[0])
+			r, ok := ranges[opcode]
+			if !ok {
+				continue
+			}
+			seen[opcode] = true
+			if i.Immediate < r[0] || i.Immediate > r[1] {
+				t.Errorf("generated %v with immediate %d, want it within [%d, %d]", i, i.Immediate, r[0], r[1])
+			}
+		}
+	}
+	for opcode := range ranges {
+		if !seen[opcode] {
+			t.Errorf("never generated opcode %#02x", opcode)
+		}
+	}
+
+	if jmp := RandomConditionalJump(R1, R2, 1); jmp.Immediate != 0 {
+		t.Errorf("RandomConditionalJump() with a register = %v, want no immediate", jmp)
+	}
+	if err := SetImmRange(0x65, 3, 0); !errors.Is(err, ErrInvalidImmRange) {
+		t.Errorf("SetImmRange() with min > max = %v, want %v", err, ErrInvalidImmRange)
+	}
+
+	ClearImmRanges()
+	if len(immRanges) != 0 {
+		t.Errorf("ClearImmRanges() left %v", immRanges)
+	}
+}
+
+func TestRandomRegisterJump(t *testing.T) {
+	instructions := []*pb.Instruction{
+		LdMapByFd(R6, 3),
+		Mov64(R7, R10),
+		Add64(R7, -8),
+		Mov64(R8, 5),
+		LdDW(R9, R1, 0),
+		Exit(),
+	}
+	// R1, R6, R7 and R10 hold pointers, R8 and R9 scalars.
+	pointers := RegisterSet(0).Add(R1).Add(R6).Add(R7).Add(R10)
+	if got := PointerRegisters(instructions, 5); got != pointers {
+		t.Fatalf("PointerRegisters() = %v, want %v", got.Registers(), pointers.Registers())
+	}
+
+	for n := 0; n < 1000; n++ {
+		jmp := RandomRegisterJump(instructions, 5, 1)
+		if jmp.DstReg == jmp.SrcReg {
+			t.Fatalf("RandomRegisterJump() = %v, compares a register with itself", jmp)
+		}
+		if pointers.Contains(jmp.DstReg) != pointers.Contains(jmp.SrcReg) {
+			t.Fatalf("RandomRegisterJump() = %v, compares a pointer with a scalar", jmp)
+		}
+		if !DefinedRegistersAt(instructions, 5).Contains(jmp.DstReg) {
+			t.Fatalf("RandomRegisterJump() = %v, reads an uninitialized register", jmp)
+		}
+	}
+
+	defer func(percent uint64) { SelfComparePercent = percent }(SelfComparePercent)
+	SelfComparePercent = 100
+	if jmp := RandomRegisterJump(instructions, 5, 1); jmp.DstReg != jmp.SrcReg {
+		t.Errorf("RandomRegisterJump() with SelfComparePercent = 100 is %v, want a self comparison", jmp)
+	}
+	if jmp := RandomJmpInstruction(1); jmp.GetJmpOpcode().Source == pb.SrcOperand_RegSrc && jmp.DstReg != jmp.SrcReg {
+		t.Errorf("RandomJmpInstruction() with SelfComparePercent = 100 is %v, want a self comparison", jmp)
+	}
+}
+
+func TestInterestingImmediates(t *testing.T) {
+	pool := map[int32]bool{}
+	for _, v := range InterestingImmediates() {
+		if pool[v] {
+			t.Errorf("InterestingImmediates() has %d more than once", v)
+		}
+		pool[v] = true
+	}
+	want := []int32{0, 1, -1, math.MinInt32, math.MaxInt32, math.MinInt32 + 1, math.MaxInt32 - 1}
+	for shift := 1; shift < 31; shift++ {
+		power := int32(1) << shift
+		want = append(want, power, power-1, -power)
+	}
+	for _, v := range want {
+		if !pool[v] {
+			t.Errorf("InterestingImmediates() is missing %d", v)
+		}
+	}
+	if len(pool) != len(want)-1 {
+		// 1 is both a limit and 2^1 - 1.
+		t.Errorf("InterestingImmediates() has %d distinct values, want %d", len(pool), len(want)-1)
+	}
+}
+
+func TestRandomImmediate(t *testing.T) {
+	savedRNG := rand.SharedRNG
+	savedPercent := InterestingImmediatePercent
+	defer func() {
+		rand.SharedRNG = savedRNG
+		InterestingImmediatePercent = savedPercent
+	}()
+
+	pool := map[int32]bool{}
+	for _, v := range InterestingImmediates() {
+		pool[v] = true
+	}
+
+	for _, c := range []struct {
+		percent uint64
+		inPool  bool
+	}{
+		{100, true},
+		{0, false},
+	} {
+		InterestingImmediatePercent = c.percent
+		rand.SharedRNG = rand.NewRand(gorand.NewSource(1))
+		distinct := map[int32]bool{}
+		for n := 0; n < 1000; n++ {
+			v := RandomImmediate()
+			distinct[v] = true
+			// The pool has 96 of the 2^32 values, uniform draws
+			// from this seed never hit it.
+			if pool[v] != c.inPool {
+				t.Fatalf("InterestingImmediatePercent = %d: RandomImmediate() = %d, in pool %v, want %v", c.percent, v, pool[v], c.inPool)
+			}
+		}
+		if len(distinct) < 50 {
+			t.Errorf("InterestingImmediatePercent = %d: RandomImmediate() returned only %d distinct values", c.percent, len(distinct))
+		}
+	}
+}
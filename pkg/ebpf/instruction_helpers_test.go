@@ -15,6 +15,7 @@
 package ebpf
 
 import (
+	"errors"
 	"reflect"
 	"testing"
 
@@ -48,6 +49,19 @@ func TestInstructionChainHelperTest(t *testing.T) {
 					pb.Reg_R1), Exit()},
 			expectedError: nil,
 		},
+		{
+			testName:      "Empty instruction chain",
+			operations:    []*pb.Instruction{},
+			expectedError: ErrEmptySequence,
+		},
+		{
+			testName: "Instruction chain with nil instruction",
+			operations: []*pb.Instruction{
+				Mov64(pb.Reg_R0, 0),
+				nil,
+				Exit()},
+			expectedError: ErrNilInstruction,
+		},
 	}
 
 	for _, tc := range tests {
@@ -55,7 +69,7 @@ func TestInstructionChainHelperTest(t *testing.T) {
 			t.Logf("Running test case %s", tc.testName)
 			root, err := InstructionSequence(tc.operations...)
 			if tc.expectedError != nil {
-				if err.Error() != tc.expectedError.Error() {
+				if !errors.Is(err, tc.expectedError) {
 					t.Fatalf("Want error %v, got %v", tc.expectedError, err)
 				}
 				return
@@ -16,17 +16,51 @@ package ebpf
 
 import (
 	pb "buzzer/proto/ebpf_go_proto"
+	"errors"
 	"fmt"
 )
 
+var (
+	// ErrEmptySequence is returned by InstructionSequence when it is called
+	// without any instructions.
+	ErrEmptySequence = errors.New("Empty instruction sequence")
+
+	// ErrNilInstruction is returned by InstructionSequence when any of the
+	// passed instructions is nil, this usually means a constructor rejected
+	// its arguments (e.g. an unsupported Src type). The returned error is
+	// wrapped with the index of the offending instruction.
+	ErrNilInstruction = errors.New("Nil instruction")
+)
+
 // InstructionSequence abstracts away the process of creating a sequence of
 // ebpf instructions. This should make writing ebpf programs in buzzer
 // more readable and easier to achieve.
+//
+// Returns ErrEmptySequence or ErrNilInstruction if the sequence is not valid,
+// use errors.Is to tell them apart.
 func InstructionSequence(instructions ...*pb.Instruction) ([]*pb.Instruction, error) {
+	if len(instructions) == 0 {
+		return nil, ErrEmptySequence
+	}
 	for index, inst := range instructions {
 		if inst == nil {
-			return nil, fmt.Errorf("Nil instruction at index %d, did you pass an unsigned int value?", index)
+			return nil, fmt.Errorf("%w at index %d, did you pass an unsigned int value?", ErrNilInstruction, index)
 		}
 	}
 	return instructions, nil
 }
+
+// Pin marks `i` as part of a skeleton that mutations (SwapAdjacent,
+// InsertNops, GenerateInRange) must not touch and returns it, so it can be
+// used inline in InstructionSequence:
+//
+//	InstructionSequence(
+//		Pin(Mov64(pb.Reg_R0, 0)),
+//		Pin(Exit()),
+//	)
+func Pin(i *pb.Instruction) *pb.Instruction {
+	if i != nil {
+		i.Pinned = true
+	}
+	return i
+}
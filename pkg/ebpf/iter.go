@@ -0,0 +1,110 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrUnknownIterType is returned by IterProgram for iterator types
+	// the kernel doesn't define.
+	ErrUnknownIterType = errors.New("Unknown iterator type")
+)
+
+// iterTypes are the iterators defined with DEFINE_BPF_ITER_FUNC in the
+// kernel, with the offset in their context, struct bpf_iter__<type>, of the
+// pointer that is NULL on the last call of an iteration. All contexts start
+// with a pointer to struct bpf_iter_meta, most follow it with the object
+// being visited. The map iterators have the map first instead, which is
+// never NULL, so the element they visit comes later.
+var iterTypes = map[string]int16{
+	"bpf_link":           8,
+	"bpf_map":            8,
+	"bpf_map_elem":       24, // meta, map, key, value
+	"bpf_prog":           8,
+	"bpf_sk_storage_map": 16, // meta, map, sk, value
+	"cgroup":             8,
+	"ipv6_route":         8,
+	"ksym":               8,
+	"netlink":            8,
+	"sockmap":            24, // meta, map, key, sk
+	"task":               8,
+	"task_file":          8,
+	"task_vma":           8,
+	"tcp":                8,
+	"udp":                8,
+	"unix":               8,
+}
+
+// IterTemplate is a bpf_iter program with a hole where the generated body
+// goes. Every instruction but the hole is pinned, so mutations only change
+// the body.
+type IterTemplate struct {
+	Program *pb.Program
+
+	// AttachFunction is the kernel function whose BTF id has to be passed
+	// in attach_btf_id when loading the program as BPF_PROG_TYPE_TRACING
+	// with expected_attach_type BPF_TRACE_ITER.
+	AttachFunction string
+
+	// Body is the index of the placeholder instruction to replace with the
+	// generated body, e.g. with GenerateInRange(instructions, Body,
+	// Body+1, generator).
+	Body int
+}
+
+// IterProgram returns a template for an iterator of type `iterType`, e.g.
+// "task" or "bpf_map_elem". When the body runs:
+//
+//   - R6 holds the context.
+//   - R7 holds the struct bpf_iter_meta pointer.
+//   - R8 holds the object being visited, e.g. the task for "task" or the
+//     value for "bpf_map_elem". The preamble exits when it is NULL as it
+//     is on the last call of every iteration.
+//   - R9 holds the struct seq_file pointer for bpf_seq_printf and friends.
+//
+// The program returns 0 after the body, iterators can only return 0 or 1.
+func IterProgram(iterType string) (*IterTemplate, error) {
+	object, ok := iterTypes[iterType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownIterType, iterType)
+	}
+	instructions, err := InstructionSequence(
+		Pin(Mov64(R6, R1)),
+		Pin(LdDW(R7, R6, 0)),
+		Pin(LdDW(R8, R6, object)),
+		Pin(JmpEQ(R8, 0, 2)),
+		Pin(LdDW(R9, R7, 0)),
+
+		// The hole.
+		Mov64(R0, 0),
+
+		Pin(Mov64(R0, 0)),
+		Pin(Exit()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &IterTemplate{
+		Program: &pb.Program{
+			Functions: []*pb.Functions{{Instructions: instructions}},
+		},
+		AttachFunction: "bpf_iter_" + iterType,
+		Body:           5,
+	}, nil
+}
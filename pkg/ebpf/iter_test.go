@@ -0,0 +1,78 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+	"errors"
+	"testing"
+)
+
+func TestIterProgram(t *testing.T) {
+	template, err := IterProgram("task")
+	if err != nil {
+		t.Fatalf("IterProgram() unexpected error: %v", err)
+	}
+	if template.AttachFunction != "bpf_iter_task" {
+		t.Errorf("IterProgram().AttachFunction = %q, want %q", template.AttachFunction, "bpf_iter_task")
+	}
+	instructions := template.Program.Functions[0].Instructions
+	if err := Validate(instructions); err != nil {
+		t.Errorf("IterProgram() is invalid: %v", err)
+	}
+	for index, i := range instructions {
+		if i.Pinned == (index == template.Body) {
+			t.Errorf("IterProgram() instruction %d pinned = %v", index, i.Pinned)
+		}
+	}
+	defined := DefinedRegistersAt(instructions, template.Body)
+	for _, reg := range []pb.Reg{R6, R7, R8, R9} {
+		if !defined.Contains(reg) {
+			t.Errorf("IterProgram() body can't use %v", reg)
+		}
+	}
+
+	// Filling the hole keeps the null check jumping to the epilogue.
+	body := []*pb.Instruction{Mov64(R1, R9), Mov64(R2, int64(1)<<40), Mov64(R3, R8)}
+	next := 0
+	generator := func(remaining int) *pb.Instruction {
+		next++
+		return body[next-1]
+	}
+	filled, err := GenerateInRange(instructions, template.Body, template.Body+1, generator)
+	if err != nil {
+		t.Fatalf("GenerateInRange() unexpected error: %v", err)
+	}
+	if got, want := jumpTargets(filled)[3], len(filled)-2; got != want {
+		t.Errorf("filled IterProgram() null check jumps to %d, want %d", got, want)
+	}
+
+	// The map iterators visit the element after the map pointer, which is
+	// never NULL.
+	for iterType, want := range map[string]int32{"task": 8, "bpf_map_elem": 24, "bpf_sk_storage_map": 16, "sockmap": 24} {
+		template, err := IterProgram(iterType)
+		if err != nil {
+			t.Fatalf("IterProgram(%q) unexpected error: %v", iterType, err)
+		}
+		load := template.Program.Functions[0].Instructions[2]
+		if load.DstReg != R8 || load.SrcReg != R6 || load.Offset != want {
+			t.Errorf("IterProgram(%q) loads R8 with %v, want ctx+%d", iterType, load, want)
+		}
+	}
+
+	if _, err := IterProgram("files"); !errors.Is(err, ErrUnknownIterType) {
+		t.Errorf("IterProgram() with an unknown type = %v, want %v", err, ErrUnknownIterType)
+	}
+}
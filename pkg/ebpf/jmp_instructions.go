@@ -16,6 +16,18 @@ package ebpf
 
 import (
 	pb "buzzer/proto/ebpf_go_proto"
+	"errors"
+	"fmt"
+
+	protobuf "github.com/golang/protobuf/proto"
+)
+
+var (
+	// ErrTooManyKfuncArgs is returned by CallKfunc when it is given more
+	// than MaxKfuncArgs arguments. There is no stack passing convention for
+	// the extra ones, BTF_KIND_FUNC_PROTOs with more arguments are rejected
+	// when the kfunc is resolved.
+	ErrTooManyKfuncArgs = errors.New("Too many kfunc arguments")
 )
 
 func newJmpInstruction[T Src](oc pb.JmpOperationCode, insclass pb.InsClass, dst pb.Reg, src T, offset int16) *pb.Instruction {
@@ -38,7 +50,7 @@ func newJmpInstruction[T Src](oc pb.JmpOperationCode, insclass pb.InsClass, dst
 		imm = any(src).(int32)
 	}
 
-	return &pb.Instruction{
+	return withOrigin(&pb.Instruction{
 		Opcode: &pb.Instruction_JmpOpcode{
 			JmpOpcode: &pb.JmpOpcode{
 				OperationCode:    oc,
@@ -55,7 +67,7 @@ func newJmpInstruction[T Src](oc pb.JmpOperationCode, insclass pb.InsClass, dst
 		PseudoInstruction: &pb.Instruction_Empty{
 			Empty: &pb.Empty{},
 		},
-	}
+	})
 }
 
 // Jmp represents an inconditional jump of `offset` instructions.
@@ -123,6 +135,40 @@ func Call(functionValue int32) *pb.Instruction {
 	return newJmpInstruction(pb.JmpOperationCode_JmpCALL, pb.InsClass_InsClassJmp, pb.Reg_R0, functionValue, int16(UnusedField))
 }
 
+// KfuncCall returns a call to the kernel function with BTF id `btfID` in
+// vmlinux BTF.
+func KfuncCall(btfID int32) *pb.Instruction {
+	call := Call(btfID)
+	call.SrcReg = PseudoKfuncCall
+	return call
+}
+
+// CallKfunc sets up the state of the registers to invoke the kernel function
+// with BTF id `btfID`, copying `args` to R1 onwards in order, so an argument
+// can't be any of the registers before it.
+//
+// Returns ErrTooManyKfuncArgs if there are more than MaxKfuncArgs `args`.
+func CallKfunc(btfID int32, args ...pb.Reg) ([]*pb.Instruction, error) {
+	if len(args) > MaxKfuncArgs {
+		return nil, fmt.Errorf("%w: got %d, at most %d fit in registers", ErrTooManyKfuncArgs, len(args), MaxKfuncArgs)
+	}
+	instructions := []*pb.Instruction{}
+	for index, arg := range args {
+		instructions = append(instructions, Mov64(pb.Reg_R1+pb.Reg(index), arg))
+	}
+	return InstructionSequence(append(instructions, KfuncCall(btfID))...)
+}
+
+// HelperFunctionNumber returns the number of the helper function called by
+// `i`. The second return value is false if `i` is not a call to a helper,
+// e.g. it is a bpf to bpf call or not a call at all.
+func HelperFunctionNumber(i *pb.Instruction) (int32, bool) {
+	if !isCall(i) || i.SrcReg != pb.Reg_R0 {
+		return 0, false
+	}
+	return i.Immediate, true
+}
+
 func LdFunctionPtr(Imm int32) *pb.Instruction {
 	return &pb.Instruction{
 		Opcode: &pb.Instruction_MemOpcode{
@@ -174,6 +220,138 @@ func LdMapElement(mapPtr pb.Reg, element int32, keyPtr pb.Reg, offset int16) ([]
 	)
 }
 
+// LdMapInMapElement looks up `innerKey` in the map stored at `outerKey` of
+// the ARRAY_OF_MAPS/HASH_OF_MAPS map in `outerMap`, leaving the pointer to the
+// inner element in R0.
+// It does the following operations:
+// - Looks up `outerKey` in `outerMap` (see LdMapElement).
+// - Exits the program if the returned inner map pointer is null.
+// - Looks up `innerKey` in the returned inner map.
+//
+// `keyPtr` is used to hold both keys so it must survive helper calls, e.g.
+// R10 or one of R6-R9.
+func LdMapInMapElement(outerMap pb.Reg, outerKey int32, innerKey int32, keyPtr pb.Reg, offset int16) ([]*pb.Instruction, error) {
+	outer, err := LdMapElement(outerMap, outerKey, keyPtr, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	// The verifier requires the inner map pointer to be null checked
+	// before it can be used.
+	nullCheck, err := InstructionSequence(
+		JmpNE(R0, 0, 1),
+		Exit(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	inner, err := LdMapElement(R0, innerKey, keyPtr, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	result := append(outer, nullCheck...)
+	return InstructionSequence(append(result, inner...)...)
+}
+
+// Select emits the branchy equivalent of `dst = cond ? ifTrue : ifFalse`:
+//
+//	cond goto +2
+//	dst = ifFalse
+//	goto +1
+//	dst = ifTrue
+//
+// `cond` must be a conditional jump, its offset is ignored and replaced in a
+// copy so the caller's instruction is left untouched.
+func Select(cond *pb.Instruction, ifTrue int32, ifFalse int32, dst pb.Reg) ([]*pb.Instruction, error) {
+	if cond == nil || !isConditionalJump(cond) {
+		return nil, fmt.Errorf("Select condition must be a conditional jump, got %v", cond)
+	}
+
+	branch := protobuf.Clone(cond).(*pb.Instruction)
+	branch.Offset = 2
+	return InstructionSequence(
+		branch,
+		Mov64(dst, ifFalse),
+		Jmp(1),
+		Mov64(dst, ifTrue),
+	)
+}
+
+// ExitWithValue sets R0 to `value` and exits, how the value is interpreted
+// depends on the program type.
+func ExitWithValue(value int32) ([]*pb.Instruction, error) {
+	return InstructionSequence(
+		Mov64(pb.Reg_R0, value),
+		Exit(),
+	)
+}
+
+// ExitXDP makes an XDP program exit returning `action`.
+func ExitXDP(action XdpAction) ([]*pb.Instruction, error) {
+	return ExitWithValue(int32(action))
+}
+
+// ExitTC makes a tc program exit returning `action`.
+func ExitTC(action TcAction) ([]*pb.Instruction, error) {
+	return ExitWithValue(int32(action))
+}
+
+// XdpPacketAccessPreamble loads the packet pointers of an XDP program from
+// the context in R1 and checks that at least `bytesNeeded` bytes of packet
+// data are available, exiting with XDP_PASS otherwise. It returns the
+// sequence and the register that holds the start of the packet data, which
+// can be read up to `bytesNeeded` bytes after the sequence runs.
+//
+// The preamble clobbers R2, R3 and R4 and leaves R1 untouched, it has to
+// run before R1 is overwritten:
+//
+//	r2 = *(u32 *)(r1 + 0)  // xdp_md->data
+//	r3 = *(u32 *)(r1 + 4)  // xdp_md->data_end
+//	r4 = r2
+//	r4 += bytesNeeded
+//	if r4 <= r3 goto +2
+//	r0 = XDP_PASS
+//	exit
+func XdpPacketAccessPreamble(bytesNeeded int32) ([]*pb.Instruction, pb.Reg, error) {
+	if bytesNeeded <= 0 {
+		return nil, pb.Reg_R0, fmt.Errorf("bytesNeeded must be positive, got %d", bytesNeeded)
+	}
+	exit, err := ExitXDP(XdpPass)
+	if err != nil {
+		return nil, pb.Reg_R0, err
+	}
+	preamble, err := InstructionSequence(
+		LdW(pb.Reg_R2, pb.Reg_R1, 0),
+		LdW(pb.Reg_R3, pb.Reg_R1, 4),
+		Mov64(pb.Reg_R4, pb.Reg_R2),
+		Add64(pb.Reg_R4, bytesNeeded),
+		JmpLE(pb.Reg_R4, pb.Reg_R3, int16(SlotCount(exit))),
+	)
+	if err != nil {
+		return nil, pb.Reg_R0, err
+	}
+	return append(preamble, exit...), pb.Reg_R2, nil
+}
+
+// NullCheckR0 checks the pointer returned by a helper like map_lookup_elem
+// in R0 and runs `onNull` if it is NULL. The returned sequence is laid out
+// as:
+//
+//	if r0 != 0 goto +len(onNull)
+//	onNull...
+//
+// so the non NULL path continues right after it. `onNull` usually ends in an
+// exit, if it doesn't it falls through into the non NULL path.
+func NullCheckR0(onNull ...*pb.Instruction) ([]*pb.Instruction, error) {
+	block, err := InstructionSequence(onNull...)
+	if err != nil {
+		return nil, err
+	}
+	return append([]*pb.Instruction{JmpNE(pb.Reg_R0, 0, int16(SlotCount(block)))}, block...), nil
+}
+
 // CallSkbLoadBytesRelative sets up the state of the registers to invoke the
 // skb_load_bytes_relative helper function.
 //
@@ -191,6 +369,396 @@ func CallSkbLoadBytesRelative[T Src](skb pb.Reg, skb_offset T, dstAddress pb.Reg
 	)
 }
 
+// CallGetStackId sets up the state of the registers to invoke the
+// get_stackid helper function, which stores the current stack trace in the
+// BPF_MAP_TYPE_STACK_TRACE map `stackMap` and returns its id in R0.
+//
+// The invocation of this function would look more or less like this:
+// get_stackid(ctx, stackMap, flags).
+func CallGetStackId(ctx pb.Reg, stackMap pb.Reg, flags int32) ([]*pb.Instruction, error) {
+	return InstructionSequence(
+		Mov64(pb.Reg_R1, ctx),
+		Mov64(pb.Reg_R2, stackMap),
+		Mov64(pb.Reg_R3, flags),
+		Call(GetStackId),
+	)
+}
+
+// CallGetStack sets up the state of the registers to invoke the get_stack
+// helper function, which copies the current stack trace to `buf`.
+//
+// The invocation of this function would look more or less like this:
+// get_stack(ctx, buf, size, flags).
+func CallGetStack[T Src](ctx pb.Reg, buf pb.Reg, size T, flags int32) ([]*pb.Instruction, error) {
+	return InstructionSequence(
+		Mov64(pb.Reg_R1, ctx),
+		Mov64(pb.Reg_R2, buf),
+		Mov64(pb.Reg_R3, size),
+		Mov64(pb.Reg_R4, flags),
+		Call(GetStack),
+	)
+}
+
+// CallRedirect sets up the state of the registers to invoke the redirect
+// helper function, which makes a tc or XDP program send the packet to the
+// device with index `ifindex` when it exits.
+//
+// The invocation of this function would look more or less like this:
+// redirect(ifindex, flags).
+func CallRedirect[T Src](ifindex T, flags int32) ([]*pb.Instruction, error) {
+	return InstructionSequence(
+		Mov64(pb.Reg_R1, ifindex),
+		Mov64(pb.Reg_R2, flags),
+		Call(Redirect),
+	)
+}
+
+// CallCloneRedirect sets up the state of the registers to invoke the
+// clone_redirect helper function, which sends a copy of the packet in `skb`
+// to the device with index `ifindex`.
+//
+// The invocation of this function would look more or less like this:
+// clone_redirect(skb, ifindex, flags).
+func CallCloneRedirect[T Src](skb pb.Reg, ifindex T, flags int32) ([]*pb.Instruction, error) {
+	return InstructionSequence(
+		Mov64(pb.Reg_R1, skb),
+		Mov64(pb.Reg_R2, ifindex),
+		Mov64(pb.Reg_R3, flags),
+		Call(CloneRedirect),
+	)
+}
+
+// CallDynptrFromMem sets up the state of the registers to invoke the
+// dynptr_from_mem helper function, which initializes the dynptr at `dynptr`
+// to point to `size` bytes at `data`. `dynptr` must point to DynptrSize
+// bytes of stack.
+//
+// The arguments are copied to R1-R4 in order, so `dynptr` can't be any of
+// R1-R3.
+//
+// The invocation of this function would look more or less like this:
+// dynptr_from_mem(data, size, flags, dynptr).
+func CallDynptrFromMem[T Src](data pb.Reg, size T, flags int32, dynptr pb.Reg) ([]*pb.Instruction, error) {
+	return InstructionSequence(
+		Mov64(pb.Reg_R1, data),
+		Mov64(pb.Reg_R2, size),
+		Mov64(pb.Reg_R3, flags),
+		Mov64(pb.Reg_R4, dynptr),
+		Call(DynptrFromMem),
+	)
+}
+
+// CallDynptrRead sets up the state of the registers to invoke the
+// dynptr_read helper function, which copies `length` bytes starting at
+// `offset` of the dynptr at `dynptr` to `dst`.
+//
+// The invocation of this function would look more or less like this:
+// dynptr_read(dst, length, dynptr, offset, flags).
+func CallDynptrRead[T Src](dst pb.Reg, length T, dynptr pb.Reg, offset T, flags int32) ([]*pb.Instruction, error) {
+	return InstructionSequence(
+		Mov64(pb.Reg_R1, dst),
+		Mov64(pb.Reg_R2, length),
+		Mov64(pb.Reg_R3, dynptr),
+		Mov64(pb.Reg_R4, offset),
+		Mov64(pb.Reg_R5, flags),
+		Call(DynptrRead),
+	)
+}
+
+// CallDynptrWrite sets up the state of the registers to invoke the
+// dynptr_write helper function, which copies `length` bytes from `src` to
+// the dynptr at `dynptr`, starting at `offset`.
+//
+// The invocation of this function would look more or less like this:
+// dynptr_write(dynptr, offset, src, length, flags).
+func CallDynptrWrite[T Src](dynptr pb.Reg, offset T, src pb.Reg, length T, flags int32) ([]*pb.Instruction, error) {
+	return InstructionSequence(
+		Mov64(pb.Reg_R1, dynptr),
+		Mov64(pb.Reg_R2, offset),
+		Mov64(pb.Reg_R3, src),
+		Mov64(pb.Reg_R4, length),
+		Mov64(pb.Reg_R5, flags),
+		Call(DynptrWrite),
+	)
+}
+
+// CallSkbStoreBytes sets up the state of the registers to invoke the
+// skb_store_bytes helper function, which writes `length` bytes from `from`
+// into the packet at `offset`. `flags` is a combination of
+// StoreBytesRecomputeCsum and StoreBytesInvalidateHash.
+//
+// The arguments are copied to R1-R5 in order, so `from` can't be R1 or R2.
+//
+// The invocation of this function would look more or less like this:
+// skb_store_bytes(skb, offset, from, length, flags).
+func CallSkbStoreBytes[T Src](skb pb.Reg, offset T, from pb.Reg, length T, flags int32) ([]*pb.Instruction, error) {
+	return InstructionSequence(
+		Mov64(pb.Reg_R1, skb),
+		Mov64(pb.Reg_R2, offset),
+		Mov64(pb.Reg_R3, from),
+		Mov64(pb.Reg_R4, length),
+		Mov64(pb.Reg_R5, flags),
+		Call(SkbStoreBytes),
+	)
+}
+
+// CallL3CsumReplace sets up the state of the registers to invoke the
+// l3_csum_replace helper function, which updates the IP checksum at
+// `offset` of the packet after a `size` byte field (2 or 4) changed from
+// `from` to `to`.
+//
+// The arguments are copied to R1-R5 in order, so registers passed as `from`
+// or `to` can't be any of the ones before them.
+//
+// The invocation of this function would look more or less like this:
+// l3_csum_replace(skb, offset, from, to, size).
+func CallL3CsumReplace[T Src](skb pb.Reg, offset int32, from T, to T, size int32) ([]*pb.Instruction, error) {
+	return InstructionSequence(
+		Mov64(pb.Reg_R1, skb),
+		Mov64(pb.Reg_R2, offset),
+		Mov64(pb.Reg_R3, from),
+		Mov64(pb.Reg_R4, to),
+		Mov64(pb.Reg_R5, size),
+		Call(L3CsumReplace),
+	)
+}
+
+// CallL4CsumReplace sets up the state of the registers to invoke the
+// l4_csum_replace helper function, which updates the TCP/UDP checksum at
+// `offset` of the packet after a field changed from `from` to `to`. The
+// size of the field goes in the low bits of `flags`, combined with
+// CsumPseudoHdr and CsumMarkMangled0.
+//
+// The arguments are copied to R1-R5 in order, so registers passed as `from`
+// or `to` can't be any of the ones before them.
+//
+// The invocation of this function would look more or less like this:
+// l4_csum_replace(skb, offset, from, to, flags).
+func CallL4CsumReplace[T Src](skb pb.Reg, offset int32, from T, to T, flags int32) ([]*pb.Instruction, error) {
+	return InstructionSequence(
+		Mov64(pb.Reg_R1, skb),
+		Mov64(pb.Reg_R2, offset),
+		Mov64(pb.Reg_R3, from),
+		Mov64(pb.Reg_R4, to),
+		Mov64(pb.Reg_R5, flags),
+		Call(L4CsumReplace),
+	)
+}
+
+// CallPerfEventOutput sets up the state of the registers to invoke the
+// perf_event_output helper function, which copies `size` bytes from `data`
+// to the perf buffer at index `flags` of the BPF_MAP_TYPE_PERF_EVENT_ARRAY
+// map in `perfMap`, or to the one of the current CPU if `flags` is
+// PerfEventCurrentCpu.
+//
+// Immediate flags are moved with a 32-bit mov so PerfEventCurrentCpu isn't
+// sign extended, the helper rejects any bit set above it. The arguments are
+// copied to R1-R5 in order, so registers passed as `perfMap`, `data` or
+// `size` can't be any of the ones before them.
+//
+// The invocation of this function would look more or less like this:
+// perf_event_output(ctx, perfMap, flags, data, size).
+func CallPerfEventOutput[F, S Src](ctx pb.Reg, perfMap pb.Reg, flags F, data pb.Reg, size S) ([]*pb.Instruction, error) {
+	setFlags := Mov(pb.Reg_R3, flags)
+	if reg, ok := any(flags).(pb.Reg); ok {
+		setFlags = Mov64(pb.Reg_R3, reg)
+	}
+	return InstructionSequence(
+		Mov64(pb.Reg_R1, ctx),
+		Mov64(pb.Reg_R2, perfMap),
+		setFlags,
+		Mov64(pb.Reg_R4, data),
+		Mov64(pb.Reg_R5, size),
+		Call(PerfEventOutput),
+	)
+}
+
+// CallCsumDiff sets up the state of the registers to invoke the csum_diff
+// helper function, which computes the checksum difference between the
+// `fromSize` bytes at `from` and the `toSize` bytes at `to`, added to
+// `seed`, and leaves it in R0. Either buffer can be NULL with a size of 0,
+// e.g. to checksum a single buffer, and both sizes must be multiples of 4.
+//
+// The arguments are copied to R1-R5 in order, so registers passed as `to`,
+// `toSize` or `seed` can't be any of the ones before them.
+//
+// The invocation of this function would look more or less like this:
+// csum_diff(from, fromSize, to, toSize, seed).
+func CallCsumDiff[T, S Src](from pb.Reg, fromSize T, to pb.Reg, toSize T, seed S) ([]*pb.Instruction, error) {
+	return InstructionSequence(
+		Mov64(pb.Reg_R1, from),
+		Mov64(pb.Reg_R2, fromSize),
+		Mov64(pb.Reg_R3, to),
+		Mov64(pb.Reg_R4, toSize),
+		Mov64(pb.Reg_R5, seed),
+		Call(CsumDiff),
+	)
+}
+
+// CallTracePrintk sets up the state of the registers to invoke the
+// trace_printk helper function, which prints the `fmtLen` bytes long format
+// string already stored on the stack at R10 + `fmtStackOffset`, including
+// its NUL terminator, to the trace pipe with `arg` as its only argument.
+// This is meant for debugging, e.g. to print the value of a register while
+// reproducing a test case.
+//
+// R1 is set before R2 and R3, so `fmtLen` and `arg` can't be R1 and `arg`
+// can't be R2.
+//
+// The invocation of this function would look more or less like this:
+// trace_printk(R10 + fmtStackOffset, fmtLen, arg).
+func CallTracePrintk[T, S Src](fmtStackOffset int16, fmtLen T, arg S) ([]*pb.Instruction, error) {
+	return InstructionSequence(
+		Mov64(pb.Reg_R1, pb.Reg_R10),
+		Add64(pb.Reg_R1, int32(fmtStackOffset)),
+		Mov64(pb.Reg_R2, fmtLen),
+		Mov64(pb.Reg_R3, arg),
+		Call(TracePrintk),
+	)
+}
+
+// CallGetFuncArg sets up the state of the registers to invoke the
+// get_func_arg helper function, which stores argument `n` of the traced
+// function, as saved by the trampoline, in the 8 bytes of stack at `value`.
+//
+// The helper is only available to fentry, fexit and fmod_ret programs. The
+// verifier inlines the call, and an `n` past the number of arguments of the
+// traced function makes it return -EINVAL without touching `value`. The
+// arguments are copied to R1-R3 in order, so `value` can't be R1 or R2.
+//
+// The invocation of this function would look more or less like this:
+// get_func_arg(ctx, n, value).
+func CallGetFuncArg[T Src](ctx pb.Reg, n T, value pb.Reg) ([]*pb.Instruction, error) {
+	return InstructionSequence(
+		Mov64(pb.Reg_R1, ctx),
+		Mov64(pb.Reg_R2, n),
+		Mov64(pb.Reg_R3, value),
+		Call(GetFuncArg),
+	)
+}
+
+// CallGetFuncRet sets up the state of the registers to invoke the
+// get_func_ret helper function, which stores the return value of the traced
+// function in the 8 bytes of stack at `value`.
+//
+// The helper is only available to fexit and fmod_ret programs, the only
+// ones that run after the traced function returns. `value` is copied to R2
+// after `ctx` is copied to R1, so it can't be R1.
+//
+// The invocation of this function would look more or less like this:
+// get_func_ret(ctx, value).
+func CallGetFuncRet(ctx pb.Reg, value pb.Reg) ([]*pb.Instruction, error) {
+	return InstructionSequence(
+		Mov64(pb.Reg_R1, ctx),
+		Mov64(pb.Reg_R2, value),
+		Call(GetFuncRet),
+	)
+}
+
+// CallForEachMapElem sets up the state of the registers to invoke the
+// for_each_map_elem helper function, which calls the bpf function at
+// `callbackOffset` for every element of the map in `mapReg`.
+//
+// The callback is passed as a function pointer (see LdFunctionPtr), so
+// `callbackOffset` is relative to the function pointer load: the callback
+// starts at slot (index of the load + callbackOffset + 1). The load is the
+// third instruction of the returned sequence.
+//
+// `mapReg` is copied to R1 first, so any register works. `ctx` is copied to
+// R3 after that and before the callback pointer is loaded into R2, so it
+// can't be R1.
+//
+// The invocation of this function would look more or less like this:
+// for_each_map_elem(mapReg, callback, ctx, flags).
+func CallForEachMapElem(mapReg pb.Reg, callbackOffset int32, ctx pb.Reg, flags int32) ([]*pb.Instruction, error) {
+	return InstructionSequence(
+		Mov64(pb.Reg_R1, mapReg),
+		Mov64(pb.Reg_R3, ctx),
+		LdFunctionPtr(callbackOffset),
+		Mov64(pb.Reg_R4, flags),
+		Call(ForEachMapElem),
+	)
+}
+
+// jmpConditionDescriptions holds the english description of the condition
+// checked by each conditional jump, JSET is handled separately.
+var jmpConditionDescriptions = map[pb.JmpOperationCode]string{
+	pb.JmpOperationCode_JmpJEQ:  "equal to",
+	pb.JmpOperationCode_JmpJNE:  "not equal to",
+	pb.JmpOperationCode_JmpJGT:  "greater-than",
+	pb.JmpOperationCode_JmpJGE:  "greater-than-or-equal-to",
+	pb.JmpOperationCode_JmpJLT:  "less-than",
+	pb.JmpOperationCode_JmpJLE:  "less-than-or-equal-to",
+	pb.JmpOperationCode_JmpJSGT: "signed-greater-than",
+	pb.JmpOperationCode_JmpJSGE: "signed-greater-than-or-equal-to",
+	pb.JmpOperationCode_JmpJSLT: "signed-less-than",
+	pb.JmpOperationCode_JmpJSLE: "signed-less-than-or-equal-to",
+}
+
+func explainJumpOffset(offset int32) string {
+	if offset == 0 {
+		return "continue to the next instruction"
+	}
+	direction := "forward"
+	if offset < 0 {
+		direction = "backward"
+		offset = -offset
+	}
+	unit := "instructions"
+	if offset == 1 {
+		unit = "instruction"
+	}
+	return fmt.Sprintf("jump %d %s %s", offset, unit, direction)
+}
+
+// ExplainJump returns an english description of what the jump, call or exit
+// instruction `i` does, e.g. "if r1 (64-bit) is signed-less-than 5, jump 3
+// instructions forward; otherwise fall through". Offsets are expressed in
+// instruction slots, like in the bytecode.
+func ExplainJump(i *pb.Instruction) (string, error) {
+	jmp, ok := i.Opcode.(*pb.Instruction_JmpOpcode)
+	if !ok {
+		return "", fmt.Errorf("%v is not a jump instruction", i)
+	}
+	op := jmp.JmpOpcode
+
+	switch op.OperationCode {
+	case pb.JmpOperationCode_JmpExit:
+		return "exit the program, returning r0", nil
+	case pb.JmpOperationCode_JmpCALL:
+		if number, ok := HelperFunctionNumber(i); ok {
+			if name := GetBpfFuncName(number); name != "unknown" {
+				return fmt.Sprintf("call helper %d (%s)", number, name), nil
+			}
+			return fmt.Sprintf("call helper %d", number), nil
+		}
+		return fmt.Sprintf("call bpf function at %d instructions from here", i.Immediate+1), nil
+	case pb.JmpOperationCode_JmpJA:
+		return explainJumpOffset(i.Offset), nil
+	}
+
+	is32 := op.InstructionClass == pb.InsClass_InsClassJmp32
+	width := "64-bit"
+	if is32 {
+		width = "32-bit"
+	}
+	dst := RegisterName(i.DstReg, is32)
+	src := fmt.Sprintf("%d", i.Immediate)
+	if op.Source == pb.SrcOperand_RegSrc {
+		src = RegisterName(i.SrcReg, is32)
+	}
+
+	var condition string
+	if op.OperationCode == pb.JmpOperationCode_JmpJSET {
+		condition = fmt.Sprintf("%s & %s (%s) is not zero", dst, src, width)
+	} else if description, ok := jmpConditionDescriptions[op.OperationCode]; ok {
+		condition = fmt.Sprintf("%s (%s) is %s %s", dst, width, description, src)
+	} else {
+		return "", fmt.Errorf("unknown jump operation %v", op.OperationCode)
+	}
+	return fmt.Sprintf("if %s, %s; otherwise fall through", condition, explainJumpOffset(i.Offset)), nil
+}
+
 func Exit() *pb.Instruction {
 	return newJmpInstruction(pb.JmpOperationCode_JmpExit, pb.InsClass_InsClassJmp, pb.Reg_R0, int32(UnusedField), int16(UnusedField))
 }
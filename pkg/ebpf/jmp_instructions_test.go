@@ -16,6 +16,7 @@ package ebpf
 
 import (
 	pb "buzzer/proto/ebpf_go_proto"
+	"errors"
 	protobuf "github.com/golang/protobuf/proto"
 	"reflect"
 	"testing"
@@ -358,3 +359,714 @@ func TestJmpOperationCorrectEncoding(t *testing.T) {
 		})
 	}
 }
+
+func TestSelect(t *testing.T) {
+	cond := JmpEQ(pb.Reg_R1, 0, 99)
+	got, err := Select(cond, 1, 2, pb.Reg_R0)
+	if err != nil {
+		t.Fatalf("Select() unexpected error: %v", err)
+	}
+
+	want := []*pb.Instruction{
+		JmpEQ(pb.Reg_R1, 0, 2),
+		Mov64(pb.Reg_R0, 2),
+		Jmp(1),
+		Mov64(pb.Reg_R0, 1),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("len(Select()) = %d, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !protobuf.Equal(got[i], want[i]) {
+			t.Errorf("Select()[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	if cond.Offset != 99 {
+		t.Errorf("Select() modified the condition offset to %d", cond.Offset)
+	}
+
+	if _, err := Select(Mov64(pb.Reg_R0, 0), 1, 2, pb.Reg_R0); err == nil {
+		t.Errorf("Select() with a non jump condition expected error, got nil")
+	}
+}
+
+func TestHelperFunctionNumber(t *testing.T) {
+	pseudoCall := Call(2)
+	pseudoCall.SrcReg = pb.Reg_R1
+
+	tests := []struct {
+		testName    string
+		instruction *pb.Instruction
+		want        int32
+		wantOk      bool
+	}{
+		{
+			testName:    "Helper call",
+			instruction: Call(MapLookup),
+			want:        MapLookup,
+			wantOk:      true,
+		},
+		{
+			testName:    "Bpf to bpf call",
+			instruction: pseudoCall,
+			wantOk:      false,
+		},
+		{
+			testName:    "Kfunc call",
+			instruction: KfuncCall(1234),
+			wantOk:      false,
+		},
+		{
+			testName:    "Not a call",
+			instruction: Exit(),
+			wantOk:      false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got, ok := HelperFunctionNumber(tc.instruction)
+			if got != tc.want || ok != tc.wantOk {
+				t.Errorf("HelperFunctionNumber() = %d, %v, want %d, %v", got, ok, tc.want, tc.wantOk)
+			}
+		})
+	}
+}
+
+func TestCallKfunc(t *testing.T) {
+	got, err := CallKfunc(1234, pb.Reg_R6, pb.Reg_R7)
+	if err != nil {
+		t.Fatalf("CallKfunc() unexpected error: %v", err)
+	}
+	want := []*pb.Instruction{
+		Mov64(pb.Reg_R1, pb.Reg_R6),
+		Mov64(pb.Reg_R2, pb.Reg_R7),
+		KfuncCall(1234),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CallKfunc() = %v, want %v", got, want)
+	}
+	if call := got[2]; call.SrcReg != PseudoKfuncCall || call.Immediate != 1234 {
+		t.Errorf("KfuncCall() has src %v and imm %d, want %v and 1234", call.SrcReg, call.Immediate, PseudoKfuncCall)
+	}
+
+	if _, err := CallKfunc(1234, pb.Reg_R6, pb.Reg_R7, pb.Reg_R8, pb.Reg_R9, pb.Reg_R0, pb.Reg_R6); !errors.Is(err, ErrTooManyKfuncArgs) {
+		t.Errorf("CallKfunc() with 6 arguments error = %v, want %v", err, ErrTooManyKfuncArgs)
+	}
+}
+
+func TestExplainJump(t *testing.T) {
+	tests := []struct {
+		testName    string
+		instruction *pb.Instruction
+		want        string
+		wantError   bool
+	}{
+		{
+			testName:    "Signed immediate comparison",
+			instruction: JmpSLT(pb.Reg_R1, 5, 3),
+			want:        "if r1 (64-bit) is signed-less-than 5, jump 3 instructions forward; otherwise fall through",
+		},
+		{
+			testName:    "32-bit register comparison",
+			instruction: JmpEQ32(pb.Reg_R2, pb.Reg_R3, -1),
+			want:        "if w2 (32-bit) is equal to w3, jump 1 instruction backward; otherwise fall through",
+		},
+		{
+			testName:    "Bit test",
+			instruction: JmpSET(pb.Reg_R4, 8, 2),
+			want:        "if r4 & 8 (64-bit) is not zero, jump 2 instructions forward; otherwise fall through",
+		},
+		{
+			testName:    "Unconditional jump",
+			instruction: Jmp(4),
+			want:        "jump 4 instructions forward",
+		},
+		{
+			testName:    "Helper call",
+			instruction: Call(MapLookup),
+			want:        "call helper 1 (BPF_FUNC_map_lookup_elem)",
+		},
+		{
+			testName:    "Exit",
+			instruction: Exit(),
+			want:        "exit the program, returning r0",
+		},
+		{
+			testName:    "Not a jump",
+			instruction: Mov64(pb.Reg_R0, 0),
+			wantError:   true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got, err := ExplainJump(tc.instruction)
+			if tc.wantError {
+				if err == nil {
+					t.Fatalf("ExplainJump() expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ExplainJump() unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("ExplainJump() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStackTraceHelpers(t *testing.T) {
+	tests := []struct {
+		testName string
+		build    func() ([]*pb.Instruction, error)
+		want     []*pb.Instruction
+	}{
+		{
+			testName: "get_stackid",
+			build: func() ([]*pb.Instruction, error) {
+				return CallGetStackId(pb.Reg_R6, pb.Reg_R7, 0)
+			},
+			want: []*pb.Instruction{
+				Mov64(pb.Reg_R1, pb.Reg_R6),
+				Mov64(pb.Reg_R2, pb.Reg_R7),
+				Mov64(pb.Reg_R3, int32(0)),
+				Call(GetStackId),
+			},
+		},
+		{
+			testName: "get_stack with register size",
+			build: func() ([]*pb.Instruction, error) {
+				return CallGetStack(pb.Reg_R6, pb.Reg_R7, pb.Reg_R8, 256)
+			},
+			want: []*pb.Instruction{
+				Mov64(pb.Reg_R1, pb.Reg_R6),
+				Mov64(pb.Reg_R2, pb.Reg_R7),
+				Mov64(pb.Reg_R3, pb.Reg_R8),
+				Mov64(pb.Reg_R4, int32(256)),
+				Call(GetStack),
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got, err := tc.build()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRedirectHelpers(t *testing.T) {
+	tests := []struct {
+		testName string
+		build    func() ([]*pb.Instruction, error)
+		want     []*pb.Instruction
+	}{
+		{
+			testName: "redirect",
+			build: func() ([]*pb.Instruction, error) {
+				return CallRedirect(1, RedirectIngress)
+			},
+			want: []*pb.Instruction{
+				Mov64(pb.Reg_R1, int32(1)),
+				Mov64(pb.Reg_R2, int32(RedirectIngress)),
+				Call(Redirect),
+			},
+		},
+		{
+			testName: "clone_redirect with register ifindex",
+			build: func() ([]*pb.Instruction, error) {
+				return CallCloneRedirect(pb.Reg_R6, pb.Reg_R7, 0)
+			},
+			want: []*pb.Instruction{
+				Mov64(pb.Reg_R1, pb.Reg_R6),
+				Mov64(pb.Reg_R2, pb.Reg_R7),
+				Mov64(pb.Reg_R3, int32(0)),
+				Call(CloneRedirect),
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got, err := tc.build()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPacketRewriteHelpers(t *testing.T) {
+	tests := []struct {
+		testName string
+		build    func() ([]*pb.Instruction, error)
+		want     []*pb.Instruction
+	}{
+		{
+			testName: "skb_store_bytes",
+			build: func() ([]*pb.Instruction, error) {
+				return CallSkbStoreBytes(pb.Reg_R6, 14, pb.Reg_R7, 4, StoreBytesRecomputeCsum)
+			},
+			want: []*pb.Instruction{
+				Mov64(pb.Reg_R1, pb.Reg_R6),
+				Mov64(pb.Reg_R2, int32(14)),
+				Mov64(pb.Reg_R3, pb.Reg_R7),
+				Mov64(pb.Reg_R4, int32(4)),
+				Mov64(pb.Reg_R5, int32(StoreBytesRecomputeCsum)),
+				Call(SkbStoreBytes),
+			},
+		},
+		{
+			testName: "l3_csum_replace with registers",
+			build: func() ([]*pb.Instruction, error) {
+				return CallL3CsumReplace(pb.Reg_R6, 24, pb.Reg_R8, pb.Reg_R9, 4)
+			},
+			want: []*pb.Instruction{
+				Mov64(pb.Reg_R1, pb.Reg_R6),
+				Mov64(pb.Reg_R2, int32(24)),
+				Mov64(pb.Reg_R3, pb.Reg_R8),
+				Mov64(pb.Reg_R4, pb.Reg_R9),
+				Mov64(pb.Reg_R5, int32(4)),
+				Call(L3CsumReplace),
+			},
+		},
+		{
+			testName: "l4_csum_replace with immediates",
+			build: func() ([]*pb.Instruction, error) {
+				return CallL4CsumReplace(pb.Reg_R6, 50, 0, 0x1234, CsumPseudoHdr|2)
+			},
+			want: []*pb.Instruction{
+				Mov64(pb.Reg_R1, pb.Reg_R6),
+				Mov64(pb.Reg_R2, int32(50)),
+				Mov64(pb.Reg_R3, int32(0)),
+				Mov64(pb.Reg_R4, int32(0x1234)),
+				Mov64(pb.Reg_R5, int32(CsumPseudoHdr|2)),
+				Call(L4CsumReplace),
+			},
+		},
+		{
+			testName: "csum_diff with immediates",
+			build: func() ([]*pb.Instruction, error) {
+				return CallCsumDiff(pb.Reg_R6, 4, pb.Reg_R7, 8, 0)
+			},
+			want: []*pb.Instruction{
+				Mov64(pb.Reg_R1, pb.Reg_R6),
+				Mov64(pb.Reg_R2, int32(4)),
+				Mov64(pb.Reg_R3, pb.Reg_R7),
+				Mov64(pb.Reg_R4, int32(8)),
+				Mov64(pb.Reg_R5, int32(0)),
+				Call(CsumDiff),
+			},
+		},
+		{
+			testName: "csum_diff with registers",
+			build: func() ([]*pb.Instruction, error) {
+				return CallCsumDiff(pb.Reg_R6, pb.Reg_R7, pb.Reg_R8, pb.Reg_R9, pb.Reg_R0)
+			},
+			want: []*pb.Instruction{
+				Mov64(pb.Reg_R1, pb.Reg_R6),
+				Mov64(pb.Reg_R2, pb.Reg_R7),
+				Mov64(pb.Reg_R3, pb.Reg_R8),
+				Mov64(pb.Reg_R4, pb.Reg_R9),
+				Mov64(pb.Reg_R5, pb.Reg_R0),
+				Call(CsumDiff),
+			},
+		},
+		{
+			testName: "trace_printk with immediates",
+			build: func() ([]*pb.Instruction, error) {
+				return CallTracePrintk(-16, 12, 42)
+			},
+			want: []*pb.Instruction{
+				Mov64(pb.Reg_R1, pb.Reg_R10),
+				Add64(pb.Reg_R1, int32(-16)),
+				Mov64(pb.Reg_R2, int32(12)),
+				Mov64(pb.Reg_R3, int32(42)),
+				Call(TracePrintk),
+			},
+		},
+		{
+			testName: "trace_printk with registers",
+			build: func() ([]*pb.Instruction, error) {
+				return CallTracePrintk(-8, pb.Reg_R6, pb.Reg_R7)
+			},
+			want: []*pb.Instruction{
+				Mov64(pb.Reg_R1, pb.Reg_R10),
+				Add64(pb.Reg_R1, int32(-8)),
+				Mov64(pb.Reg_R2, pb.Reg_R6),
+				Mov64(pb.Reg_R3, pb.Reg_R7),
+				Call(TracePrintk),
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got, err := tc.build()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTracingHelpers(t *testing.T) {
+	tests := []struct {
+		testName string
+		build    func() ([]*pb.Instruction, error)
+		want     []*pb.Instruction
+	}{
+		{
+			testName: "get_func_arg with an immediate index",
+			build: func() ([]*pb.Instruction, error) {
+				return CallGetFuncArg(pb.Reg_R6, 2, pb.Reg_R7)
+			},
+			want: []*pb.Instruction{
+				Mov64(pb.Reg_R1, pb.Reg_R6),
+				Mov64(pb.Reg_R2, int32(2)),
+				Mov64(pb.Reg_R3, pb.Reg_R7),
+				Call(GetFuncArg),
+			},
+		},
+		{
+			testName: "get_func_arg with a register index",
+			build: func() ([]*pb.Instruction, error) {
+				return CallGetFuncArg(pb.Reg_R6, pb.Reg_R8, pb.Reg_R7)
+			},
+			want: []*pb.Instruction{
+				Mov64(pb.Reg_R1, pb.Reg_R6),
+				Mov64(pb.Reg_R2, pb.Reg_R8),
+				Mov64(pb.Reg_R3, pb.Reg_R7),
+				Call(GetFuncArg),
+			},
+		},
+		{
+			testName: "get_func_ret",
+			build: func() ([]*pb.Instruction, error) {
+				return CallGetFuncRet(pb.Reg_R6, pb.Reg_R7)
+			},
+			want: []*pb.Instruction{
+				Mov64(pb.Reg_R1, pb.Reg_R6),
+				Mov64(pb.Reg_R2, pb.Reg_R7),
+				Call(GetFuncRet),
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got, err := tc.build()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPerfEventOutput(t *testing.T) {
+	tests := []struct {
+		testName string
+		build    func() ([]*pb.Instruction, error)
+		want     []*pb.Instruction
+	}{
+		{
+			testName: "perf_event_output on the current cpu",
+			build: func() ([]*pb.Instruction, error) {
+				return CallPerfEventOutput(pb.Reg_R6, pb.Reg_R7, PerfEventCurrentCpu, pb.Reg_R8, 16)
+			},
+			want: []*pb.Instruction{
+				Mov64(pb.Reg_R1, pb.Reg_R6),
+				Mov64(pb.Reg_R2, pb.Reg_R7),
+				Mov(pb.Reg_R3, int32(-1)),
+				Mov64(pb.Reg_R4, pb.Reg_R8),
+				Mov64(pb.Reg_R5, 16),
+				Call(PerfEventOutput),
+			},
+		},
+		{
+			testName: "perf_event_output with registers",
+			build: func() ([]*pb.Instruction, error) {
+				return CallPerfEventOutput(pb.Reg_R6, pb.Reg_R7, pb.Reg_R8, pb.Reg_R9, pb.Reg_R9)
+			},
+			want: []*pb.Instruction{
+				Mov64(pb.Reg_R1, pb.Reg_R6),
+				Mov64(pb.Reg_R2, pb.Reg_R7),
+				Mov64(pb.Reg_R3, pb.Reg_R8),
+				Mov64(pb.Reg_R4, pb.Reg_R9),
+				Mov64(pb.Reg_R5, pb.Reg_R9),
+				Call(PerfEventOutput),
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got, err := tc.build()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDynptrHelpers(t *testing.T) {
+	tests := []struct {
+		testName string
+		build    func() ([]*pb.Instruction, error)
+		want     []*pb.Instruction
+	}{
+		{
+			testName: "dynptr_from_mem",
+			build: func() ([]*pb.Instruction, error) {
+				return CallDynptrFromMem(pb.Reg_R6, 64, 0, pb.Reg_R7)
+			},
+			want: []*pb.Instruction{
+				Mov64(pb.Reg_R1, pb.Reg_R6),
+				Mov64(pb.Reg_R2, int32(64)),
+				Mov64(pb.Reg_R3, int32(0)),
+				Mov64(pb.Reg_R4, pb.Reg_R7),
+				Call(DynptrFromMem),
+			},
+		},
+		{
+			testName: "dynptr_read",
+			build: func() ([]*pb.Instruction, error) {
+				return CallDynptrRead(pb.Reg_R8, 8, pb.Reg_R7, 4, 0)
+			},
+			want: []*pb.Instruction{
+				Mov64(pb.Reg_R1, pb.Reg_R8),
+				Mov64(pb.Reg_R2, int32(8)),
+				Mov64(pb.Reg_R3, pb.Reg_R7),
+				Mov64(pb.Reg_R4, int32(4)),
+				Mov64(pb.Reg_R5, int32(0)),
+				Call(DynptrRead),
+			},
+		},
+		{
+			testName: "dynptr_write with register length",
+			build: func() ([]*pb.Instruction, error) {
+				return CallDynptrWrite(pb.Reg_R7, pb.Reg_R9, pb.Reg_R8, pb.Reg_R6, 0)
+			},
+			want: []*pb.Instruction{
+				Mov64(pb.Reg_R1, pb.Reg_R7),
+				Mov64(pb.Reg_R2, pb.Reg_R9),
+				Mov64(pb.Reg_R3, pb.Reg_R8),
+				Mov64(pb.Reg_R4, pb.Reg_R6),
+				Mov64(pb.Reg_R5, int32(0)),
+				Call(DynptrWrite),
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got, err := tc.build()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCallForEachMapElem(t *testing.T) {
+	got, err := CallForEachMapElem(pb.Reg_R6, 10, pb.Reg_R10, 0)
+	if err != nil {
+		t.Fatalf("CallForEachMapElem() unexpected error: %v", err)
+	}
+	want := []*pb.Instruction{
+		Mov64(pb.Reg_R1, pb.Reg_R6),
+		Mov64(pb.Reg_R3, pb.Reg_R10),
+		LdFunctionPtr(10),
+		Mov64(pb.Reg_R4, int32(0)),
+		Call(ForEachMapElem),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CallForEachMapElem() = %v, want %v", got, want)
+	}
+}
+
+func TestExitWithAction(t *testing.T) {
+	tests := []struct {
+		testName  string
+		generator func() ([]*pb.Instruction, error)
+		wantValue int32
+	}{
+		{
+			testName:  "Raw value",
+			generator: func() ([]*pb.Instruction, error) { return ExitWithValue(42) },
+			wantValue: 42,
+		},
+		{
+			testName:  "XDP pass",
+			generator: func() ([]*pb.Instruction, error) { return ExitXDP(XdpPass) },
+			wantValue: 2,
+		},
+		{
+			testName:  "TC shot",
+			generator: func() ([]*pb.Instruction, error) { return ExitTC(TcActShot) },
+			wantValue: 2,
+		},
+		{
+			testName:  "TC unspec",
+			generator: func() ([]*pb.Instruction, error) { return ExitTC(TcActUnspec) },
+			wantValue: -1,
+		},
+	}
+
+	for _, c := range tests {
+		t.Run(c.testName, func(t *testing.T) {
+			got, err := c.generator()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want := []*pb.Instruction{Mov64(pb.Reg_R0, c.wantValue), Exit()}
+			if len(got) != len(want) {
+				t.Fatalf("got %d instructions, want %d", len(got), len(want))
+			}
+			for i := range want {
+				if !protobuf.Equal(got[i], want[i]) {
+					t.Errorf("instruction %d = %v, want %v", i, got[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestXdpPacketAccessPreamble(t *testing.T) {
+	preamble, data, err := XdpPacketAccessPreamble(14)
+	if err != nil {
+		t.Fatalf("XdpPacketAccessPreamble() unexpected error: %v", err)
+	}
+	if data != pb.Reg_R2 {
+		t.Errorf("XdpPacketAccessPreamble() data register = %v, want %v", data, pb.Reg_R2)
+	}
+	if !protobuf.Equal(preamble[3], Add64(pb.Reg_R4, int32(14))) {
+		t.Errorf("XdpPacketAccessPreamble() bounds computation = %v, want r4 += 14", preamble[3])
+	}
+
+	// The bounds check has to skip the early exit and land on whatever
+	// comes after the preamble.
+	program := append(preamble, LdB(pb.Reg_R0, data, 13), Exit())
+	if err := Validate(program); err != nil {
+		t.Fatalf("XdpPacketAccessPreamble() produced an invalid program: %v", err)
+	}
+	check := len(preamble) - 3
+	if !isConditionalJump(program[check]) {
+		t.Fatalf("instruction %d = %v, want the bounds check", check, program[check])
+	}
+	if target := jumpTargets(program)[check]; target != len(preamble) {
+		t.Errorf("bounds check jumps to %d, want %d", target, len(preamble))
+	}
+	if value, ok := ExitValue(program, len(preamble)-1); !ok || value != int64(XdpPass) {
+		t.Errorf("ExitValue() of the early exit = %d, %v, want %d, true", value, ok, XdpPass)
+	}
+
+	if _, _, err := XdpPacketAccessPreamble(0); err == nil {
+		t.Errorf("XdpPacketAccessPreamble(0) expected error, got nil")
+	}
+}
+
+func TestNullCheckR0(t *testing.T) {
+	exit, err := ExitWithValue(0)
+	if err != nil {
+		t.Fatalf("ExitWithValue() unexpected error: %v", err)
+	}
+	lookup, err := LdMapElement(pb.Reg_R6, 0, pb.Reg_R10, -4)
+	if err != nil {
+		t.Fatalf("LdMapElement() unexpected error: %v", err)
+	}
+	check, err := NullCheckR0(append([]*pb.Instruction{Mov64(pb.Reg_R1, int64(1)<<40)}, exit...)...)
+	if err != nil {
+		t.Fatalf("NullCheckR0() unexpected error: %v", err)
+	}
+
+	// The check has to skip the wide instruction and the exit, landing
+	// on the dereference.
+	program := append(append(lookup, check...), LdDW(pb.Reg_R0, pb.Reg_R0, 0), Exit())
+	checkIndex := len(lookup)
+	want := len(lookup) + len(check)
+	if target := jumpTargets(program)[checkIndex]; target != want {
+		t.Errorf("null check jumps to %d, want %d", target, want)
+	}
+	if err := Validate(program); err != nil {
+		t.Errorf("NullCheckR0() produced an invalid program: %v", err)
+	}
+
+	if _, err := NullCheckR0(); !errors.Is(err, ErrEmptySequence) {
+		t.Errorf("NullCheckR0() without instructions error = %v, want %v", err, ErrEmptySequence)
+	}
+}
+
+func TestLdMapInMapElement(t *testing.T) {
+	got, err := LdMapInMapElement(pb.Reg_R6, 2, 3, pb.Reg_R10, -4)
+	if err != nil {
+		t.Fatalf("LdMapInMapElement() unexpected error: %v", err)
+	}
+
+	want := []*pb.Instruction{
+		Mov64(pb.Reg_R1, pb.Reg_R6),
+		StW(pb.Reg_R10, 2, -4),
+		Mov64(pb.Reg_R2, pb.Reg_R10),
+		Add64(pb.Reg_R2, -4),
+		Call(MapLookup),
+		JmpNE(pb.Reg_R0, 0, 1),
+		Exit(),
+		Mov64(pb.Reg_R1, pb.Reg_R0),
+		StW(pb.Reg_R10, 3, -4),
+		Mov64(pb.Reg_R2, pb.Reg_R10),
+		Add64(pb.Reg_R2, -4),
+		Call(MapLookup),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("len(LdMapInMapElement()) = %d, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !protobuf.Equal(got[i], want[i]) {
+			t.Errorf("LdMapInMapElement()[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	// The null check of the inner map pointer skips only the exit and
+	// lands on the inner lookup, after the wide map load.
+	program := append([]*pb.Instruction{LdMapByFd(pb.Reg_R6, 3)}, got...)
+	program = append(program,
+		JmpNE(pb.Reg_R0, 0, 1),
+		Exit(),
+		LdDW(pb.Reg_R0, pb.Reg_R0, 0),
+		Exit(),
+	)
+	if target := jumpTargets(program)[6]; target != 8 {
+		t.Errorf("inner map null check jumps to %d, want 8", target)
+	}
+	if exit := program[7]; !isExit(exit) {
+		t.Errorf("inner map null check skips %v, want an exit", exit)
+	}
+	if err := Validate(program); err != nil {
+		t.Errorf("LdMapInMapElement() produced an invalid program: %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+	"fmt"
+	"math"
+
+	protobuf "github.com/golang/protobuf/proto"
+)
+
+// Label identifies an instruction in a labeled program.
+type Label int
+
+// NoLabel is the target of instructions that don't jump anywhere.
+const NoLabel Label = -1
+
+// LabeledInstruction is an instruction whose jump target is expressed as the
+// label of another instruction instead of as an offset. This makes inserting
+// and removing instructions trivial, as offsets are only computed once the
+// program is turned back into plain instructions.
+type LabeledInstruction struct {
+	Label       Label
+	Instruction *pb.Instruction
+
+	// Target is the label this instruction jumps to, NoLabel if it is not
+	// a jump.
+	Target Label
+}
+
+// ToLabeled converts `instructions` to the labeled form, instruction `i` gets
+// Label(i).
+//
+// Jumps that don't land on an instruction return ErrInvalidJumpTarget: they
+// have no label to point to, and their offset would point somewhere else as
+// soon as the number of slots around them changes.
+func ToLabeled(instructions []*pb.Instruction) ([]LabeledInstruction, error) {
+	targets := jumpTargets(instructions)
+	labeled := make([]LabeledInstruction, len(instructions))
+	for index, inst := range instructions {
+		labeled[index] = LabeledInstruction{
+			Label:       Label(index),
+			Instruction: inst,
+			Target:      NoLabel,
+		}
+		if !isJump(inst) {
+			continue
+		}
+		if targets[index] < 0 {
+			return nil, fmt.Errorf("instruction %d: %w", index, ErrInvalidJumpTarget)
+		}
+		labeled[index].Target = Label(targets[index])
+	}
+	return labeled, nil
+}
+
+// FromLabeled converts `labeled` back to plain instructions, resolving the
+// offset of every jump with a Target. Converting the output of ToLabeled
+// back yields the same bytecode.
+//
+// Instructions whose offset changes are copied, the ones in `labeled` are
+// never modified.
+func FromLabeled(labeled []LabeledInstruction) ([]*pb.Instruction, error) {
+	instructions := make([]*pb.Instruction, len(labeled))
+	indexes := make(map[Label]int)
+	for index, l := range labeled {
+		if _, ok := indexes[l.Label]; ok {
+			return nil, fmt.Errorf("Duplicate label %d", l.Label)
+		}
+		indexes[l.Label] = index
+		instructions[index] = l.Instruction
+	}
+
+	slots := slotIndexes(instructions)
+	for index, l := range labeled {
+		if l.Target == NoLabel {
+			continue
+		}
+		target, ok := indexes[l.Target]
+		if !ok {
+			return nil, fmt.Errorf("Instruction %d jumps to undefined label %d", index, l.Target)
+		}
+		offset := slots[target] - slots[index] - 1
+		if offset < math.MinInt16 || offset > math.MaxInt16 {
+			return nil, fmt.Errorf("Jump offset %d at instruction %d does not fit in 16 bits", offset, index)
+		}
+		if l.Instruction.Offset != int32(offset) {
+			relinked := protobuf.Clone(l.Instruction).(*pb.Instruction)
+			relinked.Offset = int32(offset)
+			instructions[index] = relinked
+		}
+	}
+	return instructions, nil
+}
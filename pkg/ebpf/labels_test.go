@@ -0,0 +1,203 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+func encodeForTest(t *testing.T, instructions []*pb.Instruction) []uint64 {
+	t.Helper()
+	encoding, err := NewBatchEncoder(0).Encode(&pb.Program{
+		Functions: []*pb.Functions{{Instructions: instructions}},
+	})
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	return encoding
+}
+
+func TestLabeledRoundTrip(t *testing.T) {
+	instructions := []*pb.Instruction{
+		Mov64(R0, 0),
+		JmpEQ(R1, 0, 4),
+		Mov64(R2, int64(1)<<40),
+		JmpGT(R2, R1, -4),
+		Add64(R0, 1),
+		Exit(),
+	}
+
+	labeled, err := ToLabeled(instructions)
+	if err != nil {
+		t.Fatalf("ToLabeled() unexpected error: %v", err)
+	}
+	got, err := FromLabeled(labeled)
+	if err != nil {
+		t.Fatalf("FromLabeled() unexpected error: %v", err)
+	}
+	if want := encodeForTest(t, instructions); !reflect.DeepEqual(encodeForTest(t, got), want) {
+		t.Errorf("FromLabeled(ToLabeled()) changed the bytecode")
+	}
+}
+
+func TestLabeledInsertion(t *testing.T) {
+	labeled, err := ToLabeled([]*pb.Instruction{
+		JmpEQ(R1, 0, 1),
+		Mov64(R0, 1),
+		Exit(),
+	})
+	if err != nil {
+		t.Fatalf("ToLabeled() unexpected error: %v", err)
+	}
+
+	// Insert a wide instruction inside the jump, the offset should grow by
+	// two slots.
+	inserted := LabeledInstruction{Label: 100, Instruction: Mov64(R3, int64(1)<<40), Target: NoLabel}
+	labeled = append(labeled[:2], append([]LabeledInstruction{inserted}, labeled[2:]...)...)
+
+	got, err := FromLabeled(labeled)
+	if err != nil {
+		t.Fatalf("FromLabeled() unexpected error: %v", err)
+	}
+	if got[0].Offset != 3 {
+		t.Errorf("FromLabeled() jump offset = %d, want 3", got[0].Offset)
+	}
+
+	// Removing the target is an error.
+	if _, err := FromLabeled(labeled[:3]); err == nil {
+		t.Errorf("FromLabeled() with an undefined target expected error, got nil")
+	}
+}
+
+func TestLabeledInvalidTarget(t *testing.T) {
+	for _, tc := range []struct {
+		testName     string
+		instructions []*pb.Instruction
+	}{
+		{
+			testName:     "Past the end",
+			instructions: []*pb.Instruction{JmpEQ(R1, 0, 1), Exit()},
+		},
+		{
+			testName:     "Before the start",
+			instructions: []*pb.Instruction{Mov64(R0, 0), Jmp(-3), Exit()},
+		},
+		{
+			testName:     "Second slot of a wide instruction",
+			instructions: []*pb.Instruction{JmpEQ(R1, 0, 1), Mov64(R0, int64(1)<<40), Exit()},
+		},
+	} {
+		t.Run(tc.testName, func(t *testing.T) {
+			if _, err := ToLabeled(tc.instructions); !errors.Is(err, ErrInvalidJumpTarget) {
+				t.Errorf("ToLabeled() error = %v, want %v", err, ErrInvalidJumpTarget)
+			}
+		})
+	}
+}
+
+// TestNestedJumpNumbering checks the slot numbers and jump offsets
+// FromLabeled computes when jumps are nested on both sides of a branch and
+// wide instructions shift everything after them.
+func TestNestedJumpNumbering(t *testing.T) {
+	wide := func(reg pb.Reg) *pb.Instruction { return Mov64(reg, int64(1)<<40) }
+	tests := []struct {
+		testName     string
+		instructions []*pb.Instruction
+		// Label of the instruction each one jumps to, labels are the
+		// original indexes.
+		targets     []Label
+		wantSlots   []int
+		wantOffsets []int32
+	}{
+		{
+			testName: "Jumps on both arms",
+			instructions: []*pb.Instruction{
+				JmpEQ(R1, 0, 0),
+				JmpEQ(R2, 0, 0),
+				wide(R0),
+				Mov64(R0, 1),
+				Jmp(0),
+				JmpEQ(R3, 0, 0),
+				Mov64(R0, 2),
+				Mov64(R0, 3),
+				Exit(),
+			},
+			targets:     []Label{5, 3, NoLabel, NoLabel, 8, 7, NoLabel, NoLabel, NoLabel},
+			wantSlots:   []int{0, 1, 2, 4, 5, 6, 7, 8, 9},
+			wantOffsets: []int32{5, 2, 0, 0, 3, 1, 0, 0, 0},
+		},
+		{
+			testName: "Backward jump inside a forward one",
+			instructions: []*pb.Instruction{
+				Mov64(R0, 0),
+				JmpEQ(R1, 0, 0),
+				JmpGT(R2, R1, 0),
+				wide(R3),
+				JmpEQ(R3, 0, 0),
+				wide(R4),
+				Exit(),
+			},
+			targets:     []Label{NoLabel, 4, 1, NoLabel, 6, NoLabel, NoLabel},
+			wantSlots:   []int{0, 1, 2, 3, 5, 6, 8},
+			wantOffsets: []int32{0, 3, -2, 0, 2, 0, 0},
+		},
+		{
+			testName: "Jump to the next instruction after a wide one",
+			instructions: []*pb.Instruction{
+				wide(R1),
+				JmpEQ(R1, 0, 0),
+				Exit(),
+			},
+			targets:     []Label{NoLabel, 2, NoLabel},
+			wantSlots:   []int{0, 2, 3},
+			wantOffsets: []int32{0, 0, 0},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			labeled, err := ToLabeled(tc.instructions)
+			if err != nil {
+				t.Fatalf("ToLabeled() unexpected error: %v", err)
+			}
+			for index := range labeled {
+				labeled[index].Target = tc.targets[index]
+			}
+			got, err := FromLabeled(labeled)
+			if err != nil {
+				t.Fatalf("FromLabeled() unexpected error: %v", err)
+			}
+			if slots := slotIndexes(got); !reflect.DeepEqual(slots, tc.wantSlots) {
+				t.Errorf("slotIndexes() = %v, want %v", slots, tc.wantSlots)
+			}
+			for index, i := range got {
+				if isJump(i) && i.Offset != tc.wantOffsets[index] {
+					t.Errorf("instruction %d offset = %d, want %d", index, i.Offset, tc.wantOffsets[index])
+				}
+			}
+			// And the offsets have to lead back to the labeled targets,
+			// non jumps get -1 which is also NoLabel.
+			for index, target := range jumpTargets(got) {
+				if Label(target) != tc.targets[index] {
+					t.Errorf("instruction %d jumps to %d, want %d", index, target, tc.targets[index])
+				}
+			}
+		})
+	}
+}
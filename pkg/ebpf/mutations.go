@@ -0,0 +1,658 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"buzzer/pkg/rand"
+	pb "buzzer/proto/ebpf_go_proto"
+	"errors"
+	"fmt"
+	"math"
+
+	protobuf "github.com/golang/protobuf/proto"
+)
+
+// InstructionGenerator returns a new instruction to be placed at a position
+// that has `remaining` instructions after it in the region being generated.
+// Jumps it returns must have an offset, counted in instructions, of at most
+// `remaining`.
+type InstructionGenerator func(remaining int) *pb.Instruction
+
+// RandomInstructionGenerator is an InstructionGenerator that returns random
+// ALU and conditional jump instructions.
+func RandomInstructionGenerator(remaining int) *pb.Instruction {
+	if remaining == 0 || rand.SharedRNG.RandRange(1, 100) > 30 {
+		return RandomAluInstruction()
+	}
+	return RandomJmpInstruction(uint64(remaining))
+}
+
+// SwapAdjacent picks two adjacent non control flow instructions that don't
+// depend on each other and swaps them in place. Returns false if no such
+// pair exists.
+//
+// Reordering independent instructions doesn't change what the program
+// computes but it does change the order in which the verifier and the JIT
+// see things. Pinned instructions are never moved.
+func SwapAdjacent(instructions []*pb.Instruction, rng *rand.NumGen) bool {
+	targets := jumpTargets(instructions)
+	isTarget := make(map[int]bool)
+	for _, target := range targets {
+		if target >= 0 {
+			isTarget[target] = true
+		}
+	}
+
+	candidates := []int{}
+	for index := 0; index+1 < len(instructions); index++ {
+		a, b := instructions[index], instructions[index+1]
+		if a.Pinned || b.Pinned {
+			continue
+		}
+		if _, ok := a.Opcode.(*pb.Instruction_JmpOpcode); ok {
+			continue
+		}
+		if _, ok := b.Opcode.(*pb.Instruction_JmpOpcode); ok {
+			continue
+		}
+		// If something jumps to the second instruction, after the swap
+		// that jump would skip the first one.
+		if isTarget[index+1] {
+			continue
+		}
+		if !areIndependent(a, b) {
+			continue
+		}
+		candidates = append(candidates, index)
+	}
+
+	if len(candidates) == 0 {
+		return false
+	}
+
+	index := candidates[rng.RandRange(0, uint64(len(candidates)-1))]
+	instructions[index], instructions[index+1] = instructions[index+1], instructions[index]
+	return true
+}
+
+// RetargetJump picks a conditional jump and points it to a different
+// instruction after it, replacing the jump in place with a copy that has
+// the new offset. Returns false if no jump can be retargeted.
+//
+// Only forward targets past the next instruction are picked, so the new
+// branch can't form a loop or be a no-op, and the offset always fits in 16
+// bits. Targets that leave an instruction unreachable, which the verifier
+// rejects, are skipped, as are targets that make a valid program fail
+// Validate, e.g. by jumping over the only write to R0. Changing where
+// branches go without adding or removing instructions makes the verifier
+// explore different paths and merge different states. Pinned jumps are
+// never retargeted.
+func RetargetJump(instructions []*pb.Instruction, rng *rand.NumGen) bool {
+	targets := jumpTargets(instructions)
+	slots := slotIndexes(instructions)
+	candidates := [][]int{}
+	jumps := []int{}
+	for index, inst := range instructions {
+		if inst.Pinned || !isConditionalJump(inst) {
+			continue
+		}
+		newTargets := []int{}
+		for target := index + 2; target < len(instructions); target++ {
+			if target != targets[index] && slots[target]-slots[index]-1 <= math.MaxInt16 {
+				newTargets = append(newTargets, target)
+			}
+		}
+		if len(newTargets) > 0 {
+			jumps = append(jumps, index)
+			candidates = append(candidates, newTargets)
+		}
+	}
+
+	valid := Validate(instructions) == nil
+	for len(jumps) > 0 {
+		pick := rng.RandRange(0, uint64(len(jumps)-1))
+		index, newTargets := jumps[pick], candidates[pick]
+		for len(newTargets) > 0 {
+			t := rng.RandRange(0, uint64(len(newTargets)-1))
+			target := newTargets[t]
+			newTargets = append(newTargets[:t], newTargets[t+1:]...)
+
+			original := instructions[index]
+			retargeted := protobuf.Clone(original).(*pb.Instruction)
+			retargeted.Offset = int32(slots[target] - slots[index] - 1)
+			instructions[index] = retargeted
+			if allReachable(instructions) && (!valid || Validate(instructions) == nil) {
+				return true
+			}
+			instructions[index] = original
+		}
+		jumps = append(jumps[:pick], jumps[pick+1:]...)
+		candidates = append(candidates[:pick], candidates[pick+1:]...)
+	}
+	return false
+}
+
+// allReachable returns true if every instruction can be reached from the
+// first one.
+func allReachable(instructions []*pb.Instruction) bool {
+	for _, reachable := range reachableInstructions(instructions) {
+		if !reachable {
+			return false
+		}
+	}
+	return true
+}
+
+// GenerateInRange replaces the instructions in [start, end) with the ones
+// returned by `generator`, leaving the rest of the program untouched. This
+// allows focusing generation on a region of interest, e.g. one that coverage
+// says is close to new code.
+//
+// Jumps are re-linked afterwards: jumps outside the region keep pointing to
+// the same instruction even if the region changes size in slots, and jumps
+// generated inside it can only land within the region or right after it.
+// The offsets of generated jumps count instructions, like the `remaining`
+// passed to `generator`, since the instructions after them don't exist yet;
+// they are converted to slots once the region is complete, so the generator
+// can emit 64-bit immediate loads. Pinned instructions in the region are
+// kept as they are.
+//
+// Once the generator emits an exit, or an unconditional jump, generation
+// stops until a position that something jumps to: the instructions in
+// between could never run and the verifier rejects unreachable code, so
+// they are left out of the program. Generated jumps that land on one of
+// them land on the next instruction that is kept.
+//
+// If the region runs to the end of the program, generation stops wherever
+// the generator left it: generated jumps can also land right after the
+// program and, when they do or the last instruction falls through, the
+// program is finished with the termination strategy like in Terminate.
+func GenerateInRange(instructions []*pb.Instruction, start, end int, generator InstructionGenerator) ([]*pb.Instruction, error) {
+	if start < 0 || end > len(instructions) || start >= end {
+		return nil, fmt.Errorf("Invalid range [%d, %d) for a program of %d instructions", start, end, len(instructions))
+	}
+
+	labeled, err := ToLabeled(instructions)
+	if err != nil {
+		return nil, err
+	}
+	dropped := make([]bool, len(labeled))
+	// targeted returns true if a kept instruction jumps to `label`. The
+	// instructions of the region that are still to be generated don't
+	// count, they are going to be replaced.
+	targeted := func(label Label, index int) bool {
+		for i, l := range labeled {
+			if dropped[i] || (i >= index && i < end && !l.Instruction.Pinned) {
+				continue
+			}
+			if l.Target == label {
+				return true
+			}
+		}
+		return false
+	}
+	pastEnd := []int{}
+	prev := start - 1
+	for index := start; index < end; index++ {
+		if labeled[index].Instruction.Pinned {
+			prev = index
+			continue
+		}
+		if prev >= start && !fallsThrough(labeled[prev].Instruction) && !targeted(labeled[index].Label, index) {
+			dropped[index] = true
+			continue
+		}
+		prev = index
+		remaining := end - index - 1
+		instruction := generator(remaining)
+		if instruction == nil {
+			return nil, fmt.Errorf("%w at index %d", ErrNilInstruction, index)
+		}
+		labeled[index].Instruction = instruction
+		labeled[index].Target = NoLabel
+		if isJump(instruction) {
+			target := index + 1 + int(instruction.Offset)
+			if target < start || target > end {
+				return nil, fmt.Errorf("Generated jump at index %d lands outside of the range", index)
+			}
+			if target == len(labeled) {
+				pastEnd = append(pastEnd, index)
+				continue
+			}
+			labeled[index].Target = labeled[target].Label
+		}
+	}
+
+	last := end - 1
+	for dropped[last] {
+		last--
+	}
+	if end == len(labeled) && (len(pastEnd) > 0 || fallsThrough(labeled[last].Instruction)) {
+		sequence, err := terminationSequence()
+		if err != nil {
+			return nil, err
+		}
+		// Jumps in the sequence are relative to it and it goes last, so
+		// they don't need to be relinked.
+		first := Label(len(labeled))
+		for i, instruction := range sequence {
+			labeled = append(labeled, LabeledInstruction{Label: first + Label(i), Instruction: instruction, Target: NoLabel})
+		}
+		for _, index := range pastEnd {
+			labeled[index].Target = first
+		}
+	}
+	return FromLabeled(withoutDropped(labeled, dropped))
+}
+
+// withoutDropped returns the instructions of `labeled` that are not
+// `dropped`, with jumps to dropped instructions moved to the first kept
+// instruction after them.
+func withoutDropped(labeled []LabeledInstruction, dropped []bool) []LabeledInstruction {
+	next := make(map[Label]Label)
+	for i := len(labeled) - 1; i >= 0; i-- {
+		if i < len(dropped) && dropped[i] {
+			if i+1 < len(labeled) {
+				next[labeled[i].Label] = next[labeled[i+1].Label]
+			}
+			continue
+		}
+		next[labeled[i].Label] = labeled[i].Label
+	}
+	kept := []LabeledInstruction{}
+	for i, l := range labeled {
+		if i < len(dropped) && dropped[i] {
+			continue
+		}
+		if target, ok := next[l.Target]; ok && l.Target != NoLabel {
+			l.Target = target
+		}
+		kept = append(kept, l)
+	}
+	return kept
+}
+
+// ReplaceAt replaces the instruction at `index` with `replacement`, which
+// is spliced into the program as a single unit: jumps that landed on the
+// old instruction land on the first instruction of the replacement, and the
+// rest of the program keeps its jump targets even if the replacement takes
+// a different number of slots. This is the building block for targeted
+// edits, e.g. rewriting an instruction that coverage says is interesting.
+//
+// Jump offsets in `replacement` are relative to the replacement itself and
+// can only land within it or right after it, on the instruction that
+// followed the replaced one. Pinned instructions can't be replaced.
+func ReplaceAt(instructions []*pb.Instruction, index int, replacement []*pb.Instruction) ([]*pb.Instruction, error) {
+	if index < 0 || index >= len(instructions) {
+		return nil, fmt.Errorf("Invalid index %d for a program of %d instructions", index, len(instructions))
+	}
+	if len(replacement) == 0 {
+		return nil, ErrEmptySequence
+	}
+	if instructions[index].Pinned {
+		return nil, fmt.Errorf("Instruction %d is pinned, can't replace it", index)
+	}
+	for i, r := range replacement {
+		if r == nil {
+			return nil, fmt.Errorf("%w at index %d of the replacement", ErrNilInstruction, i)
+		}
+	}
+	if i := functionReference(replacement); i >= 0 {
+		return nil, fmt.Errorf("Instruction %d of the replacement references another function", i)
+	}
+	if i := functionReference(instructions); i >= 0 {
+		return nil, fmt.Errorf("Instruction %d references another function, can't replace instructions", i)
+	}
+
+	labeled, err := ToLabeled(instructions)
+	if err != nil {
+		return nil, err
+	}
+	next := NoLabel
+	if index+1 < len(labeled) {
+		next = labeled[index+1].Label
+	}
+
+	// The exit stands for the instruction after the replacement, so jumps
+	// to it resolve to index len(replacement).
+	targets := jumpTargets(append(append([]*pb.Instruction{}, replacement...), Exit()))
+	spliced := make([]LabeledInstruction, len(replacement))
+	for i, r := range replacement {
+		label := Label(len(instructions) + i)
+		if i == 0 {
+			label = labeled[index].Label
+		}
+		spliced[i] = LabeledInstruction{Label: label, Instruction: r, Target: NoLabel}
+	}
+	for i, r := range replacement {
+		if !isJump(r) {
+			continue
+		}
+		switch {
+		case targets[i] < 0 || (targets[i] == len(replacement) && next == NoLabel):
+			return nil, fmt.Errorf("Jump at index %d of the replacement lands outside of it", i)
+		case targets[i] == len(replacement):
+			spliced[i].Target = next
+		default:
+			spliced[i].Target = spliced[targets[i]].Label
+		}
+	}
+
+	labeled = append(labeled[:index], append(spliced, labeled[index+1:]...)...)
+	return FromLabeled(labeled)
+}
+
+var (
+	// ErrInvalidTermination is returned by Terminate when the termination
+	// strategy returns a sequence that doesn't end in an exit.
+	ErrInvalidTermination = errors.New("Termination sequence does not end in an exit")
+)
+
+// TerminationStrategy returns the instructions Terminate and GenerateInRange
+// append to a program whose branches fall off its end. The sequence must end in an
+// exit, jumps in it are relative to the sequence itself.
+type TerminationStrategy func() []*pb.Instruction
+
+// DefaultTermination is the TerminationStrategy used unless another one is
+// set: `r0 = 0; exit`.
+func DefaultTermination() []*pb.Instruction {
+	return []*pb.Instruction{Mov64(pb.Reg_R0, 0), Exit()}
+}
+
+var termination TerminationStrategy = DefaultTermination
+
+// SetTerminationStrategy sets the strategy Terminate uses, nil restores
+// DefaultTermination.
+func SetTerminationStrategy(strategy TerminationStrategy) {
+	if strategy == nil {
+		strategy = DefaultTermination
+	}
+	termination = strategy
+}
+
+// Terminate makes sure no path through `instructions` runs past its end,
+// which the verifier rejects, by appending the sequence returned by the
+// termination strategy (see SetTerminationStrategy) if the last
+// instruction falls through or a jump lands right after it. Those jumps
+// land on the first instruction of the sequence. This lets generators stop
+// at any point, e.g. when their instruction budget runs out, and still
+// produce a complete program.
+//
+// Programs that can't fall off the end are returned as they are.
+func Terminate(instructions []*pb.Instruction) ([]*pb.Instruction, error) {
+	fallsOff := len(instructions) == 0 || fallsThrough(instructions[len(instructions)-1])
+	// The exit stands for the instruction after the program, so jumps to
+	// it resolve to index len(instructions).
+	for _, target := range jumpTargets(append(append([]*pb.Instruction{}, instructions...), Exit())) {
+		fallsOff = fallsOff || target == len(instructions)
+	}
+	if !fallsOff {
+		return instructions, nil
+	}
+
+	sequence, err := terminationSequence()
+	if err != nil {
+		return nil, err
+	}
+	terminated := append(append([]*pb.Instruction{}, instructions...), sequence...)
+	return InstructionSequence(terminated...)
+}
+
+// terminationSequence returns the sequence of the termination strategy,
+// which must end in an exit.
+func terminationSequence() ([]*pb.Instruction, error) {
+	sequence := termination()
+	if len(sequence) == 0 || !isExit(sequence[len(sequence)-1]) {
+		return nil, ErrInvalidTermination
+	}
+	return sequence, nil
+}
+
+// fallsThrough returns true if the instruction after `i` can run right
+// after it.
+func fallsThrough(i *pb.Instruction) bool {
+	return !isExit(i) && (!isJump(i) || isConditionalJump(i))
+}
+
+// RemoveDeadCodeAfterExit removes the instructions that can't be reached,
+// i.e. the ones after an exit or an unconditional jump that nothing jumps
+// to, and re-links the remaining jumps. The verifier rejects programs with
+// unreachable instructions.
+//
+// Only sequences without bpf to bpf calls or function pointers are
+// supported, as removing instructions would break their offsets.
+func RemoveDeadCodeAfterExit(instructions []*pb.Instruction) ([]*pb.Instruction, error) {
+	if index := functionReference(instructions); index >= 0 {
+		return nil, fmt.Errorf("Instruction %d references another function, can't remove dead code", index)
+	}
+
+	all, err := ToLabeled(instructions)
+	if err != nil {
+		return nil, err
+	}
+	reachable := reachableInstructions(instructions)
+	labeled := []LabeledInstruction{}
+	for index, l := range all {
+		if reachable[index] {
+			labeled = append(labeled, l)
+		}
+	}
+	return FromLabeled(labeled)
+}
+
+// functionReference returns the index of the first bpf to bpf call or
+// function pointer load in `instructions`, -1 if there is none. Their
+// offsets are not re-linked by FromLabeled.
+func functionReference(instructions []*pb.Instruction) int {
+	for index, inst := range instructions {
+		if (isCall(inst) && inst.SrcReg == PseudoCall) || (isLdImm64(inst) && inst.SrcReg == PseudoFunc) {
+			return index
+		}
+	}
+	return -1
+}
+
+// nopCandidate returns a register that is initialized when the instruction
+// after `prev` runs, because `prev` just wrote to it. The second return value
+// is false if there is no such register, e.g. `prev` is a jump or a call,
+// which leaves R1-R5 uninitialized.
+func nopCandidate(prev *pb.Instruction) (pb.Reg, bool) {
+	switch c := prev.Opcode.(type) {
+	case *pb.Instruction_AluOpcode:
+		return prev.DstReg, prev.DstReg != pb.Reg_R10
+	case *pb.Instruction_MemOpcode:
+		if c.MemOpcode.InstructionClass == pb.InsClass_InsClassLdx || isLdImm64(prev) {
+			return prev.DstReg, prev.DstReg != pb.Reg_R10
+		}
+	}
+	return 0, false
+}
+
+// InsertNops inserts `n` instructions that don't change what the program
+// computes at random points of `instructions` and re-links the jumps around
+// them. The extra instructions still change how the verifier explores the
+// program, e.g. where it decides to prune states, which has historically
+// exposed pruning bugs.
+//
+// The inserted instructions are `goto +0` or, right after an instruction that
+// wrote to rX, `rX = rX`. Things like `rX += 0` are avoided as the verifier
+// rejects arithmetic on some pointer types. Nops are only inserted where the
+// previous instruction falls through, so they are never dead code, and never
+// between two pinned instructions.
+func InsertNops(instructions []*pb.Instruction, n int, rng *rand.NumGen) ([]*pb.Instruction, error) {
+	if index := functionReference(instructions); index >= 0 {
+		return nil, fmt.Errorf("Instruction %d references another function, can't insert instructions", index)
+	}
+
+	if len(instructions) == 0 {
+		return nil, ErrEmptySequence
+	}
+
+	labeled, err := ToLabeled(instructions)
+	if err != nil {
+		return nil, err
+	}
+	nextLabel := Label(len(instructions))
+	for inserted := 0; inserted < n; inserted++ {
+		positions := []int{0}
+		for index := 1; index < len(labeled); index++ {
+			prev := labeled[index-1].Instruction
+			if isExit(prev) || (isJump(prev) && !isConditionalJump(prev)) {
+				continue
+			}
+			if prev.Pinned && labeled[index].Instruction.Pinned {
+				continue
+			}
+			positions = append(positions, index)
+		}
+
+		position := positions[rng.RandRange(0, uint64(len(positions)-1))]
+		nop := Jmp(0)
+		if position > 0 {
+			if reg, ok := nopCandidate(labeled[position-1].Instruction); ok && rng.OneOf(2) {
+				nop = Mov64(reg, reg)
+			}
+		}
+		labeled = append(labeled[:position], append([]LabeledInstruction{{Label: nextLabel, Instruction: nop, Target: NoLabel}}, labeled[position:]...)...)
+		nextLabel++
+	}
+	return FromLabeled(labeled)
+}
+
+// ShuffleBlocks reorders the basic blocks of `instructions` at random and
+// re-links the jumps, so the bytecode order changes but what the program
+// computes doesn't. This produces layouts a compiler would never emit, e.g.
+// the exit of the program before the code that branches to it, which take
+// different paths through the verifier than sequential code.
+//
+// Blocks that fall through into the next one are moved together with it,
+// so what gets shuffled are the chains of blocks that end in an exit or an
+// unconditional jump. The chain with the first instruction stays in place,
+// as well as the chains with pinned instructions and a last chain that
+// falls off the end of the program.
+//
+// The same `rng` state always gives the same order, the input is never
+// modified.
+func ShuffleBlocks(instructions []*pb.Instruction, rng *rand.NumGen) ([]*pb.Instruction, error) {
+	if index := functionReference(instructions); index >= 0 {
+		return nil, fmt.Errorf("Instruction %d references another function, can't shuffle blocks", index)
+	}
+	for index, target := range jumpTargets(instructions) {
+		if isJump(instructions[index]) && target < 0 {
+			return nil, fmt.Errorf("Instruction %d jumps outside the program, can't shuffle blocks", index)
+		}
+	}
+
+	labeled, err := ToLabeled(instructions)
+	if err != nil {
+		return nil, err
+	}
+	chains := [][]LabeledInstruction{}
+	movable := []int{}
+	start := 0
+	for index, inst := range instructions {
+		endsChain := isExit(inst) || (isJump(inst) && !isConditionalJump(inst))
+		if !endsChain && index != len(instructions)-1 {
+			continue
+		}
+		chain := labeled[start : index+1]
+		canMove := start > 0 && endsChain
+		for _, l := range chain {
+			canMove = canMove && !l.Instruction.Pinned
+		}
+		if canMove {
+			movable = append(movable, len(chains))
+		}
+		chains = append(chains, chain)
+		start = index + 1
+	}
+
+	for i := len(movable) - 1; i > 0; i-- {
+		j := int(rng.RandRange(0, uint64(i)))
+		chains[movable[i]], chains[movable[j]] = chains[movable[j]], chains[movable[i]]
+	}
+
+	shuffled := []LabeledInstruction{}
+	for _, chain := range chains {
+		shuffled = append(shuffled, chain...)
+	}
+	return FromLabeled(shuffled)
+}
+
+// registerOperands returns if the dst and src fields of `i` name registers,
+// as opposed to being unused or holding something else, like the pseudo
+// type of a 64-bit immediate load or of a call.
+func registerOperands(i *pb.Instruction) (dst bool, src bool) {
+	switch c := i.Opcode.(type) {
+	case *pb.Instruction_AluOpcode:
+		op := c.AluOpcode
+		// For BPF_END the source bit picks the byte order.
+		hasSrc := op.OperationCode != pb.AluOperationCode_AluNeg && op.OperationCode != pb.AluOperationCode_AluEnd
+		return true, hasSrc && op.Source == pb.SrcOperand_RegSrc
+	case *pb.Instruction_JmpOpcode:
+		op := c.JmpOpcode
+		switch op.OperationCode {
+		case pb.JmpOperationCode_JmpJA, pb.JmpOperationCode_JmpExit, pb.JmpOperationCode_JmpCALL:
+			return false, false
+		}
+		return true, op.Source == pb.SrcOperand_RegSrc
+	case *pb.Instruction_MemOpcode:
+		op := c.MemOpcode
+		switch op.InstructionClass {
+		case pb.InsClass_InsClassLd:
+			switch op.Mode {
+			case pb.StLdMode_StLdModeIMM:
+				return true, false
+			case pb.StLdMode_StLdModeIND:
+				return false, true
+			}
+			return false, false
+		case pb.InsClass_InsClassSt:
+			return true, false
+		}
+		return true, true
+	}
+	return false, false
+}
+
+// RewriteRegister returns a copy of `instructions` where every dst and src
+// operand that names `from` names `to` instead, e.g. to change the register
+// allocation of a program or to normalize it before comparing it with
+// another one. Instructions that change are cloned, the rest are shared
+// with `instructions`.
+//
+// Registers used implicitly, like the arguments of a call or R6 in legacy
+// packet loads, are not rewritten.
+func RewriteRegister(instructions []*pb.Instruction, from, to pb.Reg) []*pb.Instruction {
+	rewritten := make([]*pb.Instruction, len(instructions))
+	for index, i := range instructions {
+		rewritten[index] = i
+		dst, src := registerOperands(i)
+		dst = dst && i.DstReg == from
+		src = src && i.SrcReg == from
+		if !dst && !src {
+			continue
+		}
+		clone := protobuf.Clone(i).(*pb.Instruction)
+		if dst {
+			clone.DstReg = to
+		}
+		if src {
+			clone.SrcReg = to
+		}
+		rewritten[index] = clone
+	}
+	return rewritten
+}
@@ -0,0 +1,761 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"buzzer/pkg/rand"
+	pb "buzzer/proto/ebpf_go_proto"
+	"errors"
+	gorand "math/rand"
+	"reflect"
+	"testing"
+
+	protobuf "github.com/golang/protobuf/proto"
+)
+
+func TestSwapAdjacent(t *testing.T) {
+	tests := []struct {
+		testName     string
+		instructions []*pb.Instruction
+		wantSwapped  bool
+		want         []*pb.Instruction
+	}{
+		{
+			testName: "Only one independent pair",
+			instructions: []*pb.Instruction{
+				Mov64(R1, 1),
+				Mov64(R2, 2),
+				Add64(R1, R2),
+				Exit(),
+			},
+			wantSwapped: true,
+			want: []*pb.Instruction{
+				Mov64(R2, 2),
+				Mov64(R1, 1),
+				Add64(R1, R2),
+				Exit(),
+			},
+		},
+		{
+			testName: "Data dependencies only",
+			instructions: []*pb.Instruction{
+				Mov64(R1, 1),
+				Add64(R1, 2),
+				Mov64(R0, R1),
+				Exit(),
+			},
+			wantSwapped: false,
+		},
+		{
+			testName: "Memory dependencies only",
+			instructions: []*pb.Instruction{
+				StDW(R10, R1, -8),
+				LdDW(R2, R10, -8),
+				Exit(),
+			},
+			wantSwapped: false,
+		},
+		{
+			testName: "Second instruction is a jump target",
+			instructions: []*pb.Instruction{
+				JmpEQ(R3, 0, 1),
+				Mov64(R1, 1),
+				Mov64(R2, 2),
+				Exit(),
+			},
+			wantSwapped: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			rng := rand.NewRand(gorand.NewSource(0))
+			got := SwapAdjacent(tc.instructions, rng)
+			if got != tc.wantSwapped {
+				t.Fatalf("SwapAdjacent() = %v, want %v", got, tc.wantSwapped)
+			}
+			if tc.wantSwapped && !reflect.DeepEqual(tc.instructions, tc.want) {
+				t.Errorf("SwapAdjacent() instructions = %v, want %v", tc.instructions, tc.want)
+			}
+		})
+	}
+}
+
+func TestRetargetJump(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		instructions := []*pb.Instruction{
+			JmpEQ(R1, 0, 2),
+			Mov64(R0, int64(1)<<40),
+			Mov64(R0, 1),
+			Mov64(R0, 2),
+			Exit(),
+		}
+		original := instructions[0]
+		if !RetargetJump(instructions, rand.NewRand(gorand.NewSource(seed))) {
+			t.Fatalf("RetargetJump() = false, want true")
+		}
+		if original.Offset != 2 {
+			t.Errorf("RetargetJump() modified the original jump")
+		}
+		// The jump can only land on the mov of 2, the exit would skip
+		// every write to R0. The wide instruction takes two slots.
+		if got := instructions[0].Offset; got != 3 {
+			t.Errorf("RetargetJump() offset = %d, want 3", got)
+		}
+		if err := Validate(instructions); err != nil {
+			t.Errorf("RetargetJump() result is invalid: %v", err)
+		}
+	}
+
+	noCandidates := [][]*pb.Instruction{
+		{Mov64(R0, 0), Exit()},
+		{JmpEQ(R1, 0, 1), Mov64(R0, 0), Exit()},
+		{Pin(JmpEQ(R1, 0, 0)), Mov64(R0, 0), Mov64(R0, 1), Exit()},
+		// Landing on the first exit skips the only write to R0 and leaves
+		// the second arm unreachable, landing on the second exit leaves
+		// its mov unreachable.
+		{JmpEQ(R1, 0, 2), Mov64(R0, 0), Exit(), Mov64(R0, 1), Exit()},
+	}
+	for _, instructions := range noCandidates {
+		if RetargetJump(instructions, rand.NewRand(gorand.NewSource(0))) {
+			t.Errorf("RetargetJump(%v) = true, want false", instructions)
+		}
+	}
+}
+
+func TestGenerateInRange(t *testing.T) {
+	instructions := []*pb.Instruction{
+		JmpEQ(R1, 0, 3),
+		Mov64(R0, 0),
+		Mov64(R0, 1),
+		Mov64(R0, 2),
+		Exit(),
+	}
+	generated := []*pb.Instruction{
+		Mov64(R0, int64(1)<<40),
+		JmpEQ(R2, 0, 1),
+		Mov64(R3, 3),
+	}
+	next := 0
+	generator := func(remaining int) *pb.Instruction {
+		if want := len(generated) - next - 1; remaining != want {
+			t.Errorf("generator called with remaining = %d, want %d", remaining, want)
+		}
+		next++
+		return generated[next-1]
+	}
+
+	got, err := GenerateInRange(instructions, 1, 4, generator)
+	if err != nil {
+		t.Fatalf("GenerateInRange() unexpected error: %v", err)
+	}
+
+	// The wide instruction takes an extra slot so the outer jump needs to be
+	// relinked, while the generated one still lands on the exit.
+	want := []*pb.Instruction{
+		JmpEQ(R1, 0, 4),
+		Mov64(R0, int64(1)<<40),
+		JmpEQ(R2, 0, 1),
+		Mov64(R3, 3),
+		Exit(),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("len(GenerateInRange()) = %d, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !protobuf.Equal(got[i], want[i]) {
+			t.Errorf("GenerateInRange()[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+	if instructions[0].Offset != 3 {
+		t.Errorf("GenerateInRange() modified the input program")
+	}
+
+	escaping := func(remaining int) *pb.Instruction {
+		return Jmp(int16(remaining + 1))
+	}
+	if _, err := GenerateInRange(instructions, 1, 4, escaping); err == nil {
+		t.Errorf("GenerateInRange() with a jump out of the range expected error, got nil")
+	}
+
+	if _, err := GenerateInRange(instructions, 3, 1, generator); err == nil {
+		t.Errorf("GenerateInRange() with an empty range expected error, got nil")
+	}
+}
+
+func TestGenerateInRangeSkipsDeadCode(t *testing.T) {
+	instructions := []*pb.Instruction{
+		Mov64(R0, 0),
+		Mov64(R0, 1),
+		Mov64(R0, 2),
+		Mov64(R0, 3),
+		Mov64(R0, 4),
+		Mov64(R0, 5),
+		Exit(),
+	}
+	generate := func(generated ...*pb.Instruction) ([]*pb.Instruction, int) {
+		next := 0
+		generator := func(remaining int) *pb.Instruction {
+			next++
+			return generated[next-1]
+		}
+		got, err := GenerateInRange(instructions, 1, 6, generator)
+		if err != nil {
+			t.Fatalf("GenerateInRange() unexpected error: %v", err)
+		}
+		return got, next
+	}
+	check := func(got []*pb.Instruction, want []*pb.Instruction) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("len(GenerateInRange()) = %d, want %d: %v", len(got), len(want), got)
+		}
+		for i := range want {
+			if !protobuf.Equal(got[i], want[i]) {
+				t.Errorf("GenerateInRange()[%d] = %v, want %v", i, got[i], want[i])
+			}
+		}
+	}
+
+	// Nothing is generated after the exit.
+	got, calls := generate(Mov64(R1, 1), Exit(), Mov64(R2, 2), Mov64(R3, 3), Mov64(R4, 4))
+	if calls != 2 {
+		t.Errorf("GenerateInRange() called the generator %d times after an exit, want 2", calls)
+	}
+	check(got, []*pb.Instruction{Mov64(R0, 0), Mov64(R1, 1), Exit(), Exit()})
+
+	// A jump over the exit keeps the code it lands on, generation resumes
+	// there.
+	got, calls = generate(JmpEQ(R1, 0, 2), Exit(), Mov64(R2, 2), Mov64(R3, 3), Mov64(R4, 4))
+	if calls != 4 {
+		t.Errorf("GenerateInRange() called the generator %d times, want 4", calls)
+	}
+	check(got, []*pb.Instruction{Mov64(R0, 0), JmpEQ(R1, 0, 1), Exit(), Mov64(R2, 2), Mov64(R3, 3), Exit()})
+
+	if err := Validate(got); err != nil {
+		t.Errorf("GenerateInRange() produced an invalid program: %v", err)
+	}
+}
+
+func TestReplaceAt(t *testing.T) {
+	instructions := []*pb.Instruction{
+		JmpEQ(R1, 0, 2),
+		Mov64(R0, 0),
+		Mov64(R0, 1),
+		Mov64(R0, 2),
+		Exit(),
+	}
+
+	tests := []struct {
+		testName    string
+		index       int
+		replacement []*pb.Instruction
+		want        []*pb.Instruction
+	}{
+		{
+			// The wide instruction takes an extra slot so the outer jump
+			// needs to be relinked, the inner one lands on the instruction
+			// that followed the replaced one.
+			testName: "wider replacement",
+			index:    1,
+			replacement: []*pb.Instruction{
+				JmpEQ(R2, 0, 3),
+				Mov64(R0, int64(1)<<40),
+				Mov64(R0, 3),
+			},
+			want: []*pb.Instruction{
+				JmpEQ(R1, 0, 5),
+				JmpEQ(R2, 0, 3),
+				Mov64(R0, int64(1)<<40),
+				Mov64(R0, 3),
+				Mov64(R0, 1),
+				Mov64(R0, 2),
+				Exit(),
+			},
+		},
+		{
+			testName:    "replaced jump target",
+			index:       3,
+			replacement: []*pb.Instruction{Mov64(R0, 5), Mov64(R0, 6)},
+			want: []*pb.Instruction{
+				JmpEQ(R1, 0, 2),
+				Mov64(R0, 0),
+				Mov64(R0, 1),
+				Mov64(R0, 5),
+				Mov64(R0, 6),
+				Exit(),
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got, err := ReplaceAt(instructions, tc.index, tc.replacement)
+			if err != nil {
+				t.Fatalf("ReplaceAt() unexpected error: %v", err)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("len(ReplaceAt()) = %d, want %d", len(got), len(tc.want))
+			}
+			for i := range tc.want {
+				if !protobuf.Equal(got[i], tc.want[i]) {
+					t.Errorf("ReplaceAt()[%d] = %v, want %v", i, got[i], tc.want[i])
+				}
+			}
+		})
+	}
+
+	if instructions[0].Offset != 2 {
+		t.Errorf("ReplaceAt() modified the input program")
+	}
+	if _, err := ReplaceAt(instructions, 3, []*pb.Instruction{Jmp(1)}); err == nil {
+		t.Errorf("ReplaceAt() with a jump out of the replacement expected error, got nil")
+	}
+	if _, err := ReplaceAt(instructions, 4, []*pb.Instruction{Jmp(0)}); err == nil {
+		t.Errorf("ReplaceAt() with a jump past the end of the program expected error, got nil")
+	}
+	if _, err := ReplaceAt(instructions, 5, []*pb.Instruction{Exit()}); err == nil {
+		t.Errorf("ReplaceAt() with an out of range index expected error, got nil")
+	}
+	if _, err := ReplaceAt(instructions, 1, nil); !errors.Is(err, ErrEmptySequence) {
+		t.Errorf("ReplaceAt() with an empty replacement = %v, want %v", err, ErrEmptySequence)
+	}
+	pinned := []*pb.Instruction{Pin(Mov64(R0, 0)), Exit()}
+	if _, err := ReplaceAt(pinned, 0, []*pb.Instruction{Mov64(R0, 1)}); err == nil {
+		t.Errorf("ReplaceAt() of a pinned instruction expected error, got nil")
+	}
+}
+
+func TestGenerateInRangeTerminates(t *testing.T) {
+	tests := []struct {
+		testName  string
+		generated []*pb.Instruction
+		want      []*pb.Instruction
+	}{
+		{
+			testName:  "Ends in an exit",
+			generated: []*pb.Instruction{Mov64(R0, 1), Exit()},
+			want:      []*pb.Instruction{Mov64(R0, 0), Mov64(R0, 1), Exit()},
+		},
+		{
+			testName:  "Falls through",
+			generated: []*pb.Instruction{Mov64(R0, 1), Mov64(R0, 2)},
+			want:      []*pb.Instruction{Mov64(R0, 0), Mov64(R0, 1), Mov64(R0, 2), Mov64(R0, 0), Exit()},
+		},
+		{
+			// The wide instruction takes two slots, the jump has to be
+			// relinked to land on the termination.
+			testName:  "Jump past the end",
+			generated: []*pb.Instruction{JmpEQ(R1, 0, 2), Mov64(R0, int64(1)<<40), Exit()},
+			want:      []*pb.Instruction{Mov64(R0, 0), JmpEQ(R1, 0, 3), Mov64(R0, int64(1)<<40), Exit(), Mov64(R0, 0), Exit()},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			instructions := []*pb.Instruction{Mov64(R0, 0)}
+			for range tc.generated {
+				instructions = append(instructions, Mov64(R0, 0))
+			}
+			next := 0
+			generator := func(remaining int) *pb.Instruction {
+				next++
+				return tc.generated[next-1]
+			}
+			got, err := GenerateInRange(instructions, 1, len(instructions), generator)
+			if err != nil {
+				t.Fatalf("GenerateInRange() unexpected error: %v", err)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("GenerateInRange() = %v, want %v", got, tc.want)
+			}
+			for i := range tc.want {
+				if !protobuf.Equal(got[i], tc.want[i]) {
+					t.Errorf("GenerateInRange()[%d] = %v, want %v", i, got[i], tc.want[i])
+				}
+			}
+			if err := Validate(got); err != nil {
+				t.Errorf("GenerateInRange() result is invalid: %v", err)
+			}
+		})
+	}
+}
+
+func TestTerminate(t *testing.T) {
+	defer SetTerminationStrategy(nil)
+
+	tests := []struct {
+		testName     string
+		instructions []*pb.Instruction
+		want         []*pb.Instruction
+	}{
+		{
+			testName:     "Already terminated",
+			instructions: []*pb.Instruction{JmpEQ(R1, 0, 1), Mov64(R0, 1), Exit()},
+			want:         []*pb.Instruction{JmpEQ(R1, 0, 1), Mov64(R0, 1), Exit()},
+		},
+		{
+			testName:     "Falls through",
+			instructions: []*pb.Instruction{Mov64(R0, 1)},
+			want:         []*pb.Instruction{Mov64(R0, 1), Mov64(R0, 0), Exit()},
+		},
+		{
+			testName:     "Jump past the end",
+			instructions: []*pb.Instruction{JmpEQ(R1, 0, 1), Exit()},
+			want:         []*pb.Instruction{JmpEQ(R1, 0, 1), Exit(), Mov64(R0, 0), Exit()},
+		},
+		{
+			testName:     "Conditional jump at the end",
+			instructions: []*pb.Instruction{Mov64(R0, 1), JmpEQ(R1, 0, -2)},
+			want:         []*pb.Instruction{Mov64(R0, 1), JmpEQ(R1, 0, -2), Mov64(R0, 0), Exit()},
+		},
+		{
+			testName:     "Empty program",
+			instructions: []*pb.Instruction{},
+			want:         []*pb.Instruction{Mov64(R0, 0), Exit()},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got, err := Terminate(tc.instructions)
+			if err != nil {
+				t.Fatalf("Terminate() unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Terminate() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	SetTerminationStrategy(func() []*pb.Instruction {
+		return []*pb.Instruction{Mov64(R0, R1), Exit()}
+	})
+	got, err := Terminate([]*pb.Instruction{Mov64(R1, 2)})
+	if err != nil {
+		t.Fatalf("Terminate() with a custom strategy unexpected error: %v", err)
+	}
+	if want := []*pb.Instruction{Mov64(R1, 2), Mov64(R0, R1), Exit()}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Terminate() with a custom strategy = %v, want %v", got, want)
+	}
+
+	SetTerminationStrategy(func() []*pb.Instruction {
+		return []*pb.Instruction{Mov64(R0, 0)}
+	})
+	if _, err := Terminate([]*pb.Instruction{Mov64(R1, 2)}); !errors.Is(err, ErrInvalidTermination) {
+		t.Errorf("Terminate() without an exit = %v, want %v", err, ErrInvalidTermination)
+	}
+}
+
+func TestRemoveDeadCodeAfterExit(t *testing.T) {
+	instructions := []*pb.Instruction{
+		JmpEQ(R1, 0, 5),
+		Mov64(R0, 0),
+		Exit(),
+		// Unreachable.
+		Mov64(R0, int64(1)<<40),
+		Exit(),
+		// Target of the first jump.
+		Mov64(R0, 1),
+		Exit(),
+		// Unreachable.
+		Mov64(R0, 2),
+	}
+
+	got, err := RemoveDeadCodeAfterExit(instructions)
+	if err != nil {
+		t.Fatalf("RemoveDeadCodeAfterExit() unexpected error: %v", err)
+	}
+	want := []*pb.Instruction{
+		JmpEQ(R1, 0, 2),
+		Mov64(R0, 0),
+		Exit(),
+		Mov64(R0, 1),
+		Exit(),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("len(RemoveDeadCodeAfterExit()) = %d, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !protobuf.Equal(got[i], want[i]) {
+			t.Errorf("RemoveDeadCodeAfterExit()[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	pseudoCall := Call(1)
+	pseudoCall.SrcReg = PseudoCall
+	if _, err := RemoveDeadCodeAfterExit([]*pb.Instruction{pseudoCall, Exit()}); err == nil {
+		t.Errorf("RemoveDeadCodeAfterExit() with a bpf to bpf call expected error, got nil")
+	}
+}
+
+func TestInsertNops(t *testing.T) {
+	original := []*pb.Instruction{
+		Mov64(R1, 1),
+		JmpEQ(R1, 0, 2),
+		Mov64(R0, 1),
+		Exit(),
+		LdDW(R0, R10, -8),
+		Exit(),
+	}
+	isNop := func(i *pb.Instruction) bool {
+		return protobuf.Equal(i, Jmp(0)) || protobuf.Equal(i, Mov64(i.DstReg, i.DstReg))
+	}
+
+	for seed := int64(0); seed < 20; seed++ {
+		rng := rand.NewRand(gorand.NewSource(seed))
+		got, err := InsertNops(original, 5, rng)
+		if err != nil {
+			t.Fatalf("InsertNops() unexpected error: %v", err)
+		}
+		if len(got) != len(original)+5 {
+			t.Fatalf("len(InsertNops()) = %d, want %d", len(got), len(original)+5)
+		}
+		if err := Validate(got); err != nil {
+			t.Errorf("InsertNops() = %v, invalid: %v", got, err)
+		}
+		for index, reachable := range reachableInstructions(got) {
+			if !reachable {
+				t.Errorf("InsertNops() = %v, instruction %d is unreachable", got, index)
+			}
+		}
+
+		// Removing the nops has to give back the original program.
+		all, err := ToLabeled(got)
+		if err != nil {
+			t.Fatalf("ToLabeled() unexpected error: %v", err)
+		}
+		labeled := []LabeledInstruction{}
+		for index, l := range all {
+			if isNop(l.Instruction) {
+				if isJump(l.Instruction) {
+					continue
+				}
+				// rX = rX has to come after something that wrote
+				// to rX, with only nops in between.
+				prev := index - 1
+				for prev > 0 && isNop(got[prev]) {
+					prev--
+				}
+				if reg, ok := nopCandidate(got[prev]); !ok || reg != l.Instruction.DstReg {
+					t.Errorf("InsertNops() = %v, %v at %d reads an uninitialized register", got, l.Instruction, index)
+				}
+				continue
+			}
+			labeled = append(labeled, l)
+		}
+		stripped, err := FromLabeled(labeled)
+		if err != nil {
+			t.Fatalf("FromLabeled() unexpected error: %v", err)
+		}
+		if len(stripped) != len(original) {
+			t.Fatalf("InsertNops() without the nops = %v, want %v", stripped, original)
+		}
+		for index := range original {
+			if !protobuf.Equal(stripped[index], original[index]) {
+				t.Errorf("InsertNops() without the nops [%d] = %v, want %v", index, stripped[index], original[index])
+			}
+		}
+	}
+
+	pseudoCall := Call(1)
+	pseudoCall.SrcReg = PseudoCall
+	if _, err := InsertNops([]*pb.Instruction{pseudoCall, Exit()}, 1, rand.NewRand(gorand.NewSource(0))); err == nil {
+		t.Errorf("InsertNops() with a bpf to bpf call expected error, got nil")
+	}
+	if _, err := InsertNops(nil, 1, rand.NewRand(gorand.NewSource(0))); err == nil {
+		t.Errorf("InsertNops() with an empty program expected error, got nil")
+	}
+}
+
+func TestPinnedInstructions(t *testing.T) {
+	rng := rand.NewRand(gorand.NewSource(0))
+	swappable := []*pb.Instruction{
+		Pin(Mov64(R1, 1)),
+		Mov64(R2, 2),
+		Add64(R1, R2),
+		Exit(),
+	}
+	if SwapAdjacent(swappable, rng) {
+		t.Errorf("SwapAdjacent() moved a pinned instruction: %v", swappable)
+	}
+
+	skeleton := []*pb.Instruction{
+		Pin(Mov64(R0, 0)),
+		Pin(JmpEQ(R1, 0, 1)),
+		Mov64(R0, 1),
+		Pin(Exit()),
+	}
+	for seed := int64(0); seed < 20; seed++ {
+		got, err := InsertNops(skeleton, 3, rand.NewRand(gorand.NewSource(seed)))
+		if err != nil {
+			t.Fatalf("InsertNops() unexpected error: %v", err)
+		}
+		// The first two pinned instructions have to stay together.
+		for index := range got {
+			if got[index].Pinned {
+				if !got[index+1].Pinned {
+					t.Errorf("InsertNops() = %v, inserted a nop between pinned instructions", got)
+				}
+				break
+			}
+		}
+	}
+
+	generator := func(remaining int) *pb.Instruction {
+		return Mov64(R0, 2)
+	}
+	got, err := GenerateInRange(skeleton, 0, 4, generator)
+	if err != nil {
+		t.Fatalf("GenerateInRange() unexpected error: %v", err)
+	}
+	want := []*pb.Instruction{skeleton[0], skeleton[1], Mov64(R0, 2), skeleton[3]}
+	for i := range want {
+		if !protobuf.Equal(got[i], want[i]) {
+			t.Errorf("GenerateInRange()[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestRewriteRegister(t *testing.T) {
+	pseudoCall := Call(1)
+	pseudoCall.SrcReg = PseudoCall
+	instructions := []*pb.Instruction{
+		LdMapByFd(R1, 3),
+		Mov64(R2, R1),
+		StDW(R10, R1, -8),
+		JmpEQ(R1, 0, 1),
+		pseudoCall,
+		Mov64(R3, 5),
+		Exit(),
+	}
+
+	got := RewriteRegister(instructions, R1, R7)
+	// The pseudo types of the map load and the call live in the src
+	// field and happen to have the same value as R1, they must be kept.
+	want := []*pb.Instruction{
+		LdMapByFd(R7, 3),
+		Mov64(R2, R7),
+		StDW(R10, R7, -8),
+		JmpEQ(R7, 0, 1),
+		pseudoCall,
+		Mov64(R3, 5),
+		Exit(),
+	}
+	for i := range want {
+		if !protobuf.Equal(got[i], want[i]) {
+			t.Errorf("RewriteRegister()[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+	if instructions[1].SrcReg != R1 {
+		t.Errorf("RewriteRegister() modified the input program")
+	}
+	if got[5] != instructions[5] {
+		t.Errorf("RewriteRegister() cloned an instruction that didn't change")
+	}
+
+	// Unused src fields are R0 too, only real operands have to change.
+	got = RewriteRegister(instructions, R0, R9)
+	for i := range instructions {
+		if got[i] != instructions[i] {
+			t.Errorf("RewriteRegister(R0, R9)[%d] = %v, want it unchanged", i, got[i])
+		}
+	}
+}
+
+func TestShuffleBlocks(t *testing.T) {
+	original := []*pb.Instruction{
+		Mov64(R0, 0),
+		JmpEQ(R1, 0, 4),
+		JmpEQ(R1, 1, 5),
+		Mov64(R0, 1),
+		// Falls through into the next block.
+		JmpEQ(R2, 0, 6),
+		Jmp(5),
+		// Target of the first jump.
+		Mov64(R0, 2),
+		Exit(),
+		// Target of the second jump.
+		Mov64(R0, int64(1)<<40),
+		Exit(),
+		// Target of the third and fourth jumps.
+		Pin(Mov64(R0, 4)),
+		Exit(),
+	}
+
+	// successors maps every instruction to the ones that can run after it,
+	// jumps are compared without their offset.
+	key := func(i *pb.Instruction) string {
+		clone := protobuf.Clone(i).(*pb.Instruction)
+		clone.Offset = 0
+		return clone.String()
+	}
+	successors := func(instructions []*pb.Instruction) map[string][]string {
+		got := map[string][]string{}
+		targets := jumpTargets(instructions)
+		for index, i := range instructions {
+			next := []string{}
+			if !isExit(i) && (!isJump(i) || isConditionalJump(i)) {
+				next = append(next, key(instructions[index+1]))
+			}
+			if targets[index] >= 0 {
+				next = append(next, key(instructions[targets[index]]))
+			}
+			got[key(i)] = next
+		}
+		return got
+	}
+
+	moved := false
+	for seed := int64(0); seed < 20; seed++ {
+		got, err := ShuffleBlocks(original, rand.NewRand(gorand.NewSource(seed)))
+		if err != nil {
+			t.Fatalf("ShuffleBlocks() unexpected error: %v", err)
+		}
+		if err := Validate(got); err != nil {
+			t.Errorf("ShuffleBlocks() = %v, invalid: %v", got, err)
+		}
+		if !reflect.DeepEqual(successors(got), successors(original)) {
+			t.Errorf("ShuffleBlocks() = %v, changed the control flow of %v", got, original)
+		}
+		if !protobuf.Equal(got[0], original[0]) {
+			t.Errorf("ShuffleBlocks() moved the first instruction: %v", got)
+		}
+		if !got[10].Pinned {
+			t.Errorf("ShuffleBlocks() moved a pinned instruction: %v", got)
+		}
+		moved = moved || !protobuf.Equal(got[6], original[6])
+
+		again, err := ShuffleBlocks(original, rand.NewRand(gorand.NewSource(seed)))
+		if err != nil || !reflect.DeepEqual(again, got) {
+			t.Errorf("ShuffleBlocks() with the same seed = %v, want %v", again, got)
+		}
+	}
+	if !moved {
+		t.Errorf("ShuffleBlocks() never reordered the blocks")
+	}
+	if original[1].Offset != 4 {
+		t.Errorf("ShuffleBlocks() modified the input program")
+	}
+
+	pseudoCall := Call(1)
+	pseudoCall.SrcReg = PseudoCall
+	if _, err := ShuffleBlocks([]*pb.Instruction{pseudoCall, Exit()}, rand.NewRand(gorand.NewSource(0))); err == nil {
+		t.Errorf("ShuffleBlocks() with a bpf to bpf call expected error, got nil")
+	}
+	if _, err := ShuffleBlocks([]*pb.Instruction{Jmp(1), Exit()}, rand.NewRand(gorand.NewSource(0))); err == nil {
+		t.Errorf("ShuffleBlocks() with a jump out of the program expected error, got nil")
+	}
+}
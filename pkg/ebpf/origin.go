@@ -0,0 +1,93 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// RecordOrigins makes the instruction constructors remember where they were
+// called from, see InstructionOrigin, and the pocs print it next to every
+// instruction. This is meant for debugging the generators, when it is off
+// constructing an instruction costs nothing more.
+var RecordOrigins = false
+
+// instructionOrigins maps the instructions built while RecordOrigins was set
+// to the call site that built them.
+var instructionOrigins sync.Map
+
+// originSkippedFiles are the files whose frames are skipped when looking for
+// the call site of a constructor, they only hold the constructors and the
+// Call* wrappers around them.
+var originSkippedFiles = map[string]bool{
+	"alu_instructions.go":   true,
+	"jmp_instructions.go":   true,
+	"st_ld_instructions.go": true,
+	"origin.go":             true,
+}
+
+// originDir is the directory of this package, frames from other packages
+// with files named like the skipped ones are not skipped.
+var originDir = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(file)
+}()
+
+// withOrigin records the call site of the constructor of `i` if
+// RecordOrigins is set, and returns `i`.
+func withOrigin(i *pb.Instruction) *pb.Instruction {
+	if !RecordOrigins {
+		return i
+	}
+	pcs := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		if filepath.Dir(frame.File) != originDir || !originSkippedFiles[filepath.Base(frame.File)] {
+			instructionOrigins.Store(i, fmt.Sprintf("%s:%d", frame.File, frame.Line))
+			break
+		}
+		if !more {
+			break
+		}
+	}
+	return i
+}
+
+// InstructionOrigin returns the file:line of the code that built `i`, the
+// first caller outside of the instruction constructors, or an empty string
+// if `i` was built while RecordOrigins was off. Copies of `i`, e.g. made by
+// proto.Clone or by decoding a program, have no origin.
+func InstructionOrigin(i *pb.Instruction) string {
+	if origin, ok := instructionOrigins.Load(i); ok {
+		return origin.(string)
+	}
+	return ""
+}
+
+// ClearInstructionOrigins forgets the origin of every instruction. Recorded
+// origins keep their instructions alive, so long running generators should
+// clear them once they are done with a program, Control does it before
+// generating each one.
+func ClearInstructionOrigins() {
+	instructionOrigins.Range(func(key, _ any) bool {
+		instructionOrigins.Delete(key)
+		return true
+	})
+}
@@ -0,0 +1,57 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestInstructionOrigin(t *testing.T) {
+	if i := Mov64(R0, 0); InstructionOrigin(i) != "" {
+		t.Errorf("InstructionOrigin() = %q with RecordOrigins off, want empty", InstructionOrigin(i))
+	}
+
+	RecordOrigins = true
+	defer func() {
+		RecordOrigins = false
+		ClearInstructionOrigins()
+	}()
+
+	_, file, line, _ := runtime.Caller(0)
+	mov, jmp, store := Mov64(R0, int64(1)<<40), JmpEQ(R1, 0, 1), StW(R10, 0, -4)
+	sequence, err := CallTracePrintk(-8, 4, R6)
+	if err != nil {
+		t.Fatalf("CallTracePrintk() unexpected error: %v", err)
+	}
+
+	for _, i := range append(sequence, mov, jmp, store) {
+		origin := InstructionOrigin(i)
+		wantLine := line + 1
+		if i != mov && i != jmp && i != store {
+			wantLine = line + 2
+		}
+		if !strings.HasPrefix(origin, file+":") || !strings.HasSuffix(origin, fmt.Sprintf(":%d", wantLine)) {
+			t.Errorf("InstructionOrigin(%v) = %q, want %s:%d", i, origin, file, wantLine)
+		}
+	}
+
+	ClearInstructionOrigins()
+	if origin := InstructionOrigin(mov); origin != "" {
+		t.Errorf("InstructionOrigin() = %q after ClearInstructionOrigins, want empty", origin)
+	}
+}
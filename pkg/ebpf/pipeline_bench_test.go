@@ -0,0 +1,117 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"fmt"
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+// benchmarkSizes are the program lengths, in instructions, the generation
+// pipeline is benchmarked with.
+var benchmarkSizes = []int{10, 100, 1000, 10000}
+
+// benchmarkProgram returns a program of `size` instructions ending in an
+// exit. Branchy programs have a forward conditional jump every few
+// instructions, linear ones only ALU instructions.
+func benchmarkProgram(size int, branchy bool) *pb.Program {
+	instructions := make([]*pb.Instruction, 0, size)
+	for len(instructions) < size-1 {
+		index := len(instructions)
+		remaining := size - 1 - index
+		if branchy && index%4 == 0 && remaining > 1 {
+			offset := int16(min(remaining-1, 3))
+			instructions = append(instructions, JmpGT(R1, int32(index), offset))
+			continue
+		}
+		instructions = append(instructions, Add64(R0, int32(index)))
+	}
+	instructions = append(instructions, Exit())
+	return &pb.Program{Functions: []*pb.Functions{{Instructions: instructions}}}
+}
+
+// runPipelineBenchmark runs `fn` over programs of every benchmark size,
+// linear and branchy.
+func runPipelineBenchmark(b *testing.B, fn func(b *testing.B, program *pb.Program)) {
+	for _, size := range benchmarkSizes {
+		for _, branchy := range []bool{false, true} {
+			shape := "linear"
+			if branchy {
+				shape = "branchy"
+			}
+			program := benchmarkProgram(size, branchy)
+			b.Run(fmt.Sprintf("%s/%d", shape, size), func(b *testing.B) {
+				b.ReportAllocs()
+				fn(b, program)
+			})
+		}
+	}
+}
+
+func BenchmarkGenerateInstructions(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		for _, branchy := range []bool{false, true} {
+			shape := "linear"
+			if branchy {
+				shape = "branchy"
+			}
+			b.Run(fmt.Sprintf("%s/%d", shape, size), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					instructions := make([]*pb.Instruction, 0, size)
+					for j := 0; j < size; j++ {
+						if branchy && j%4 == 0 {
+							instructions = append(instructions, RandomJmpInstruction(uint64(size-j)))
+							continue
+						}
+						instructions = append(instructions, RandomAluInstruction())
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkNumberInstructions(b *testing.B) {
+	runPipelineBenchmark(b, func(b *testing.B, program *pb.Program) {
+		instructions := program.Functions[0].Instructions
+		for i := 0; i < b.N; i++ {
+			slotIndexes(instructions)
+			jumpTargets(instructions)
+		}
+	})
+}
+
+func BenchmarkEncodeProgram(b *testing.B) {
+	runPipelineBenchmark(b, func(b *testing.B, program *pb.Program) {
+		for i := 0; i < b.N; i++ {
+			if _, _, err := EncodeInstructions(program); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkGenerateInsnArray(b *testing.B) {
+	runPipelineBenchmark(b, func(b *testing.B, program *pb.Program) {
+		for i := 0; i < b.N; i++ {
+			if _, err := GenerateInsnArray(program); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
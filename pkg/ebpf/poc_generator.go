@@ -20,11 +20,49 @@ import (
 	"fmt"
 	jsonpb "github.com/golang/protobuf/jsonpb"
 	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// pocMacroRegex matches the invocation of a function like macro in the poc.
+var pocMacroRegex = regexp.MustCompile(`\b(BPF_[A-Z0-9_]+)\(`)
+
+// cIdentifierRegex matches the names that can be used as is in the poc.
+var cIdentifierRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// NumberedPocs makes GeneratePoc prefix every instruction of the poc with
+// its index, as printed in verifier logs.
+var NumberedPocs = false
+
+// PocDialect selects the conventions the instruction array of a poc is
+// written in, so it compiles as is in the tree it is meant for.
+type PocDialect int
+
+const (
+	// PocDialectSelftests uses the macros from the kernel's
+	// include/linux/filter.h (also shipped in tools/include for the
+	// selftests) and names registers BPF_REG_0 to BPF_REG_10.
+	PocDialectSelftests PocDialect = iota
+
+	// PocDialectTestBpf uses the same macros but names registers R0 to R10,
+	// like lib/test_bpf.c and the trees that copied its defines.
+	PocDialectTestBpf
+
+	// PocDialectRaw spells out every encoded instruction as a designated
+	// initializer of `struct bpf_insn`, which only needs linux/bpf.h.
+	PocDialectRaw
 )
 
 // GeneratePoc generates a c program that can be used to reproduce fuzzer
 // test cases.
 func GeneratePoc(program *pb.Program) error {
+	return GeneratePocDialect(program, PocDialectSelftests)
+}
+
+// GeneratePocDialect is like GeneratePoc but writes the instruction array in
+// `dialect`.
+func GeneratePocDialect(program *pb.Program, dialect PocDialect) error {
 	m := &jsonpb.Marshaler{
 		OrigName:     true,
 		EnumsAsInts:  false,
@@ -42,6 +80,433 @@ func GeneratePoc(program *pb.Program) error {
 
 	fmt.Printf("Writing eBPF PoC %q.\n", f.Name())
 	_, err = f.Write([]byte(textpbData))
+	if err = errors.Join(err, f.Close()); err != nil {
+		return err
+	}
+
+	insnArray, err := generateInsnArray(program, NumberedPocs, dialect)
+	if err != nil {
+		return err
+	}
+	f, err = os.CreateTemp("", "ebpf-poc-*.c")
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Writing eBPF PoC instruction array %q.\n", f.Name())
+	_, err = f.Write([]byte(insnArray))
 	return errors.Join(err, f.Close())
+}
+
+// GenerateInsnArray returns the program as a C `struct bpf_insn` array
+// written with the macros from the kernel's include/linux/filter.h.
+func GenerateInsnArray(program *pb.Program) (string, error) {
+	return generateInsnArray(program, false, PocDialectSelftests)
+}
+
+// GenerateInsnArrayDialect is like GenerateInsnArray but the array is written
+// in `dialect`.
+func GenerateInsnArrayDialect(program *pb.Program, dialect PocDialect) (string, error) {
+	return generateInsnArray(program, false, dialect)
+}
+
+// GenerateNumberedInsnArray is like GenerateInsnArray but every instruction
+// is prefixed with a comment holding its index, counted in slots the same
+// way the verifier does, e.g. `/* 42 */`. This makes it trivial to find the
+// instruction a verifier log is complaining about.
+func GenerateNumberedInsnArray(program *pb.Program) (string, error) {
+	return generateInsnArray(program, true, PocDialectSelftests)
+}
+
+func generateInsnArray(program *pb.Program, numbered bool, dialect PocDialect) (string, error) {
+	instructions := programInstructions(program)
+	slots := slotIndexes(instructions)
+	var sb strings.Builder
+	sb.WriteString("struct bpf_insn insns[] = {\n")
+	for index, inst := range instructions {
+		format := instructionMacro
+		if dialect == PocDialectRaw {
+			format = func(i *pb.Instruction, _ PocDialect) (string, error) { return rawInstructionInitializer(i) }
+		}
+		macro, err := format(inst, dialect)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString("\t")
+		if numbered {
+			sb.WriteString(fmt.Sprintf("/* %d */ ", slots[index]))
+		}
+		sb.WriteString(macro + ",")
+
+		// Exit values are what matters for a lot of program types, if
+		// we can tell what the program returns say it in the poc.
+		if value, ok := ExitValue(instructions, index); ok {
+			sb.WriteString(fmt.Sprintf(" /* returns %d */", value))
+		}
+		if origin := InstructionOrigin(inst); origin != "" {
+			sb.WriteString(fmt.Sprintf(" /* %s */", origin))
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString("};\n")
+	return sb.String(), nil
+}
+
+// GenerateRustInsnArray returns the program as a Rust array of the encoded
+// instructions, one u64 per slot numbered like in verifier logs. On little
+// endian machines every u64 has the layout of a `struct bpf_insn`, so the
+// array can be transmuted to the instructions aya's loader takes, e.g.
+// `aya_obj::generated::bpf_insn`.
+func GenerateRustInsnArray(program *pb.Program) (string, error) {
+	words := []uint64{}
+	for _, inst := range programInstructions(program) {
+		encoding, err := encodeInstruction(inst)
+		if err != nil {
+			return "", err
+		}
+		words = append(words, encoding...)
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("const INSNS: [u64; %d] = [\n", len(words)))
+	for slot, word := range words {
+		sb.WriteString(fmt.Sprintf("    0x%016x, // %d\n", word, slot))
+	}
+	sb.WriteString("];\n")
+	return sb.String(), nil
+}
+
+func isLdImm64(i *pb.Instruction) bool {
+	mem, ok := i.Opcode.(*pb.Instruction_MemOpcode)
+	return ok && mem.MemOpcode.InstructionClass == pb.InsClass_InsClassLd && mem.MemOpcode.Mode == pb.StLdMode_StLdModeIMM && mem.MemOpcode.Size == pb.StLdSize_StLdSizeDW
+}
+
+// PocReferencedMacros returns the sorted, distinct names of the function like
+// macros (e.g. BPF_JMP_IMM) used by the instruction array GenerateInsnArray
+// produces for `program`. This allows checking that a poc will compile
+// against a given set of headers before shipping it.
+func PocReferencedMacros(program *pb.Program) ([]string, error) {
+	insns, err := GenerateInsnArray(program)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	macros := []string{}
+	for _, match := range pocMacroRegex.FindAllStringSubmatch(insns, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			macros = append(macros, match[1])
+		}
+	}
+	sort.Strings(macros)
+	return macros, nil
+}
+
+func regMacro(r pb.Reg, dialect PocDialect) string {
+	if dialect == PocDialectTestBpf {
+		return fmt.Sprintf("R%d", r)
+	}
+	return fmt.Sprintf("BPF_REG_%d", r)
+}
+
+func aluOpMacro(op pb.AluOperationCode) string {
+	switch op {
+	case pb.AluOperationCode_AluAdd:
+		return "BPF_ADD"
+	case pb.AluOperationCode_AluSub:
+		return "BPF_SUB"
+	case pb.AluOperationCode_AluMul:
+		return "BPF_MUL"
+	case pb.AluOperationCode_AluDiv:
+		return "BPF_DIV"
+	case pb.AluOperationCode_AluOr:
+		return "BPF_OR"
+	case pb.AluOperationCode_AluAnd:
+		return "BPF_AND"
+	case pb.AluOperationCode_AluLsh:
+		return "BPF_LSH"
+	case pb.AluOperationCode_AluRsh:
+		return "BPF_RSH"
+	case pb.AluOperationCode_AluNeg:
+		return "BPF_NEG"
+	case pb.AluOperationCode_AluMod:
+		return "BPF_MOD"
+	case pb.AluOperationCode_AluXor:
+		return "BPF_XOR"
+	case pb.AluOperationCode_AluMov:
+		return "BPF_MOV"
+	case pb.AluOperationCode_AluArsh:
+		return "BPF_ARSH"
+	case pb.AluOperationCode_AluEnd:
+		return "BPF_END"
+	default:
+		return fmt.Sprintf("0x%02x", uint8(op))
+	}
+}
 
+func jmpOpMacro(op pb.JmpOperationCode) string {
+	switch op {
+	case pb.JmpOperationCode_JmpJA:
+		return "BPF_JA"
+	case pb.JmpOperationCode_JmpJEQ:
+		return "BPF_JEQ"
+	case pb.JmpOperationCode_JmpJGT:
+		return "BPF_JGT"
+	case pb.JmpOperationCode_JmpJGE:
+		return "BPF_JGE"
+	case pb.JmpOperationCode_JmpJSET:
+		return "BPF_JSET"
+	case pb.JmpOperationCode_JmpJNE:
+		return "BPF_JNE"
+	case pb.JmpOperationCode_JmpJSGT:
+		return "BPF_JSGT"
+	case pb.JmpOperationCode_JmpJSGE:
+		return "BPF_JSGE"
+	case pb.JmpOperationCode_JmpCALL:
+		return "BPF_CALL"
+	case pb.JmpOperationCode_JmpExit:
+		return "BPF_EXIT"
+	case pb.JmpOperationCode_JmpJLT:
+		return "BPF_JLT"
+	case pb.JmpOperationCode_JmpJLE:
+		return "BPF_JLE"
+	case pb.JmpOperationCode_JmpJSLT:
+		return "BPF_JSLT"
+	case pb.JmpOperationCode_JmpJSLE:
+		return "BPF_JSLE"
+	default:
+		return fmt.Sprintf("0x%02x", uint8(op))
+	}
+}
+
+func sizeMacro(s pb.StLdSize) string {
+	switch s {
+	case pb.StLdSize_StLdSizeW:
+		return "BPF_W"
+	case pb.StLdSize_StLdSizeH:
+		return "BPF_H"
+	case pb.StLdSize_StLdSizeB:
+		return "BPF_B"
+	default:
+		return "BPF_DW"
+	}
+}
+
+// rawInstructionMacro is the fallback for instructions that don't have a
+// dedicated macro, it spells out every field of the instruction. It emits one
+// BPF_RAW_INSN per encoded word of `i`, so wide instructions take as many
+// entries in the poc as in the bytecode.
+func rawInstructionMacro(i *pb.Instruction, dialect PocDialect) (string, error) {
+	encoding, err := encodeInstruction(i)
+	if err != nil {
+		return "", err
+	}
+	macros := []string{}
+	for _, word := range encoding {
+		macros = append(macros, fmt.Sprintf("BPF_RAW_INSN(0x%02x, %s, %s, %d, %d)", uint8(word), regMacro(pb.Reg((word>>8)&0x0f), dialect), regMacro(pb.Reg((word>>12)&0x0f), dialect), int16(word>>16), int32(word>>32)))
+	}
+	return strings.Join(macros, ",\n\t"), nil
+}
+
+// rawInstructionInitializer returns `i` as designated initializers of
+// `struct bpf_insn`, one per encoded word.
+func rawInstructionInitializer(i *pb.Instruction) (string, error) {
+	encoding, err := encodeInstruction(i)
+	if err != nil {
+		return "", err
+	}
+	initializers := []string{}
+	for _, word := range encoding {
+		initializers = append(initializers, fmt.Sprintf("{ .code = 0x%02x, .dst_reg = %d, .src_reg = %d, .off = %d, .imm = %d }", uint8(word), (word>>8)&0x0f, (word>>12)&0x0f, int16(word>>16), int32(word>>32)))
+	}
+	return strings.Join(initializers, ",\n\t"), nil
+}
+
+func aluInstructionMacro(i *pb.Instruction, op *pb.AluOpcode, dialect PocDialect) (string, error) {
+	if op.OperationCode == pb.AluOperationCode_AluEnd {
+		return rawInstructionMacro(i, dialect)
+	}
+
+	width := "32"
+	if op.InstructionClass == pb.InsClass_InsClassAlu64 {
+		width = "64"
+	}
+
+	if op.OperationCode == pb.AluOperationCode_AluMov {
+		if op.Source == pb.SrcOperand_RegSrc {
+			return fmt.Sprintf("BPF_MOV%s_REG(%s, %s)", width, regMacro(i.DstReg, dialect), regMacro(i.SrcReg, dialect)), nil
+		}
+		return fmt.Sprintf("BPF_MOV%s_IMM(%s, %d)", width, regMacro(i.DstReg, dialect), i.Immediate), nil
+	}
+
+	if op.Source == pb.SrcOperand_RegSrc {
+		return fmt.Sprintf("BPF_ALU%s_REG(%s, %s, %s)", width, aluOpMacro(op.OperationCode), regMacro(i.DstReg, dialect), regMacro(i.SrcReg, dialect)), nil
+	}
+	return fmt.Sprintf("BPF_ALU%s_IMM(%s, %s, %d)", width, aluOpMacro(op.OperationCode), regMacro(i.DstReg, dialect), i.Immediate), nil
+}
+
+func jmpInstructionMacro(i *pb.Instruction, op *pb.JmpOpcode, dialect PocDialect) (string, error) {
+	switch op.OperationCode {
+	case pb.JmpOperationCode_JmpExit:
+		return "BPF_EXIT_INSN()", nil
+	case pb.JmpOperationCode_JmpCALL:
+		fn := fmt.Sprintf("%d", i.Immediate)
+		if number, ok := HelperFunctionNumber(i); ok {
+			fn = helperMacroArg(number, GetBpfFuncName(number))
+		}
+		return fmt.Sprintf("BPF_RAW_INSN(BPF_JMP | BPF_CALL, 0, %d, 0, %s)", i.SrcReg, fn), nil
+	case pb.JmpOperationCode_JmpJA:
+		if op.InstructionClass != pb.InsClass_InsClassJmp {
+			return rawInstructionMacro(i, dialect)
+		}
+		return fmt.Sprintf("BPF_JMP_A(%d)", int16(i.Offset)), nil
+	}
+
+	class := "JMP"
+	if op.InstructionClass == pb.InsClass_InsClassJmp32 {
+		class = "JMP32"
+	}
+	if op.Source == pb.SrcOperand_RegSrc {
+		return fmt.Sprintf("BPF_%s_REG(%s, %s, %s, %d)", class, jmpOpMacro(op.OperationCode), regMacro(i.DstReg, dialect), regMacro(i.SrcReg, dialect), int16(i.Offset)), nil
+	}
+	return fmt.Sprintf("BPF_%s_IMM(%s, %s, %d, %d)", class, jmpOpMacro(op.OperationCode), regMacro(i.DstReg, dialect), i.Immediate, int16(i.Offset)), nil
+}
+
+// helperMacroArg returns what to put in the poc for a call to helper
+// `number` named `name`. The name is only used if it is a valid C
+// identifier, so the poc always compiles, otherwise the raw number is.
+func helperMacroArg(number int32, name string) string {
+	if name == "unknown" || !cIdentifierRegex.MatchString(name) {
+		return fmt.Sprintf("%d", number)
+	}
+	return name
+}
+
+func atomicOpMacro(imm int32) string {
+	const fetch = 0x01
+	// BPF_XCHG and BPF_CMPXCHG already include BPF_FETCH, without it the
+	// raw value has to be spelled out.
+	switch imm {
+	case 0xe1:
+		return "BPF_XCHG"
+	case 0xf1:
+		return "BPF_CMPXCHG"
+	}
+	name := aluOpMacro(pb.AluOperationCode(imm &^ fetch))
+	if imm&fetch != 0 {
+		name += " | BPF_FETCH"
+	}
+	return name
+}
+
+func memInstructionMacro(i *pb.Instruction, op *pb.MemOpcode, dialect PocDialect) (string, error) {
+	size := sizeMacro(op.Size)
+	switch op.InstructionClass {
+	case pb.InsClass_InsClassLd:
+		switch op.Mode {
+		case pb.StLdMode_StLdModeIMM:
+			p, ok := i.PseudoInstruction.(*pb.Instruction_PseudoValue)
+			if !ok || op.Size != pb.StLdSize_StLdSizeDW {
+				return rawInstructionMacro(i, dialect)
+			}
+			if i.SrcReg == PseudoMapFD {
+				return fmt.Sprintf("BPF_LD_MAP_FD(%s, %d)", regMacro(i.DstReg, dialect), i.Immediate), nil
+			}
+			value := uint64(uint32(i.Immediate)) | uint64(p.PseudoValue.Immediate)<<32
+			if i.SrcReg == pb.Reg_R0 {
+				return fmt.Sprintf("BPF_LD_IMM64(%s, 0x%x)", regMacro(i.DstReg, dialect), value), nil
+			}
+			return fmt.Sprintf("BPF_LD_IMM64_RAW(%s, %d, 0x%x)", regMacro(i.DstReg, dialect), i.SrcReg, value), nil
+		case pb.StLdMode_StLdModeABS:
+			return fmt.Sprintf("BPF_LD_ABS(%s, %d)", size, i.Immediate), nil
+		case pb.StLdMode_StLdModeIND:
+			return fmt.Sprintf("BPF_LD_IND(%s, %s, %d)", size, regMacro(i.SrcReg, dialect), i.Immediate), nil
+		}
+	case pb.InsClass_InsClassLdx:
+		if op.Mode == pb.StLdMode_StLdModeMEM {
+			return fmt.Sprintf("BPF_LDX_MEM(%s, %s, %s, %d)", size, regMacro(i.DstReg, dialect), regMacro(i.SrcReg, dialect), int16(i.Offset)), nil
+		}
+	case pb.InsClass_InsClassSt:
+		if op.Mode == pb.StLdMode_StLdModeMEM {
+			return fmt.Sprintf("BPF_ST_MEM(%s, %s, %d, %d)", size, regMacro(i.DstReg, dialect), int16(i.Offset), i.Immediate), nil
+		}
+	case pb.InsClass_InsClassStx:
+		switch op.Mode {
+		case pb.StLdMode_StLdModeMEM:
+			return fmt.Sprintf("BPF_STX_MEM(%s, %s, %s, %d)", size, regMacro(i.DstReg, dialect), regMacro(i.SrcReg, dialect), int16(i.Offset)), nil
+		case pb.StLdMode_StLdModeATOMIC:
+			return fmt.Sprintf("BPF_ATOMIC_OP(%s, %s, %s, %s, %d)", size, atomicOpMacro(i.Immediate), regMacro(i.DstReg, dialect), regMacro(i.SrcReg, dialect), int16(i.Offset)), nil
+		}
+	}
+	return rawInstructionMacro(i, dialect)
+}
+
+// macroDropsFields returns true if `i` has a non zero value in a field that
+// its dedicated macro always sets to zero, e.g. an offset in an ALU
+// instruction. This happens with mutated or raw instructions and using the
+// macro would make the poc differ from the bytecode.
+func macroDropsFields(i *pb.Instruction) bool {
+	var dst, src, off, imm bool
+	switch c := i.Opcode.(type) {
+	case *pb.Instruction_AluOpcode:
+		regSrc := c.AluOpcode.Source == pb.SrcOperand_RegSrc
+		dst, src, imm = true, regSrc, !regSrc
+	case *pb.Instruction_JmpOpcode:
+		regSrc := c.JmpOpcode.Source == pb.SrcOperand_RegSrc
+		switch c.JmpOpcode.OperationCode {
+		case pb.JmpOperationCode_JmpExit:
+		case pb.JmpOperationCode_JmpCALL:
+			src, imm = true, true
+		case pb.JmpOperationCode_JmpJA:
+			off = true
+		default:
+			dst, src, off, imm = true, regSrc, true, !regSrc
+		}
+	case *pb.Instruction_MemOpcode:
+		switch c.MemOpcode.Mode {
+		case pb.StLdMode_StLdModeIMM:
+			dst, src, imm = true, true, true
+			if p, ok := i.PseudoInstruction.(*pb.Instruction_PseudoValue); ok {
+				pseudo := p.PseudoValue
+				if encoding, err := encodeInstruction(pseudo); err != nil || encoding[0]&0xffffffff != 0 {
+					return true
+				}
+			}
+		case pb.StLdMode_StLdModeABS:
+			imm = true
+		case pb.StLdMode_StLdModeIND:
+			src, imm = true, true
+		default:
+			dst, src, off, imm = true, true, true, true
+			if c.MemOpcode.InstructionClass == pb.InsClass_InsClassSt {
+				src = false
+			} else if c.MemOpcode.Mode == pb.StLdMode_StLdModeMEM {
+				imm = false
+			}
+		}
+	}
+	return (!dst && i.DstReg != 0) || (!src && i.SrcReg != 0) || (!off && i.Offset != 0) || (!imm && i.Immediate != 0)
+}
+
+// instructionMacro returns the filter.h macro that produces `i`.
+func instructionMacro(i *pb.Instruction, dialect PocDialect) (string, error) {
+	// Only LD_IMM64 has a macro that expands to two instructions, anything
+	// else that is wide has to be emitted word by word to match the
+	// bytecode.
+	if _, wide := i.PseudoInstruction.(*pb.Instruction_PseudoValue); wide && !isLdImm64(i) {
+		return rawInstructionMacro(i, dialect)
+	}
+	if macroDropsFields(i) {
+		return rawInstructionMacro(i, dialect)
+	}
+
+	switch c := i.Opcode.(type) {
+	case *pb.Instruction_AluOpcode:
+		return aluInstructionMacro(i, c.AluOpcode, dialect)
+	case *pb.Instruction_JmpOpcode:
+		return jmpInstructionMacro(i, c.JmpOpcode, dialect)
+	case *pb.Instruction_MemOpcode:
+		return memInstructionMacro(i, c.MemOpcode, dialect)
+	default:
+		return "", UnknownOpcodeType
+	}
 }
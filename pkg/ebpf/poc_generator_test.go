@@ -0,0 +1,537 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestGenerateInsnArray(t *testing.T) {
+	tests := []struct {
+		testName     string
+		instructions []*pb.Instruction
+		want         string
+	}{
+		{
+			testName: "Constant exit value",
+			instructions: []*pb.Instruction{
+				Mov64(R0, 1),
+				Exit(),
+			},
+			want: "struct bpf_insn insns[] = {\n" +
+				"\tBPF_MOV64_IMM(BPF_REG_0, 1),\n" +
+				"\tBPF_EXIT_INSN(), /* returns 1 */\n" +
+				"};\n",
+		},
+		{
+			testName: "Folded exit value",
+			instructions: []*pb.Instruction{
+				Mov64(R1, 20),
+				Mov64(R0, R1),
+				Add64(R0, 22),
+				Exit(),
+			},
+			want: "struct bpf_insn insns[] = {\n" +
+				"\tBPF_MOV64_IMM(BPF_REG_1, 20),\n" +
+				"\tBPF_MOV64_REG(BPF_REG_0, BPF_REG_1),\n" +
+				"\tBPF_ALU64_IMM(BPF_ADD, BPF_REG_0, 22),\n" +
+				"\tBPF_EXIT_INSN(), /* returns 42 */\n" +
+				"};\n",
+		},
+		{
+			testName: "Exit value clobbered by helper call",
+			instructions: []*pb.Instruction{
+				Mov64(R0, 0),
+				Call(MapLookup),
+				Exit(),
+			},
+			want: "struct bpf_insn insns[] = {\n" +
+				"\tBPF_MOV64_IMM(BPF_REG_0, 0),\n" +
+				"\tBPF_RAW_INSN(BPF_JMP | BPF_CALL, 0, 0, 0, BPF_FUNC_map_lookup_elem),\n" +
+				"\tBPF_EXIT_INSN(),\n" +
+				"};\n",
+		},
+		{
+			testName: "Exit value defined in another block",
+			instructions: []*pb.Instruction{
+				Mov64(R0, 0),
+				JmpEQ(R1, 0, 1),
+				Mov64(R0, 1),
+				Exit(),
+			},
+			want: "struct bpf_insn insns[] = {\n" +
+				"\tBPF_MOV64_IMM(BPF_REG_0, 0),\n" +
+				"\tBPF_JMP_IMM(BPF_JEQ, BPF_REG_1, 0, 1),\n" +
+				"\tBPF_MOV64_IMM(BPF_REG_0, 1),\n" +
+				"\tBPF_EXIT_INSN(),\n" +
+				"};\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			program := &pb.Program{
+				Functions: []*pb.Functions{
+					{Instructions: tc.instructions},
+				},
+			}
+			got, err := GenerateInsnArray(program)
+			if err != nil {
+				t.Fatalf("GenerateInsnArray() error = %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("GenerateInsnArray() = \n%s\nwant\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestGenerateInsnArrayMatchesBytecode checks that every entry of the poc
+// array corresponds to one encoded instruction, in the same order.
+func TestGenerateInsnArrayMatchesBytecode(t *testing.T) {
+	wideAlu := Mov64(R1, 1)
+	wideAlu.PseudoInstruction = Mov64(R0, int64(1)<<40).PseudoInstruction
+	offsetAlu := Mov64(R2, 7)
+	offsetAlu.Offset = 1
+
+	program := &pb.Program{
+		Functions: []*pb.Functions{
+			{
+				Instructions: []*pb.Instruction{
+					LdMapByFd(R1, 3),
+					wideAlu,
+					Mov64(R0, int64(1)<<40),
+					Exit(),
+				},
+			},
+			{
+				Instructions: []*pb.Instruction{
+					offsetAlu,
+					Mov64(R0, 0),
+					Exit(),
+				},
+			},
+		},
+	}
+
+	want := "struct bpf_insn insns[] = {\n" +
+		"\tBPF_LD_MAP_FD(BPF_REG_1, 3),\n" +
+		"\tBPF_RAW_INSN(0xb7, BPF_REG_1, BPF_REG_0, 0, 1),\n" +
+		"\tBPF_RAW_INSN(0x00, BPF_REG_0, BPF_REG_0, 0, 256),\n" +
+		"\tBPF_LD_IMM64(BPF_REG_0, 0x10000000000),\n" +
+		"\tBPF_EXIT_INSN(), /* returns 1099511627776 */\n" +
+		"\tBPF_RAW_INSN(0xb7, BPF_REG_2, BPF_REG_0, 1, 7),\n" +
+		"\tBPF_MOV64_IMM(BPF_REG_0, 0),\n" +
+		"\tBPF_EXIT_INSN(), /* returns 0 */\n" +
+		"};\n"
+
+	got, err := GenerateInsnArray(program)
+	if err != nil {
+		t.Fatalf("GenerateInsnArray() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("GenerateInsnArray() = \n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestPocReferencedMacros(t *testing.T) {
+	program := &pb.Program{
+		Functions: []*pb.Functions{
+			{
+				Instructions: []*pb.Instruction{
+					LdMapByFd(R1, 3),
+					Mov64(R0, 0),
+					JmpEQ(R1, 0, 1),
+					Mov64(R0, 1),
+					Call(MapLookup),
+					Exit(),
+				},
+			},
+		},
+	}
+
+	got, err := PocReferencedMacros(program)
+	if err != nil {
+		t.Fatalf("PocReferencedMacros() error = %v", err)
+	}
+	want := []string{"BPF_EXIT_INSN", "BPF_JMP_IMM", "BPF_LD_MAP_FD", "BPF_MOV64_IMM", "BPF_RAW_INSN"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PocReferencedMacros() = %v, want %v", got, want)
+	}
+}
+
+func TestGenerateNumberedInsnArray(t *testing.T) {
+	program := &pb.Program{
+		Functions: []*pb.Functions{
+			{
+				Instructions: []*pb.Instruction{
+					LdMapByFd(R1, 3),
+					JmpEQ(R1, 0, 1),
+					Mov64(R0, 1),
+					Exit(),
+				},
+			},
+		},
+	}
+
+	want := "struct bpf_insn insns[] = {\n" +
+		"\t/* 0 */ BPF_LD_MAP_FD(BPF_REG_1, 3),\n" +
+		"\t/* 2 */ BPF_JMP_IMM(BPF_JEQ, BPF_REG_1, 0, 1),\n" +
+		"\t/* 3 */ BPF_MOV64_IMM(BPF_REG_0, 1),\n" +
+		"\t/* 4 */ BPF_EXIT_INSN(),\n" +
+		"};\n"
+
+	got, err := GenerateNumberedInsnArray(program)
+	if err != nil {
+		t.Fatalf("GenerateNumberedInsnArray() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("GenerateNumberedInsnArray() = \n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGenerateInsnArrayDialect(t *testing.T) {
+	program := &pb.Program{
+		Functions: []*pb.Functions{
+			{
+				Instructions: []*pb.Instruction{
+					LdMapByFd(R1, 3),
+					JmpEQ(R1, R2, 1),
+					Mov64(R0, 1),
+					Exit(),
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		testName string
+		dialect  PocDialect
+		want     string
+	}{
+		{
+			testName: "Selftests",
+			dialect:  PocDialectSelftests,
+			want: "struct bpf_insn insns[] = {\n" +
+				"\tBPF_LD_MAP_FD(BPF_REG_1, 3),\n" +
+				"\tBPF_JMP_REG(BPF_JEQ, BPF_REG_1, BPF_REG_2, 1),\n" +
+				"\tBPF_MOV64_IMM(BPF_REG_0, 1),\n" +
+				"\tBPF_EXIT_INSN(),\n" +
+				"};\n",
+		},
+		{
+			testName: "test_bpf",
+			dialect:  PocDialectTestBpf,
+			want: "struct bpf_insn insns[] = {\n" +
+				"\tBPF_LD_MAP_FD(R1, 3),\n" +
+				"\tBPF_JMP_REG(BPF_JEQ, R1, R2, 1),\n" +
+				"\tBPF_MOV64_IMM(R0, 1),\n" +
+				"\tBPF_EXIT_INSN(),\n" +
+				"};\n",
+		},
+		{
+			testName: "Raw",
+			dialect:  PocDialectRaw,
+			want: "struct bpf_insn insns[] = {\n" +
+				"\t{ .code = 0x18, .dst_reg = 1, .src_reg = 1, .off = 0, .imm = 3 },\n" +
+				"\t{ .code = 0x00, .dst_reg = 0, .src_reg = 0, .off = 0, .imm = 0 },\n" +
+				"\t{ .code = 0x1d, .dst_reg = 1, .src_reg = 2, .off = 1, .imm = 0 },\n" +
+				"\t{ .code = 0xb7, .dst_reg = 0, .src_reg = 0, .off = 0, .imm = 1 },\n" +
+				"\t{ .code = 0x95, .dst_reg = 0, .src_reg = 0, .off = 0, .imm = 0 },\n" +
+				"};\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got, err := GenerateInsnArrayDialect(program, tc.dialect)
+			if err != nil {
+				t.Fatalf("GenerateInsnArrayDialect() error = %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("GenerateInsnArrayDialect() = \n%s\nwant\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGenerateRustInsnArray(t *testing.T) {
+	program := &pb.Program{
+		Functions: []*pb.Functions{
+			{
+				Instructions: []*pb.Instruction{
+					LdMapByFd(R1, 3),
+					JmpEQ(R1, R2, 1),
+					Mov64(R0, -1),
+					Exit(),
+				},
+			},
+		},
+	}
+
+	want := "const INSNS: [u64; 5] = [\n" +
+		"    0x0000000300001118, // 0\n" +
+		"    0x0000000000000000, // 1\n" +
+		"    0x000000000001211d, // 2\n" +
+		"    0xffffffff000000b7, // 3\n" +
+		"    0x0000000000000095, // 4\n" +
+		"];\n"
+
+	got, err := GenerateRustInsnArray(program)
+	if err != nil {
+		t.Fatalf("GenerateRustInsnArray() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("GenerateRustInsnArray() = \n%s\nwant\n%s", got, want)
+	}
+}
+
+// pocMacroConstants holds the value of the filter.h and bpf.h constants that
+// show up as macro arguments in the pocs.
+var pocMacroConstants = map[string]int64{
+	"BPF_LD": 0x00, "BPF_LDX": 0x01, "BPF_ST": 0x02, "BPF_STX": 0x03,
+	"BPF_ALU": 0x04, "BPF_JMP": 0x05, "BPF_JMP32": 0x06, "BPF_ALU64": 0x07,
+	"BPF_W": 0x00, "BPF_H": 0x08, "BPF_B": 0x10, "BPF_DW": 0x18,
+	"BPF_IMM": 0x00, "BPF_ABS": 0x20, "BPF_IND": 0x40, "BPF_MEM": 0x60, "BPF_ATOMIC": 0xc0,
+	"BPF_K": 0x00, "BPF_X": 0x08,
+	"BPF_ADD": 0x00, "BPF_SUB": 0x10, "BPF_MUL": 0x20, "BPF_DIV": 0x30,
+	"BPF_OR": 0x40, "BPF_AND": 0x50, "BPF_LSH": 0x60, "BPF_RSH": 0x70,
+	"BPF_NEG": 0x80, "BPF_MOD": 0x90, "BPF_XOR": 0xa0, "BPF_MOV": 0xb0,
+	"BPF_ARSH": 0xc0, "BPF_END": 0xd0,
+	"BPF_JA": 0x00, "BPF_JEQ": 0x10, "BPF_JGT": 0x20, "BPF_JGE": 0x30,
+	"BPF_JSET": 0x40, "BPF_JNE": 0x50, "BPF_JSGT": 0x60, "BPF_JSGE": 0x70,
+	"BPF_CALL": 0x80, "BPF_EXIT": 0x90, "BPF_JLT": 0xa0, "BPF_JLE": 0xb0,
+	"BPF_JSLT": 0xc0, "BPF_JSLE": 0xd0,
+	"BPF_FETCH": 0x01, "BPF_XCHG": 0xe1, "BPF_CMPXCHG": 0xf1,
+	"BPF_PSEUDO_MAP_FD": 1,
+}
+
+// macroWord encodes a single instruction the way the kernel's struct
+// bpf_insn lays it out.
+func macroWord(code, dst, src, off, imm int64) uint64 {
+	return uint64(uint8(code)) | uint64(uint8(dst)&0x0f)<<8 | uint64(uint8(src)&0x0f)<<12 | uint64(uint16(off))<<16 | uint64(uint32(imm))<<32
+}
+
+func macroLdImm64(dst, src, imm int64) []uint64 {
+	return []uint64{
+		macroWord(0x00|0x18|0x00, dst, src, 0, int64(uint32(imm))),
+		macroWord(0, 0, 0, 0, int64(uint64(imm)>>32)),
+	}
+}
+
+// pocMacroWords maps every filter.h macro GenerateInsnArray uses to the
+// words it expands to, following the definitions in the kernel headers.
+var pocMacroWords = map[string]func(a []int64) []uint64{
+	"BPF_RAW_INSN":     func(a []int64) []uint64 { return []uint64{macroWord(a[0], a[1], a[2], a[3], a[4])} },
+	"BPF_EXIT_INSN":    func(a []int64) []uint64 { return []uint64{macroWord(0x05|0x90, 0, 0, 0, 0)} },
+	"BPF_JMP_A":        func(a []int64) []uint64 { return []uint64{macroWord(0x05|0x00, 0, 0, a[0], 0)} },
+	"BPF_MOV64_REG":    func(a []int64) []uint64 { return []uint64{macroWord(0x07|0xb0|0x08, a[0], a[1], 0, 0)} },
+	"BPF_MOV32_REG":    func(a []int64) []uint64 { return []uint64{macroWord(0x04|0xb0|0x08, a[0], a[1], 0, 0)} },
+	"BPF_MOV64_IMM":    func(a []int64) []uint64 { return []uint64{macroWord(0x07|0xb0, a[0], 0, 0, a[1])} },
+	"BPF_MOV32_IMM":    func(a []int64) []uint64 { return []uint64{macroWord(0x04|0xb0, a[0], 0, 0, a[1])} },
+	"BPF_ALU64_REG":    func(a []int64) []uint64 { return []uint64{macroWord(0x07|a[0]|0x08, a[1], a[2], 0, 0)} },
+	"BPF_ALU32_REG":    func(a []int64) []uint64 { return []uint64{macroWord(0x04|a[0]|0x08, a[1], a[2], 0, 0)} },
+	"BPF_ALU64_IMM":    func(a []int64) []uint64 { return []uint64{macroWord(0x07|a[0], a[1], 0, 0, a[2])} },
+	"BPF_ALU32_IMM":    func(a []int64) []uint64 { return []uint64{macroWord(0x04|a[0], a[1], 0, 0, a[2])} },
+	"BPF_JMP_REG":      func(a []int64) []uint64 { return []uint64{macroWord(0x05|a[0]|0x08, a[1], a[2], a[3], 0)} },
+	"BPF_JMP32_REG":    func(a []int64) []uint64 { return []uint64{macroWord(0x06|a[0]|0x08, a[1], a[2], a[3], 0)} },
+	"BPF_JMP_IMM":      func(a []int64) []uint64 { return []uint64{macroWord(0x05|a[0], a[1], 0, a[3], a[2])} },
+	"BPF_JMP32_IMM":    func(a []int64) []uint64 { return []uint64{macroWord(0x06|a[0], a[1], 0, a[3], a[2])} },
+	"BPF_LD_IMM64":     func(a []int64) []uint64 { return macroLdImm64(a[0], 0, a[1]) },
+	"BPF_LD_IMM64_RAW": func(a []int64) []uint64 { return macroLdImm64(a[0], a[1], a[2]) },
+	"BPF_LD_MAP_FD":    func(a []int64) []uint64 { return macroLdImm64(a[0], 1, a[1]) },
+	"BPF_LD_ABS":       func(a []int64) []uint64 { return []uint64{macroWord(0x00|a[0]|0x20, 0, 0, 0, a[1])} },
+	"BPF_LD_IND":       func(a []int64) []uint64 { return []uint64{macroWord(0x00|a[0]|0x40, 0, a[1], 0, a[2])} },
+	"BPF_LDX_MEM":      func(a []int64) []uint64 { return []uint64{macroWord(0x01|a[0]|0x60, a[1], a[2], a[3], 0)} },
+	"BPF_ST_MEM":       func(a []int64) []uint64 { return []uint64{macroWord(0x02|a[0]|0x60, a[1], 0, a[2], a[3])} },
+	"BPF_STX_MEM":      func(a []int64) []uint64 { return []uint64{macroWord(0x03|a[0]|0x60, a[1], a[2], a[3], 0)} },
+	"BPF_ATOMIC_OP":    func(a []int64) []uint64 { return []uint64{macroWord(0x03|a[0]|0xc0, a[2], a[3], a[4], a[1])} },
+}
+
+// evalMacroArg evaluates a macro argument, which can be a number, a known
+// constant or several of them or'ed together.
+func evalMacroArg(arg string) (int64, error) {
+	value := int64(0)
+	for _, term := range strings.Split(arg, "|") {
+		term = strings.TrimSpace(term)
+		if v, ok := pocMacroConstants[term]; ok {
+			value |= v
+			continue
+		}
+		if reg, ok := strings.CutPrefix(term, "BPF_REG_"); ok {
+			term = reg
+		}
+		v, err := strconv.ParseInt(term, 0, 64)
+		if err != nil {
+			// LD_IMM64 values are printed as unsigned hex.
+			u, uerr := strconv.ParseUint(term, 0, 64)
+			if uerr != nil {
+				return 0, fmt.Errorf("unknown macro argument %q", term)
+			}
+			v = int64(u)
+		}
+		value |= v
+	}
+	return value, nil
+}
+
+// expandInsnArray expands the macros of an array produced by
+// GenerateInsnArray into instruction words using pocMacroWords.
+func expandInsnArray(insns string) ([]uint64, error) {
+	words := []uint64{}
+	for _, match := range pocMacroRegex.FindAllStringSubmatchIndex(insns, -1) {
+		name := insns[match[2]:match[3]]
+		end := strings.Index(insns[match[1]:], ")")
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated %s", name)
+		}
+		expand, ok := pocMacroWords[name]
+		if !ok {
+			return nil, fmt.Errorf("no expansion for macro %s", name)
+		}
+		args := []int64{}
+		if argList := insns[match[1] : match[1]+end]; argList != "" {
+			for _, arg := range strings.Split(argList, ",") {
+				value, err := evalMacroArg(arg)
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, value)
+			}
+		}
+		words = append(words, expand(args)...)
+	}
+	return words, nil
+}
+
+// TestGenerateInsnArrayRoundtrip checks that expanding the poc macros yields
+// exactly the same words as encoding the program.
+func TestGenerateInsnArrayRoundtrip(t *testing.T) {
+	for number := int32(0); number < 0x100; number++ {
+		if name := GetBpfFuncName(number); name != "unknown" {
+			pocMacroConstants[name] = int64(number)
+		}
+	}
+
+	raw, err := RawInstructions(
+		// BPF_LD | BPF_ABS | BPF_W
+		0x0000002a00000020,
+		// BPF_LD | BPF_IND | BPF_H, src = r6
+		0x0000000460000048,
+		// BPF_STX | BPF_ATOMIC | BPF_DW with BPF_XCHG
+		0x000000e1fff812db,
+		// BPF_STX | BPF_ATOMIC | BPF_W with 0xe0, xchg without fetch
+		0x000000e0fff812c3,
+		// BPF_STX | BPF_ATOMIC | BPF_DW with BPF_CMPXCHG
+		0x000000f1fff812db,
+		// BPF_JMP32 | BPF_JA
+		0x0000000000030006,
+	)
+	if err != nil {
+		t.Fatalf("RawInstructions() error = %v", err)
+	}
+	wideAlu := Mov64(R1, 1)
+	wideAlu.PseudoInstruction = Mov64(R0, int64(1)<<40).PseudoInstruction
+	pseudoCall := Call(3)
+	pseudoCall.SrcReg = PseudoCall
+
+	instructions := []*pb.Instruction{
+		Mov64(R1, R2), Mov(R3, R4), Mov64(R5, -1), Mov(R6, 7),
+		Add64(R1, R2), Sub(R3, 5), Mul64(R4, -3), Div(R5, R6),
+		Or64(R1, 0x7fffffff), And(R2, R3), Lsh64(R1, 63), Rsh(R2, R3),
+		Neg64(R1, 0), Mod(R2, 3), Xor64(R3, R4), Arsh(R5, 1),
+		End64(R1, 16), wideAlu,
+		Mov64(R0, int64(-1)<<40), LdMapByFd(R1, 3), LdMapValue(R2, 4, 16), LdFunctionPtr(2),
+		LdDW(R0, R10, -8), LdW(R1, R2, 4), LdH(R3, R4, 0), LdB(R5, R6, 1),
+		StDW(R10, 1, -8), StW(R1, R2, 4), StH(R3, -1, 2), StB(R4, R5, -1),
+		MemAdd64(R10, R1, -8), MemOr(R2, R3, 0), MemAnd64(R4, R5, 8), XAdd(R6, R7, -4),
+		Jmp(-2), JmpEQ(R1, 0, 1), JmpNE32(R2, R3, -1), JmpGT(R4, -5, 3),
+		JmpSGE32(R5, R6, 0), JmpSET(R7, 8, 2), JmpLT32(R8, R9, 4), JmpSLE(R1, -1, -7),
+		Call(MapLookup), Call(DynptrRead), Call(0x7ff), pseudoCall, Exit(),
+	}
+	instructions = append(instructions, raw...)
+
+	want := []uint64{}
+	for _, i := range instructions {
+		encoding, err := encodeInstruction(i)
+		if err != nil {
+			t.Fatalf("encodeInstruction(%v) error = %v", i, err)
+		}
+		want = append(want, encoding...)
+	}
+
+	insns, err := GenerateInsnArray(&pb.Program{
+		Functions: []*pb.Functions{{Instructions: instructions}},
+	})
+	if err != nil {
+		t.Fatalf("GenerateInsnArray() error = %v", err)
+	}
+	got, err := expandInsnArray(insns)
+	if err != nil {
+		t.Fatalf("expandInsnArray() error = %v", err)
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("poc expands to %d words, bytecode has %d\n%s", len(got), len(want), insns)
+	}
+	for index := range want {
+		if got[index] != want[index] {
+			t.Errorf("word %d: poc expands to %#016x, bytecode has %#016x", index, got[index], want[index])
+		}
+	}
+}
+
+func TestHelperMacroArg(t *testing.T) {
+	tests := []struct {
+		number int32
+		name   string
+		want   string
+	}{
+		{MapLookup, "BPF_FUNC_map_lookup_elem", "BPF_FUNC_map_lookup_elem"},
+		{0x7ff, "unknown", "2047"},
+		{42, "func#42", "42"},
+		{42, "BPF_FUNC_a-b", "42"},
+		{42, "1BPF_FUNC", "42"},
+		{42, "", "42"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := helperMacroArg(tc.number, tc.name); got != tc.want {
+				t.Errorf("helperMacroArg(%d, %q) = %q, want %q", tc.number, tc.name, got, tc.want)
+			}
+		})
+	}
+
+	// A call to an unknown helper has to give a poc whose macro arguments
+	// are all numbers or known constants.
+	insns, err := GenerateInsnArray(&pb.Program{
+		Functions: []*pb.Functions{{Instructions: []*pb.Instruction{Call(0x7ff), Exit()}}},
+	})
+	if err != nil {
+		t.Fatalf("GenerateInsnArray() error = %v", err)
+	}
+	if !strings.Contains(insns, "BPF_RAW_INSN(BPF_JMP | BPF_CALL, 0, 0, 0, 2047)") {
+		t.Errorf("GenerateInsnArray() = %s, want the raw helper number", insns)
+	}
+	if _, err := expandInsnArray(insns); err != nil {
+		t.Errorf("GenerateInsnArray() = %s, invalid: %v", insns, err)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+
+	protobuf "github.com/golang/protobuf/proto"
+)
+
+// InitRegisters returns a copy of `program` that starts by setting each
+// register in `values` to its value, in register order, so its inputs are
+// the same no matter what the runner leaves in them. This matters when
+// comparing the results of a program across kernels.
+//
+// The movs are pinned and prepended to the first function, the func infos
+// of the other functions are moved after them. Setting R1 overwrites the
+// context, and R10 can't be set at all.
+func InitRegisters(program *pb.Program, values map[pb.Reg]int32) (*pb.Program, error) {
+	if len(program.Functions) == 0 {
+		return nil, ErrEmptySequence
+	}
+	if _, ok := values[pb.Reg_R10]; ok {
+		return nil, ErrFramePointerWrite
+	}
+
+	preamble := []*pb.Instruction{}
+	for reg := pb.Reg_R0; reg < pb.Reg_R10; reg++ {
+		if value, ok := values[reg]; ok {
+			preamble = append(preamble, Pin(Mov64(reg, value)))
+		}
+	}
+
+	initialized := protobuf.Clone(program).(*pb.Program)
+	first := initialized.Functions[0]
+	first.Instructions = append(preamble, first.Instructions...)
+	for _, function := range initialized.Functions[1:] {
+		if function.FuncInfo != nil {
+			function.FuncInfo.InsnOff += int32(len(preamble))
+		}
+	}
+	return initialized, nil
+}
+
+// SaveContext returns a register that holds the context pointer right
+// before the instruction at `index`, like ContextRegister, for generators
+// that overwrite R1 and still want to use the context later, e.g. to pass
+// it to a helper or compare it. If the context is lost by then,
+// `instructions` are returned starting with a pinned copy of R1 to the
+// first callee saved register (R6-R9) that keeps it until `index`, which
+// then refers to the same instruction in the returned program.
+//
+// The last return value is false if no register can keep the context, in
+// which case `instructions` are returned as they are.
+func SaveContext(instructions []*pb.Instruction, index int) ([]*pb.Instruction, pb.Reg, bool) {
+	if reg, ok := ContextRegister(instructions, index); ok {
+		return instructions, reg, true
+	}
+	for _, reg := range []pb.Reg{pb.Reg_R6, pb.Reg_R7, pb.Reg_R8, pb.Reg_R9} {
+		saved := append([]*pb.Instruction{Pin(Mov64(reg, pb.Reg_R1))}, instructions...)
+		if ctx, ok := ContextRegister(saved, index+1); ok {
+			return saved, ctx, true
+		}
+	}
+	return instructions, pb.Reg_R1, false
+}
+
+// GuardR0 returns `instructions` starting with a pinned `r0 = 0` if R0 can
+// be read before anything writes to it, e.g. by random ALU instructions at
+// the start of the program or by an exit reached without setting a return
+// value, which the verifier rejects right away. Otherwise `instructions` are
+// returned as they are. Generators that don't track what they initialize
+// can run their output through this before loading it.
+//
+// `instructions` must be the first function of a program.
+func GuardR0(instructions []*pb.Instruction) []*pb.Instruction {
+	entry := RegisterSet(0).Add(pb.Reg_R1).Add(pb.Reg_R10)
+	defined, reached := mustRegisters(instructions, entry, definedAfter)
+	for index, i := range instructions {
+		if reached[index] && !defined[index].Contains(pb.Reg_R0) && readsRegister(i, pb.Reg_R0) {
+			return append([]*pb.Instruction{Pin(Mov64(pb.Reg_R0, int32(0)))}, instructions...)
+		}
+	}
+	return instructions
+}
@@ -0,0 +1,186 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	btfpb "buzzer/proto/btf_go_proto"
+	pb "buzzer/proto/ebpf_go_proto"
+	"errors"
+	"testing"
+
+	protobuf "github.com/golang/protobuf/proto"
+)
+
+func TestInitRegisters(t *testing.T) {
+	program := &pb.Program{
+		Functions: []*pb.Functions{
+			{
+				Instructions: []*pb.Instruction{Call(1), Exit()},
+				FuncInfo:     &btfpb.FuncInfo{InsnOff: 0, TypeId: 2},
+			},
+			{
+				Instructions: []*pb.Instruction{Mov64(R0, 0), Exit()},
+				FuncInfo:     &btfpb.FuncInfo{InsnOff: 2, TypeId: 3},
+			},
+		},
+	}
+
+	got, err := InitRegisters(program, map[pb.Reg]int32{R7: -1, R2: 5})
+	if err != nil {
+		t.Fatalf("InitRegisters() unexpected error: %v", err)
+	}
+	want := &pb.Program{
+		Functions: []*pb.Functions{
+			{
+				Instructions: []*pb.Instruction{Mov64(R2, 5), Mov64(R7, -1), Call(1), Exit()},
+				FuncInfo:     &btfpb.FuncInfo{InsnOff: 0, TypeId: 2},
+			},
+			{
+				Instructions: []*pb.Instruction{Mov64(R0, 0), Exit()},
+				FuncInfo:     &btfpb.FuncInfo{InsnOff: 4, TypeId: 3},
+			},
+		},
+	}
+	if diff := ProgramDiff(got, want); diff != "" {
+		t.Errorf("InitRegisters() mismatch:\n%s", diff)
+	}
+	for index := 0; index < 2; index++ {
+		if !got.Functions[0].Instructions[index].Pinned {
+			t.Errorf("InitRegisters() instruction %d is not pinned", index)
+		}
+	}
+	if len(program.Functions[0].Instructions) != 2 || program.Functions[1].FuncInfo.InsnOff != 2 {
+		t.Errorf("InitRegisters() modified the input program")
+	}
+
+	if _, err := InitRegisters(program, map[pb.Reg]int32{R10: 0}); !errors.Is(err, ErrFramePointerWrite) {
+		t.Errorf("InitRegisters() of R10 = %v, want %v", err, ErrFramePointerWrite)
+	}
+}
+
+func TestGuardR0(t *testing.T) {
+	tests := []struct {
+		testName     string
+		instructions []*pb.Instruction
+		wantGuard    bool
+	}{
+		{
+			testName:     "R0 read by an ALU instruction",
+			instructions: []*pb.Instruction{Add64(R0, 1), Exit()},
+			wantGuard:    true,
+		},
+		{
+			testName:     "R0 written on one path",
+			instructions: []*pb.Instruction{JmpEQ(R1, 0, 1), Mov64(R0, 0), Exit()},
+			wantGuard:    true,
+		},
+		{
+			testName:     "R0 written first",
+			instructions: []*pb.Instruction{Mov64(R0, 1), Add64(R0, 1), Exit()},
+			wantGuard:    false,
+		},
+		{
+			testName:     "R0 written by a call",
+			instructions: []*pb.Instruction{Call(GetPrandomU32), Exit()},
+			wantGuard:    false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got := GuardR0(tc.instructions)
+			if !tc.wantGuard {
+				if len(got) != len(tc.instructions) || got[0] != tc.instructions[0] {
+					t.Fatalf("GuardR0() modified a program that writes R0 first")
+				}
+				return
+			}
+			if len(got) != len(tc.instructions)+1 {
+				t.Fatalf("GuardR0() returned %d instructions, want %d", len(got), len(tc.instructions)+1)
+			}
+			if want := Pin(Mov64(R0, 0)); !protobuf.Equal(got[0], want) {
+				t.Errorf("GuardR0() first instruction = %v, want %v", got[0], want)
+			}
+			if err := Validate(got); err != nil {
+				t.Errorf("Validate() of the guarded program = %v", err)
+			}
+		})
+	}
+}
+
+func TestSaveContext(t *testing.T) {
+	tests := []struct {
+		testName     string
+		instructions []*pb.Instruction
+		wantSaved    bool
+		wantReg      pb.Reg
+		wantOk       bool
+	}{
+		{
+			testName:     "Context still in R1",
+			instructions: []*pb.Instruction{Mov64(R2, 0), Mov64(R0, 0), Exit()},
+			wantReg:      R1,
+			wantOk:       true,
+		},
+		{
+			testName:     "Context already copied",
+			instructions: []*pb.Instruction{Mov64(R7, R1), Mov64(R1, 0), Mov64(R0, 0), Exit()},
+			wantReg:      R7,
+			wantOk:       true,
+		},
+		{
+			testName:     "R1 overwritten",
+			instructions: []*pb.Instruction{Mov64(R1, 0), Mov64(R0, 0), Exit()},
+			wantSaved:    true,
+			wantReg:      R6,
+			wantOk:       true,
+		},
+		{
+			testName:     "R1 and R6 overwritten",
+			instructions: []*pb.Instruction{Mov64(R1, 0), Mov64(R6, 0), Mov64(R0, 0), Exit()},
+			wantSaved:    true,
+			wantReg:      R7,
+			wantOk:       true,
+		},
+		{
+			testName:     "Every callee saved register overwritten",
+			instructions: []*pb.Instruction{Mov64(R1, 0), Mov64(R6, 0), Mov64(R7, 0), Mov64(R8, 0), Mov64(R9, 0), Mov64(R0, 0), Exit()},
+			wantReg:      R1,
+			wantOk:       false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			index := len(tc.instructions) - 1
+			got, reg, ok := SaveContext(tc.instructions, index)
+			if reg != tc.wantReg || ok != tc.wantOk {
+				t.Errorf("SaveContext() = %v, %v, want %v, %v", reg, ok, tc.wantReg, tc.wantOk)
+			}
+			if !tc.wantSaved {
+				if len(got) != len(tc.instructions) {
+					t.Errorf("SaveContext() added %d instructions, want none", len(got)-len(tc.instructions))
+				}
+				return
+			}
+			if want := Pin(Mov64(tc.wantReg, R1)); len(got) != len(tc.instructions)+1 || !protobuf.Equal(got[0], want) {
+				t.Fatalf("SaveContext() = %v, want it to start with %v", got, want)
+			}
+			if ctx, ok := ContextRegister(got, index+1); !ok || ctx != reg {
+				t.Errorf("ContextRegister() of the saved program = %v, %v, want %v, true", ctx, ok, reg)
+			}
+		})
+	}
+}
@@ -0,0 +1,393 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+	"math"
+)
+
+// rangeWideningVisits is how many times the range of an instruction can
+// change before its bounds are widened to infinity, which makes the
+// analysis of loops terminate.
+const rangeWideningVisits = 8
+
+// valueRange is an interval of signed 64-bit values, empty if min > max.
+type valueRange struct {
+	min int64
+	max int64
+}
+
+var fullRange = valueRange{math.MinInt64, math.MaxInt64}
+
+func constantRange(value int64) valueRange {
+	return valueRange{value, value}
+}
+
+func (r valueRange) isEmpty() bool {
+	return r.min > r.max
+}
+
+func (r valueRange) isConstant() bool {
+	return r.min == r.max
+}
+
+func (r valueRange) join(other valueRange) valueRange {
+	return valueRange{min(r.min, other.min), max(r.max, other.max)}
+}
+
+// widen returns `next`, with the bounds that grew since `prev` pushed to
+// infinity.
+func (r valueRange) widen(next valueRange) valueRange {
+	if next.min < r.min {
+		next.min = math.MinInt64
+	}
+	if next.max > r.max {
+		next.max = math.MaxInt64
+	}
+	return next
+}
+
+// addRanges returns the range of a + b, or fullRange if it can overflow.
+func addRanges(a, b valueRange) valueRange {
+	if (b.min < 0 && a.min < math.MinInt64-b.min) || (b.max > 0 && a.max > math.MaxInt64-b.max) {
+		return fullRange
+	}
+	return valueRange{a.min + b.min, a.max + b.max}
+}
+
+// subRanges returns the range of a - b, or fullRange if it can overflow.
+func subRanges(a, b valueRange) valueRange {
+	if b.min == math.MinInt64 {
+		return fullRange
+	}
+	return addRanges(a, valueRange{-b.max, -b.min})
+}
+
+// rangeState holds the range of every register, registers that are not
+// initialized or hold a pointer have fullRange.
+type rangeState [pb.Reg_R10 + 1]valueRange
+
+func newRangeState() rangeState {
+	var s rangeState
+	for reg := range s {
+		s[reg] = fullRange
+	}
+	return s
+}
+
+func (s *rangeState) clobberCallerSaved() {
+	for reg := pb.Reg_R0; reg <= pb.Reg_R5; reg++ {
+		s[reg] = fullRange
+	}
+}
+
+// aluRange returns the range of the destination of `op` given the ranges
+// of its operands. Operations that can't be tracked give fullRange, or the
+// whole 32-bit range for 32-bit operations.
+func aluRange(op *pb.AluOpcode, dst, src valueRange) valueRange {
+	is64 := op.InstructionClass == pb.InsClass_InsClassAlu64
+	result := fullRange
+	switch {
+	case dst.isConstant() && src.isConstant():
+		if value, ok := evalAlu(op.OperationCode, is64, dst.min, src.min); ok {
+			return constantRange(value)
+		}
+	case op.OperationCode == pb.AluOperationCode_AluMov:
+		result = src
+	case op.OperationCode == pb.AluOperationCode_AluAdd:
+		result = addRanges(dst, src)
+	case op.OperationCode == pb.AluOperationCode_AluSub:
+		result = subRanges(dst, src)
+	case op.OperationCode == pb.AluOperationCode_AluAnd && src.isConstant() && src.min >= 0:
+		result = valueRange{0, src.min}
+		if dst.min >= 0 {
+			result.max = min(result.max, dst.max)
+		}
+	case op.OperationCode == pb.AluOperationCode_AluRsh && src.isConstant() && is64:
+		shift := uint64(src.min) & 63
+		if dst.min >= 0 {
+			result = valueRange{dst.min >> shift, dst.max >> shift}
+		} else if shift > 0 {
+			result = valueRange{0, int64(uint64(math.MaxUint64) >> shift)}
+		}
+	}
+	if !is64 && (result.min < 0 || result.max > math.MaxUint32) {
+		// 32-bit operations zero extend their result.
+		result = valueRange{0, math.MaxUint32}
+	}
+	return result
+}
+
+// rangeAfter returns the ranges of the registers after `i` runs, for
+// anything but jumps.
+func rangeAfter(i *pb.Instruction, s rangeState) rangeState {
+	switch c := i.Opcode.(type) {
+	case *pb.Instruction_AluOpcode:
+		if i.Offset != 0 {
+			// Signed division and sign extending moves.
+			s[i.DstReg] = fullRange
+			break
+		}
+		src := constantRange(int64(i.Immediate))
+		if c.AluOpcode.Source == pb.SrcOperand_RegSrc {
+			src = s[i.SrcReg]
+		}
+		s[i.DstReg] = aluRange(c.AluOpcode, s[i.DstReg], src)
+	case *pb.Instruction_MemOpcode:
+		op := c.MemOpcode
+		switch {
+		case isLdImm64(i):
+			p, ok := i.PseudoInstruction.(*pb.Instruction_PseudoValue)
+			s[i.DstReg] = fullRange
+			if ok && i.SrcReg == pb.Reg_R0 {
+				s[i.DstReg] = constantRange(int64(uint64(uint32(i.Immediate)) | uint64(p.PseudoValue.Immediate)<<32))
+			}
+		case op.InstructionClass == pb.InsClass_InsClassLd:
+			s.clobberCallerSaved()
+		case op.InstructionClass == pb.InsClass_InsClassLdx && op.Mode == pb.StLdMode_StLdModeMEM:
+			switch op.Size {
+			case pb.StLdSize_StLdSizeB:
+				s[i.DstReg] = valueRange{0, math.MaxUint8}
+			case pb.StLdSize_StLdSizeH:
+				s[i.DstReg] = valueRange{0, math.MaxUint16}
+			case pb.StLdSize_StLdSizeW:
+				s[i.DstReg] = valueRange{0, math.MaxUint32}
+			default:
+				s[i.DstReg] = fullRange
+			}
+		default:
+			for _, reg := range registerDefs(i) {
+				s[reg] = fullRange
+			}
+		}
+	case *pb.Instruction_JmpOpcode:
+		if isCall(i) {
+			s.clobberCallerSaved()
+		}
+	}
+	return s
+}
+
+// refineRange returns the part of `r` for which `r op value` is true.
+func refineRange(op pb.JmpOperationCode, r valueRange, value int64) valueRange {
+	// Unsigned comparisons are only tracked when both sides are known to
+	// be non negative, where they agree with the signed ones.
+	unsigned := r.min >= 0 && value >= 0
+	switch op {
+	case pb.JmpOperationCode_JmpJEQ:
+		if value < r.min || value > r.max {
+			return valueRange{1, 0}
+		}
+		return constantRange(value)
+	case pb.JmpOperationCode_JmpJNE:
+		switch {
+		case r.isConstant() && r.min == value:
+			return valueRange{1, 0}
+		case r.min == value:
+			r.min++
+		case r.max == value:
+			r.max--
+		}
+	case pb.JmpOperationCode_JmpJSGT:
+		if value == math.MaxInt64 {
+			return valueRange{1, 0}
+		}
+		r.min = max(r.min, value+1)
+	case pb.JmpOperationCode_JmpJSGE:
+		r.min = max(r.min, value)
+	case pb.JmpOperationCode_JmpJSLT:
+		if value == math.MinInt64 {
+			return valueRange{1, 0}
+		}
+		r.max = min(r.max, value-1)
+	case pb.JmpOperationCode_JmpJSLE:
+		r.max = min(r.max, value)
+	case pb.JmpOperationCode_JmpJGT:
+		if unsigned {
+			return refineRange(pb.JmpOperationCode_JmpJSGT, r, value)
+		}
+	case pb.JmpOperationCode_JmpJGE:
+		if unsigned {
+			return refineRange(pb.JmpOperationCode_JmpJSGE, r, value)
+		}
+	case pb.JmpOperationCode_JmpJLT:
+		if unsigned {
+			return refineRange(pb.JmpOperationCode_JmpJSLT, r, value)
+		}
+	case pb.JmpOperationCode_JmpJLE:
+		if unsigned {
+			return refineRange(pb.JmpOperationCode_JmpJSLE, r, value)
+		}
+	}
+	return r
+}
+
+// negatedJumps maps each conditional jump to the one that is true when it
+// isn't, JSET has no such counterpart.
+var negatedJumps = map[pb.JmpOperationCode]pb.JmpOperationCode{
+	pb.JmpOperationCode_JmpJEQ:  pb.JmpOperationCode_JmpJNE,
+	pb.JmpOperationCode_JmpJNE:  pb.JmpOperationCode_JmpJEQ,
+	pb.JmpOperationCode_JmpJGT:  pb.JmpOperationCode_JmpJLE,
+	pb.JmpOperationCode_JmpJLE:  pb.JmpOperationCode_JmpJGT,
+	pb.JmpOperationCode_JmpJGE:  pb.JmpOperationCode_JmpJLT,
+	pb.JmpOperationCode_JmpJLT:  pb.JmpOperationCode_JmpJGE,
+	pb.JmpOperationCode_JmpJSGT: pb.JmpOperationCode_JmpJSLE,
+	pb.JmpOperationCode_JmpJSLE: pb.JmpOperationCode_JmpJSGT,
+	pb.JmpOperationCode_JmpJSGE: pb.JmpOperationCode_JmpJSLT,
+	pb.JmpOperationCode_JmpJSLT: pb.JmpOperationCode_JmpJSGE,
+}
+
+// branchRanges returns the ranges of the registers when the conditional
+// jump `i` is taken and when it isn't. Only 64-bit comparisons against an
+// immediate or a register with a known value narrow the ranges, a branch
+// that can't be followed gets a state with an empty range.
+func branchRanges(i *pb.Instruction, s rangeState) (taken rangeState, notTaken rangeState) {
+	taken, notTaken = s, s
+	op := i.Opcode.(*pb.Instruction_JmpOpcode).JmpOpcode
+	negated, ok := negatedJumps[op.OperationCode]
+	if !ok || op.InstructionClass != pb.InsClass_InsClassJmp {
+		return taken, notTaken
+	}
+	value := int64(i.Immediate)
+	if op.Source == pb.SrcOperand_RegSrc {
+		if !s[i.SrcReg].isConstant() {
+			return taken, notTaken
+		}
+		value = s[i.SrcReg].min
+	}
+	taken[i.DstReg] = refineRange(op.OperationCode, s[i.DstReg], value)
+	notTaken[i.DstReg] = refineRange(negated, s[i.DstReg], value)
+	return taken, notTaken
+}
+
+// RangeAt returns the signed range of values the scalar in `reg` can hold
+// right before the instruction at `index` runs, over every path from the
+// start of `instructions`. It is a coarse version of the verifier's bounds
+// tracking: constants are propagated through moves, additions,
+// subtractions, masks and shifts, loads are bounded by their size and
+// 64-bit conditional jumps against known values narrow the range on each
+// of their branches.
+//
+// The last return value is false if nothing is known about `reg`, because
+// it isn't a bounded scalar or because `index` can't be reached.
+func RangeAt(instructions []*pb.Instruction, index int, reg pb.Reg) (int64, int64, bool) {
+	if index < 0 || index >= len(instructions) || reg < pb.Reg_R0 || reg > pb.Reg_R10 {
+		return math.MinInt64, math.MaxInt64, false
+	}
+
+	before, reached := rangeStates(instructions)
+	r := before[index][reg]
+	if !reached[index] || r == fullRange {
+		return math.MinInt64, math.MaxInt64, false
+	}
+	return r.min, r.max, true
+}
+
+// rangeStates runs the analysis behind RangeAt and returns the ranges of the
+// registers before every instruction, along with which instructions can be
+// reached. The branch of a conditional jump the ranges rule out is never
+// followed.
+func rangeStates(instructions []*pb.Instruction) ([]rangeState, []bool) {
+	if len(instructions) == 0 {
+		return nil, nil
+	}
+
+	targets := jumpTargets(instructions)
+	reached := make([]bool, len(instructions))
+	visits := make([]int, len(instructions))
+	before := make([]rangeState, len(instructions))
+	reached[0] = true
+	before[0] = newRangeState()
+
+	pending := []int{0}
+	for len(pending) > 0 {
+		current := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		inst := instructions[current]
+		type edge struct {
+			next  int
+			state rangeState
+		}
+		edges := []edge{}
+		switch {
+		case isExit(inst):
+		case isConditionalJump(inst):
+			taken, notTaken := branchRanges(inst, before[current])
+			if targets[current] >= 0 {
+				edges = append(edges, edge{targets[current], taken})
+			}
+			edges = append(edges, edge{current + 1, notTaken})
+		case isJump(inst):
+			if targets[current] >= 0 {
+				edges = append(edges, edge{targets[current], before[current]})
+			}
+		default:
+			edges = append(edges, edge{current + 1, rangeAfter(inst, before[current])})
+		}
+
+		for _, e := range edges {
+			// Only the register a jump compares can become empty,
+			// on a branch that is never followed.
+			if e.next >= len(instructions) || e.state[inst.DstReg].isEmpty() {
+				continue
+			}
+			if !reached[e.next] {
+				reached[e.next] = true
+				before[e.next] = e.state
+				pending = append(pending, e.next)
+				continue
+			}
+			merged := before[e.next]
+			for r := range merged {
+				joined := merged[r].join(e.state[r])
+				if visits[e.next] >= rangeWideningVisits {
+					joined = merged[r].widen(joined)
+				}
+				merged[r] = joined
+			}
+			if merged == before[e.next] {
+				continue
+			}
+			visits[e.next]++
+			before[e.next] = merged
+			pending = append(pending, e.next)
+		}
+	}
+
+	return before, reached
+}
+
+// followedEdges returns a function telling whether control can go from the
+// instruction at `from` to the one at `to` of `instructions`, according to
+// the ranges of RangeAt. Like the verifier, it doesn't follow the branch of
+// a conditional jump whose outcome is known, e.g. the jump of
+// `r2 = 0; if r2 != 0 goto +1`.
+func followedEdges(instructions []*pb.Instruction) func(from, to int) bool {
+	before, reached := rangeStates(instructions)
+	targets := jumpTargets(instructions)
+	return func(from, to int) bool {
+		inst := instructions[from]
+		if !reached[from] || !isConditionalJump(inst) {
+			return true
+		}
+		taken, notTaken := branchRanges(inst, before[from])
+		if to == targets[from] && !taken[inst.DstReg].isEmpty() {
+			return true
+		}
+		return to == from+1 && !notTaken[inst.DstReg].isEmpty()
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+	"math"
+	"testing"
+)
+
+func TestRangeAt(t *testing.T) {
+	tests := []struct {
+		testName     string
+		instructions []*pb.Instruction
+		index        int
+		reg          pb.Reg
+		wantMin      int64
+		wantMax      int64
+		wantKnown    bool
+	}{
+		{
+			testName:     "Constant",
+			instructions: []*pb.Instruction{Mov64(R2, 5), Add64(R2, 3), Exit()},
+			index:        2,
+			reg:          R2,
+			wantMin:      8,
+			wantMax:      8,
+			wantKnown:    true,
+		},
+		{
+			testName:     "Byte load",
+			instructions: []*pb.Instruction{LdB(R2, R1, 0), Add64(R2, -10), Exit()},
+			index:        2,
+			reg:          R2,
+			wantMin:      -10,
+			wantMax:      245,
+			wantKnown:    true,
+		},
+		{
+			testName: "Narrowed by unsigned jump",
+			instructions: []*pb.Instruction{
+				LdW(R2, R1, 0),
+				JmpGT(R2, 16, 1),
+				Mov64(R0, R2),
+				Exit(),
+			},
+			index:     2,
+			reg:       R2,
+			wantMin:   0,
+			wantMax:   16,
+			wantKnown: true,
+		},
+		{
+			testName: "Narrowed on the taken branch",
+			instructions: []*pb.Instruction{
+				LdDW(R2, R1, 0),
+				JmpSGE(R2, 100, 1),
+				Exit(),
+				Mov64(R0, R2),
+				Exit(),
+			},
+			index:     3,
+			reg:       R2,
+			wantMin:   100,
+			wantMax:   math.MaxInt64,
+			wantKnown: true,
+		},
+		{
+			testName: "Joined at the merge point",
+			instructions: []*pb.Instruction{
+				LdDW(R3, R1, 0),
+				Mov64(R2, 1),
+				JmpEQ(R3, 0, 1),
+				Mov64(R2, 7),
+				Mov64(R0, R2),
+				Exit(),
+			},
+			index:     4,
+			reg:       R2,
+			wantMin:   1,
+			wantMax:   7,
+			wantKnown: true,
+		},
+		{
+			testName:     "Masked",
+			instructions: []*pb.Instruction{LdDW(R2, R1, 0), And64(R2, 0xff), Rsh64(R2, 4), Exit()},
+			index:        3,
+			reg:          R2,
+			wantMin:      0,
+			wantMax:      0xf,
+			wantKnown:    true,
+		},
+		{
+			testName: "Loop counter",
+			instructions: []*pb.Instruction{
+				Mov64(R2, 0),
+				Add64(R2, 1),
+				JmpLT(R2, 5, -2),
+				Mov64(R0, R2),
+				Exit(),
+			},
+			index:     3,
+			reg:       R2,
+			wantMin:   5,
+			wantMax:   5,
+			wantKnown: true,
+		},
+		{
+			// The counter is widened before the loop is done, which
+			// makes the addition overflow.
+			testName: "Long loop",
+			instructions: []*pb.Instruction{
+				Mov64(R2, 0),
+				Add64(R2, 1),
+				JmpLT(R2, 1000, -2),
+				Mov64(R0, R2),
+				Exit(),
+			},
+			index:     3,
+			reg:       R2,
+			wantMin:   math.MinInt64,
+			wantMax:   math.MaxInt64,
+			wantKnown: false,
+		},
+		{
+			testName:     "Unknown after a call",
+			instructions: []*pb.Instruction{Mov64(R2, 1), Call(MapLookup), Exit()},
+			index:        2,
+			reg:          R2,
+			wantMin:      math.MinInt64,
+			wantMax:      math.MaxInt64,
+			wantKnown:    false,
+		},
+		{
+			testName:     "Unreachable",
+			instructions: []*pb.Instruction{Mov64(R2, 1), Exit(), Mov64(R0, R2), Exit()},
+			index:        2,
+			reg:          R2,
+			wantMin:      math.MinInt64,
+			wantMax:      math.MaxInt64,
+			wantKnown:    false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			gotMin, gotMax, gotKnown := RangeAt(tc.instructions, tc.index, tc.reg)
+			if gotMin != tc.wantMin || gotMax != tc.wantMax || gotKnown != tc.wantKnown {
+				t.Errorf("RangeAt() = (%d, %d, %v), want (%d, %d, %v)", gotMin, gotMax, gotKnown, tc.wantMin, tc.wantMax, tc.wantKnown)
+			}
+		})
+	}
+}
@@ -16,29 +16,34 @@ package ebpf
 
 import (
 	pb "buzzer/proto/ebpf_go_proto"
+	"fmt"
 )
 
 func newStoreOperation[T Src](size pb.StLdSize, dst pb.Reg, src T, offset int16) *pb.Instruction {
 	var srcReg pb.Reg
-	var imm int32
+	var imm int64
 	var class pb.InsClass
 	mode := pb.StLdMode_StLdModeMEM
-	switch any(src).(type) {
+	switch v := any(src).(type) {
 	case pb.Reg:
-		srcReg = any(src).(pb.Reg)
+		srcReg = v
 		imm = 0
 		class = pb.InsClass_InsClassStx
 	case int:
 		srcReg = pb.Reg_R0
-		intImm := any(src).(int)
-		imm = int32(intImm)
+		imm = int64(v)
+		class = pb.InsClass_InsClassSt
+	case int64:
+		srcReg = pb.Reg_R0
+		imm = v
 		class = pb.InsClass_InsClassSt
 	default:
 		srcReg = pb.Reg_R0
-		imm = any(src).(int32)
+		imm = int64(any(src).(int32))
 		class = pb.InsClass_InsClassSt
 	}
-	return &pb.Instruction{
+
+	i := &pb.Instruction{
 		Opcode: &pb.Instruction_MemOpcode{
 			MemOpcode: &pb.MemOpcode{
 				Mode:             mode,
@@ -51,14 +56,25 @@ func newStoreOperation[T Src](size pb.StLdSize, dst pb.Reg, src T, offset int16)
 		// Oh protobuf why don't you have int16 support?, need to cast
 		// this to int32 to make golang happy.
 		Offset:    int32(offset),
-		Immediate: imm,
+		Immediate: int32(imm),
 		PseudoInstruction: &pb.Instruction_Empty{
 			Empty: &pb.Empty{},
 		},
 	}
+
+	// BPF_ST only has room for a 32-bit immediate, that the kernel sign
+	// extends to the size of the store. Anything wider is flagged so it is
+	// not silently truncated, Lint reports it with ErrStoreImmediateTooWide.
+	// The instruction still takes a single slot.
+	i.ImmediateTruncated = imm != int64(int32(imm))
+	return withOrigin(i)
 }
 
-// StDW Stores 8 byte data from `src` into `dst`
+// StDW Stores 8 byte data from `src` into `dst`.
+//
+// If `src` is an immediate it is stored sign extended from 32 bits, BPF_ST
+// can't store a full 64-bit value. To do that load it into a register with
+// Mov64 first and store the register instead.
 func StDW[T Src](dst pb.Reg, src T, offset int16) *pb.Instruction {
 	return newStoreOperation(pb.StLdSize_StLdSizeDW, dst, src, offset)
 }
@@ -80,7 +96,7 @@ func StB[T Src](dst pb.Reg, src T, offset int16) *pb.Instruction {
 
 // "Standard" Load operations always take as a source a register.
 func newLoadOperation(size pb.StLdSize, dst pb.Reg, src pb.Reg, offset int16) *pb.Instruction {
-	return &pb.Instruction{
+	return withOrigin(&pb.Instruction{
 		Opcode: &pb.Instruction_MemOpcode{
 			MemOpcode: &pb.MemOpcode{
 				Mode:             pb.StLdMode_StLdModeMEM,
@@ -97,7 +113,7 @@ func newLoadOperation(size pb.StLdSize, dst pb.Reg, src pb.Reg, offset int16) *p
 		PseudoInstruction: &pb.Instruction_Empty{
 			Empty: &pb.Empty{},
 		},
-	}
+	})
 }
 
 func newLoadImmOperation(size pb.StLdSize, dst pb.Reg, src pb.Reg, offset int16, imm int32, pseudoIns *pb.Instruction) *pb.Instruction {
@@ -127,7 +143,7 @@ func newLoadImmOperation(size pb.StLdSize, dst pb.Reg, src pb.Reg, offset int16,
 		}
 	}
 
-	return ret
+	return withOrigin(ret)
 }
 
 // LdDW Stores 8 byte data from `src` into `dst`
@@ -170,12 +186,34 @@ func LdMapByFd(dst pb.Reg, fd int) *pb.Instruction {
 	return newLoadImmOperation(pb.StLdSize_StLdSizeDW, dst, PseudoMapFD, UnusedField, int32(fd), pseudoIns)
 }
 
+// LdMapValue loads into `dst` a pointer to `offset` bytes into the first
+// value of the map `fd`. This is how global variables are accessed.
+func LdMapValue(dst pb.Reg, fd int, offset int32) *pb.Instruction {
+	pseudoIns := &pb.Instruction{
+		Opcode: &pb.Instruction_MemOpcode{
+			MemOpcode: &pb.MemOpcode{
+				Mode:             0,
+				Size:             0,
+				InstructionClass: 0,
+			},
+		},
+		DstReg:    0,
+		SrcReg:    0,
+		Offset:    0,
+		Immediate: offset,
+		PseudoInstruction: &pb.Instruction_Empty{
+			Empty: &pb.Empty{},
+		},
+	}
+	return newLoadImmOperation(pb.StLdSize_StLdSizeDW, dst, PseudoMapValue, UnusedField, int32(fd), pseudoIns)
+}
+
 func newAtomicInstruction(dst, src pb.Reg, size pb.StLdSize, offset int16, operation int32) *pb.Instruction {
 	class := pb.InsClass_InsClassStx
 
 	// This if is needed because the underlying interface of
 	// PseudoInstruction is not exported outside of the proto.
-	return &pb.Instruction{
+	return withOrigin(&pb.Instruction{
 		Opcode: &pb.Instruction_MemOpcode{
 			MemOpcode: &pb.MemOpcode{
 				Mode:             pb.StLdMode_StLdModeATOMIC,
@@ -192,7 +230,7 @@ func newAtomicInstruction(dst, src pb.Reg, size pb.StLdSize, offset int16, opera
 		PseudoInstruction: &pb.Instruction_Empty{
 			Empty: &pb.Empty{},
 		},
-	}
+	})
 }
 
 func MemAdd64(dst, src pb.Reg, offset int16) *pb.Instruction {
@@ -226,3 +264,92 @@ func MemXor64(dst, src pb.Reg, offset int16) *pb.Instruction {
 func MemXor(dst, src pb.Reg, offset int16) *pb.Instruction {
 	return newAtomicInstruction(dst, src, pb.StLdSize_StLdSizeW, offset, int32(pb.AluOperationCode_AluXor))
 }
+
+// XAdd64 emits the legacy `BPF_STX | BPF_XADD | BPF_DW` instruction:
+// *(u64 *)(dst + offset) += src.
+//
+// Before kernel 5.12 this was the only atomic operation. BPF_ATOMIC later
+// reused the BPF_XADD mode value and moved the operation into the immediate,
+// where BPF_ADD is 0, so the legacy form encodes exactly like MemAdd64 with
+// the fetch flag unset. Use this when targeting old kernels to make the
+// intent explicit.
+func XAdd64(dst, src pb.Reg, offset int16) *pb.Instruction {
+	return newAtomicInstruction(dst, src, pb.StLdSize_StLdSizeDW, offset, UnusedField)
+}
+
+// XAdd emits the legacy `BPF_STX | BPF_XADD | BPF_W` instruction:
+// *(u32 *)(dst + offset) += src. See XAdd64.
+func XAdd(dst, src pb.Reg, offset int16) *pb.Instruction {
+	return newAtomicInstruction(dst, src, pb.StLdSize_StLdSizeW, offset, UnusedField)
+}
+
+// MaxStackSize is the size in bytes of the stack available to an eBPF program.
+const MaxStackSize = 512
+
+// StackScratch zeroes the `bytes` bytes of stack in [R10 - bytes, R10) and
+// leaves a pointer to the start of the region in R9, which is also returned
+// for convenience.
+//
+// The verifier rejects reads of uninitialized stack, so programs that pass
+// stack buffers to helpers usually need this prologue. Stores are naturally
+// aligned because the verifier enforces strict alignment on the stack.
+func StackScratch(bytes int) ([]*pb.Instruction, pb.Reg, error) {
+	if bytes <= 0 || bytes > MaxStackSize {
+		return nil, R9, fmt.Errorf("Invalid stack scratch size %d, must be in [1, %d]", bytes, MaxStackSize)
+	}
+
+	instructions := []*pb.Instruction{}
+	for offset := -bytes; offset < 0; {
+		switch {
+		case offset%8 == 0 && offset+8 <= 0:
+			instructions = append(instructions, StDW(R10, 0, int16(offset)))
+			offset += 8
+		case offset%4 == 0 && offset+4 <= 0:
+			instructions = append(instructions, StW(R10, 0, int16(offset)))
+			offset += 4
+		case offset%2 == 0 && offset+2 <= 0:
+			instructions = append(instructions, StH(R10, 0, int16(offset)))
+			offset += 2
+		default:
+			instructions = append(instructions, StB(R10, 0, int16(offset)))
+			offset += 1
+		}
+	}
+	instructions = append(instructions, Mov64(R9, R10), Add64(R9, int32(-bytes)))
+
+	sequence, err := InstructionSequence(instructions...)
+	return sequence, R9, err
+}
+
+// WithSpilledPointers wraps `call`, usually a helper call and the setup of
+// its arguments, so the registers in `regs` are spilled to the stack before
+// it and filled back after it. This keeps pointers held in R1-R5 alive
+// across the call, which clobbers them.
+//
+// The registers are spilled to the 8 byte slots right below the `depth`
+// bytes of stack the caller already uses, or below the stack `call` uses
+// itself if that is deeper. Spilling no deeper than needed leaves room for
+// the frames of bpf to bpf calls and callbacks, which add up. R0 can't be
+// spilled as it holds the return value of the call.
+func WithSpilledPointers(regs []pb.Reg, call []*pb.Instruction, depth int) ([]*pb.Instruction, error) {
+	if depth < 0 {
+		return nil, fmt.Errorf("Invalid stack depth %d", depth)
+	}
+	base := (max(depth, stackDepth(call)) + 7) / 8 * 8
+	if base+len(regs)*8 > MaxStackSize {
+		return nil, fmt.Errorf("Can't spill %d registers below %d bytes of stack, only %d fit", len(regs), base, (MaxStackSize-base)/8)
+	}
+	spills := []*pb.Instruction{}
+	fills := []*pb.Instruction{}
+	for index, reg := range regs {
+		if reg == R0 || reg == R10 {
+			return nil, fmt.Errorf("Can't spill %v around a call", reg)
+		}
+		offset := int16(-base - 8*(index+1))
+		spills = append(spills, StDW(R10, reg, offset))
+		fills = append(fills, LdDW(reg, R10, offset))
+	}
+
+	instructions := append(spills, call...)
+	return InstructionSequence(append(instructions, fills...)...)
+}
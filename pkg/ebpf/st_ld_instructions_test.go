@@ -186,6 +186,30 @@ func TestMemoryInstructionCorrectEncoding(t *testing.T) {
 			wantImm:              0,
 			wantEncoding:         []uint64{0xfff80971},
 		},
+		{
+			testName:             "Encoding XAdd64 Instruction",
+			instruction:          XAdd64(testDstReg, testSrcReg, testOffset),
+			wantMode:             pb.StLdMode_StLdModeATOMIC,
+			wantSize:             pb.StLdSize_StLdSizeDW,
+			wantInstructionClass: pb.InsClass_InsClassStx,
+			wantOffset:           testOffset,
+			wantDstReg:           testDstReg,
+			wantSrcReg:           testSrcReg,
+			wantImm:              0,
+			wantEncoding:         []uint64{0xfff809db},
+		},
+		{
+			testName:             "Encoding XAdd Instruction",
+			instruction:          XAdd(testDstReg, testSrcReg, testOffset),
+			wantMode:             pb.StLdMode_StLdModeATOMIC,
+			wantSize:             pb.StLdSize_StLdSizeW,
+			wantInstructionClass: pb.InsClass_InsClassStx,
+			wantOffset:           testOffset,
+			wantDstReg:           testDstReg,
+			wantSrcReg:           testSrcReg,
+			wantImm:              0,
+			wantEncoding:         []uint64{0xfff809c3},
+		},
 		{
 			testName:             "Encoding LdMapByFd Instruction",
 			instruction:          LdMapByFd(testDstReg, 42),
@@ -254,3 +278,232 @@ func TestMemoryInstructionCorrectEncoding(t *testing.T) {
 		})
 	}
 }
+
+func TestStackScratch(t *testing.T) {
+	tests := []struct {
+		testName  string
+		bytes     int
+		want      []*pb.Instruction
+		wantError bool
+	}{
+		{
+			testName: "Aligned scratch region",
+			bytes:    16,
+			want: []*pb.Instruction{
+				StDW(R10, 0, -16),
+				StDW(R10, 0, -8),
+				Mov64(R9, R10),
+				Add64(R9, -16),
+			},
+		},
+		{
+			testName: "Unaligned scratch region",
+			bytes:    15,
+			want: []*pb.Instruction{
+				StB(R10, 0, -15),
+				StH(R10, 0, -14),
+				StW(R10, 0, -12),
+				StDW(R10, 0, -8),
+				Mov64(R9, R10),
+				Add64(R9, -15),
+			},
+		},
+		{
+			testName:  "Empty scratch region",
+			bytes:     0,
+			wantError: true,
+		},
+		{
+			testName:  "Scratch region larger than the stack",
+			bytes:     MaxStackSize + 1,
+			wantError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got, reg, err := StackScratch(tc.bytes)
+			if tc.wantError {
+				if err == nil {
+					t.Fatalf("StackScratch(%d) expected error, got nil", tc.bytes)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("StackScratch(%d) unexpected error: %v", tc.bytes, err)
+			}
+			if reg != R9 {
+				t.Errorf("StackScratch(%d) register = %v, want %v", tc.bytes, reg, R9)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("StackScratch(%d) = %v, want %v", tc.bytes, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWithSpilledPointers(t *testing.T) {
+	call := []*pb.Instruction{Mov64(R1, 0), Call(MapLookup)}
+	lookup := []*pb.Instruction{StW(R10, 0, -4), Mov64(R2, R10), Add64(R2, -4), Call(MapLookup)}
+	tests := []struct {
+		testName  string
+		regs      []pb.Reg
+		call      []*pb.Instruction
+		depth     int
+		want      []*pb.Instruction
+		wantError bool
+	}{
+		{
+			testName: "Two pointers",
+			regs:     []pb.Reg{R2, R5},
+			call:     call,
+			want: []*pb.Instruction{
+				StDW(R10, R2, -8),
+				StDW(R10, R5, -16),
+				Mov64(R1, 0),
+				Call(MapLookup),
+				LdDW(R2, R10, -8),
+				LdDW(R5, R10, -16),
+			},
+		},
+		{
+			testName: "Below the caller stack",
+			regs:     []pb.Reg{R3},
+			call:     call,
+			depth:    12,
+			want: []*pb.Instruction{
+				StDW(R10, R3, -24),
+				Mov64(R1, 0),
+				Call(MapLookup),
+				LdDW(R3, R10, -24),
+			},
+		},
+		{
+			testName: "Below the call stack",
+			regs:     []pb.Reg{R1},
+			call:     lookup,
+			want: append(append([]*pb.Instruction{StDW(R10, R1, -16)}, lookup...),
+				LdDW(R1, R10, -16),
+			),
+		},
+		{
+			testName: "Nothing to spill",
+			regs:     nil,
+			call:     call,
+			want:     call,
+		},
+		{
+			testName:  "Return value",
+			regs:      []pb.Reg{R1, R0},
+			call:      call,
+			wantError: true,
+		},
+		{
+			testName:  "Out of stack",
+			regs:      []pb.Reg{R1, R2},
+			call:      call,
+			depth:     500,
+			wantError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got, err := WithSpilledPointers(tc.regs, tc.call, tc.depth)
+			if tc.wantError {
+				if err == nil {
+					t.Fatalf("WithSpilledPointers() expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("WithSpilledPointers() unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("WithSpilledPointers() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestMemoryDisplacementEncoding makes sure the off field of memory
+// instructions is encoded as a signed displacement.
+func TestMemoryDisplacementEncoding(t *testing.T) {
+	tests := []struct {
+		testName     string
+		instruction  *pb.Instruction
+		wantEncoding []uint64
+	}{
+		{
+			testName:     "Bottom of the stack",
+			instruction:  LdDW(R1, R10, -512),
+			wantEncoding: []uint64{0xfe00a179},
+		},
+		{
+			testName:     "Negative stack displacement from register",
+			instruction:  StDW(R10, R1, -8),
+			wantEncoding: []uint64{0xfff81a7b},
+		},
+		{
+			testName:     "Negative stack displacement with immediate",
+			instruction:  StW(R10, 7, -4),
+			wantEncoding: []uint64{0x00000007fffc0a62},
+		},
+		{
+			testName:     "Largest positive context offset",
+			instruction:  LdW(R0, R1, 0x7fff),
+			wantEncoding: []uint64{0x7fff1061},
+		},
+		{
+			testName:     "Large positive store offset",
+			instruction:  StB(R1, R2, 0x7000),
+			wantEncoding: []uint64{0x70002173},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			if err := ValidateInstruction(tc.instruction); err != nil {
+				t.Errorf("ValidateInstruction() unexpected error: %v", err)
+			}
+			encoding, err := encodeInstruction(tc.instruction)
+			if err != nil {
+				t.Fatalf("encodeInstruction() unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(encoding, tc.wantEncoding) {
+				t.Errorf("encodeInstruction() = %x, want %x", encoding, tc.wantEncoding)
+			}
+		})
+	}
+}
+
+func TestStoreImmediateWidth(t *testing.T) {
+	// Values that fit in 32 bits are encoded the same no matter the Go
+	// type they are passed as, the kernel sign extends them.
+	for _, got := range []*pb.Instruction{StDW(R10, int64(-2), -8), StDW(R10, -2, -8)} {
+		if want := StDW(R10, int32(-2), -8); !protobuf.Equal(got, want) {
+			t.Errorf("StDW() = %v, want %v", got, want)
+		}
+	}
+
+	wide := StDW(R10, int64(0x1234567800000001), -8)
+	if wide.Immediate != 1 {
+		t.Errorf("StDW() immediate = %#x, want 1", wide.Immediate)
+	}
+	if !wide.ImmediateTruncated {
+		t.Errorf("StDW() of a 64-bit immediate is not flagged as truncated")
+	}
+
+	// BPF_ST is a single word no matter the immediate it was built with,
+	// jumps over it must not count a second slot.
+	if got := instructionSlots(wide); got != 1 {
+		t.Errorf("instructionSlots() = %d, want 1", got)
+	}
+	encoding, err := encodeInstruction(wide)
+	if err != nil {
+		t.Fatalf("encodeInstruction() unexpected error: %v", err)
+	}
+	if want := []uint64{0x00000001fff80a7a}; !reflect.DeepEqual(encoding, want) {
+		t.Errorf("encodeInstruction() = %x, want %x", encoding, want)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+	"encoding/binary"
+	"hash/fnv"
+)
+
+// selectsOperation returns true if the immediate of `i` picks what the
+// instruction does rather than being an operand: the helper of a call or
+// the operation of an atomic instruction.
+func selectsOperation(i *pb.Instruction) bool {
+	if isCall(i) {
+		return true
+	}
+	mem, ok := i.Opcode.(*pb.Instruction_MemOpcode)
+	return ok && mem.MemOpcode.Mode == pb.StLdMode_StLdModeATOMIC
+}
+
+// StructuralHash returns a hash of the shape of `program`: how many
+// functions it has and, for every instruction, its opcode, its registers
+// and for jumps the index of the instruction they land on. Immediates and
+// offsets are left out, except for the immediates that select an
+// operation, so programs that only differ in their constants hash the
+// same. This allows grouping the programs of a corpus by shape.
+//
+// Instructions that can't be encoded are hashed as an invalid opcode.
+func StructuralHash(program *pb.Program) uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	write := func(v uint64) {
+		binary.LittleEndian.PutUint64(buf[:], v)
+		h.Write(buf[:])
+	}
+
+	write(uint64(len(program.Functions)))
+	for _, function := range program.Functions {
+		instructions := function.Instructions
+		write(uint64(len(instructions)))
+		targets := jumpTargets(instructions)
+		for index, inst := range instructions {
+			encoding, err := encodeInstruction(inst)
+			if err != nil {
+				write(^uint64(0))
+				continue
+			}
+			// The opcode, dst and src registers are the low 16 bits.
+			write(encoding[0] & 0xffff)
+			if isJump(inst) {
+				write(uint64(targets[index]))
+			}
+			if selectsOperation(inst) {
+				write(uint64(uint32(inst.Immediate)))
+			}
+		}
+	}
+	return h.Sum64()
+}
@@ -0,0 +1,109 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+func TestStructuralHash(t *testing.T) {
+	base := singleFunctionProgram(
+		LdMapByFd(R1, 3),
+		Mov64(R0, 1),
+		JmpGT(R0, 10, 1),
+		StDW(R10, 5, -8),
+		Call(MapLookup),
+		Exit(),
+	)
+
+	tests := []struct {
+		testName string
+		program  *pb.Program
+		wantSame bool
+	}{
+		{
+			testName: "Different constants",
+			program: singleFunctionProgram(
+				LdMapByFd(R1, 7),
+				Mov64(R0, 42),
+				JmpGT(R0, -3, 1),
+				StDW(R10, 9, -16),
+				Call(MapLookup),
+				Exit(),
+			),
+			wantSame: true,
+		},
+		{
+			testName: "Different register",
+			program: singleFunctionProgram(
+				LdMapByFd(R1, 3),
+				Mov64(R2, 1),
+				JmpGT(R0, 10, 1),
+				StDW(R10, 5, -8),
+				Call(MapLookup),
+				Exit(),
+			),
+			wantSame: false,
+		},
+		{
+			testName: "Different jump target",
+			program: singleFunctionProgram(
+				LdMapByFd(R1, 3),
+				Mov64(R0, 1),
+				JmpGT(R0, 10, 2),
+				StDW(R10, 5, -8),
+				Call(MapLookup),
+				Exit(),
+			),
+			wantSame: false,
+		},
+		{
+			testName: "Different opcode",
+			program: singleFunctionProgram(
+				LdMapByFd(R1, 3),
+				Mov64(R0, 1),
+				JmpGE(R0, 10, 1),
+				StDW(R10, 5, -8),
+				Call(MapLookup),
+				Exit(),
+			),
+			wantSame: false,
+		},
+		{
+			testName: "Different helper",
+			program: singleFunctionProgram(
+				LdMapByFd(R1, 3),
+				Mov64(R0, 1),
+				JmpGT(R0, 10, 1),
+				StDW(R10, 5, -8),
+				Call(GetPrandomU32),
+				Exit(),
+			),
+			wantSame: false,
+		},
+	}
+
+	want := StructuralHash(base)
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got := StructuralHash(tc.program)
+			if (got == want) != tc.wantSame {
+				t.Errorf("StructuralHash() = %#x, base program hashes to %#x, want same = %v", got, want, tc.wantSame)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	// ErrFramePointerWrite is returned when an instruction writes to R10,
+	// the read-only frame pointer. The verifier always rejects these.
+	ErrFramePointerWrite = errors.New("R10 is read only")
+
+	// ErrOffsetOutOfRange is returned when the offset of an instruction
+	// does not fit in the signed 16 bits of the off field. The proto holds
+	// it in an int32 so this can't be caught at encoding time.
+	ErrOffsetOutOfRange = errors.New("Offset does not fit in 16 bits")
+
+	// ErrInvalidJumpTarget is returned when a jump lands outside of the
+	// program or in the second slot of a wide instruction, which usually
+	// means instructions were added or removed without re-linking the
+	// jumps around them.
+	ErrInvalidJumpTarget = errors.New("Jump does not land on an instruction")
+
+	// ErrJumpOutOfRange is returned along with ErrInvalidJumpTarget when a
+	// jump lands before the start or past the end of the program rather
+	// than in the middle of a wide instruction. The verifier reports these
+	// as "jump out of range" without saying where the jump was meant to go.
+	ErrJumpOutOfRange = errors.New("Jump target out of range")
+
+	// ErrShiftOutOfRange is returned when a shift by an immediate is
+	// negative or not smaller than the width of the operation.
+	ErrShiftOutOfRange = errors.New("Shift amount out of range")
+
+	// ErrStoreImmediateTooWide is reported by Lint when a BPF_ST
+	// instruction was given an immediate that doesn't fit in 32 bits. The
+	// verifier accepts it, sign extended, but the caller probably expected
+	// a full 64-bit store: load the value into a register with Mov64 and
+	// store the register instead.
+	ErrStoreImmediateTooWide = errors.New("Store immediate does not fit in 32 bits")
+
+	// ErrInfiniteLoop is returned when the jumps of a program form a cycle
+	// that no path leaves, e.g. after a mutation pointed a branch back at
+	// an earlier instruction. The verifier rejects these after exploring
+	// them up to its complexity limit.
+	ErrInfiniteLoop = errors.New("Loop can never exit")
+
+	// ErrFalseBranchSize is returned by CheckFalseBranch when the offset of
+	// a jump doesn't match the slots taken by the instructions it should
+	// skip, which happens when the offset was computed by counting
+	// instructions and the branch has a wide one.
+	ErrFalseBranchSize = errors.New("Jump offset does not match the size of its false branch")
+
+	// ErrUninitializedArgument is returned when a helper is called while
+	// one of the registers it takes arguments in is not initialized on
+	// every path to the call. The verifier rejects these with "R2 !read_ok".
+	ErrUninitializedArgument = errors.New("Helper argument is not initialized")
+
+	// ErrUninitializedR0 is returned when R0 is read, e.g. by an ALU
+	// instruction or an exit, before something writes to it on some path.
+	// Unlike R1, R0 holds nothing when the program starts.
+	ErrUninitializedR0 = errors.New("R0 is read before being initialized")
+)
+
+// ValidateInstruction checks `i` against the rules the verifier enforces on
+// every instruction regardless of program state, returning the first one
+// that is violated.
+func ValidateInstruction(i *pb.Instruction) error {
+	if i == nil {
+		return ErrNilInstruction
+	}
+	if i.Offset < math.MinInt16 || i.Offset > math.MaxInt16 {
+		return ErrOffsetOutOfRange
+	}
+	for _, reg := range registerDefs(i) {
+		if reg == pb.Reg_R10 {
+			return ErrFramePointerWrite
+		}
+	}
+	if alu, ok := i.Opcode.(*pb.Instruction_AluOpcode); ok && isImmShift(alu.AluOpcode) {
+		width := int32(64)
+		if alu.AluOpcode.InstructionClass == pb.InsClass_InsClassAlu {
+			width = 32
+		}
+		if i.Immediate < 0 || i.Immediate >= width {
+			return ErrShiftOutOfRange
+		}
+	}
+	return nil
+}
+
+func isImmShift(op *pb.AluOpcode) bool {
+	if op.Source != pb.SrcOperand_Immediate {
+		return false
+	}
+	switch op.OperationCode {
+	case pb.AluOperationCode_AluLsh, pb.AluOperationCode_AluRsh, pb.AluOperationCode_AluArsh:
+		return true
+	}
+	return false
+}
+
+// Validate runs ValidateInstruction over `instructions` and checks that every
+// jump lands on an instruction, that every loop can be left, that R0 is not
+// read before being written and that helpers are called with their
+// arguments initialized, returning the first error found wrapped with the
+// index of the offending instruction. Register reads are only checked on
+// the paths the verifier explores, skipping branches decided by known
+// scalar values.
+// Jumps that land outside of the program also wrap ErrJumpOutOfRange and
+// say where they land. Programs that fail validation are guaranteed to be
+// rejected by the verifier so generators can use this to skip them before
+// loading.
+func Validate(instructions []*pb.Instruction) error {
+	for index, i := range instructions {
+		if err := ValidateInstruction(i); err != nil {
+			return fmt.Errorf("instruction %d: %w", index, err)
+		}
+	}
+	slots := slotIndexes(instructions)
+	programSlots := 0
+	if len(instructions) > 0 {
+		last := len(instructions) - 1
+		programSlots = slots[last] + instructionSlots(instructions[last])
+	}
+	for index, target := range jumpTargets(instructions) {
+		if target >= 0 || !isJump(instructions[index]) {
+			continue
+		}
+		i := instructions[index]
+		targetSlot := slots[index] + instructionSlots(i) + int(i.Offset)
+		if targetSlot < 0 || targetSlot >= programSlots {
+			return fmt.Errorf("instruction %d: %w, %w: lands at %d, the program has %d slots", index, ErrInvalidJumpTarget, ErrJumpOutOfRange, targetSlot, programSlots)
+		}
+		return fmt.Errorf("instruction %d: %w", index, ErrInvalidJumpTarget)
+	}
+	if index := inescapableLoop(instructions); index >= 0 {
+		return fmt.Errorf("instruction %d: %w", index, ErrInfiniteLoop)
+	}
+	return checkRegisterReads(instructions)
+}
+
+// checkRegisterReads checks that R0 is initialized on every path reaching
+// an instruction that reads it and that, for every call to a helper with a
+// known signature, so are the registers holding its arguments.
+// `instructions` are assumed to be the main function, which starts with only
+// R1 and R10 initialized.
+//
+// Paths through a branch that RangeAt knows is never followed are skipped,
+// the verifier prunes those too and doesn't complain about reads on them.
+func checkRegisterReads(instructions []*pb.Instruction) error {
+	entry := RegisterSet(0).Add(pb.Reg_R1).Add(pb.Reg_R10)
+	defined, reached := mustRegistersFollowing(instructions, entry, definedAfter, followedEdges(instructions))
+	for index, i := range instructions {
+		if !reached[index] {
+			continue
+		}
+		if !defined[index].Contains(pb.Reg_R0) && readsRegister(i, pb.Reg_R0) {
+			return fmt.Errorf("instruction %d: %w", index, ErrUninitializedR0)
+		}
+		number, ok := HelperFunctionNumber(i)
+		if !ok {
+			continue
+		}
+		args, ok := HelperSignature(number)
+		if !ok {
+			continue
+		}
+		for arg := range args {
+			reg := pb.Reg_R1 + pb.Reg(arg)
+			if !defined[index].Contains(reg) {
+				return fmt.Errorf("instruction %d: %w: %v, argument %d of %s", index, ErrUninitializedArgument, reg, arg+1, GetBpfFuncName(number))
+			}
+		}
+	}
+	return nil
+}
+
+// Lint returns warnings about `instructions` that the verifier accepts but
+// that probably don't do what their author meant, each wrapped with the
+// index of the offending instruction. Unlike Validate failures these don't
+// predict a rejection.
+func Lint(instructions []*pb.Instruction) []error {
+	warnings := []error{}
+	for index, i := range instructions {
+		if mem, ok := i.GetOpcode().(*pb.Instruction_MemOpcode); ok && mem.MemOpcode.InstructionClass == pb.InsClass_InsClassSt && i.ImmediateTruncated {
+			warnings = append(warnings, fmt.Errorf("instruction %d: %w", index, ErrStoreImmediateTooWide))
+		}
+	}
+	return warnings
+}
+
+// CheckFalseBranch checks that the jump at `index` skips exactly the
+// `length` instructions that follow it, its false branch, taking into
+// account that wide instructions take two slots. Programs built by hand
+// often compute offsets with len() of the branch, which is wrong as soon
+// as it has a 64-bit immediate load and still passes Validate if the jump
+// happens to land on another instruction.
+func CheckFalseBranch(instructions []*pb.Instruction, index int, length int) error {
+	if index < 0 || index >= len(instructions) || !isJump(instructions[index]) {
+		return fmt.Errorf("Instruction %d is not a jump", index)
+	}
+	if length < 0 || index+1+length > len(instructions) {
+		return fmt.Errorf("False branch of %d instructions at %d is out of range", length, index)
+	}
+	want := 0
+	for _, i := range instructions[index+1 : index+1+length] {
+		want += instructionSlots(i)
+	}
+	if got := int(instructions[index].Offset); got != want {
+		return fmt.Errorf("%w: instruction %d skips %d slots, its false branch of %d instructions takes %d", ErrFalseBranchSize, index, got, length, want)
+	}
+	return nil
+}
@@ -0,0 +1,327 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		testName     string
+		instructions []*pb.Instruction
+		wantError    error
+	}{
+		{
+			testName: "Valid program",
+			instructions: []*pb.Instruction{
+				Mov64(R1, R10),
+				StW(R10, 0, -4),
+				LdW(R0, R10, -4),
+				JmpEQ(R10, 0, 0),
+				Exit(),
+			},
+			wantError: nil,
+		},
+		{
+			testName:     "Mov to R10",
+			instructions: []*pb.Instruction{Mov64(R10, R1), Exit()},
+			wantError:    ErrFramePointerWrite,
+		},
+		{
+			testName:     "ALU to R10",
+			instructions: []*pb.Instruction{Add64(R10, -8), Exit()},
+			wantError:    ErrFramePointerWrite,
+		},
+		{
+			testName:     "Load to R10",
+			instructions: []*pb.Instruction{LdDW(R10, R1, 0), Exit()},
+			wantError:    ErrFramePointerWrite,
+		},
+		{
+			testName:     "Wide load to R10",
+			instructions: []*pb.Instruction{Mov64(R10, int64(1)<<40), Exit()},
+			wantError:    ErrFramePointerWrite,
+		},
+		{
+			testName:     "Offset out of range",
+			instructions: []*pb.Instruction{{Opcode: LdW(R0, R1, 0).Opcode, Offset: 1 << 15}},
+			wantError:    ErrOffsetOutOfRange,
+		},
+		{
+			testName:     "Jump past the end",
+			instructions: []*pb.Instruction{JmpEQ(R1, 0, 1), Exit()},
+			wantError:    ErrInvalidJumpTarget,
+		},
+		{
+			testName:     "Jump before the start",
+			instructions: []*pb.Instruction{Mov64(R0, 0), Jmp(-3), Exit()},
+			wantError:    ErrInvalidJumpTarget,
+		},
+		{
+			testName:     "Jump past the end out of range",
+			instructions: []*pb.Instruction{JmpEQ(R1, 0, 1), Exit()},
+			wantError:    ErrJumpOutOfRange,
+		},
+		{
+			testName:     "Jump before the start out of range",
+			instructions: []*pb.Instruction{Mov64(R0, 0), Jmp(-3), Exit()},
+			wantError:    ErrJumpOutOfRange,
+		},
+		{
+			testName:     "Jump past a trailing wide instruction",
+			instructions: []*pb.Instruction{JmpEQ(R1, 0, 3), Exit(), LdMapByFd(R1, 3)},
+			wantError:    ErrJumpOutOfRange,
+		},
+		{
+			testName: "Jump into a wide instruction",
+			instructions: []*pb.Instruction{
+				JmpEQ(R1, 0, 1),
+				LdMapByFd(R1, 3),
+				Exit(),
+			},
+			wantError: ErrInvalidJumpTarget,
+		},
+		{
+			testName: "Jump over a wide instruction",
+			instructions: []*pb.Instruction{
+				Mov64(R0, 0),
+				JmpEQ(R1, 0, 2),
+				LdMapByFd(R1, 3),
+				Exit(),
+			},
+			wantError: nil,
+		},
+		{
+			testName:     "Jump to itself",
+			instructions: []*pb.Instruction{Mov64(R0, 0), Jmp(-1), Exit()},
+			wantError:    ErrInfiniteLoop,
+		},
+		{
+			testName: "Loop without a way out",
+			instructions: []*pb.Instruction{
+				Mov64(R0, 0),
+				Add64(R0, 1),
+				JmpEQ(R1, 0, -2),
+				Jmp(-4),
+				Exit(),
+			},
+			wantError: ErrInfiniteLoop,
+		},
+		{
+			testName: "Bounded loop",
+			instructions: []*pb.Instruction{
+				Mov64(R0, 0),
+				Add64(R0, 1),
+				JmpLT(R0, 10, -2),
+				Exit(),
+			},
+			wantError: nil,
+		},
+		{
+			testName:     "Shift by the operation width",
+			instructions: []*pb.Instruction{Lsh(R1, 32), Exit()},
+			wantError:    ErrShiftOutOfRange,
+		},
+		{
+			testName:     "Negative shift",
+			instructions: []*pb.Instruction{Arsh64(R1, -1), Exit()},
+			wantError:    ErrShiftOutOfRange,
+		},
+		{
+			testName:     "Widest valid shift",
+			instructions: []*pb.Instruction{Rsh64(R1, 63), Rsh64(R1, R2), Mov64(R0, 0), Exit()},
+			wantError:    nil,
+		},
+		{
+			testName:     "64-bit store immediate",
+			instructions: []*pb.Instruction{StDW(R10, int64(1)<<32, -8), Mov64(R0, 0), Exit()},
+			wantError:    nil,
+		},
+		{
+			testName:     "Negative 64-bit store immediate that fits",
+			instructions: []*pb.Instruction{StDW(R10, int64(-1), -8), Mov64(R0, 0), Exit()},
+			wantError:    nil,
+		},
+		{
+			testName: "Helper arguments initialized",
+			instructions: []*pb.Instruction{
+				LdMapByFd(R1, 3),
+				Mov64(R2, R10),
+				Add64(R2, -4),
+				Call(MapLookup),
+				Exit(),
+			},
+			wantError: nil,
+		},
+		{
+			testName: "Helper argument never initialized",
+			instructions: []*pb.Instruction{
+				LdMapByFd(R1, 3),
+				Call(MapLookup),
+				Exit(),
+			},
+			wantError: ErrUninitializedArgument,
+		},
+		{
+			testName: "Helper argument initialized on one path",
+			instructions: []*pb.Instruction{
+				LdMapByFd(R1, 3),
+				JmpEQ(R1, 0, 1),
+				Mov64(R2, R10),
+				Call(MapLookup),
+				Exit(),
+			},
+			wantError: ErrUninitializedArgument,
+		},
+		{
+			testName: "Helper argument clobbered by a previous call",
+			instructions: []*pb.Instruction{
+				LdMapByFd(R1, 3),
+				Mov64(R2, R10),
+				Call(MapLookup),
+				LdMapByFd(R1, 3),
+				Call(MapLookup),
+				Exit(),
+			},
+			wantError: ErrUninitializedArgument,
+		},
+		{
+			testName:     "Helper without arguments",
+			instructions: []*pb.Instruction{Mov64(R1, 0), Call(GetPrandomU32), Exit()},
+			wantError:    nil,
+		},
+		{
+			testName:     "Exit without a return value",
+			instructions: []*pb.Instruction{Exit()},
+			wantError:    ErrUninitializedR0,
+		},
+		{
+			testName:     "R0 read before being written",
+			instructions: []*pb.Instruction{Add64(R0, 1), Exit()},
+			wantError:    ErrUninitializedR0,
+		},
+		{
+			testName:     "R0 written on one path",
+			instructions: []*pb.Instruction{JmpEQ(R1, 0, 1), Mov64(R0, 0), Exit()},
+			wantError:    ErrUninitializedR0,
+		},
+		{
+			testName:     "R0 skipped by a branch never taken",
+			instructions: []*pb.Instruction{Mov64(R2, 0), JmpNE(R2, 0, 1), Mov64(R0, 0), Exit()},
+			wantError:    nil,
+		},
+		{
+			testName: "Helper argument skipped by a branch never taken",
+			instructions: []*pb.Instruction{
+				LdMapByFd(R1, 3),
+				Mov64(R3, 1),
+				JmpEQ(R3, 0, 1),
+				Mov64(R2, R10),
+				Call(MapLookup),
+				Exit(),
+			},
+			wantError: nil,
+		},
+		{
+			testName:     "R0 written by a call",
+			instructions: []*pb.Instruction{Call(GetPrandomU32), Exit()},
+			wantError:    nil,
+		},
+		{
+			testName:     "Nil instruction",
+			instructions: []*pb.Instruction{nil},
+			wantError:    ErrNilInstruction,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			err := Validate(tc.instructions)
+			if tc.wantError == nil {
+				if err != nil {
+					t.Fatalf("Validate() unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tc.wantError) {
+				t.Errorf("Validate() error = %v, want %v", err, tc.wantError)
+			}
+		})
+	}
+}
+
+func TestLint(t *testing.T) {
+	clean := []*pb.Instruction{StDW(R10, int64(-1), -8), Mov64(R0, 0), Exit()}
+	if warnings := Lint(clean); len(warnings) != 0 {
+		t.Errorf("Lint() = %v, want no warnings", warnings)
+	}
+
+	wide := []*pb.Instruction{Mov64(R0, 0), StDW(R10, int64(1)<<32, -8), Exit()}
+	warnings := Lint(wide)
+	if len(warnings) != 1 || !errors.Is(warnings[0], ErrStoreImmediateTooWide) {
+		t.Fatalf("Lint() = %v, want a single %v", warnings, ErrStoreImmediateTooWide)
+	}
+	if want := "instruction 1: "; !strings.HasPrefix(warnings[0].Error(), want) {
+		t.Errorf("Lint() warning %q does not start with %q", warnings[0], want)
+	}
+}
+
+func TestCheckFalseBranch(t *testing.T) {
+	tests := []struct {
+		testName     string
+		instructions []*pb.Instruction
+		length       int
+		wantError    error
+	}{
+		{
+			testName:     "Narrow branch",
+			instructions: []*pb.Instruction{JmpEQ(R1, 0, 2), Mov64(R0, 0), Mov64(R0, 1), Exit()},
+			length:       2,
+			wantError:    nil,
+		},
+		{
+			testName:     "Wide branch",
+			instructions: []*pb.Instruction{JmpEQ(R1, 0, 3), Mov64(R0, int64(1)<<40), Mov64(R0, 1), Exit()},
+			length:       2,
+			wantError:    nil,
+		},
+		{
+			testName:     "Wide branch counted in instructions",
+			instructions: []*pb.Instruction{JmpEQ(R1, 0, 2), Mov64(R0, 0), Mov64(R0, int64(1)<<40), Exit()},
+			length:       2,
+			wantError:    ErrFalseBranchSize,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			if err := CheckFalseBranch(tc.instructions, 0, tc.length); !errors.Is(err, tc.wantError) {
+				t.Errorf("CheckFalseBranch() = %v, want %v", err, tc.wantError)
+			}
+		})
+	}
+
+	if err := CheckFalseBranch([]*pb.Instruction{Mov64(R0, 0), Exit()}, 0, 1); err == nil {
+		t.Errorf("CheckFalseBranch() of a mov expected error, got nil")
+	}
+	if err := CheckFalseBranch([]*pb.Instruction{Jmp(0), Exit()}, 0, 2); err == nil {
+		t.Errorf("CheckFalseBranch() with a branch past the end expected error, got nil")
+	}
+}
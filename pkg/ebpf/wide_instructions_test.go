@@ -0,0 +1,126 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+// TestWideInstructionSlots checks that every view of a program agrees that a
+// 64-bit immediate load takes two slots: a jump built with labels over one,
+// the bytecode, the pocs, the xlated dump and the analyses.
+func TestWideInstructionSlots(t *testing.T) {
+	labeled, err := ToLabeled([]*pb.Instruction{
+		JmpEQ(R1, 0, 0),
+		Mov64(R0, int64(1)<<40),
+		Mov64(R0, 1),
+		Exit(),
+	})
+	if err != nil {
+		t.Fatalf("ToLabeled() unexpected error: %v", err)
+	}
+	labeled[0].Target = labeled[3].Label
+	instructions, err := FromLabeled(labeled)
+	if err != nil {
+		t.Fatalf("FromLabeled() unexpected error: %v", err)
+	}
+	const wantOffset, targetSlot = 3, 4
+	if got := instructions[0].Offset; got != wantOffset {
+		t.Fatalf("FromLabeled() jump offset = %d, want %d", got, wantOffset)
+	}
+	// The jump skips the writes to R0, only the slots matter here.
+	if err := Validate(instructions); err != nil && !errors.Is(err, ErrUninitializedR0) {
+		t.Errorf("Validate() unexpected error: %v", err)
+	}
+	if got := jumpTargets(instructions)[0]; got != 3 {
+		t.Errorf("jumpTargets() = %d, want 3", got)
+	}
+	if err := CheckFalseBranch(instructions, 0, 2); err != nil {
+		t.Errorf("CheckFalseBranch() unexpected error: %v", err)
+	}
+	if got := SlotCount(instructions); got != 5 {
+		t.Errorf("SlotCount() = %d, want 5", got)
+	}
+
+	program := singleFunctionProgram(instructions...)
+	bytecode, _, err := EncodeInstructions(program)
+	if err != nil {
+		t.Fatalf("EncodeInstructions() unexpected error: %v", err)
+	}
+	if len(bytecode) != 5*8 {
+		t.Fatalf("EncodeInstructions() returned %d bytes, want %d", len(bytecode), 5*8)
+	}
+	words := make([]uint64, len(bytecode)/8)
+	for index := range words {
+		words[index] = binary.LittleEndian.Uint64(bytecode[index*8:])
+	}
+	decoded, err := ProgramFromBytecode(words)
+	if err != nil {
+		t.Fatalf("ProgramFromBytecode() unexpected error: %v", err)
+	}
+	if diff := ProgramDiff(decoded, program); diff != "" {
+		t.Errorf("ProgramFromBytecode() returned a different program:\n%s", diff)
+	}
+
+	numbered, err := GenerateNumberedInsnArray(program)
+	if err != nil {
+		t.Fatalf("GenerateNumberedInsnArray() unexpected error: %v", err)
+	}
+	for _, want := range []string{"/* 1 */ BPF_LD_IMM64(BPF_REG_0, 0x10000000000)", "/* 3 */ BPF_MOV64_IMM", "/* 4 */ BPF_EXIT_INSN()"} {
+		if !strings.Contains(numbered, want) {
+			t.Errorf("GenerateNumberedInsnArray() = \n%s\nwant it to contain %q", numbered, want)
+		}
+	}
+
+	xlated, err := DumpXlated(program)
+	if err != nil {
+		t.Fatalf("DumpXlated() unexpected error: %v", err)
+	}
+	for _, want := range []string{"goto pc+3", "   4: (95) exit"} {
+		if !strings.Contains(xlated, want) {
+			t.Errorf("DumpXlated() = \n%s\nwant it to contain %q", xlated, want)
+		}
+	}
+
+	slots := slotIndexes(instructions)
+	if got := slots[0] + instructionSlots(instructions[0]) + int(instructions[0].Offset); got != targetSlot || slots[3] != targetSlot {
+		t.Errorf("jump lands at slot %d and the exit is at slot %d, want both %d", got, slots[3], targetSlot)
+	}
+}
+
+func TestGenerateInRangeWideInstruction(t *testing.T) {
+	generated := []*pb.Instruction{JmpEQ(R1, 0, 2), Mov64(R0, int64(1)<<40), Mov64(R0, 1)}
+	next := 0
+	got, err := GenerateInRange([]*pb.Instruction{Mov64(R0, 0), Mov64(R0, 0), Mov64(R0, 0), Exit()}, 0, 3, func(remaining int) *pb.Instruction {
+		next++
+		return generated[next-1]
+	})
+	if err != nil {
+		t.Fatalf("GenerateInRange() unexpected error: %v", err)
+	}
+	// The jump skips 2 instructions, which take 3 slots.
+	if got[0].Offset != 3 {
+		t.Errorf("GenerateInRange() jump offset = %d, want 3", got[0].Offset)
+	}
+	// The jump skips the writes to R0, only the slots matter here.
+	if err := Validate(got); err != nil && !errors.Is(err, ErrUninitializedR0) {
+		t.Errorf("Validate() unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+	"fmt"
+	"strings"
+)
+
+// The tables below mirror the ones in the kernel's kernel/bpf/disasm.c,
+// indexed the same way. Missing entries are printed as "(null)" like the
+// kernel's printf does.
+var (
+	xlatedAluStrings = map[uint8]string{
+		0x00: "+=",
+		0x10: "-=",
+		0x20: "*=",
+		0x30: "/=",
+		0x40: "|=",
+		0x50: "&=",
+		0x60: "<<=",
+		0x70: ">>=",
+		0x80: "neg",
+		0x90: "%=",
+		0xa0: "^=",
+		0xb0: "=",
+		0xc0: "s>>=",
+		0xd0: "endian",
+	}
+	xlatedAluSignStrings = map[uint8]string{
+		0x30: "s/=",
+		0x90: "s%=",
+	}
+	xlatedMovsxStrings = map[int16]string{
+		8:  "(s8)",
+		16: "(s16)",
+		32: "(s32)",
+	}
+	xlatedAtomicAluStrings = map[uint8]string{
+		0x00: "add",
+		0x40: "or",
+		0x50: "and",
+		0xa0: "xor",
+	}
+	xlatedJmpStrings = map[uint8]string{
+		0x00: "jmp",
+		0x10: "==",
+		0x20: ">",
+		0x30: ">=",
+		0x40: "&",
+		0x50: "!=",
+		0x60: "s>",
+		0x70: "s>=",
+		0x80: "call",
+		0x90: "exit",
+		0xa0: "<",
+		0xb0: "<=",
+		0xc0: "s<",
+		0xd0: "s<=",
+	}
+	xlatedLdStStrings   = []string{"u32", "u16", "u8", "u64"}
+	xlatedLdxSxStrings  = []string{"s32", "s16", "s8", "s64"}
+	xlatedUnknownString = "(null)"
+)
+
+// xlatedWord is a decoded instruction word with the field types of the
+// kernel's struct bpf_insn.
+type xlatedWord struct {
+	code uint8
+	dst  uint8
+	src  uint8
+	off  int16
+	imm  int32
+}
+
+func newXlatedWord(word uint64) xlatedWord {
+	return xlatedWord{
+		code: uint8(word),
+		dst:  uint8(word>>8) & 0x0f,
+		src:  uint8(word>>12) & 0x0f,
+		off:  int16(word >> 16),
+		imm:  int32(word >> 32),
+	}
+}
+
+func xlatedString(table map[uint8]string, key uint8) string {
+	if s, ok := table[key]; ok {
+		return s
+	}
+	return xlatedUnknownString
+}
+
+// DumpXlated disassembles the program in the plain text format of
+// `bpftool prog dump xlated`, one instruction per line prefixed with its
+// slot number, e.g. `   0: (b7) r0 = 0`. The output is built from the
+// encoded instructions so it shows exactly what would be sent to the
+// kernel, which makes it possible to diff it against bpftool to find
+// encoding discrepancies.
+//
+// The program is dumped as submitted, not as rewritten by the verifier, so
+// helper calls keep their ids instead of the kernel's call offsets. Map
+// loads print their fd where bpftool prints the map id.
+func DumpXlated(program *pb.Program) (string, error) {
+	words := []uint64{}
+	for _, i := range programInstructions(program) {
+		var err error
+		words, err = appendInstruction(words, i)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var sb strings.Builder
+	for index := 0; index < len(words); index++ {
+		insn := newXlatedWord(words[index])
+		var next *xlatedWord
+		if insn.code == 0x18 {
+			if index+1 >= len(words) {
+				return "", fmt.Errorf("Wide instruction at slot %d is missing its second half", index)
+			}
+			w := newXlatedWord(words[index+1])
+			next = &w
+		}
+		sb.WriteString(fmt.Sprintf("%4d: %s\n", index, xlatedInstruction(insn, next)))
+		if next != nil {
+			index++
+		}
+	}
+	return sb.String(), nil
+}
+
+// xlatedInstruction follows print_bpf_insn in kernel/bpf/disasm.c, `next`
+// is the second half of a 64-bit immediate load.
+func xlatedInstruction(insn xlatedWord, next *xlatedWord) string {
+	class := insn.code & 0x07
+	op := insn.code & 0xf0
+	size := xlatedLdStStrings[(insn.code&0x18)>>3]
+	mode := insn.code & 0xe0
+	isX := insn.code&0x08 != 0
+
+	switch class {
+	case 0x04, 0x07: // BPF_ALU, BPF_ALU64
+		r := 'r'
+		if class == 0x04 {
+			r = 'w'
+		}
+		switch {
+		case op == 0xd0 && class == 0x07:
+			return fmt.Sprintf("(%02x) r%d = bswap%d r%d", insn.code, insn.dst, insn.imm, insn.dst)
+		case op == 0xd0:
+			endian := "le"
+			if isX {
+				endian = "be"
+			}
+			return fmt.Sprintf("(%02x) r%d = %s%d r%d", insn.code, insn.dst, endian, insn.imm, insn.dst)
+		case op == 0x80:
+			return fmt.Sprintf("(%02x) %c%d = -%c%d", insn.code, r, insn.dst, r, insn.dst)
+		}
+		opString := xlatedString(xlatedAluStrings, op)
+		if (op == 0x30 || op == 0x90) && insn.off == 1 {
+			opString = xlatedAluSignStrings[op]
+		}
+		if isX {
+			movsx := ""
+			if s, ok := xlatedMovsxStrings[insn.off]; ok && op == 0xb0 {
+				movsx = s
+			}
+			return fmt.Sprintf("(%02x) %c%d %s %s%c%d", insn.code, r, insn.dst, opString, movsx, r, insn.src)
+		}
+		return fmt.Sprintf("(%02x) %c%d %s %d", insn.code, r, insn.dst, opString, insn.imm)
+	case 0x03: // BPF_STX
+		suffix := ""
+		if insn.code&0x18 == 0x18 {
+			suffix = "64"
+		}
+		switch {
+		case mode == 0x60:
+			return fmt.Sprintf("(%02x) *(%s *)(r%d %+d) = r%d", insn.code, size, insn.dst, insn.off, insn.src)
+		case mode != 0xc0:
+			break
+		case insn.imm == 0x00 || insn.imm == 0x40 || insn.imm == 0x50 || insn.imm == 0xa0:
+			return fmt.Sprintf("(%02x) lock *(%s *)(r%d %+d) %s r%d", insn.code, size, insn.dst, insn.off, xlatedString(xlatedAluStrings, uint8(insn.imm)), insn.src)
+		case insn.imm == 0x01 || insn.imm == 0x41 || insn.imm == 0x51 || insn.imm == 0xa1:
+			return fmt.Sprintf("(%02x) r%d = atomic%s_fetch_%s((%s *)(r%d %+d), r%d)", insn.code, insn.src, suffix, xlatedAtomicAluStrings[uint8(insn.imm)&0xf0], size, insn.dst, insn.off, insn.src)
+		case insn.imm == 0xf1:
+			return fmt.Sprintf("(%02x) r0 = atomic%s_cmpxchg((%s *)(r%d %+d), r0, r%d)", insn.code, suffix, size, insn.dst, insn.off, insn.src)
+		case insn.imm == 0xe1:
+			return fmt.Sprintf("(%02x) r%d = atomic%s_xchg((%s *)(r%d %+d), r%d)", insn.code, insn.src, suffix, size, insn.dst, insn.off, insn.src)
+		}
+		return fmt.Sprintf("BUG_%02x", insn.code)
+	case 0x02: // BPF_ST
+		if mode == 0x60 {
+			return fmt.Sprintf("(%02x) *(%s *)(r%d %+d) = %d", insn.code, size, insn.dst, insn.off, insn.imm)
+		}
+		return fmt.Sprintf("BUG_st_%02x", insn.code)
+	case 0x01: // BPF_LDX
+		switch mode {
+		case 0x60:
+			return fmt.Sprintf("(%02x) r%d = *(%s *)(r%d %+d)", insn.code, insn.dst, size, insn.src, insn.off)
+		case 0x80:
+			return fmt.Sprintf("(%02x) r%d = *(%s *)(r%d %+d)", insn.code, insn.dst, xlatedLdxSxStrings[(insn.code&0x18)>>3], insn.src, insn.off)
+		}
+		return fmt.Sprintf("BUG_ldx_%02x", insn.code)
+	case 0x00: // BPF_LD
+		switch {
+		case mode == 0x20:
+			return fmt.Sprintf("(%02x) r0 = *(%s *)skb[%d]", insn.code, size, insn.imm)
+		case mode == 0x40:
+			return fmt.Sprintf("(%02x) r0 = *(%s *)skb[r%d + %d]", insn.code, size, insn.src, insn.imm)
+		case mode == 0x00 && next != nil:
+			return fmt.Sprintf("(%02x) r%d = %s", insn.code, insn.dst, xlatedImmediate(insn, *next))
+		}
+		return fmt.Sprintf("BUG_ld_%02x", insn.code)
+	default: // BPF_JMP, BPF_JMP32
+		r := 'r'
+		if class == 0x06 {
+			r = 'w'
+		}
+		switch {
+		case op == 0x80:
+			switch pb.Reg(insn.src) {
+			case PseudoCall:
+				return fmt.Sprintf("(%02x) call pc%+d", insn.code, insn.imm)
+			case PseudoKfuncCall:
+				return fmt.Sprintf("(%02x) call kernel-function#%d", insn.code, insn.imm)
+			}
+			return fmt.Sprintf("(%02x) call %s#%d", insn.code, xlatedHelperName(insn.imm), insn.imm)
+		case op == 0x00 && class == 0x05:
+			return fmt.Sprintf("(%02x) goto pc%+d", insn.code, insn.off)
+		case op == 0x00:
+			return fmt.Sprintf("(%02x) gotol pc%+d", insn.code, insn.imm)
+		case op == 0x90:
+			return fmt.Sprintf("(%02x) exit", insn.code)
+		case isX:
+			return fmt.Sprintf("(%02x) if %c%d %s %c%d goto pc%+d", insn.code, r, insn.dst, xlatedString(xlatedJmpStrings, op), r, insn.src, insn.off)
+		}
+		return fmt.Sprintf("(%02x) if %c%d %s 0x%x goto pc%+d", insn.code, r, insn.dst, xlatedString(xlatedJmpStrings, op), uint32(insn.imm), insn.off)
+	}
+}
+
+// xlatedImmediate prints the value of a 64-bit immediate load the way
+// bpftool's print_imm does.
+func xlatedImmediate(insn, next xlatedWord) string {
+	switch pb.Reg(insn.src) {
+	case PseudoMapFD:
+		return fmt.Sprintf("map[id:%d]", uint32(insn.imm))
+	case PseudoMapValue:
+		return fmt.Sprintf("map[id:%d][0]+%d", uint32(insn.imm), uint32(next.imm))
+	}
+	return fmt.Sprintf("0x%x", uint64(uint32(next.imm))<<32|uint64(uint32(insn.imm)))
+}
+
+// xlatedHelperName returns the kernel's name for helper `id`, e.g.
+// bpf_map_lookup_elem.
+func xlatedHelperName(id int32) string {
+	name := GetBpfFuncName(id)
+	if !strings.HasPrefix(name, "BPF_FUNC_") {
+		return name
+	}
+	return "bpf_" + strings.TrimPrefix(name, "BPF_FUNC_")
+}
@@ -0,0 +1,116 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+	"testing"
+)
+
+func TestDumpXlated(t *testing.T) {
+	pseudoCall := Call(2)
+	pseudoCall.SrcReg = PseudoCall
+	program := &pb.Program{
+		Functions: []*pb.Functions{
+			{
+				Instructions: []*pb.Instruction{
+					LdMapByFd(R1, 3),
+					Mov64(R2, R10),
+					Add64(R2, -8),
+					StW(R10, 0, -8),
+					Call(MapLookup),
+					JmpEQ(R0, 0, 4),
+					LdDW(R3, R0, 0),
+					MemAdd64(R0, R3, 8),
+					StDW(R10, R3, -16),
+					JmpNE32(R3, R2, -1),
+					Mov64(R0, int64(1)<<40),
+					pseudoCall,
+					Exit(),
+				},
+			},
+			{
+				Instructions: []*pb.Instruction{
+					Add(R1, R2),
+					Neg(R1, 0),
+					End(R1, 16),
+					JmpEQ(R1, -1, 0),
+					Exit(),
+				},
+			},
+		},
+	}
+
+	want := `   0: (18) r1 = map[id:3]
+   2: (bf) r2 = r10
+   3: (07) r2 += -8
+   4: (62) *(u32 *)(r10 -8) = 0
+   5: (85) call bpf_map_lookup_elem#1
+   6: (15) if r0 == 0x0 goto pc+4
+   7: (79) r3 = *(u64 *)(r0 +0)
+   8: (db) lock *(u64 *)(r0 +8) += r3
+   9: (7b) *(u64 *)(r10 -16) = r3
+  10: (5e) if w3 != w2 goto pc-1
+  11: (18) r0 = 0x10000000000
+  13: (85) call pc+2
+  14: (95) exit
+  15: (0c) w1 += w2
+  16: (84) w1 = -w1
+  17: (d4) r1 = le16 r1
+  18: (15) if r1 == 0xffffffff goto pc+0
+  19: (95) exit
+`
+	got, err := DumpXlated(program)
+	if err != nil {
+		t.Fatalf("DumpXlated() unexpected error: %v", err)
+	}
+	if got != want {
+		t.Errorf("DumpXlated() = \n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDumpXlatedRawOpcodes(t *testing.T) {
+	tests := []struct {
+		instruction *pb.Instruction
+		want        string
+	}{
+		{RawOpcodeInstruction(0xbf, R1, R2, 8, 0), "(bf) r1 = (s8)r2"},
+		{RawOpcodeInstruction(0x3f, R1, R2, 1, 0), "(3f) r1 s/= r2"},
+		{RawOpcodeInstruction(0xd7, R1, R0, 0, 32), "(d7) r1 = bswap32 r1"},
+		{RawOpcodeInstruction(0x91, R1, R2, -4, 0), "(91) r1 = *(s8 *)(r2 -4)"},
+		{RawOpcodeInstruction(0xc3, R1, R2, 0, 0xe1), "(c3) r2 = atomic_xchg((u32 *)(r1 +0), r2)"},
+		{RawOpcodeInstruction(0xdb, R1, R2, 0, 0xf1), "(db) r0 = atomic64_cmpxchg((u64 *)(r1 +0), r0, r2)"},
+		{RawOpcodeInstruction(0xdb, R1, R2, 0, 0x01), "(db) r2 = atomic64_fetch_add((u64 *)(r1 +0), r2)"},
+		{RawOpcodeInstruction(0x06, R0, R0, 0, 100), "(06) gotol pc+100"},
+		{RawOpcodeInstruction(0x30, R0, R0, 0, 12), "(30) r0 = *(u8 *)skb[12]"},
+		{RawOpcodeInstruction(0xe5, R1, R0, 0, 0), "(e5) if r1 (null) 0x0 goto pc+0"},
+		{RawOpcodeInstruction(0x42, R1, R0, 0, 0), "BUG_st_42"},
+		{RawOpcodeInstruction(0x43, R1, R2, 0, 0), "BUG_43"},
+		{RawOpcodeInstruction(0xc3, R1, R2, 0, 0x02), "BUG_c3"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.want, func(t *testing.T) {
+			program := &pb.Program{Functions: []*pb.Functions{{Instructions: []*pb.Instruction{tc.instruction}}}}
+			got, err := DumpXlated(program)
+			if err != nil {
+				t.Fatalf("DumpXlated() unexpected error: %v", err)
+			}
+			if want := "   0: " + tc.want + "\n"; got != want {
+				t.Errorf("DumpXlated() = %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package grammar generates eBPF programs by expanding production rules built
+// on top of the ebpf instruction constructors.
+package grammar
+
+import (
+	"buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/rand"
+	pb "buzzer/proto/ebpf_go_proto"
+	"errors"
+	"fmt"
+	"math"
+
+	protobuf "github.com/golang/protobuf/proto"
+)
+
+const (
+	// DefaultMaxDepth is the default number of nested rule expansions
+	// after which Generate gives up.
+	DefaultMaxDepth = 64
+)
+
+var (
+	// ErrMaxDepth is returned when the expansion of a grammar nests
+	// deeper than its MaxDepth, usually because of unbounded recursion.
+	ErrMaxDepth = errors.New("Maximum grammar expansion depth reached")
+
+	// ErrBranchTooLong is returned when the body of a Branch is too long
+	// for its jump offset.
+	ErrBranchTooLong = errors.New("Branch body does not fit in a jump offset")
+)
+
+// Rule is a production rule that expands into a sequence of instructions.
+type Rule interface {
+	expand(g *Grammar, rng *rand.NumGen, depth int) ([]*pb.Instruction, error)
+}
+
+// Grammar is a set of named rules, programs are generated by expanding the
+// rule called Start.
+type Grammar struct {
+	// Start is the name of the rule programs are generated from.
+	Start string
+
+	// MaxDepth is the maximum number of nested rule expansions.
+	MaxDepth int
+
+	rules map[string]Rule
+}
+
+// NewGrammar returns an empty grammar that starts expanding from `start`.
+func NewGrammar(start string) *Grammar {
+	return &Grammar{
+		Start:    start,
+		MaxDepth: DefaultMaxDepth,
+		rules:    make(map[string]Rule),
+	}
+}
+
+// Define sets the production of the rule called `name`, replacing any
+// previous definition.
+func (g *Grammar) Define(name string, rule Rule) {
+	g.rules[name] = rule
+}
+
+// Generate expands `g` into a program with a single function.
+func Generate(g *Grammar, rng *rand.NumGen) (*pb.Program, error) {
+	instructions, err := Ref(g.Start).expand(g, rng, 0)
+	if err != nil {
+		return nil, err
+	}
+	instructions, err = ebpf.InstructionSequence(instructions...)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Program{
+		Functions: []*pb.Functions{
+			{Instructions: instructions},
+		},
+	}, nil
+}
+
+type ruleFunc func(g *Grammar, rng *rand.NumGen, depth int) ([]*pb.Instruction, error)
+
+func (f ruleFunc) expand(g *Grammar, rng *rand.NumGen, depth int) ([]*pb.Instruction, error) {
+	if depth > g.MaxDepth {
+		return nil, ErrMaxDepth
+	}
+	return f(g, rng, depth)
+}
+
+// Literal always expands to a copy of `instructions`.
+func Literal(instructions ...*pb.Instruction) Rule {
+	return ruleFunc(func(*Grammar, *rand.NumGen, int) ([]*pb.Instruction, error) {
+		result := []*pb.Instruction{}
+		for _, i := range instructions {
+			if i == nil {
+				return nil, ebpf.ErrNilInstruction
+			}
+			result = append(result, protobuf.Clone(i).(*pb.Instruction))
+		}
+		return result, nil
+	})
+}
+
+// Builder expands to whatever `build` returns, e.g. one of the random
+// instruction generators or a helper sequence like ebpf.LdMapElement.
+func Builder(build func(rng *rand.NumGen) ([]*pb.Instruction, error)) Rule {
+	return ruleFunc(func(_ *Grammar, rng *rand.NumGen, _ int) ([]*pb.Instruction, error) {
+		return build(rng)
+	})
+}
+
+// Ref expands the rule called `name` in the grammar, which allows rules to
+// be recursive.
+func Ref(name string) Rule {
+	return ruleFunc(func(g *Grammar, rng *rand.NumGen, depth int) ([]*pb.Instruction, error) {
+		rule, ok := g.rules[name]
+		if !ok {
+			return nil, fmt.Errorf("Undefined grammar rule %q", name)
+		}
+		return rule.expand(g, rng, depth+1)
+	})
+}
+
+// Seq expands all of `rules` one after the other.
+func Seq(rules ...Rule) Rule {
+	return ruleFunc(func(g *Grammar, rng *rand.NumGen, depth int) ([]*pb.Instruction, error) {
+		result := []*pb.Instruction{}
+		for _, rule := range rules {
+			instructions, err := rule.expand(g, rng, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, instructions...)
+		}
+		return result, nil
+	})
+}
+
+// Choice expands one of `rules` picked at random.
+func Choice(rules ...Rule) Rule {
+	return ruleFunc(func(g *Grammar, rng *rand.NumGen, depth int) ([]*pb.Instruction, error) {
+		if len(rules) == 0 {
+			return nil, fmt.Errorf("Choice needs at least one rule")
+		}
+		rule := rules[rng.RandRange(0, uint64(len(rules)-1))]
+		return rule.expand(g, rng, depth+1)
+	})
+}
+
+// Repeat expands `rule` between `min` and `max` times, both included.
+func Repeat(rule Rule, min, max int) Rule {
+	return ruleFunc(func(g *Grammar, rng *rand.NumGen, depth int) ([]*pb.Instruction, error) {
+		if min < 0 || max < min {
+			return nil, fmt.Errorf("Invalid repetition range [%d, %d]", min, max)
+		}
+		count := int(rng.RandRange(uint64(min), uint64(max)))
+		result := []*pb.Instruction{}
+		for i := 0; i < count; i++ {
+			instructions, err := rule.expand(g, rng, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, instructions...)
+		}
+		return result, nil
+	})
+}
+
+// Branch expands `body` and guards it with the conditional jump built by
+// `cond`, which is given the offset needed to skip the body. Bodies longer
+// than math.MaxInt16 encoded words fail with ErrBranchTooLong.
+func Branch(cond func(offset int16) *pb.Instruction, body Rule) Rule {
+	return ruleFunc(func(g *Grammar, rng *rand.NumGen, depth int) ([]*pb.Instruction, error) {
+		instructions, err := body.expand(g, rng, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		slots := ebpf.SlotCount(instructions)
+		if slots > math.MaxInt16 {
+			return nil, fmt.Errorf("%w: %d slots", ErrBranchTooLong, slots)
+		}
+		return append([]*pb.Instruction{cond(int16(slots))}, instructions...), nil
+	})
+}
@@ -0,0 +1,111 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grammar
+
+import (
+	"buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/rand"
+	pb "buzzer/proto/ebpf_go_proto"
+	"errors"
+	"math"
+	gorand "math/rand"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	rng := rand.NewRand(gorand.NewSource(0))
+
+	g := NewGrammar("program")
+	g.Define("program", Seq(
+		Literal(ebpf.Mov64(ebpf.R0, 0)),
+		Repeat(Ref("statement"), 1, 5),
+		Literal(ebpf.Exit()),
+	))
+	g.Define("statement", Choice(
+		Literal(ebpf.Add64(ebpf.R0, 1)),
+		Branch(func(offset int16) *pb.Instruction {
+			return ebpf.JmpEQ(ebpf.R0, 0, offset)
+		}, Repeat(Literal(ebpf.Sub64(ebpf.R0, 1)), 1, 3)),
+	))
+
+	for i := 0; i < 20; i++ {
+		program, err := Generate(g, rng)
+		if err != nil {
+			t.Fatalf("Generate() unexpected error: %v", err)
+		}
+		instructions := program.Functions[0].Instructions
+		if len(instructions) < 3 {
+			t.Fatalf("len(Generate()) = %d, want at least 3", len(instructions))
+		}
+		last := instructions[len(instructions)-1]
+		if last.GetJmpOpcode().GetOperationCode() != pb.JmpOperationCode_JmpExit {
+			t.Errorf("Generate() last instruction = %v, want exit", last)
+		}
+		// Every branch has to land inside the program.
+		for index, inst := range instructions {
+			if inst.GetJmpOpcode().GetOperationCode() == pb.JmpOperationCode_JmpJEQ {
+				if target := index + 1 + int(inst.Offset); target >= len(instructions) {
+					t.Errorf("Branch at %d jumps to %d, past the end of the program", index, target)
+				}
+			}
+		}
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	rng := rand.NewRand(gorand.NewSource(0))
+
+	recursive := NewGrammar("loop")
+	recursive.Define("loop", Seq(Literal(ebpf.Exit()), Ref("loop")))
+	if _, err := Generate(recursive, rng); !errors.Is(err, ErrMaxDepth) {
+		t.Errorf("Generate() of a recursive grammar error = %v, want %v", err, ErrMaxDepth)
+	}
+
+	undefined := NewGrammar("missing")
+	if _, err := Generate(undefined, rng); err == nil {
+		t.Errorf("Generate() of an undefined rule expected error, got nil")
+	}
+
+	empty := NewGrammar("empty")
+	empty.Define("empty", Repeat(Literal(ebpf.Exit()), 0, 0))
+	if _, err := Generate(empty, rng); !errors.Is(err, ebpf.ErrEmptySequence) {
+		t.Errorf("Generate() of an empty program error = %v, want %v", err, ebpf.ErrEmptySequence)
+	}
+
+	// A body of 16384 wide loads takes one more word than a jump offset
+	// can skip, one instruction less fits.
+	for _, c := range []struct {
+		loads   int
+		wantErr bool
+	}{
+		{math.MaxInt16 / 2, false},
+		{math.MaxInt16/2 + 1, true},
+	} {
+		long := NewGrammar("long")
+		long.Define("long", Seq(
+			Branch(func(offset int16) *pb.Instruction {
+				return ebpf.JmpEQ(ebpf.R0, 0, offset)
+			}, Repeat(Literal(ebpf.Mov64(ebpf.R1, int64(1)<<40)), c.loads, c.loads)),
+			Literal(ebpf.Exit()),
+		))
+		_, err := Generate(long, rng)
+		if c.wantErr && !errors.Is(err, ErrBranchTooLong) {
+			t.Errorf("Generate() with a body of %d wide loads error = %v, want %v", c.loads, err, ErrBranchTooLong)
+		}
+		if !c.wantErr && err != nil {
+			t.Errorf("Generate() with a body of %d wide loads unexpected error: %v", c.loads, err)
+		}
+	}
+}
@@ -44,6 +44,13 @@ var (
 // to prevent concurrent VMs from generating the same inputs
 type NumGen struct {
 	r *rand.Rand
+
+	// See StartRecording and NewReplayRand.
+	recording bool
+	trace     DecisionTrace
+	replaying bool
+	replay    DecisionTrace
+	replayErr error
 }
 
 // NewRand generates a new random number generator
@@ -57,12 +64,16 @@ var SharedRNG = NewRand(rand.NewSource(time.Now().Unix()))
 
 // RandRange returns a random 64-bit integer in the range of begin..end
 func (g *NumGen) RandRange(begin, end uint64) uint64 {
-	return begin + uint64(g.r.Intn(int(end-begin+1)))
+	return g.decide("RandRange", begin, end, func() uint64 {
+		return begin + uint64(g.r.Intn(int(end-begin+1)))
+	})
 }
 
 // OneOf returns true 1 out of n times
 func (g *NumGen) OneOf(n int) bool {
-	return g.r.Intn(n) == 0
+	return g.decide("OneOf", uint64(n), 0, func() uint64 {
+		return boolValue(g.r.Intn(n) == 0)
+	}) != 0
 }
 
 // NOutOf returns true n out of outOf times.
@@ -70,6 +81,12 @@ func (g *NumGen) NOutOf(n, outOf int) bool {
 	if n <= 0 || n >= outOf {
 		panic("bad probability")
 	}
+	return g.decide("NOutOf", uint64(n), uint64(outOf), func() uint64 {
+		return boolValue(g.nOutOf(n, outOf))
+	}) != 0
+}
+
+func (g *NumGen) nOutOf(n, outOf int) bool {
 	v := g.r.Intn(outOf)
 	return v < n
 }
@@ -77,20 +94,29 @@ func (g *NumGen) NOutOf(n, outOf int) bool {
 // RandInt is the preferred method for generating a random integer. It is biased towards
 // 'special' numbers such as 256, 4096, 1 << 31, 1 << 63 etc.
 func (g *NumGen) RandInt() uint64 {
-	v := uint64(g.r.Int63())
+	return g.decide("RandInt", 0, 0, func() uint64 {
+		v := uint64(g.r.Int63())
+
+		// All of these proababilities are subject to tuning and can be changed at any time for experiments
+		switch {
+		case g.nOutOf(3, 10):
+			v = specialInts[g.r.Intn(len(specialInts))]
+		case g.nOutOf(1, 10):
+			v %= 256
+		case g.nOutOf(1, 10):
+			v %= 64 << 10
+		case g.nOutOf(1, 10):
+			v %= 1 << 31
+		case g.nOutOf(1, 10):
+			v = uint64(-int64(v))
+		}
+		return v
+	})
+}
 
-	// All of these proababilities are subject to tuning and can be changed at any time for experiments
-	switch {
-	case g.NOutOf(3, 10):
-		v = specialInts[g.r.Intn(len(specialInts))]
-	case g.NOutOf(1, 10):
-		v %= 256
-	case g.NOutOf(1, 10):
-		v %= 64 << 10
-	case g.NOutOf(1, 10):
-		v %= 1 << 31
-	case g.NOutOf(1, 10):
-		v = uint64(-int64(v))
+func boolValue(b bool) uint64 {
+	if b {
+		return 1
 	}
-	return v
+	return 0
 }
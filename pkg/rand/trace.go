@@ -0,0 +1,110 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rand
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+)
+
+var (
+	// ErrTraceDiverged is returned by ReplayError when the generation asked
+	// for a different decision than the one recorded.
+	ErrTraceDiverged = errors.New("Generation diverged from the decision trace")
+)
+
+// Decision is the outcome of a single call to a NumGen method. Begin and
+// End hold the arguments of the call so a replay can tell if it is asked
+// the same question, booleans are stored as 0 or 1 in Value.
+type Decision struct {
+	Method string `json:"method"`
+	Begin  uint64 `json:"begin,omitempty"`
+	End    uint64 `json:"end,omitempty"`
+	Value  uint64 `json:"value"`
+}
+
+// DecisionTrace is the sequence of random decisions made while generating
+// a program, see StartRecording and NewReplayRand.
+type DecisionTrace []Decision
+
+// StartRecording makes `g` record every decision it makes from now on,
+// discarding any previous recording.
+func (g *NumGen) StartRecording() {
+	g.recording = true
+	g.trace = DecisionTrace{}
+}
+
+// StopRecording stops recording and returns the decisions made since
+// StartRecording.
+func (g *NumGen) StopRecording() DecisionTrace {
+	trace := g.trace
+	g.recording = false
+	g.trace = nil
+	return trace
+}
+
+// NewReplayRand returns a NumGen that makes the decisions in `trace`
+// again, in order. Unlike replaying a seed this gives the same outcomes even
+// if the way NumGen derives them from its source changes, e.g. if RandInt
+// is biased differently.
+//
+// As soon as it is asked for a decision that doesn't match the next one in
+// `trace`, or there are none left, it stops replaying and falls back to a
+// source seeded with 0, see ReplayError.
+func NewReplayRand(trace DecisionTrace) *NumGen {
+	g := NewRand(rand.NewSource(0))
+	g.replaying = true
+	g.replay = trace
+	return g
+}
+
+// ReplayError returns a wrapped ErrTraceDiverged if the decisions made by
+// a NumGen from NewReplayRand didn't match its trace, including when only
+// part of the trace was used. It returns nil for other generators.
+func (g *NumGen) ReplayError() error {
+	if g.replayErr != nil {
+		return g.replayErr
+	}
+	if g.replaying && len(g.replay) > 0 {
+		return fmt.Errorf("%w: %d decisions were not used", ErrTraceDiverged, len(g.replay))
+	}
+	return nil
+}
+
+// decide returns the value of the next decision, taking it from the trace
+// being replayed if any and otherwise from `choose`.
+func (g *NumGen) decide(method string, begin, end uint64, choose func() uint64) uint64 {
+	if g.replaying {
+		if len(g.replay) > 0 {
+			next := g.replay[0]
+			if next.Method == method && next.Begin == begin && next.End == end {
+				g.replay = g.replay[1:]
+				return next.Value
+			}
+			g.replayErr = fmt.Errorf("%w: got %s(%d, %d), want %s(%d, %d)", ErrTraceDiverged, method, begin, end, next.Method, next.Begin, next.End)
+		} else {
+			g.replayErr = fmt.Errorf("%w: ran out of decisions at %s(%d, %d)", ErrTraceDiverged, method, begin, end)
+		}
+		g.replaying = false
+		g.replay = nil
+	}
+
+	value := choose()
+	if g.recording {
+		g.trace = append(g.trace, Decision{Method: method, Begin: begin, End: end, Value: value})
+	}
+	return value
+}
@@ -0,0 +1,232 @@
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+	pb "buzzer/proto/program_go_proto"
+	"fmt"
+	"math"
+)
+
+// maxConvergentDiamonds caps how many diamonds a program generated by the
+// convergent branch strategy has.
+const maxConvergentDiamonds = 64
+
+// convergentRegisters are the registers the arms of the diamonds write to,
+// R6 and R7 hold the unknown values the diamonds branch on.
+var convergentRegisters = []epb.Reg{R0, R1, R2, R3, R4, R5}
+
+func NewConvergentBranchStrategy() *ConvergentBranch {
+	return &ConvergentBranch{isFinished: false, mapFd: -1}
+}
+
+// ConvergentBranch is a strategy that stresses the state pruning of the
+// verifier: programs are chains of diamonds, conditional jumps on values
+// the verifier can't know whose two arms set the same registers to the
+// same values in a different way before merging. Once the first arm has
+// been explored, the verifier should find the state of the second one
+// equivalent at the merge point and prune it.
+//
+// The registers are then compared with the values they should hold and
+// the difference is added to a map value pointer like in
+// PointerArithmetic, so OnExecuteDone detects programs that compute
+// something else than what the verifier expected.
+type ConvergentBranch struct {
+	isFinished        bool
+	mapFd             int
+	programCount      int
+	validProgramCount int
+}
+
+// convergentArm returns instructions that set `regs[i]` to `values[i]`,
+// in a random order and either with a single mov or with a mov and an add,
+// so the two arms of a diamond rarely look the same.
+func convergentArm(regs []epb.Reg, values []int32) []*epb.Instruction {
+	order := make([]int, len(regs))
+	for i := range order {
+		order[i] = i
+	}
+	for i := len(order) - 1; i > 0; i-- {
+		j := int(rand.SharedRNG.RandRange(0, uint64(i)))
+		order[i], order[j] = order[j], order[i]
+	}
+
+	arm := []*epb.Instruction{}
+	for _, i := range order {
+		if rand.SharedRNG.OneOf(2) {
+			arm = append(arm, Mov64(regs[i], values[i]))
+			continue
+		}
+		delta := int32(rand.SharedRNG.RandRange(0, math.MaxInt16))
+		arm = append(arm, Mov64(regs[i], values[i]-delta), Add64(regs[i], delta))
+	}
+	return arm
+}
+
+// convergentDiamond returns a conditional jump on `cond` whose two arms set
+// random registers to the same random values, along with the values.
+func convergentDiamond(cond epb.Reg) ([]*epb.Instruction, map[epb.Reg]int32) {
+	regs := []epb.Reg{}
+	values := []int32{}
+	set := make(map[epb.Reg]int32)
+	for _, reg := range convergentRegisters {
+		if !rand.SharedRNG.OneOf(2) {
+			continue
+		}
+		value := int32(rand.SharedRNG.RandRange(0, math.MaxInt16))
+		regs = append(regs, reg)
+		values = append(values, value)
+		set[reg] = value
+	}
+
+	taken := convergentArm(regs, values)
+	notTaken := convergentArm(regs, values)
+	diamond := []*epb.Instruction{RandomConditionalJump(cond, RandomImmediate(), int16(len(notTaken)+1))}
+	diamond = append(diamond, notTaken...)
+	diamond = append(diamond, Jmp(int16(len(taken))))
+	return append(diamond, taken...), set
+}
+
+// convergentProgram returns a program with `count` diamonds that branch on
+// the value at index 0 of `mapFd`, stores 0xCAFE at that index and then
+// again at index 1, offset by how much the registers differ from the
+// values the diamonds set them to.
+func convergentProgram(mapFd int, count int) ([]*epb.Instruction, error) {
+	header, err := InstructionSequence(
+		LdMapByFd(R9, mapFd),
+		StW(R10, 0, -4),
+		Mov64(R2, R10),
+		Add64(R2, -4),
+		Mov64(R1, R9),
+		Call(MapLookup),
+		JmpNE(R0, 0, 1),
+		Exit(),
+		LdDW(R6, R0, 0),
+		Mov64(R7, R6),
+		Rsh64(R7, int32(rand.SharedRNG.RandRange(1, 63))),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := make(map[epb.Reg]int32)
+	for _, reg := range convergentRegisters {
+		expected[reg] = int32(rand.SharedRNG.RandRange(0, math.MaxInt16))
+		header = append(header, Mov64(reg, expected[reg]))
+	}
+
+	body := []*epb.Instruction{}
+	for d := 0; d < count; d++ {
+		cond := R6
+		if rand.SharedRNG.OneOf(2) {
+			cond = R7
+		}
+		diamond, set := convergentDiamond(cond)
+		body = append(body, diamond...)
+		for reg, value := range set {
+			expected[reg] = value
+		}
+	}
+
+	footer := []*epb.Instruction{Mov64(R8, 0)}
+	for _, reg := range convergentRegisters {
+		footer = append(footer, Sub64(reg, expected[reg]), Add64(R8, reg))
+	}
+	tail, err := InstructionSequence(
+		StW(R10, 0, -4),
+		Mov64(R2, R10),
+		Add64(R2, -4),
+		Mov64(R1, R9),
+		Call(MapLookup),
+		JmpNE(R0, 0, 1),
+		Exit(),
+		StDW(R0, 0xCAFE, 0),
+
+		StW(R10, 1, -4),
+		Mov64(R2, R10),
+		Add64(R2, -4),
+		Mov64(R1, R9),
+		Call(MapLookup),
+		JmpNE(R0, 0, 1),
+		Exit(),
+		Add64(R0, R8),
+		StDW(R0, 0xCAFE, 0),
+
+		Mov64(R0, 0),
+		Exit(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(append(header, body...), footer...), tail...), nil
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (cb *ConvergentBranch) GenerateProgram(ffi *units.FFI) (*pb.Program, error) {
+	cb.programCount += 1
+	fmt.Printf("Generated %d programs, %d were valid               \r", cb.programCount, cb.validProgramCount)
+
+	ffi.CloseFD(cb.mapFd)
+	cb.mapFd = ffi.CreateMapArray(2)
+	if cb.mapFd < 0 {
+		return nil, mapCreationFailed
+	}
+
+	count := int(rand.SharedRNG.RandRange(1, maxConvergentDiamonds))
+	instructions, err := convergentProgram(cb.mapFd, count)
+	if err != nil {
+		return nil, err
+	}
+	prog := &pb.Program{
+		Program: &pb.Program_Ebpf{
+			Ebpf: &epb.Program{
+				Functions: []*epb.Functions{
+					{Instructions: instructions},
+				},
+			},
+		}}
+	return prog, nil
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (cb *ConvergentBranch) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if verificationResult.IsValid {
+		cb.validProgramCount += 1
+	}
+	return verificationResult.IsValid
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+func (cb *ConvergentBranch) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	mapElements, err := ffi.GetMapElements(cb.mapFd, 2)
+	if err != nil {
+		fmt.Println(err)
+		return true
+	}
+
+	return mapElements.Elements[0] == mapElements.Elements[1]
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (cb *ConvergentBranch) OnError(e error) bool {
+	fmt.Printf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (cb *ConvergentBranch) IsFuzzingDone() bool {
+	return cb.isFinished
+}
+
+// StrategyName is used for strategy selection via runtime flags.
+func (cb *ConvergentBranch) Name() string {
+	return "convergent_branch"
+}
@@ -0,0 +1,35 @@
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	"testing"
+)
+
+func TestConvergentProgram(t *testing.T) {
+	for count := 1; count <= maxConvergentDiamonds; count *= 2 {
+		instructions, err := convergentProgram(3, count)
+		if err != nil {
+			t.Fatalf("convergentProgram() unexpected error: %v", err)
+		}
+		if err := Validate(instructions); err != nil {
+			t.Errorf("convergentProgram(%d) is invalid: %v", count, err)
+		}
+
+		// Every diamond branches on one of the unknown values and its
+		// arms merge right after the second one.
+		diamonds := 0
+		for index, i := range instructions {
+			if i.GetJmpOpcode() == nil || (i.DstReg != R6 && i.DstReg != R7) {
+				continue
+			}
+			diamonds++
+			skip := instructions[index+int(i.Offset)]
+			if skip.GetJmpOpcode() == nil || skip.Offset < 0 {
+				t.Errorf("convergentProgram(%d) diamond at %d has no jump over its second arm, got %v", count, index, skip)
+			}
+		}
+		if diamonds != count {
+			t.Errorf("convergentProgram(%d) has %d diamonds, want %d", count, diamonds, count)
+		}
+	}
+}
@@ -28,10 +28,23 @@ const (
 
 // Factory method to create a new coverage based strategy.
 func NewCoverageBasedStrategy() *CoverageBased {
+	return &CoverageBased{
+		isFinished:           false,
+		pq:                   NewPriorityQueue(),
+		coverageHashTable:    make(map[uint64]bool),
+		fingerprintHashTable: make(map[uint64]bool),
+		programCount:         0,
+		validProgramCount:    0,
+		mapFd:                -1,
+	}
+}
 
+// coverageDefaultProgram returns the program that is mutated when there are
+// no programs in the queue.
+func coverageDefaultProgram() ([]*epb.Instruction, error) {
 	// The default program simply initializes the registers to a random
 	// value as well as all stack locations from -8 to -512.
-	defaultProg, _ := InstructionSequence(
+	defaultProg, err := InstructionSequence(
 		// Need to patch the fd on every run of prog generation.
 		LdMapByFd(R1, 0),
 		StW(R10, 0, -4),
@@ -51,19 +64,13 @@ func NewCoverageBasedStrategy() *CoverageBased {
 		Mov64(R8, int(rand.SharedRNG.RandInt())),
 		Mov64(R9, int(rand.SharedRNG.RandInt())),
 	)
+	if err != nil {
+		return nil, err
+	}
 	for i := 16; i <= 512; i += 8 {
 		defaultProg = append(defaultProg, StDW(R10, R0, int16(i*-1)))
 	}
-	return &CoverageBased{
-		isFinished:           false,
-		pq:                   NewPriorityQueue(),
-		coverageHashTable:    make(map[uint64]bool),
-		fingerprintHashTable: make(map[uint64]bool),
-		programCount:         0,
-		validProgramCount:    0,
-		mapFd:                -1,
-		defaultProg:          defaultProg,
-	}
+	return defaultProg, nil
 }
 
 // CoverageBased is a strategy that seeks to maximize the number of verifier
@@ -118,7 +125,15 @@ func duplicateProgram(prog []*epb.Instruction) []*epb.Instruction {
 	return ret
 }
 
-func newRandomInstruction(maxJmp uint64) *epb.Instruction {
+// newRandomInstruction returns a random instruction to be placed right before
+// instruction `index` of `program`, followed by `following`. Register jumps
+// compare registers holding related values at that point, see
+// RandomRegisterJump.
+//
+// Jumps skip at most maxJmp of the following instructions, their offset is
+// then counted in encoded words: wide instructions take more than one and a
+// jump must not land inside of them.
+func newRandomInstruction(program []*epb.Instruction, index int, following []*epb.Instruction, maxJmp uint64) *epb.Instruction {
 	instructionType := rand.SharedRNG.RandInt() % 3
 	switch instructionType {
 	case ALU_OPERATION:
@@ -127,7 +142,12 @@ func newRandomInstruction(maxJmp uint64) *epb.Instruction {
 		if maxJmp == 0 {
 			return RandomAluInstruction()
 		}
-		return RandomJmpInstruction(maxJmp)
+		skip := rand.SharedRNG.RandRange(1, maxJmp)
+		offset := int16(SlotCount(following[:skip]))
+		if rand.SharedRNG.OneOf(2) {
+			return RandomConditionalJump(RandomRegister(), RandomImmediate(), offset)
+		}
+		return RandomRegisterJump(program, index, offset)
 	case MEM_OPERATION:
 		return RandomMemInstruction()
 	default:
@@ -135,8 +155,12 @@ func newRandomInstruction(maxJmp uint64) *epb.Instruction {
 	}
 }
 
-func handleAddInstruction(prog []*epb.Instruction) ([]*epb.Instruction, error) {
+// handleAddInstruction inserts a random instruction in `prog`, the body that
+// follows `head`.
+func handleAddInstruction(head []*epb.Instruction, prog []*epb.Instruction) ([]*epb.Instruction, error) {
+	program := append(append([]*epb.Instruction{}, head...), prog...)
 	pos := uint64(rand.SharedRNG.RandInt()) % uint64(len(prog)+1)
+	index := len(head) + int(pos)
 	var maxJmp uint64
 	if pos == 0 {
 		if len(prog) > 0 {
@@ -144,10 +168,10 @@ func handleAddInstruction(prog []*epb.Instruction) ([]*epb.Instruction, error) {
 		} else {
 			maxJmp = 0
 		}
-		newInstr := newRandomInstruction(maxJmp)
+		newInstr := newRandomInstruction(program, index, prog, maxJmp)
 		return append([]*epb.Instruction{newInstr}, prog...), nil
 	} else if pos == uint64(len(prog)) {
-		newInstr := newRandomInstruction(0)
+		newInstr := newRandomInstruction(program, index, nil, 0)
 		return append(prog, newInstr), nil
 	} else {
 		if len(prog) > 0 {
@@ -155,19 +179,24 @@ func handleAddInstruction(prog []*epb.Instruction) ([]*epb.Instruction, error) {
 		} else {
 			maxJmp = 0
 		}
-		newInstr := newRandomInstruction(maxJmp)
-		newProg := append(prog[:pos], newInstr)
+		newInstr := newRandomInstruction(program, index, prog[pos:], maxJmp)
+		// Copy the head, appending to prog[:pos] would overwrite prog[pos].
+		newProg := append([]*epb.Instruction{}, prog[:pos]...)
+		newProg = append(newProg, newInstr)
 		return append(newProg, prog[pos:]...), nil
 	}
 }
 
-func handleModifyInstruction(prog []*epb.Instruction) ([]*epb.Instruction, error) {
+// handleModifyInstruction replaces a random instruction of `prog`, the body
+// that follows `head`.
+func handleModifyInstruction(head []*epb.Instruction, prog []*epb.Instruction) ([]*epb.Instruction, error) {
 	if len(prog) == 0 {
-		return handleAddInstruction(prog)
+		return handleAddInstruction(head, prog)
 	}
+	program := append(append([]*epb.Instruction{}, head...), prog...)
 	pos := uint64(rand.SharedRNG.RandInt()) % uint64(len(prog))
 	maxJmp := uint64(uint64(len(prog)) - pos - 1)
-	newInstr := newRandomInstruction(maxJmp)
+	newInstr := newRandomInstruction(program, len(head)+int(pos), prog[pos+1:], maxJmp)
 	prog[pos] = newInstr
 	return prog, nil
 }
@@ -179,9 +208,9 @@ func mutateProgram(prog []*epb.Instruction, headSize int) ([]*epb.Instruction, e
 	var err error = nil
 	switch operation {
 	case OPERATION_ADD:
-		progBody, err = handleAddInstruction(progBody)
+		progBody, err = handleAddInstruction(progHead, progBody)
 	case OPERATION_MODIFY:
-		progBody, err = handleModifyInstruction(progBody)
+		progBody, err = handleModifyInstruction(progHead, progBody)
 	default:
 		return nil, unknownOperation
 	}
@@ -196,6 +225,16 @@ func (cv *CoverageBased) GenerateProgram(ffi *units.FFI) (*pb.Program, error) {
 	fmt.Printf("Program count: %d, Valid Programs: %d, Queue len: %d\t\t\r", cv.programCount, cv.validProgramCount, cv.pq.Len())
 	cv.programCount = cv.programCount + 1
 
+	// The default program is built on first use so that an error building
+	// it reaches the control unit like any other generation error.
+	if cv.defaultProg == nil {
+		defaultProg, err := coverageDefaultProgram()
+		if err != nil {
+			return nil, err
+		}
+		cv.defaultProg = defaultProg
+	}
+
 	// If there are no programs in the queue, reuse the default program.
 	var progHead []*epb.Instruction
 	if cv.pq.IsEmpty() {
@@ -230,11 +269,20 @@ func (cv *CoverageBased) GenerateProgram(ffi *units.FFI) (*pb.Program, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	// Mutations can leave instructions that are never reached, which the
+	// verifier rejects right away.
+	instructions, err := RemoveDeadCodeAfterExit(append(mutatedProgram, footer...))
+	if err != nil {
+		return nil, err
+	}
+	// Random instructions must not be what first reads R0.
+	instructions = GuardR0(instructions)
 	prog := &pb.Program{
 		Program: &pb.Program_Ebpf{
 			Ebpf: &epb.Program{
 				Functions: []*epb.Functions{
-					{Instructions: append(mutatedProgram, footer...)},
+					{Instructions: instructions},
 				},
 			},
 		},
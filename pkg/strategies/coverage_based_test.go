@@ -0,0 +1,154 @@
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/rand"
+	epb "buzzer/proto/ebpf_go_proto"
+	gorand "math/rand"
+	"testing"
+)
+
+// wideProgram has two 64 bit immediate loads, each taking two encoded words,
+// so random jumps inserted before them have to skip over one.
+func wideProgram() []*epb.Instruction {
+	return []*epb.Instruction{
+		Mov64(R0, 0),
+		Mov64(R1, int64(1)<<40),
+		Mov64(R2, 0),
+		Mov64(R3, int64(1)<<40),
+		Mov64(R4, 0),
+		Exit(),
+	}
+}
+
+// checkJumpTargets reports any jump of `prog` landing in the middle of a
+// wide instruction and returns how many jumps skip over one.
+func checkJumpTargets(t *testing.T, name string, prog []*epb.Instruction) int {
+	t.Helper()
+	starts := map[int]bool{}
+	for k := 0; k <= len(prog); k++ {
+		starts[SlotCount(prog[:k])] = true
+	}
+	skippedWide := 0
+	for j, i := range prog {
+		if i.GetJmpOpcode() == nil || i.Offset == 0 {
+			continue
+		}
+		slot := SlotCount(prog[:j+1])
+		target := slot + int(i.Offset)
+		if !starts[target] {
+			t.Errorf("%s: jump %d with offset %d lands inside of an instruction", name, j, i.Offset)
+		}
+		for k := j + 1; k < len(prog) && SlotCount(prog[:k]) < target; k++ {
+			if SlotCount(prog[k:k+1]) > 1 {
+				skippedWide++
+				break
+			}
+		}
+	}
+	return skippedWide
+}
+
+func TestMutationJumpOffsets(t *testing.T) {
+	saved := rand.SharedRNG
+	defer func() { rand.SharedRNG = saved }()
+
+	for _, c := range []struct {
+		name   string
+		mutate func([]*epb.Instruction, []*epb.Instruction) ([]*epb.Instruction, error)
+	}{
+		{"handleAddInstruction", handleAddInstruction},
+		{"handleModifyInstruction", handleModifyInstruction},
+	} {
+		skippedWide := 0
+		for seed := int64(0); seed < 500; seed++ {
+			rand.SharedRNG = rand.NewRand(gorand.NewSource(seed))
+			prog := wideProgram()
+			got, err := c.mutate(nil, prog)
+			if err != nil {
+				t.Fatalf("%s() unexpected error: %v", c.name, err)
+			}
+			skippedWide += checkJumpTargets(t, c.name, got)
+		}
+		if skippedWide == 0 {
+			t.Errorf("%s() never generated a jump over a wide instruction", c.name)
+		}
+	}
+}
+
+func TestHandleAddInstructionKeepsProgram(t *testing.T) {
+	saved := rand.SharedRNG
+	defer func() { rand.SharedRNG = saved }()
+
+	for seed := int64(0); seed < 100; seed++ {
+		rand.SharedRNG = rand.NewRand(gorand.NewSource(seed))
+		prog := wideProgram()
+		want := duplicateProgram(prog)
+		got, err := handleAddInstruction(nil, prog)
+		if err != nil {
+			t.Fatalf("handleAddInstruction() unexpected error: %v", err)
+		}
+		if len(got) != len(want)+1 {
+			t.Fatalf("handleAddInstruction() returned %d instructions, want %d", len(got), len(want)+1)
+		}
+		// Every original instruction is still there, in order.
+		k := 0
+		for _, i := range got {
+			if k < len(want) && i.String() == want[k].String() {
+				k++
+			}
+		}
+		if k != len(want) {
+			t.Errorf("handleAddInstruction() lost instructions of the original program:\ngot %v\nwant %v", got, want)
+		}
+	}
+}
+
+func TestMutationRegisterJumps(t *testing.T) {
+	saved := rand.SharedRNG
+	defer func() { rand.SharedRNG = saved }()
+
+	// R1, R6 and R10 hold pointers, R7 and R8 scalars when the body starts.
+	head := []*epb.Instruction{
+		Mov64(R6, R10),
+		Add64(R6, -8),
+		Mov64(R7, 5),
+		Mov64(R8, 7),
+	}
+	pointers := RegisterSet(0).Add(R1).Add(R6).Add(R10)
+	for _, c := range []struct {
+		name   string
+		mutate func([]*epb.Instruction, []*epb.Instruction) ([]*epb.Instruction, error)
+	}{
+		{"handleAddInstruction", handleAddInstruction},
+		{"handleModifyInstruction", handleModifyInstruction},
+	} {
+		registerJumps := 0
+		for seed := int64(0); seed < 500; seed++ {
+			rand.SharedRNG = rand.NewRand(gorand.NewSource(seed))
+			body := []*epb.Instruction{Mov64(R0, 0), Mov64(R0, 1), Exit()}
+			got, err := c.mutate(head, body)
+			if err != nil {
+				t.Fatalf("%s() unexpected error: %v", c.name, err)
+			}
+			program := append(append([]*epb.Instruction{}, head...), got...)
+			for index, i := range program {
+				jmp := i.GetJmpOpcode()
+				if jmp == nil || jmp.Source != epb.SrcOperand_RegSrc || i.DstReg == i.SrcReg {
+					continue
+				}
+				registerJumps++
+				defined := DefinedRegistersAt(program, index)
+				if !defined.Contains(i.DstReg) || !defined.Contains(i.SrcReg) {
+					t.Errorf("%s() = %v, compares an uninitialized register", c.name, i)
+				}
+				if pointers.Contains(i.DstReg) != pointers.Contains(i.SrcReg) {
+					t.Errorf("%s() = %v, compares a pointer with a scalar", c.name, i)
+				}
+			}
+		}
+		if registerJumps == 0 {
+			t.Errorf("%s() never generated a register jump", c.name)
+		}
+	}
+}
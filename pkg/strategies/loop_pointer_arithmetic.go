@@ -151,7 +151,7 @@ func (lp *LoopPointerArithmetic) GenerateProgram(ffi *units.FFI) (*pb.Program, e
 	ffi.CloseFD(lp.mapFd)
 	lp.mapFd = mapFd
 
-	mainBody, _ := InstructionSequence(
+	mainBody, err := InstructionSequence(
 		// Load a fd to the map.
 		LdMapByFd(R9, mapFd), // R9 = Map File Descriptor
 		// Begin by writing a value to the map without ptr arithmetic.
@@ -180,8 +180,11 @@ func (lp *LoopPointerArithmetic) GenerateProgram(ffi *units.FFI) (*pb.Program, e
 		Mov64(R0, 0),
 		Exit(), // return 0
 	)
+	if err != nil {
+		return nil, err
+	}
 
-	mainHeader, _ := InstructionSequence(
+	mainHeader, err := InstructionSequence(
 		// Set up and call loop function
 		StW(R10, 0, -4),                       // Stack[-4] = 0
 		StW(R10, 0, -12),                      // Stack[-12] = 0
@@ -190,16 +193,22 @@ func (lp *LoopPointerArithmetic) GenerateProgram(ffi *units.FFI) (*pb.Program, e
 		Mov(R1, 10),                           // R1 = 10 (param 1, # iterations)
 		Mov(R4, 0),                            // R4 = 0 (param 4, flags)
 		LdFunctionPtr(int32(len(mainBody)+3)), // R2 = func (param 2)
-		Call(181),                             // Call loop
+		Call(Loop),                            // Call loop
 	)
+	if err != nil {
+		return nil, err
+	}
 	main := append(mainHeader, mainBody...)
 
-	loopFuncHead, _ := InstructionSequence(
+	loopFuncHead, err := InstructionSequence(
 		StDW(R10, R2, -8),
 	)
+	if err != nil {
+		return nil, err
+	}
 
 	instructionCount := rand.SharedRNG.RandInt() % 1000
-	loopFuncBody, _ := InstructionSequence(
+	loopFuncBody, err := InstructionSequence(
 		Mov64(R0, int32(rand.SharedRNG.RandInt())),
 		Mov64(R2, int32(rand.SharedRNG.RandInt())),
 		Mov64(R3, int32(rand.SharedRNG.RandInt())),
@@ -210,6 +219,9 @@ func (lp *LoopPointerArithmetic) GenerateProgram(ffi *units.FFI) (*pb.Program, e
 		Mov64(R8, int32(rand.SharedRNG.RandInt())),
 		Mov64(R9, int32(rand.SharedRNG.RandInt())),
 	)
+	if err != nil {
+		return nil, err
+	}
 	for instructionCount != 0 {
 		instructionCount -= 1
 		var instruction *epb.Instruction
@@ -220,7 +232,7 @@ func (lp *LoopPointerArithmetic) GenerateProgram(ffi *units.FFI) (*pb.Program, e
 		}
 		switch t {
 		case 0:
-			instructions, _ := InstructionSequence(
+			instructions, err := InstructionSequence(
 				LdDW(R2, R10, -8),
 				And(randReg, 0x3f),
 				Mul64(randReg, -1),
@@ -228,6 +240,9 @@ func (lp *LoopPointerArithmetic) GenerateProgram(ffi *units.FFI) (*pb.Program, e
 				StB(R2, 16, 0),
 				Mov64(R2, int32(rand.SharedRNG.RandInt())),
 			)
+			if err != nil {
+				return nil, err
+			}
 			loopFuncBody = append(loopFuncBody, instructions...)
 		case 1:
 			instruction = RandomLoadInstruction()
@@ -245,10 +260,13 @@ func (lp *LoopPointerArithmetic) GenerateProgram(ffi *units.FFI) (*pb.Program, e
 		}
 	}
 
-	loopFuncFoo, _ := InstructionSequence(
+	loopFuncFoo, err := InstructionSequence(
 		Mov(R0, 0),
 		Exit(),
 	)
+	if err != nil {
+		return nil, err
+	}
 
 	loopFunc := append(loopFuncHead, loopFuncBody...)
 	loopFunc = append(loopFunc, loopFuncFoo...)
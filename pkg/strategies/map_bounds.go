@@ -0,0 +1,189 @@
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+	pb "buzzer/proto/program_go_proto"
+	"fmt"
+)
+
+// mapBoundsValueSize is the value_size of the array maps created through
+// the ffi, every element is a u64.
+const mapBoundsValueSize = 8
+
+// mapBoundsWidths are the sizes of the stores MapBounds generates.
+var mapBoundsWidths = []int{1, 2, 4, 8}
+
+func NewMapBoundsStrategy() *MapBounds {
+	return &MapBounds{isFinished: false, mapFd: -1}
+}
+
+// MapBounds is a strategy that concentrates on the bounds checks of map
+// value accesses: every program stores to the value of element 0 of an
+// array map at an offset whose end is bounded to value_size - 1,
+// value_size or value_size + 1, so the access is either barely in bounds
+// or barely out of them. The bound is enforced in a different way each
+// time, with unsigned or signed jumps, a mask or a constant, and part of
+// the offset can be moved to the off field of the store.
+//
+// Element 0 is set to the bound before running the program, so accepted
+// programs store as far as the verifier allowed them to. The elements are
+// contiguous, so a store past the value overwrites element 1, which
+// OnExecuteDone detects.
+type MapBounds struct {
+	isFinished        bool
+	mapFd             int
+	programCount      int
+	validProgramCount int
+}
+
+// mapBoundsStore returns a store of -1, which sets every byte it writes,
+// of `width` bytes at `dst` + `offset`.
+func mapBoundsStore(width int, dst epb.Reg, offset int16) *epb.Instruction {
+	switch width {
+	case 1:
+		return StB(dst, int32(-1), offset)
+	case 2:
+		return StH(dst, int32(-1), offset)
+	case 4:
+		return StW(dst, int32(-1), offset)
+	default:
+		return StDW(dst, int32(-1), offset)
+	}
+}
+
+// mapBoundsCheck returns instructions that make the verifier know R7 is in
+// [0, bound], exiting otherwise. R0 must be a scalar.
+func mapBoundsCheck(bound int32) []*epb.Instruction {
+	method := rand.SharedRNG.RandRange(0, 3)
+	if method == 2 && bound&(bound+1) != 0 {
+		// Only bounds of the form 2^n - 1 can be enforced with a mask.
+		method = 0
+	}
+	switch method {
+	case 0:
+		return []*epb.Instruction{JmpLE(R7, bound, 1), Exit()}
+	case 1:
+		return []*epb.Instruction{JmpSLE(R7, bound, 1), Exit(), JmpSGE(R7, 0, 1), Exit()}
+	case 2:
+		return []*epb.Instruction{And64(R7, bound)}
+	default:
+		return []*epb.Instruction{Mov64(R7, bound)}
+	}
+}
+
+// mapBoundsProgram returns a program that stores at the value of element 0
+// of `mapFd` offset by the value read from it, along with the largest value
+// the program lets through, which is what element 0 should be set to, and
+// how far into the map value the store ends in that case.
+func mapBoundsProgram(mapFd int) ([]*epb.Instruction, int32, int, error) {
+	width := mapBoundsWidths[rand.SharedRNG.RandRange(0, uint64(len(mapBoundsWidths)-1))]
+	ends := []int{}
+	for end := mapBoundsValueSize - 1; end <= mapBoundsValueSize+1; end++ {
+		if end >= width {
+			ends = append(ends, end)
+		}
+	}
+	end := ends[rand.SharedRNG.RandRange(0, uint64(len(ends)-1))]
+	maxOffset := end - width
+	fixed := int(rand.SharedRNG.RandRange(0, uint64(maxOffset)))
+	bound := int32(maxOffset - fixed)
+
+	header, err := InstructionSequence(
+		LdMapByFd(R9, mapFd),
+		StW(R10, 0, -4),
+		Mov64(R2, R10),
+		Add64(R2, -4),
+		Mov64(R1, R9),
+		Call(MapLookup),
+		JmpNE(R0, 0, 1),
+		Exit(),
+		Mov64(R6, R0),
+		LdDW(R7, R6, 0),
+		Mov64(R0, 0),
+	)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	footer, err := InstructionSequence(
+		Add64(R6, R7),
+		mapBoundsStore(width, R6, int16(fixed)),
+		Exit(),
+	)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return append(append(header, mapBoundsCheck(bound)...), footer...), bound, end, nil
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (mb *MapBounds) GenerateProgram(ffi *units.FFI) (*pb.Program, error) {
+	mb.programCount += 1
+	fmt.Printf("Generated %d programs, %d were valid               \r", mb.programCount, mb.validProgramCount)
+
+	ffi.CloseFD(mb.mapFd)
+	mb.mapFd = ffi.CreateMapArray(2)
+	if mb.mapFd < 0 {
+		return nil, mapCreationFailed
+	}
+
+	instructions, offset, _, err := mapBoundsProgram(mb.mapFd)
+	if err != nil {
+		return nil, err
+	}
+	if ffi.SetMapElement(mb.mapFd, 0, uint64(offset)) < 0 {
+		return nil, fmt.Errorf("Unable to set map element 0 to %d", offset)
+	}
+	prog := &pb.Program{
+		Program: &pb.Program_Ebpf{
+			Ebpf: &epb.Program{
+				Functions: []*epb.Functions{
+					{Instructions: instructions},
+				},
+			},
+		}}
+	return prog, nil
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (mb *MapBounds) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if verificationResult.IsValid {
+		mb.validProgramCount += 1
+	}
+	return verificationResult.IsValid
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+func (mb *MapBounds) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	mapElements, err := ffi.GetMapElements(mb.mapFd, 2)
+	if err != nil {
+		fmt.Println(err)
+		return true
+	}
+
+	return mapElements.Elements[1] == 0
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (mb *MapBounds) OnError(e error) bool {
+	fmt.Printf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (mb *MapBounds) IsFuzzingDone() bool {
+	return mb.isFinished
+}
+
+// StrategyName is used for strategy selection via runtime flags.
+func (mb *MapBounds) Name() string {
+	return "map_bounds"
+}
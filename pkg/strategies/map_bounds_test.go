@@ -0,0 +1,35 @@
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+	"testing"
+)
+
+func TestMapBoundsProgram(t *testing.T) {
+	for run := 0; run < 100; run++ {
+		instructions, offset, end, err := mapBoundsProgram(3)
+		if err != nil {
+			t.Fatalf("mapBoundsProgram() unexpected error: %v", err)
+		}
+		if err := Validate(instructions); err != nil {
+			t.Errorf("mapBoundsProgram() is invalid: %v", err)
+		}
+		if end < mapBoundsValueSize-1 || end > mapBoundsValueSize+1 {
+			t.Errorf("mapBoundsProgram() store ends at %d, want within one byte of %d", end, mapBoundsValueSize)
+		}
+
+		// The store is the second to last instruction, the offset in the
+		// register plus its own and its width give the end.
+		store := instructions[len(instructions)-2]
+		width := map[epb.StLdSize]int{
+			epb.StLdSize_StLdSizeB:  1,
+			epb.StLdSize_StLdSizeH:  2,
+			epb.StLdSize_StLdSizeW:  4,
+			epb.StLdSize_StLdSizeDW: 8,
+		}[store.GetMemOpcode().GetSize()]
+		if got := int(offset) + int(store.Offset) + width; got != end {
+			t.Errorf("mapBoundsProgram() store of %d bytes at %d+%d ends at %d, want %d", width, offset, store.Offset, got, end)
+		}
+	}
+}
@@ -119,6 +119,8 @@ func (pa *PointerArithmetic) GenerateProgram(ffi *units.FFI) (*pb.Program, error
 	}
 	header = append(header, body...)
 	header = append(header, footer...)
+	// The random body must not be what first reads R0.
+	header = GuardR0(header)
 	prog := &pb.Program{
 		Program: &pb.Program_Ebpf{
 			Ebpf: &epb.Program{
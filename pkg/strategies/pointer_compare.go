@@ -0,0 +1,236 @@
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+	pb "buzzer/proto/program_go_proto"
+	"errors"
+	"fmt"
+)
+
+var (
+	errContextLost = errors.New("No register can keep the context pointer")
+)
+
+// maxPointerComparisons caps how many comparisons a program generated by
+// the pointer compare strategy has.
+const maxPointerComparisons = 32
+
+func NewPointerCompareStrategy() *PointerCompare {
+	return &PointerCompare{isFinished: false, mapFd: -1}
+}
+
+// PointerCompare is a strategy that sets up registers holding pointers of
+// different types (context, map value, map, stack) and emits conditional
+// jumps comparing them against each other and against scalars. Random
+// generation rarely has two live pointers at once, so the verifier logic
+// for these comparisons is hard to reach otherwise.
+//
+// Every comparison that falls through increments a counter that is stored
+// in the map. None of the pointers can be null, so comparisons of a pointer
+// with itself or with 0 always go the same way and OnExecuteDone checks
+// that the counter agrees with them.
+type PointerCompare struct {
+	isFinished        bool
+	mapFd             int
+	programCount      int
+	validProgramCount int
+
+	// minCount and maxCount bound the counter stored by the last program.
+	minCount int
+	maxCount int
+}
+
+// pointerCompareOutcome returns whether `jump`, which compares a pointer
+// that is not null, is taken. The second return value is false if that
+// depends on the value of the pointer, which is the case unless it is
+// compared with itself or, unsigned, with 0.
+func pointerCompareOutcome(jump *epb.Instruction) (bool, bool) {
+	jmp := jump.GetJmpOpcode()
+	wide := jmp.InstructionClass == epb.InsClass_InsClassJmp
+	if jmp.Source == epb.SrcOperand_RegSrc {
+		if jump.SrcReg != jump.DstReg {
+			return false, false
+		}
+		switch jmp.OperationCode {
+		case epb.JmpOperationCode_JmpJEQ, epb.JmpOperationCode_JmpJGE, epb.JmpOperationCode_JmpJLE, epb.JmpOperationCode_JmpJSGE, epb.JmpOperationCode_JmpJSLE:
+			return true, true
+		case epb.JmpOperationCode_JmpJSET:
+			// The lower half of a pointer can be 0.
+			return true, wide
+		default:
+			return false, true
+		}
+	}
+
+	if jump.Immediate != 0 {
+		return false, false
+	}
+	switch jmp.OperationCode {
+	case epb.JmpOperationCode_JmpJSET:
+		return false, true
+	case epb.JmpOperationCode_JmpJEQ, epb.JmpOperationCode_JmpJLE:
+		return false, wide
+	case epb.JmpOperationCode_JmpJNE, epb.JmpOperationCode_JmpJGT:
+		return true, wide
+	case epb.JmpOperationCode_JmpJGE:
+		return true, true
+	case epb.JmpOperationCode_JmpJLT:
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+// pointerCompareProgram returns a program that compares pointers
+// `comparisons` times, counting in the map value at index 0 of `mapFd`
+// how many comparisons fell through, along with the least and the most
+// the count can be.
+func pointerCompareProgram(mapFd int, comparisons int) ([]*epb.Instruction, int, int, error) {
+	stackOffset := -8 * int32(rand.SharedRNG.RandRange(1, MaxStackSize/8))
+	header, err := InstructionSequence(
+		// R7: map value.
+		LdMapByFd(R1, mapFd),
+		StW(R10, 0, -4),
+		Mov64(R2, R10),
+		Add64(R2, -4),
+		Call(MapLookup),
+		JmpNE(R0, 0, 1),
+		Exit(),
+		Mov64(R7, R0),
+
+		// R8: map, R9: somewhere in the stack.
+		LdMapByFd(R8, mapFd),
+		Mov64(R9, R10),
+		Add64(R9, stackOffset),
+
+		// R1-R5: scalars.
+		Mov64(R1, RandomImmediate()),
+		Mov64(R2, RandomImmediate()),
+		Mov64(R3, RandomImmediate()),
+		Mov64(R4, RandomImmediate()),
+		Mov64(R5, RandomImmediate()),
+		Mov64(R0, 0),
+	)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	// The header overwrites R1, the context is saved to a callee saved
+	// register that it leaves alone.
+	header, ctx, ok := SaveContext(header, len(header)-1)
+	if !ok {
+		return nil, 0, 0, errContextLost
+	}
+
+	pointers := []epb.Reg{ctx, R7, R8, R9, R10}
+	scalars := []epb.Reg{R1, R2, R3, R4, R5}
+	randomReg := func(regs []epb.Reg) epb.Reg {
+		return regs[rand.SharedRNG.RandRange(0, uint64(len(regs)-1))]
+	}
+
+	body := []*epb.Instruction{}
+	minCount, maxCount := 0, 0
+	for i := 0; i < comparisons; i++ {
+		dst := randomReg(pointers)
+		var jump *epb.Instruction
+		switch rand.SharedRNG.RandRange(0, 2) {
+		case 0:
+			jump = RandomConditionalJump(dst, randomReg(pointers), 1)
+		case 1:
+			jump = RandomConditionalJump(dst, randomReg(scalars), 1)
+		default:
+			jump = RandomConditionalJump(dst, RandomImmediate(), 1)
+		}
+		// Skip over the increment when the jump is taken.
+		body = append(body, jump, Add64(R0, 1))
+		switch taken, known := pointerCompareOutcome(jump); {
+		case !known:
+			maxCount++
+		case !taken:
+			minCount++
+			maxCount++
+		}
+	}
+
+	footer, err := InstructionSequence(
+		StDW(R7, R0, 0),
+		Mov64(R0, 0),
+		Exit(),
+	)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return append(append(header, body...), footer...), minCount, maxCount, nil
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (pc *PointerCompare) GenerateProgram(ffi *units.FFI) (*pb.Program, error) {
+	pc.programCount += 1
+	fmt.Printf("Generated %d programs, %d were valid               \r", pc.programCount, pc.validProgramCount)
+
+	ffi.CloseFD(pc.mapFd)
+	pc.mapFd = ffi.CreateMapArray(1)
+	if pc.mapFd < 0 {
+		return nil, mapCreationFailed
+	}
+
+	comparisons := int(rand.SharedRNG.RandRange(1, maxPointerComparisons))
+	instructions, minCount, maxCount, err := pointerCompareProgram(pc.mapFd, comparisons)
+	if err != nil {
+		return nil, err
+	}
+	pc.minCount, pc.maxCount = minCount, maxCount
+	prog := &pb.Program{
+		Program: &pb.Program_Ebpf{
+			Ebpf: &epb.Program{
+				Functions: []*epb.Functions{
+					{Instructions: instructions},
+				},
+			},
+		}}
+	return prog, nil
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (pc *PointerCompare) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if verificationResult.IsValid {
+		pc.validProgramCount += 1
+	}
+	return verificationResult.IsValid
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+func (pc *PointerCompare) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	mapElements, err := ffi.GetMapElements(pc.mapFd, 1)
+	if err != nil {
+		fmt.Println(err)
+		return true
+	}
+
+	count := mapElements.Elements[0]
+	return count >= uint64(pc.minCount) && count <= uint64(pc.maxCount)
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (pc *PointerCompare) OnError(e error) bool {
+	fmt.Printf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (pc *PointerCompare) IsFuzzingDone() bool {
+	return pc.isFinished
+}
+
+// StrategyName is used for strategy selection via runtime flags.
+func (pc *PointerCompare) Name() string {
+	return "pointer_compare"
+}
@@ -0,0 +1,106 @@
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+	"testing"
+)
+
+func TestPointerCompareProgram(t *testing.T) {
+	for comparisons := 1; comparisons <= maxPointerComparisons; comparisons *= 2 {
+		instructions, minCount, maxCount, err := pointerCompareProgram(3, comparisons)
+		if err != nil {
+			t.Fatalf("pointerCompareProgram() unexpected error: %v", err)
+		}
+		if minCount < 0 || minCount > maxCount || maxCount > comparisons {
+			t.Errorf("pointerCompareProgram(%d) count bounds = [%d, %d]", comparisons, minCount, maxCount)
+		}
+		if err := Validate(instructions); err != nil {
+			t.Errorf("pointerCompareProgram(%d) is invalid: %v", comparisons, err)
+		}
+		// The context is saved before the header overwrites R1 and is
+		// still around to be compared.
+		if ctx, ok := ContextRegister(instructions, len(instructions)-1); !ok || ctx != R6 {
+			t.Errorf("ContextRegister() = %v, %v, want R6, true", ctx, ok)
+		}
+		// Every register compared has to be initialized by then.
+		for index, i := range instructions {
+			jmp := i.GetJmpOpcode()
+			if jmp == nil || !IsConditional(jmp.OperationCode) {
+				continue
+			}
+			defined := DefinedRegistersAt(instructions, index)
+			if !defined.Contains(i.DstReg) {
+				t.Errorf("instruction %d compares uninitialized %v", index, i.DstReg)
+			}
+		}
+	}
+}
+
+func TestPointerCompareOutcome(t *testing.T) {
+	tests := []struct {
+		testName  string
+		jump      *epb.Instruction
+		wantTaken bool
+		wantKnown bool
+	}{
+		{
+			testName:  "Equal to itself",
+			jump:      JmpEQ(R6, R6, 1),
+			wantTaken: true,
+			wantKnown: true,
+		},
+		{
+			testName:  "Greater than itself",
+			jump:      JmpSGT(R6, R6, 1),
+			wantTaken: false,
+			wantKnown: true,
+		},
+		{
+			testName:  "Lower half tested against itself",
+			jump:      JmpSET32(R6, R6, 1),
+			wantKnown: false,
+		},
+		{
+			testName:  "Another pointer",
+			jump:      JmpEQ(R6, R7, 1),
+			wantKnown: false,
+		},
+		{
+			testName:  "Not null",
+			jump:      JmpNE(R6, 0, 1),
+			wantTaken: true,
+			wantKnown: true,
+		},
+		{
+			testName:  "Lower half not null",
+			jump:      JmpNE32(R6, 0, 1),
+			wantKnown: false,
+		},
+		{
+			testName:  "Unsigned lower than 0",
+			jump:      JmpLT32(R6, 0, 1),
+			wantTaken: false,
+			wantKnown: true,
+		},
+		{
+			testName:  "Signed against 0",
+			jump:      JmpSGT(R6, 0, 1),
+			wantKnown: false,
+		},
+		{
+			testName:  "Non zero immediate",
+			jump:      JmpGT(R6, 1, 1),
+			wantKnown: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			taken, known := pointerCompareOutcome(tc.jump)
+			if known != tc.wantKnown || (known && taken != tc.wantTaken) {
+				t.Errorf("pointerCompareOutcome() = %v, %v, want %v, %v", taken, known, tc.wantTaken, tc.wantKnown)
+			}
+		})
+	}
+}
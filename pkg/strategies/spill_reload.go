@@ -0,0 +1,171 @@
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+	pb "buzzer/proto/program_go_proto"
+	"fmt"
+)
+
+const (
+	// maxSpillReloadCalls caps how many helper calls SpillReload makes
+	// between spilling the pointer and reloading it.
+	maxSpillReloadCalls = 3
+
+	// spillReloadSlots is how many 8 byte stack slots, starting at
+	// R10 - 16 and going down, the pointer can be spilled to. R10 - 8
+	// holds the map keys.
+	spillReloadSlots = 8
+)
+
+func NewSpillReloadStrategy() *SpillReload {
+	return &SpillReload{isFinished: false, mapFd: -1}
+}
+
+// SpillReload is a strategy that stresses how the verifier tracks pointers
+// spilled to the stack: every program spills a pointer to the value of
+// element 0 of an array map, makes helper calls that clobber the caller
+// saved registers, reloads the pointer and stores 0xCAFE through it. The
+// verifier has to prove that the stack slot still holds the pointer after
+// the calls, while other slots around it are written with scalars.
+//
+// OnExecuteDone checks that the store landed in element 0.
+type SpillReload struct {
+	isFinished        bool
+	mapFd             int
+	programCount      int
+	validProgramCount int
+}
+
+// spillReloadSlot returns the offset from R10 of a random spill slot.
+func spillReloadSlot() int16 {
+	return int16(-16 - 8*int(rand.SharedRNG.RandRange(0, spillReloadSlots-1)))
+}
+
+// spillReloadCall returns a helper call that clobbers R0-R5, either
+// get_prandom_u32 or a lookup of element 1 of the map in R9.
+func spillReloadCall() []*epb.Instruction {
+	if rand.SharedRNG.OneOf(2) {
+		return []*epb.Instruction{Call(GetPrandomU32)}
+	}
+	return []*epb.Instruction{
+		StW(R10, 1, -4),
+		Mov64(R2, R10),
+		Add64(R2, -4),
+		Mov64(R1, R9),
+		Call(MapLookup),
+	}
+}
+
+// spillReloadProgram returns a program that spills a pointer to the value
+// of element 0 of `mapFd`, calls helpers, reloads it and stores 0xCAFE
+// through it.
+func spillReloadProgram(mapFd int) ([]*epb.Instruction, error) {
+	slot := spillReloadSlot()
+	header, err := InstructionSequence(
+		LdMapByFd(R9, mapFd),
+		StW(R10, 0, -4),
+		Mov64(R2, R10),
+		Add64(R2, -4),
+		Mov64(R1, R9),
+		Call(MapLookup),
+		JmpNE(R0, 0, 1),
+		Exit(),
+		StDW(R10, R0, slot),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	body := []*epb.Instruction{}
+	calls := int(rand.SharedRNG.RandRange(1, maxSpillReloadCalls))
+	for c := 0; c < calls; c++ {
+		// Write scalars next to the spilled pointer, which must not
+		// affect it.
+		if other := spillReloadSlot(); other != slot && rand.SharedRNG.OneOf(2) {
+			body = append(body, StDW(R10, RandomImmediate(), other))
+		}
+		body = append(body, spillReloadCall()...)
+	}
+
+	reg := epb.Reg(rand.SharedRNG.RandRange(uint64(R0), uint64(R8)))
+	footer, err := InstructionSequence(
+		LdDW(reg, R10, slot),
+		StDW(reg, 0xCAFE, 0),
+		Mov64(R0, 0),
+		Exit(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(header, body...), footer...), nil
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (sr *SpillReload) GenerateProgram(ffi *units.FFI) (*pb.Program, error) {
+	sr.programCount += 1
+	fmt.Printf("Generated %d programs, %d were valid               \r", sr.programCount, sr.validProgramCount)
+
+	ffi.CloseFD(sr.mapFd)
+	sr.mapFd = ffi.CreateMapArray(2)
+	if sr.mapFd < 0 {
+		return nil, mapCreationFailed
+	}
+
+	instructions, err := spillReloadProgram(sr.mapFd)
+	if err != nil {
+		return nil, err
+	}
+	prog := &pb.Program{
+		Program: &pb.Program_Ebpf{
+			Ebpf: &epb.Program{
+				Functions: []*epb.Functions{
+					{Instructions: instructions},
+				},
+			},
+		}}
+	return prog, nil
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (sr *SpillReload) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if verificationResult.IsValid {
+		sr.validProgramCount += 1
+	}
+	return verificationResult.IsValid
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+func (sr *SpillReload) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	mapElements, err := ffi.GetMapElements(sr.mapFd, 2)
+	if err != nil {
+		fmt.Println(err)
+		return true
+	}
+
+	return mapElements.Elements[0] == 0xCAFE
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (sr *SpillReload) OnError(e error) bool {
+	fmt.Printf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (sr *SpillReload) IsFuzzingDone() bool {
+	return sr.isFinished
+}
+
+// StrategyName is used for strategy selection via runtime flags.
+func (sr *SpillReload) Name() string {
+	return "spill_reload"
+}
@@ -0,0 +1,38 @@
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	"testing"
+)
+
+func TestSpillReloadProgram(t *testing.T) {
+	for run := 0; run < 100; run++ {
+		instructions, err := spillReloadProgram(3)
+		if err != nil {
+			t.Fatalf("spillReloadProgram() unexpected error: %v", err)
+		}
+		if err := Validate(instructions); err != nil {
+			t.Errorf("spillReloadProgram() is invalid: %v", err)
+		}
+
+		// The pointer is spilled right after the null check and
+		// reloaded from the same slot before the store through it.
+		spill := instructions[8]
+		reload := instructions[len(instructions)-4]
+		store := instructions[len(instructions)-3]
+		if spill.DstReg != R10 || spill.SrcReg != R0 {
+			t.Errorf("spillReloadProgram() spills %v to %v, want R0 to R10", spill.SrcReg, spill.DstReg)
+		}
+		if reload.SrcReg != R10 || reload.Offset != spill.Offset {
+			t.Errorf("spillReloadProgram() reloads from %v%+d, want R10%+d", reload.SrcReg, reload.Offset, spill.Offset)
+		}
+		if store.DstReg != reload.DstReg {
+			t.Errorf("spillReloadProgram() stores through %v, want the reloaded %v", store.DstReg, reload.DstReg)
+		}
+		for _, i := range instructions[9 : len(instructions)-4] {
+			if i.DstReg == R10 && i.Offset == spill.Offset {
+				t.Errorf("spillReloadProgram() overwrites the spilled pointer with %v", i)
+			}
+		}
+	}
+}
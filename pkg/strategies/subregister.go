@@ -0,0 +1,178 @@
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+	pb "buzzer/proto/program_go_proto"
+	"fmt"
+)
+
+// maxSubregisterInstructions caps how many 32-bit operations a program
+// generated by the subregister strategy has.
+const maxSubregisterInstructions = 256
+
+func NewSubregisterStrategy() *Subregister {
+	return &Subregister{isFinished: false, mapFd: -1}
+}
+
+// Subregister is a strategy that concentrates on the zero extension of
+// subregisters: registers start with full 64-bit values and then go
+// through BPF_ALU and BPF_JMP32 operations, each 32-bit write often
+// followed by a 64-bit read of the same register.
+//
+// The upper half of the last register written, which has to be 0, is added
+// to a map value pointer like in PointerArithmetic. If the verifier or the
+// JIT get the zero extension wrong the write lands somewhere else, which
+// OnExecuteDone detects.
+type Subregister struct {
+	isFinished        bool
+	mapFd             int
+	programCount      int
+	validProgramCount int
+}
+
+// subregisterRead returns a 64-bit operation reading `reg`.
+func subregisterRead(reg epb.Reg) *epb.Instruction {
+	switch rand.SharedRNG.RandRange(0, 2) {
+	case 0:
+		return Mov64(RandomRegister(), reg)
+	case 1:
+		return Add64(RandomRegister(), reg)
+	default:
+		return JmpSGT(reg, RandomImmediate(), 0)
+	}
+}
+
+// subregisterProgram returns a program with `count` random 32-bit
+// operations that stores 0xCAFE at index 0 of `mapFd` and then again at
+// index 1, offset by the upper half of the last register written.
+func subregisterProgram(mapFd int, count int) ([]*epb.Instruction, error) {
+	header := []*epb.Instruction{}
+	for reg := R0; reg <= R9; reg++ {
+		header = append(header, Mov64(reg, int64(rand.SharedRNG.RandInt())))
+	}
+
+	body := []*epb.Instruction{}
+	var last epb.Reg
+	for remaining := count - 1; remaining >= 0; remaining-- {
+		// Jumps can land at most on the last instruction, which always
+		// is a write.
+		if remaining > 1 && rand.SharedRNG.OneOf(4) {
+			offset := int16(rand.SharedRNG.RandRange(1, uint64(remaining-1)))
+			if rand.SharedRNG.OneOf(2) {
+				body = append(body, JmpNE32(RandomRegister(), RandomImmediate(), offset))
+			} else {
+				body = append(body, RandomConditionalJump(RandomRegister(), RandomRegister(), offset))
+			}
+			continue
+		}
+		write := RandomAluInstructionOfClass(epb.InsClass_InsClassAlu)
+		body = append(body, write)
+		last = write.DstReg
+		if remaining > 0 && rand.SharedRNG.OneOf(2) {
+			body = append(body, subregisterRead(last))
+		}
+	}
+
+	footer, err := InstructionSequence(
+		Mov64(R8, last),
+		Rsh64(R8, 32),
+		LdMapByFd(R9, mapFd),
+
+		StW(R10, 0, -4),
+		Mov64(R2, R10),
+		Add64(R2, -4),
+		Mov64(R1, R9),
+		Call(MapLookup),
+		JmpNE(R0, 0, 1),
+		Exit(),
+		StDW(R0, 0xCAFE, 0),
+
+		StW(R10, 1, -4),
+		Mov64(R2, R10),
+		Add64(R2, -4),
+		Mov64(R1, R9),
+		Call(MapLookup),
+		JmpNE(R0, 0, 1),
+		Exit(),
+		Add64(R0, R8),
+		StDW(R0, 0xCAFE, 0),
+
+		Mov64(R0, 0),
+		Exit(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(header, body...), footer...), nil
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (sr *Subregister) GenerateProgram(ffi *units.FFI) (*pb.Program, error) {
+	sr.programCount += 1
+	fmt.Printf("Generated %d programs, %d were valid               \r", sr.programCount, sr.validProgramCount)
+
+	ffi.CloseFD(sr.mapFd)
+	sr.mapFd = ffi.CreateMapArray(2)
+	if sr.mapFd < 0 {
+		return nil, mapCreationFailed
+	}
+
+	count := int(rand.SharedRNG.RandRange(1, maxSubregisterInstructions))
+	instructions, err := subregisterProgram(sr.mapFd, count)
+	if err != nil {
+		return nil, err
+	}
+	prog := &pb.Program{
+		Program: &pb.Program_Ebpf{
+			Ebpf: &epb.Program{
+				Functions: []*epb.Functions{
+					{Instructions: instructions},
+				},
+			},
+		}}
+	return prog, nil
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (sr *Subregister) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if verificationResult.IsValid {
+		sr.validProgramCount += 1
+	}
+	return verificationResult.IsValid
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+func (sr *Subregister) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	mapElements, err := ffi.GetMapElements(sr.mapFd, 2)
+	if err != nil {
+		fmt.Println(err)
+		return true
+	}
+
+	return mapElements.Elements[0] == mapElements.Elements[1]
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (sr *Subregister) OnError(e error) bool {
+	fmt.Printf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (sr *Subregister) IsFuzzingDone() bool {
+	return sr.isFinished
+}
+
+// StrategyName is used for strategy selection via runtime flags.
+func (sr *Subregister) Name() string {
+	return "subregister"
+}
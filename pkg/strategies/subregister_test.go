@@ -0,0 +1,32 @@
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+	"testing"
+)
+
+func TestSubregisterProgram(t *testing.T) {
+	for count := 1; count <= maxSubregisterInstructions; count *= 2 {
+		instructions, err := subregisterProgram(3, count)
+		if err != nil {
+			t.Fatalf("subregisterProgram() unexpected error: %v", err)
+		}
+		if err := Validate(instructions); err != nil {
+			t.Errorf("subregisterProgram(%d) is invalid: %v", count, err)
+		}
+
+		// The footer starts by taking the upper half of a register that
+		// was just written by a 32-bit operation.
+		for index, i := range instructions {
+			alu := i.GetAluOpcode()
+			if alu == nil || alu.OperationCode != epb.AluOperationCode_AluRsh || alu.InstructionClass != epb.InsClass_InsClassAlu64 {
+				continue
+			}
+			read, write := instructions[index-1], instructions[index-2]
+			if write.GetAluOpcode().GetInstructionClass() != epb.InsClass_InsClassAlu || write.DstReg != read.SrcReg {
+				t.Errorf("subregisterProgram(%d) reads %v after %v, want the destination of a 32-bit operation", count, read, write)
+			}
+		}
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+	"bytes"
+	"fmt"
+	"unsafe"
+)
+
+const (
+	// BpfProgLoad is the BPF_PROG_LOAD command of the bpf syscall.
+	BpfProgLoad = 5
+
+	// BpfProgTestRun is the BPF_PROG_TEST_RUN command of the bpf syscall.
+	BpfProgTestRun = 10
+
+	// bpfInstructionSize is sizeof(struct bpf_insn).
+	bpfInstructionSize = 8
+)
+
+// Flags for the prog_flags field of BPF_PROG_LOAD, they can be set per
+// program in its LoadFlags.
+const (
+	// BpfFStrictAlignment makes the verifier check alignment even on
+	// architectures that support unaligned accesses.
+	BpfFStrictAlignment = 1 << 0
+	// BpfFAnyAlignment makes the verifier skip alignment checks.
+	BpfFAnyAlignment = 1 << 1
+	// BpfFTestRndHi32 makes the verifier fill the upper 32 bits of
+	// registers that it zero extends with random values, which exposes
+	// missing zero extensions.
+	BpfFTestRndHi32 = 1 << 2
+	// BpfFTestStateFreq makes the verifier checkpoint its state more
+	// often.
+	BpfFTestStateFreq = 1 << 3
+	// BpfFSleepable loads the program as sleepable.
+	BpfFSleepable = 1 << 4
+)
+
+// bpfProgLoadAttr mirrors the BPF_PROG_LOAD part of `union bpf_attr` up to
+// func_info_cnt. The kernel treats any field past the size we pass as zero.
+type bpfProgLoadAttr struct {
+	progType           uint32
+	insnCnt            uint32
+	insns              uint64
+	license            uint64
+	logLevel           uint32
+	logSize            uint32
+	logBuf             uint64
+	kernVersion        uint32
+	progFlags          uint32
+	progName           [16]byte
+	progIfindex        uint32
+	expectedAttachType uint32
+	progBtfFd          uint32
+	funcInfoRecSize    uint32
+	funcInfo           uint64
+	funcInfoCnt        uint32
+	_                  uint32
+}
+
+// ProgLoadAttr holds a populated `bpf_attr` for BPF_PROG_LOAD together with
+// the buffers it points to, so they stay alive as long as the ProgLoadAttr
+// does. Callers must keep it reachable (e.g. with runtime.KeepAlive) until
+// the syscall returns.
+//
+// BTF and func info are not included, as they require a BTF fd loaded
+// beforehand.
+type ProgLoadAttr struct {
+	attr    bpfProgLoadAttr
+	insns   []byte
+	license []byte
+	log     []byte
+}
+
+// NewProgLoadAttr encodes `program` and builds the BPF_PROG_LOAD attributes
+// to load it as a `progType` program under `license`, with the program's
+// LoadFlags. If `logSize` is not zero a verifier log buffer of that size is
+// attached.
+func NewProgLoadAttr(program *epb.Program, progType uint32, license string, logSize uint32) (*ProgLoadAttr, error) {
+	insns, _, err := ebpf.EncodeInstructions(program)
+	if err != nil {
+		return nil, err
+	}
+	if len(insns) == 0 {
+		return nil, fmt.Errorf("cannot build bpf_attr for an empty program")
+	}
+
+	a := &ProgLoadAttr{
+		insns:   insns,
+		license: append([]byte(license), 0),
+	}
+	a.attr.progType = progType
+	a.attr.insnCnt = uint32(len(insns) / bpfInstructionSize)
+	a.attr.progFlags = program.LoadFlags
+	a.attr.insns = uint64(uintptr(unsafe.Pointer(&a.insns[0])))
+	a.attr.license = uint64(uintptr(unsafe.Pointer(&a.license[0])))
+	if logSize != 0 {
+		a.log = make([]byte, logSize)
+		a.attr.logLevel = 1
+		a.attr.logSize = logSize
+		a.attr.logBuf = uint64(uintptr(unsafe.Pointer(&a.log[0])))
+	}
+	return a, nil
+}
+
+// Pointer returns the address of the `bpf_attr`, to be passed as the second
+// argument of the bpf syscall.
+func (a *ProgLoadAttr) Pointer() unsafe.Pointer {
+	return unsafe.Pointer(&a.attr)
+}
+
+// Size returns the size of the `bpf_attr`, to be passed as the third
+// argument of the bpf syscall.
+func (a *ProgLoadAttr) Size() uintptr {
+	return unsafe.Sizeof(a.attr)
+}
+
+// VerifierLog returns the verifier log written by the kernel, if a log
+// buffer was requested.
+func (a *ProgLoadAttr) VerifierLog() string {
+	if end := bytes.IndexByte(a.log, 0); end >= 0 {
+		return string(a.log[:end])
+	}
+	return string(a.log)
+}
+
+// TestRunConfig describes how BPF_PROG_TEST_RUN runs a loaded program.
+type TestRunConfig struct {
+	// Data is the input packet, or whatever the program type takes as
+	// data, e.g. nothing for tracing programs.
+	Data []byte
+
+	// Context is passed as the context of the program, for the program
+	// types that accept one (e.g. a struct __sk_buff for socket filters).
+	// The kernel builds a default context if it is empty.
+	Context []byte
+
+	// Repeat is how many times the program runs, the kernel treats 0 as
+	// 1. Only the results of the last run are returned.
+	Repeat uint32
+
+	// OutputSize is the size of the buffer the output data is copied to,
+	// len(Data) if zero. Programs that grow the packet need more.
+	OutputSize uint32
+}
+
+// bpfProgTestRunAttr mirrors the BPF_PROG_TEST_RUN part of `union bpf_attr`
+// up to batch_size.
+type bpfProgTestRunAttr struct {
+	progFd      uint32
+	retval      uint32
+	dataSizeIn  uint32
+	dataSizeOut uint32
+	dataIn      uint64
+	dataOut     uint64
+	repeat      uint32
+	duration    uint32
+	ctxSizeIn   uint32
+	ctxSizeOut  uint32
+	ctxIn       uint64
+	ctxOut      uint64
+	flags       uint32
+	cpu         uint32
+	batchSize   uint32
+	_           uint32
+}
+
+// ProgTestRunAttr holds a populated `bpf_attr` for BPF_PROG_TEST_RUN
+// together with the buffers it points to, with the same lifetime rules as
+// ProgLoadAttr.
+type ProgTestRunAttr struct {
+	attr    bpfProgTestRunAttr
+	dataIn  []byte
+	dataOut []byte
+	ctxIn   []byte
+}
+
+// NewProgTestRunAttr builds the BPF_PROG_TEST_RUN attributes to run the
+// program loaded at `progFd` as described by `cfg`.
+func NewProgTestRunAttr(progFd int, cfg TestRunConfig) *ProgTestRunAttr {
+	outputSize := cfg.OutputSize
+	if outputSize == 0 {
+		outputSize = uint32(len(cfg.Data))
+	}
+	a := &ProgTestRunAttr{
+		dataIn:  cfg.Data,
+		dataOut: make([]byte, outputSize),
+		ctxIn:   cfg.Context,
+	}
+	a.attr.progFd = uint32(progFd)
+	a.attr.repeat = cfg.Repeat
+	if len(a.dataIn) != 0 {
+		a.attr.dataSizeIn = uint32(len(a.dataIn))
+		a.attr.dataIn = uint64(uintptr(unsafe.Pointer(&a.dataIn[0])))
+	}
+	if len(a.dataOut) != 0 {
+		a.attr.dataSizeOut = uint32(len(a.dataOut))
+		a.attr.dataOut = uint64(uintptr(unsafe.Pointer(&a.dataOut[0])))
+	}
+	if len(a.ctxIn) != 0 {
+		a.attr.ctxSizeIn = uint32(len(a.ctxIn))
+		a.attr.ctxIn = uint64(uintptr(unsafe.Pointer(&a.ctxIn[0])))
+	}
+	return a
+}
+
+// Pointer returns the address of the `bpf_attr`, to be passed as the second
+// argument of the bpf syscall.
+func (a *ProgTestRunAttr) Pointer() unsafe.Pointer {
+	return unsafe.Pointer(&a.attr)
+}
+
+// Size returns the size of the `bpf_attr`, to be passed as the third
+// argument of the bpf syscall.
+func (a *ProgTestRunAttr) Size() uintptr {
+	return unsafe.Sizeof(a.attr)
+}
+
+// Retval returns the value the program returned on its last run.
+func (a *ProgTestRunAttr) Retval() uint32 {
+	return a.attr.retval
+}
+
+// Output returns the data as the program left it, truncated to the output
+// buffer if it grew past it.
+func (a *ProgTestRunAttr) Output() []byte {
+	return a.dataOut[:min(int(a.attr.dataSizeOut), len(a.dataOut))]
+}
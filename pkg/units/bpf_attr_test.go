@@ -0,0 +1,117 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+	"bytes"
+	"testing"
+	"unsafe"
+)
+
+func TestNewProgLoadAttr(t *testing.T) {
+	program := &epb.Program{
+		Functions: []*epb.Functions{
+			{
+				Instructions: []*epb.Instruction{
+					ebpf.Mov64(ebpf.R0, int64(1)<<40),
+					ebpf.Exit(),
+				},
+			},
+		},
+		LoadFlags: BpfFTestRndHi32 | BpfFStrictAlignment,
+	}
+
+	a, err := NewProgLoadAttr(program, BpfProgTypeSocketFilter, "GPL", 4096)
+	if err != nil {
+		t.Fatalf("NewProgLoadAttr() unexpected error: %v", err)
+	}
+
+	if a.Size() != 96 {
+		t.Errorf("Size() = %d, want 96", a.Size())
+	}
+	attr := (*bpfProgLoadAttr)(a.Pointer())
+	if attr.progType != BpfProgTypeSocketFilter {
+		t.Errorf("progType = %d, want %d", attr.progType, BpfProgTypeSocketFilter)
+	}
+	// The wide instruction takes two slots.
+	if attr.insnCnt != 3 {
+		t.Errorf("insnCnt = %d, want 3", attr.insnCnt)
+	}
+	if attr.license != uint64(uintptr(unsafe.Pointer(&a.license[0]))) || string(a.license) != "GPL\x00" {
+		t.Errorf("license = %q, want %q", a.license, "GPL\x00")
+	}
+	if attr.progFlags != BpfFTestRndHi32|BpfFStrictAlignment {
+		t.Errorf("progFlags = %#x, want %#x", attr.progFlags, BpfFTestRndHi32|BpfFStrictAlignment)
+	}
+	if attr.logSize != 4096 || attr.logLevel != 1 {
+		t.Errorf("logSize, logLevel = %d, %d, want 4096, 1", attr.logSize, attr.logLevel)
+	}
+
+	copy(a.log, "0: R1=ctx\x00garbage")
+	if got := a.VerifierLog(); got != "0: R1=ctx" {
+		t.Errorf("VerifierLog() = %q, want %q", got, "0: R1=ctx")
+	}
+
+	empty := &epb.Program{Functions: []*epb.Functions{{}}}
+	if _, err := NewProgLoadAttr(empty, BpfProgTypeSocketFilter, "GPL", 0); err == nil {
+		t.Errorf("NewProgLoadAttr() with an empty program expected error, got nil")
+	}
+}
+
+func TestNewProgTestRunAttr(t *testing.T) {
+	cfg := TestRunConfig{
+		Data:    []byte{1, 2, 3, 4},
+		Context: []byte{5, 6},
+		Repeat:  10,
+	}
+	a := NewProgTestRunAttr(7, cfg)
+
+	if a.Size() != 80 {
+		t.Errorf("Size() = %d, want 80", a.Size())
+	}
+	attr := (*bpfProgTestRunAttr)(a.Pointer())
+	if attr.progFd != 7 || attr.repeat != 10 {
+		t.Errorf("progFd, repeat = %d, %d, want 7, 10", attr.progFd, attr.repeat)
+	}
+	if attr.dataSizeIn != 4 || attr.dataIn != uint64(uintptr(unsafe.Pointer(&cfg.Data[0]))) {
+		t.Errorf("dataSizeIn, dataIn = %d, %#x, want 4 and the address of the data", attr.dataSizeIn, attr.dataIn)
+	}
+	if attr.ctxSizeIn != 2 || attr.ctxIn != uint64(uintptr(unsafe.Pointer(&cfg.Context[0]))) {
+		t.Errorf("ctxSizeIn, ctxIn = %d, %#x, want 2 and the address of the context", attr.ctxSizeIn, attr.ctxIn)
+	}
+	// Without an explicit size the output buffer is as big as the input.
+	if attr.dataSizeOut != 4 || attr.dataOut != uint64(uintptr(unsafe.Pointer(&a.dataOut[0]))) {
+		t.Errorf("dataSizeOut, dataOut = %d, %#x, want 4 and the address of the output buffer", attr.dataSizeOut, attr.dataOut)
+	}
+
+	// The kernel sets retval and the size of the output.
+	attr.retval = 2
+	attr.dataSizeOut = 3
+	copy(a.dataOut, []byte{9, 8, 7, 6})
+	if got := a.Retval(); got != 2 {
+		t.Errorf("Retval() = %d, want 2", got)
+	}
+	if got := a.Output(); !bytes.Equal(got, []byte{9, 8, 7}) {
+		t.Errorf("Output() = %v, want %v", got, []byte{9, 8, 7})
+	}
+
+	empty := NewProgTestRunAttr(3, TestRunConfig{OutputSize: 16})
+	attr = (*bpfProgTestRunAttr)(empty.Pointer())
+	if attr.dataIn != 0 || attr.ctxIn != 0 || attr.dataSizeOut != 16 {
+		t.Errorf("dataIn, ctxIn, dataSizeOut = %#x, %#x, %d, want 0, 0, 16", attr.dataIn, attr.ctxIn, attr.dataSizeOut)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Campaign aggregates the results of every program run during a fuzzing
+// campaign, it is safe to use from multiple goroutines.
+type Campaign struct {
+	lock sync.Mutex
+
+	start             time.Time
+	programsGenerated int
+	programsLoaded    int
+	newCoverageEdges  int
+	crashes           int
+	rejections        map[string]int
+}
+
+// NewCampaign returns an empty Campaign that starts counting now.
+func NewCampaign() *Campaign {
+	return &Campaign{
+		start:      time.Now(),
+		rejections: make(map[string]int),
+	}
+}
+
+// RecordGenerated records that a strategy generated a new program.
+func (c *Campaign) RecordGenerated() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.programsGenerated++
+}
+
+// RecordLoadResult records the outcome of loading a program, for rejected
+// programs the reason is taken from the verifier log.
+func (c *Campaign) RecordLoadResult(result *LoadResult) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if result.Accepted() {
+		c.programsLoaded++
+		return
+	}
+	c.rejections[verifierRejectionReason(result.VerifierLog)]++
+}
+
+// RecordNewCoverage records that a program reached `edges` new coverage
+// edges.
+func (c *Campaign) RecordNewCoverage(edges int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.newCoverageEdges += edges
+}
+
+// RecordCrash records that a program made the kernel crash.
+func (c *Campaign) RecordCrash() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.crashes++
+}
+
+// Rejections returns how many programs were rejected for each reason.
+func (c *Campaign) Rejections() map[string]int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	rejections := make(map[string]int, len(c.rejections))
+	for reason, count := range c.rejections {
+		rejections[reason] = count
+	}
+	return rejections
+}
+
+// Report returns a human readable summary of the campaign so far. Rejection
+// reasons are listed from the most to the least common one.
+func (c *Campaign) Report() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Campaign running for %s\n", time.Since(c.start).Round(time.Second))
+	fmt.Fprintf(&sb, "Programs generated: %d\n", c.programsGenerated)
+	fmt.Fprintf(&sb, "Programs loaded: %d\n", c.programsLoaded)
+	fmt.Fprintf(&sb, "New coverage edges: %d\n", c.newCoverageEdges)
+	fmt.Fprintf(&sb, "Crashes: %d\n", c.crashes)
+
+	reasons := make([]string, 0, len(c.rejections))
+	total := 0
+	for reason, count := range c.rejections {
+		reasons = append(reasons, reason)
+		total += count
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		a, b := c.rejections[reasons[i]], c.rejections[reasons[j]]
+		if a != b {
+			return a > b
+		}
+		return reasons[i] < reasons[j]
+	})
+	fmt.Fprintf(&sb, "Programs rejected: %d\n", total)
+	for _, reason := range reasons {
+		fmt.Fprintf(&sb, "  %d\t%s\n", c.rejections[reason], reason)
+	}
+	return sb.String()
+}
+
+// verifierRejectionReason returns the line of a verifier log that explains
+// why the program was rejected. The verifier prints it right before the
+// "processed N insns" summary at the end of the log.
+func verifierRejectionReason(log string) string {
+	lines := strings.Split(strings.TrimSpace(log), "\n")
+	for index := len(lines) - 1; index >= 0; index-- {
+		line := strings.TrimSpace(lines[index])
+		if line == "" || strings.HasPrefix(line, "processed ") {
+			continue
+		}
+		return line
+	}
+	return "unknown"
+}
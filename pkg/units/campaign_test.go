@@ -0,0 +1,88 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVerifierRejectionReason(t *testing.T) {
+	tests := []struct {
+		testName string
+		log      string
+		want     string
+	}{
+		{
+			testName: "Full log",
+			log:      "0: (b7) r0 = 0\n1: (95) exit\nR0 !read_ok\nprocessed 2 insns (limit 1000000) max_states_per_insn 0\n",
+			want:     "R0 !read_ok",
+		},
+		{
+			testName: "No summary",
+			log:      "invalid func unknown#12345\n",
+			want:     "invalid func unknown#12345",
+		},
+		{
+			testName: "Empty log",
+			log:      "",
+			want:     "unknown",
+		},
+	}
+
+	for _, c := range tests {
+		t.Run(c.testName, func(t *testing.T) {
+			if got := verifierRejectionReason(c.log); got != c.want {
+				t.Errorf("verifierRejectionReason(%q) = %q, want %q", c.log, got, c.want)
+			}
+		})
+	}
+}
+
+func TestCampaign(t *testing.T) {
+	c := NewCampaign()
+	for i := 0; i < 4; i++ {
+		c.RecordGenerated()
+	}
+	c.RecordLoadResult(&LoadResult{ProgramFd: 3})
+	c.RecordLoadResult(&LoadResult{ProgramFd: -1, VerifierLog: "R1 invalid mem access\nprocessed 5 insns\n"})
+	c.RecordLoadResult(&LoadResult{ProgramFd: -1, VerifierLog: "R1 invalid mem access\nprocessed 7 insns\n"})
+	c.RecordLoadResult(&LoadResult{ProgramFd: -1, VerifierLog: "back-edge from insn 3 to 1\n"})
+	c.RecordNewCoverage(10)
+	c.RecordNewCoverage(2)
+	c.RecordCrash()
+
+	wantRejections := map[string]int{
+		"R1 invalid mem access":      2,
+		"back-edge from insn 3 to 1": 1,
+	}
+	if got := c.Rejections(); !reflect.DeepEqual(got, wantRejections) {
+		t.Errorf("Rejections() = %v, want %v", got, wantRejections)
+	}
+
+	report := c.Report()
+	for _, want := range []string{
+		"Programs generated: 4\n",
+		"Programs loaded: 1\n",
+		"New coverage edges: 12\n",
+		"Crashes: 1\n",
+		"Programs rejected: 3\n  2\tR1 invalid mem access\n  1\tback-edge from insn 3 to 1\n",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("Report() = %q, want it to contain %q", report, want)
+		}
+	}
+}
@@ -18,12 +18,16 @@ package units
 import (
 	"buzzer/pkg/cbpf/cbpf"
 	"buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/rand"
 	cpb "buzzer/proto/cbpf_go_proto"
 	epb "buzzer/proto/ebpf_go_proto"
 	fpb "buzzer/proto/ffi_go_proto"
 	pb "buzzer/proto/program_go_proto"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"time"
 )
 
 var (
@@ -58,12 +62,39 @@ type Strategy interface {
 	Name() string
 }
 
+// Timings holds how long each stage of the pipeline took for a program.
+type Timings struct {
+	// Generation is the time the strategy took to generate the program.
+	Generation time.Duration
+
+	// Encoding is the time spent turning the program into bytecode.
+	Encoding time.Duration
+
+	// Verification is the time the kernel took to load the program.
+	Verification time.Duration
+}
+
 // Control directs the execution of the fuzzer.
 type Control struct {
-	strat Strategy
-	ffi   *FFI
-	cm    *CoverageManager
-	rdy   bool
+	// RecordTimings enables measuring how long each stage of the pipeline
+	// takes, see LastGenerationTimings. Nothing is measured when false.
+	RecordTimings bool
+
+	// RecordDecisions enables recording the random decisions made while
+	// generating each program, see LastDecisionTrace. The trace is printed
+	// with the poc of programs that produce unexpected results.
+	RecordDecisions bool
+
+	// LabeledCorpus, if set, receives every eBPF program that went through
+	// the verifier together with its verdict, see WriteLabeledProgram.
+	LabeledCorpus io.Writer
+
+	strat   Strategy
+	ffi     *FFI
+	cm      *CoverageManager
+	rdy     bool
+	timings Timings
+	trace   rand.DecisionTrace
 }
 
 // Init prepares the control unit to be used.
@@ -84,10 +115,53 @@ func (cu *Control) IsReady() bool {
 	return cu.rdy
 }
 
+// LastGenerationTimings returns how long each stage took for the last
+// program that went through the pipeline. Stages the program didn't reach
+// are zero, as is everything if RecordTimings is not set.
+func (cu *Control) LastGenerationTimings() Timings {
+	return cu.timings
+}
+
+// LastDecisionTrace returns the random decisions made while generating the
+// last program, which RegenerateFromTrace can use to build it again. It is
+// nil if RecordDecisions is not set.
+func (cu *Control) LastDecisionTrace() rand.DecisionTrace {
+	return cu.trace
+}
+
+// startTimer returns the current time if timings are being recorded.
+func (cu *Control) startTimer() time.Time {
+	if !cu.RecordTimings {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// stopTimer stores the time elapsed since `start` in `stage` if timings are
+// being recorded.
+func (cu *Control) stopTimer(start time.Time, stage *time.Duration) {
+	if cu.RecordTimings {
+		*stage = time.Since(start)
+	}
+}
+
 // RunFuzzer kickstars the fuzzer in the mode that was specified at Init time.
 func (cu *Control) RunFuzzer() error {
 	for !cu.strat.IsFuzzingDone() {
+		// The origins of the instructions of the previous program were
+		// only needed for its poc, keeping them would grow for the whole
+		// run.
+		ebpf.ClearInstructionOrigins()
+		cu.timings = Timings{}
+		start := cu.startTimer()
+		if cu.RecordDecisions {
+			rand.SharedRNG.StartRecording()
+		}
 		prog, err := cu.strat.GenerateProgram(cu.ffi)
+		if cu.RecordDecisions {
+			cu.trace = rand.SharedRNG.StopRecording()
+		}
+		cu.stopTimer(start, &cu.timings.Generation)
 		if err != nil {
 			fmt.Printf("Generate program error: %v\n", err)
 			if !cu.strat.OnError(err) {
@@ -121,7 +195,9 @@ func (cu *Control) RunFuzzer() error {
 }
 
 func (cu *Control) runEbpf(prog *epb.Program) error {
+	start := cu.startTimer()
 	encodedProg, encodedFuncInfo, err := ebpf.EncodeInstructions(prog)
+	cu.stopTimer(start, &cu.timings.Encoding)
 
 	if err != nil {
 		fmt.Printf("Encoding error: %v\n", err)
@@ -135,7 +211,9 @@ func (cu *Control) runEbpf(prog *epb.Program) error {
 		Btf:      prog.Btf,
 		Function: encodedFuncInfo,
 	}
+	start = cu.startTimer()
 	validationResult, err := cu.ffi.ValidateEbpfProgram(encodedProgram)
+	cu.stopTimer(start, &cu.timings.Verification)
 	if err != nil {
 		fmt.Printf("Validation error: %v\n", err)
 		if !cu.strat.OnError(err) {
@@ -144,6 +222,16 @@ func (cu *Control) runEbpf(prog *epb.Program) error {
 		return nil
 	}
 
+	if cu.LabeledCorpus != nil {
+		if err := WriteLabeledProgram(cu.LabeledCorpus, prog, validationResult); err != nil {
+			fmt.Printf("Labeled corpus error: %v\n", err)
+			if !cu.strat.OnError(err) {
+				cu.ffi.CloseFD(int(validationResult.ProgramFd))
+				return err
+			}
+		}
+	}
+
 	if !cu.strat.OnVerifyDone(cu.ffi, validationResult) || !validationResult.IsValid {
 		cu.ffi.CloseFD(int(validationResult.ProgramFd))
 		return nil
@@ -167,6 +255,12 @@ func (cu *Control) runEbpf(prog *epb.Program) error {
 	if !ok {
 		fmt.Println("Program produced unexpected results")
 		ebpf.GeneratePoc(prog)
+		if cu.RecordDecisions {
+			trace, err := json.Marshal(cu.trace)
+			if err == nil {
+				fmt.Printf("Decision trace: %s\n", trace)
+			}
+		}
 	}
 	return nil
 }
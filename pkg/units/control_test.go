@@ -0,0 +1,102 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+	pb "buzzer/proto/program_go_proto"
+	"errors"
+	"testing"
+	"time"
+)
+
+// slowStrategy is a fakeStrategy that takes `delay` to generate programs.
+type slowStrategy struct {
+	fakeStrategy
+	delay time.Duration
+}
+
+func (ss *slowStrategy) GenerateProgram(ffi *FFI) (*pb.Program, error) {
+	time.Sleep(ss.delay)
+	return ss.fakeStrategy.GenerateProgram(ffi)
+}
+
+func TestLastGenerationTimings(t *testing.T) {
+	for _, record := range []bool{false, true} {
+		strategy := &slowStrategy{
+			fakeStrategy: fakeStrategy{
+				// Generation always fails, so the program never
+				// reaches encoding.
+				programs:    []*pb.Program{nil},
+				maxPrograms: 1,
+			},
+			delay: time.Millisecond,
+		}
+		cu := &Control{RecordTimings: record}
+		if err := cu.Init(&FFI{}, nil, strategy); err != nil {
+			t.Fatalf("Init() unexpected error: %v", err)
+		}
+		if err := cu.RunFuzzer(); err != nil {
+			t.Fatalf("RunFuzzer() unexpected error: %v", err)
+		}
+
+		got := cu.LastGenerationTimings()
+		if !record {
+			if got != (Timings{}) {
+				t.Errorf("LastGenerationTimings() = %+v without RecordTimings, want all zero", got)
+			}
+			continue
+		}
+		if got.Generation < strategy.delay || got.Encoding != 0 || got.Verification != 0 {
+			t.Errorf("LastGenerationTimings() = %+v, want generation of at least %v and nothing else", got, strategy.delay)
+		}
+	}
+}
+
+// originStrategy is a fakeStrategy that builds an instruction and then
+// fails, keeping every instruction it built.
+type originStrategy struct {
+	fakeStrategy
+	built []*epb.Instruction
+}
+
+func (s *originStrategy) GenerateProgram(ffi *FFI) (*pb.Program, error) {
+	s.next++
+	s.built = append(s.built, ebpf.Mov64(ebpf.R0, 0))
+	return nil, errors.New("generation failed")
+}
+
+func TestRunFuzzerClearsOrigins(t *testing.T) {
+	defer func(saved bool) { ebpf.RecordOrigins = saved }(ebpf.RecordOrigins)
+	defer ebpf.ClearInstructionOrigins()
+	ebpf.RecordOrigins = true
+
+	strategy := &originStrategy{fakeStrategy: fakeStrategy{maxPrograms: 2}}
+	cu := &Control{}
+	if err := cu.Init(&FFI{}, nil, strategy); err != nil {
+		t.Fatalf("Init() unexpected error: %v", err)
+	}
+	if err := cu.RunFuzzer(); err != nil {
+		t.Fatalf("RunFuzzer() unexpected error: %v", err)
+	}
+
+	if origin := ebpf.InstructionOrigin(strategy.built[0]); origin != "" {
+		t.Errorf("InstructionOrigin() of the first program = %q, want it cleared", origin)
+	}
+	if origin := ebpf.InstructionOrigin(strategy.built[1]); origin == "" {
+		t.Errorf("InstructionOrigin() of the last program is empty, want its call site")
+	}
+}
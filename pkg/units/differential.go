@@ -0,0 +1,97 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	epb "buzzer/proto/ebpf_go_proto"
+)
+
+// DifferentialProgram is a program together with the load outcome that is
+// expected on every kernel it gets tested on.
+type DifferentialProgram struct {
+	Program  *epb.Program
+	ProgType uint32
+
+	expectAccepted bool
+}
+
+// NewDifferentialProgram returns a DifferentialProgram that is expected to
+// be accepted.
+func NewDifferentialProgram(program *epb.Program, progType uint32) *DifferentialProgram {
+	return &DifferentialProgram{
+		Program:        program,
+		ProgType:       progType,
+		expectAccepted: true,
+	}
+}
+
+// SetExpectation records whether the program should be accepted by the
+// verifier.
+func (dp *DifferentialProgram) SetExpectation(accepted bool) {
+	dp.expectAccepted = accepted
+}
+
+// DifferentialResult is the outcome of loading a DifferentialProgram on a
+// single kernel.
+type DifferentialResult struct {
+	// Kernel is the name of the Loader used.
+	Kernel string
+
+	ExpectedAccepted bool
+	Accepted         bool
+	VerifierLog      string
+
+	// Err is set if the program could not be submitted to the kernel.
+	Err error
+}
+
+// Matches returns true if the kernel behaved as expected.
+func (dr *DifferentialResult) Matches() bool {
+	return dr.Err == nil && dr.Accepted == dr.ExpectedAccepted
+}
+
+// RunDifferential loads `dp` with every loader in `kernels` and returns the
+// results in the same order.
+func RunDifferential(dp *DifferentialProgram, kernels []Loader) []DifferentialResult {
+	results := []DifferentialResult{}
+	for _, kernel := range kernels {
+		result := DifferentialResult{
+			Kernel:           kernel.Name(),
+			ExpectedAccepted: dp.expectAccepted,
+		}
+		loadResult, err := kernel.Load(dp.Program, dp.ProgType)
+		if err != nil {
+			result.Err = err
+		} else {
+			result.Accepted = loadResult.Accepted()
+			result.VerifierLog = loadResult.VerifierLog
+			kernel.Unload(loadResult)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// DisagreeingKernels returns the name of the kernels that did not behave
+// as expected.
+func DisagreeingKernels(results []DifferentialResult) []string {
+	kernels := []string{}
+	for _, result := range results {
+		if !result.Matches() {
+			kernels = append(kernels, result.Kernel)
+		}
+	}
+	return kernels
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	epb "buzzer/proto/ebpf_go_proto"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type fakeLoader struct {
+	name     string
+	accept   bool
+	err      error
+	unloaded int
+}
+
+func (fl *fakeLoader) Load(program *epb.Program, progType uint32) (*LoadResult, error) {
+	if fl.err != nil {
+		return nil, fl.err
+	}
+	if fl.accept {
+		return &LoadResult{ProgramFd: 3}, nil
+	}
+	return &LoadResult{ProgramFd: -1, VerifierLog: "rejected"}, nil
+}
+
+func (fl *fakeLoader) Unload(result *LoadResult) {
+	fl.unloaded++
+}
+
+func (fl *fakeLoader) TestRun(program *epb.Program, progType uint32, cfg TestRunConfig) (uint32, []byte, error) {
+	return 0, nil, ErrTestRunUnsupported
+}
+
+func (fl *fakeLoader) Name() string {
+	return fl.name
+}
+
+func TestRunDifferential(t *testing.T) {
+	good := &fakeLoader{name: "good", accept: true}
+	bad := &fakeLoader{name: "bad", accept: false}
+	broken := &fakeLoader{name: "broken", err: errors.New("no kernel")}
+
+	dp := NewDifferentialProgram(&epb.Program{}, BpfProgTypeSocketFilter)
+	dp.SetExpectation(true)
+	results := RunDifferential(dp, []Loader{good, bad, broken})
+
+	if len(results) != 3 {
+		t.Fatalf("len(RunDifferential()) = %d, want 3", len(results))
+	}
+	if !results[0].Matches() || results[1].Matches() || results[2].Matches() {
+		t.Errorf("RunDifferential() = %v, want only the first result to match", results)
+	}
+	if results[1].VerifierLog != "rejected" {
+		t.Errorf("results[1].VerifierLog = %q, want %q", results[1].VerifierLog, "rejected")
+	}
+	if good.unloaded != 1 || bad.unloaded != 1 || broken.unloaded != 0 {
+		t.Errorf("unexpected Unload calls: good %d, bad %d, broken %d", good.unloaded, bad.unloaded, broken.unloaded)
+	}
+
+	want := []string{"bad", "broken"}
+	if got := DisagreeingKernels(results); !reflect.DeepEqual(got, want) {
+		t.Errorf("DisagreeingKernels() = %v, want %v", got, want)
+	}
+}
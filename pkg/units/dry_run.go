@@ -0,0 +1,97 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+)
+
+// GenerationReport holds aggregate statistics about the programs generated
+// by a strategy.
+type GenerationReport struct {
+	// Programs is the number of eBPF programs generated, cBPF programs
+	// are only counted in Skipped.
+	Programs int
+
+	// Skipped is the number of programs that were not eBPF.
+	Skipped int
+
+	// Errors is the number of times the strategy failed to generate a
+	// program.
+	Errors int
+
+	// AverageLength is the average number of instructions per program.
+	AverageLength float64
+
+	// ClassDistribution is the total number of instructions of each class
+	// across all programs.
+	ClassDistribution map[epb.InsClass]int
+
+	// PredictedRejections is the number of programs that are certain to
+	// fail validation or are likely to hit the verifier complexity limit.
+	PredictedRejections int
+}
+
+// PredictedRejectionRate returns the fraction of programs that are
+// predicted to be rejected.
+func (r *GenerationReport) PredictedRejectionRate() float64 {
+	if r.Programs == 0 {
+		return 0
+	}
+	return float64(r.PredictedRejections) / float64(r.Programs)
+}
+
+// DryRunGenerate asks `strategy` for `n` programs and reports statistics
+// about them without encoding or loading them. This allows quickly tuning a
+// strategy's parameters.
+//
+// `ffi` is passed down to GenerateProgram, as some strategies need it to
+// create maps.
+func DryRunGenerate(n int, strategy Strategy, ffi *FFI) *GenerationReport {
+	report := &GenerationReport{
+		ClassDistribution: make(map[epb.InsClass]int),
+	}
+	totalLength := 0
+	for i := 0; i < n; i++ {
+		prog, err := strategy.GenerateProgram(ffi)
+		if err != nil {
+			report.Errors++
+			continue
+		}
+		program := prog.GetEbpf()
+		if program == nil {
+			report.Skipped++
+			continue
+		}
+
+		report.Programs++
+		instructions := []*epb.Instruction{}
+		for _, function := range program.Functions {
+			instructions = append(instructions, function.Instructions...)
+		}
+		totalLength += len(instructions)
+		for class, count := range ebpf.ClassHistogram(program) {
+			report.ClassDistribution[class] += count
+		}
+		if ebpf.Validate(instructions) != nil || ebpf.EstimateComplexity(program) > ebpf.VerifierComplexityLimit {
+			report.PredictedRejections++
+		}
+	}
+	if report.Programs != 0 {
+		report.AverageLength = float64(totalLength) / float64(report.Programs)
+	}
+	return report
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+	pb "buzzer/proto/program_go_proto"
+	"errors"
+	"testing"
+)
+
+// fakeStrategy returns the programs in `programs` in order, nil entries
+// are returned as errors.
+type fakeStrategy struct {
+	programs []*pb.Program
+	next     int
+
+	// maxPrograms makes IsFuzzingDone return true once that many
+	// programs were generated, if not zero.
+	maxPrograms int
+}
+
+func (fs *fakeStrategy) GenerateProgram(ffi *FFI) (*pb.Program, error) {
+	prog := fs.programs[fs.next%len(fs.programs)]
+	fs.next++
+	if prog == nil {
+		return nil, errors.New("generation failed")
+	}
+	return prog, nil
+}
+
+func (fs *fakeStrategy) OnVerifyDone(ffi *FFI, verificationResult *fpb.ValidationResult) bool {
+	return true
+}
+
+func (fs *fakeStrategy) OnExecuteDone(ffi *FFI, executionResult *fpb.ExecutionResult) bool {
+	return true
+}
+
+func (fs *fakeStrategy) OnError(e error) bool {
+	return true
+}
+
+func (fs *fakeStrategy) IsFuzzingDone() bool {
+	return fs.maxPrograms != 0 && fs.next >= fs.maxPrograms
+}
+
+func (fs *fakeStrategy) Name() string {
+	return "fake"
+}
+
+func ebpfProgram(instructions ...*epb.Instruction) *pb.Program {
+	return &pb.Program{
+		Program: &pb.Program_Ebpf{
+			Ebpf: &epb.Program{
+				Functions: []*epb.Functions{{Instructions: instructions}},
+			},
+		},
+	}
+}
+
+func TestDryRunGenerate(t *testing.T) {
+	strategy := &fakeStrategy{
+		programs: []*pb.Program{
+			ebpfProgram(ebpf.Mov64(ebpf.R0, 0), ebpf.Exit()),
+			// Writing to R10 is always rejected.
+			ebpfProgram(ebpf.Mov64(ebpf.R10, 0), ebpf.Mov64(ebpf.R0, 0), ebpf.Exit()),
+			nil,
+			{Program: &pb.Program_Cbpf{}},
+		},
+	}
+
+	report := DryRunGenerate(4, strategy, nil)
+	if report.Programs != 2 || report.Errors != 1 || report.Skipped != 1 {
+		t.Errorf("Programs, Errors, Skipped = %d, %d, %d, want 2, 1, 1", report.Programs, report.Errors, report.Skipped)
+	}
+	if report.AverageLength != 2.5 {
+		t.Errorf("AverageLength = %f, want 2.5", report.AverageLength)
+	}
+	if got := report.ClassDistribution[epb.InsClass_InsClassAlu64]; got != 3 {
+		t.Errorf("ClassDistribution[Alu64] = %d, want 3", got)
+	}
+	if got := report.PredictedRejectionRate(); got != 0.5 {
+		t.Errorf("PredictedRejectionRate() = %f, want 0.5", got)
+	}
+}
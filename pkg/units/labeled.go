@@ -0,0 +1,109 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"bufio"
+	"buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// maxLabeledRecordSize bounds the length prefixes LoadLabeledProgram
+// accepts, so a corrupted corpus doesn't make it allocate gigabytes.
+const maxLabeledRecordSize = 1 << 30
+
+// WriteLabeledProgram appends a record with `program` and what the verifier
+// said about it to `w`, so a fuzzing run can be turned into a dataset of
+// programs and verdicts. Records are read back in order with
+// LoadLabeledProgram.
+//
+// A record is the program in the compact encoding (see ebpf.EncodeCompact)
+// followed by a ffi ValidationResult proto, each prefixed with its length
+// as a uvarint. Only the verdict, the verifier log and the error are kept
+// from `result`, the fd and coverage are meaningless outside of this run.
+func WriteLabeledProgram(w io.Writer, program *epb.Program, result *fpb.ValidationResult) error {
+	encoded, err := ebpf.EncodeCompact(program)
+	if err != nil {
+		return err
+	}
+	label, err := proto.Marshal(&fpb.ValidationResult{
+		IsValid:     result.IsValid,
+		VerifierLog: result.VerifierLog,
+		BpfError:    result.BpfError,
+	})
+	if err != nil {
+		return err
+	}
+
+	record := binary.AppendUvarint(nil, uint64(len(encoded)))
+	record = append(record, encoded...)
+	record = binary.AppendUvarint(record, uint64(len(label)))
+	record = append(record, label...)
+	_, err = w.Write(record)
+	return err
+}
+
+// readLabeledField reads one length prefixed field of a labeled record,
+// returning io.EOF only if `r` ends before the field starts.
+func readLabeledField(r *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxLabeledRecordSize {
+		return nil, fmt.Errorf("Labeled record field of %d bytes is too big", size)
+	}
+	field := make([]byte, size)
+	if _, err := io.ReadFull(r, field); err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+	return field, nil
+}
+
+// LoadLabeledProgram reads the next record written by WriteLabeledProgram
+// from `r`, returning the program and its verdict. It returns io.EOF once
+// there are no more records, and io.ErrUnexpectedEOF if the last one is
+// truncated.
+func LoadLabeledProgram(r *bufio.Reader) (*epb.Program, *fpb.ValidationResult, error) {
+	encoded, err := readLabeledField(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	label, err := readLabeledField(r)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	program, err := ebpf.DecodeCompact(encoded)
+	if err != nil {
+		return nil, nil, err
+	}
+	result := &fpb.ValidationResult{}
+	if err := proto.Unmarshal(label, result); err != nil {
+		return nil, nil, err
+	}
+	return program, result, nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"bufio"
+	"buzzer/pkg/ebpf/ebpf"
+	fpb "buzzer/proto/ffi_go_proto"
+	pb "buzzer/proto/program_go_proto"
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestLabeledProgram(t *testing.T) {
+	programs := []*pb.Program{
+		ebpfProgram(ebpf.Mov64(ebpf.R0, 0), ebpf.Exit()),
+		ebpfProgram(ebpf.Mov64(ebpf.R0, int64(1)<<40), ebpf.Exit()),
+	}
+	results := []*fpb.ValidationResult{
+		{IsValid: true, VerifierLog: "processed 2 insns", ProgramFd: 5, CoverageSize: 3},
+		{IsValid: false, VerifierLog: "R0 !read_ok", BpfError: "permission denied"},
+	}
+
+	var corpus bytes.Buffer
+	for index := range programs {
+		if err := WriteLabeledProgram(&corpus, programs[index].GetEbpf(), results[index]); err != nil {
+			t.Fatalf("WriteLabeledProgram() unexpected error: %v", err)
+		}
+	}
+	data := corpus.Bytes()
+
+	r := bufio.NewReader(bytes.NewReader(data))
+	for index := range programs {
+		program, result, err := LoadLabeledProgram(r)
+		if err != nil {
+			t.Fatalf("LoadLabeledProgram() record %d unexpected error: %v", index, err)
+		}
+		if diff := ebpf.ProgramDiff(program, programs[index].GetEbpf()); diff != "" {
+			t.Errorf("LoadLabeledProgram() record %d program mismatch:\n%s", index, diff)
+		}
+		want := &fpb.ValidationResult{
+			IsValid:     results[index].IsValid,
+			VerifierLog: results[index].VerifierLog,
+			BpfError:    results[index].BpfError,
+		}
+		if !proto.Equal(result, want) {
+			t.Errorf("LoadLabeledProgram() record %d result = %v, want %v", index, result, want)
+		}
+	}
+	if _, _, err := LoadLabeledProgram(r); err != io.EOF {
+		t.Errorf("LoadLabeledProgram() after the last record = %v, want %v", err, io.EOF)
+	}
+
+	truncated := bufio.NewReader(bytes.NewReader(data[:len(data)-1]))
+	if _, _, err := LoadLabeledProgram(truncated); err != nil {
+		t.Fatalf("LoadLabeledProgram() of the complete record unexpected error: %v", err)
+	}
+	if _, _, err := LoadLabeledProgram(truncated); err != io.ErrUnexpectedEOF {
+		t.Errorf("LoadLabeledProgram() of a truncated record = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
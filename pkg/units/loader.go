@@ -0,0 +1,122 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+var (
+	// ErrTestRunUnsupported is returned by the TestRun of loaders that
+	// can't run programs.
+	ErrTestRunUnsupported = errors.New("Loader can't test run programs")
+)
+
+const (
+	// BpfProgTypeSocketFilter is BPF_PROG_TYPE_SOCKET_FILTER from
+	// linux/bpf.h
+	BpfProgTypeSocketFilter = 1
+)
+
+// LoadResult holds the outcome of submitting a program to the kernel.
+type LoadResult struct {
+	// ProgramFd is the fd of the loaded program, -1 if it was rejected.
+	ProgramFd int
+
+	// VerifierLog is the log produced by the verifier while checking the
+	// program.
+	VerifierLog string
+
+	// Errno is the error returned by BPF_PROG_LOAD, 0 if the program
+	// was accepted or if the loader can't tell.
+	Errno syscall.Errno
+}
+
+// Accepted returns true if the kernel accepted the program.
+func (lr *LoadResult) Accepted() bool {
+	return lr.ProgramFd >= 0
+}
+
+// Loader abstracts away how programs are submitted to a kernel.
+type Loader interface {
+	// Load submits `program` as a program of type `progType`. A non
+	// nil error means the program could not be submitted at all, a
+	// program rejected by the verifier is not an error.
+	Load(program *epb.Program, progType uint32) (*LoadResult, error)
+
+	// Unload releases the resources held by a previous Load result.
+	Unload(result *LoadResult)
+
+	// TestRun loads `program` as a program of type `progType`, runs it
+	// once as described by `cfg` and unloads it, returning its return
+	// value and output data. Loaders that can't run programs return
+	// ErrTestRunUnsupported.
+	TestRun(program *epb.Program, progType uint32, cfg TestRunConfig) (uint32, []byte, error)
+
+	// Name identifies the kernel behind this loader.
+	Name() string
+}
+
+// Load implements Loader on top of the ffi, which currently only supports
+// socket filter programs.
+func (e *FFI) Load(program *epb.Program, progType uint32) (*LoadResult, error) {
+	if progType != BpfProgTypeSocketFilter {
+		return nil, fmt.Errorf("program type %d is not supported by the ffi loader", progType)
+	}
+
+	encodedProg, encodedFuncInfo, err := ebpf.EncodeInstructions(program)
+	if err != nil {
+		return nil, err
+	}
+	validationResult, err := e.ValidateEbpfProgram(&fpb.EncodedProgram{
+		Program:  encodedProg,
+		Btf:      program.Btf,
+		Function: encodedFuncInfo,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &LoadResult{
+		ProgramFd:   -1,
+		VerifierLog: validationResult.VerifierLog,
+	}
+	if validationResult.IsValid {
+		result.ProgramFd = int(validationResult.ProgramFd)
+	}
+	return result, nil
+}
+
+// Unload closes the program fd held by `result`, if any.
+func (e *FFI) Unload(result *LoadResult) {
+	if result.Accepted() {
+		e.CloseFD(result.ProgramFd)
+	}
+}
+
+// TestRun is not supported by the ffi, it returns ErrTestRunUnsupported.
+func (e *FFI) TestRun(program *epb.Program, progType uint32, cfg TestRunConfig) (uint32, []byte, error) {
+	return 0, nil, ErrTestRunUnsupported
+}
+
+// Name returns the name of the ffi loader.
+func (e *FFI) Name() string {
+	return "ffi"
+}
@@ -0,0 +1,42 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"buzzer/pkg/rand"
+	pb "buzzer/proto/program_go_proto"
+)
+
+// RegenerateFromTrace asks `strategy` for a program while rand.SharedRNG
+// replays `trace`, e.g. one obtained with Control.LastDecisionTrace, which
+// rebuilds the program the trace was recorded for. The strategy should be
+// in the same state it was when the trace was recorded, usually freshly
+// constructed.
+//
+// The program is returned together with a rand.ErrTraceDiverged error if
+// generation didn't make exactly the recorded decisions, which happens when
+// the strategy changed too much since.
+func RegenerateFromTrace(trace rand.DecisionTrace, strategy Strategy, ffi *FFI) (*pb.Program, error) {
+	replay := rand.NewReplayRand(trace)
+	saved := rand.SharedRNG
+	rand.SharedRNG = replay
+	defer func() { rand.SharedRNG = saved }()
+
+	prog, err := strategy.GenerateProgram(ffi)
+	if err != nil {
+		return nil, err
+	}
+	return prog, replay.ReplayError()
+}
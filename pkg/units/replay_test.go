@@ -0,0 +1,120 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/rand"
+	epb "buzzer/proto/ebpf_go_proto"
+	pb "buzzer/proto/program_go_proto"
+	"errors"
+	gorand "math/rand"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// randomStrategy is a fakeStrategy that generates `length` random
+// instructions, or fails after deciding the length if `fail` is set.
+type randomStrategy struct {
+	fakeStrategy
+	length uint64
+	fail   bool
+}
+
+func (rs *randomStrategy) GenerateProgram(ffi *FFI) (*pb.Program, error) {
+	rs.next++
+	length := rand.SharedRNG.RandRange(1, rs.length)
+	if rs.fail {
+		return nil, errors.New("generation failed")
+	}
+	instructions := []*epb.Instruction{}
+	for i := uint64(0); i < length; i++ {
+		if rand.SharedRNG.OneOf(3) {
+			instructions = append(instructions, ebpf.RandomJmpInstruction(1))
+		} else {
+			instructions = append(instructions, ebpf.RandomAluInstruction())
+		}
+	}
+	instructions = append(instructions, ebpf.Mov64(ebpf.R0, int64(rand.SharedRNG.RandInt())), ebpf.Exit())
+	return ebpfProgram(instructions...), nil
+}
+
+func TestRegenerateFromTrace(t *testing.T) {
+	saved := rand.SharedRNG
+	defer func() { rand.SharedRNG = saved }()
+
+	rand.SharedRNG = rand.NewRand(gorand.NewSource(1))
+	rand.SharedRNG.StartRecording()
+	want, err := (&randomStrategy{length: 50}).GenerateProgram(&FFI{})
+	if err != nil {
+		t.Fatalf("GenerateProgram() unexpected error: %v", err)
+	}
+	trace := rand.SharedRNG.StopRecording()
+
+	// A different seed doesn't matter, every decision comes from the trace.
+	rand.SharedRNG = rand.NewRand(gorand.NewSource(2))
+	got, err := RegenerateFromTrace(trace, &randomStrategy{length: 50}, &FFI{})
+	if err != nil {
+		t.Fatalf("RegenerateFromTrace() unexpected error: %v", err)
+	}
+	if !proto.Equal(got, want) {
+		t.Errorf("RegenerateFromTrace() = %v, want %v", got, want)
+	}
+
+	// A strategy that asks for a different range diverges right away.
+	if _, err := RegenerateFromTrace(trace, &randomStrategy{length: 60}, &FFI{}); !errors.Is(err, rand.ErrTraceDiverged) {
+		t.Errorf("RegenerateFromTrace() with a changed strategy = %v, want %v", err, rand.ErrTraceDiverged)
+	}
+	if _, err := RegenerateFromTrace(trace[:len(trace)-1], &randomStrategy{length: 50}, &FFI{}); !errors.Is(err, rand.ErrTraceDiverged) {
+		t.Errorf("RegenerateFromTrace() with a truncated trace = %v, want %v", err, rand.ErrTraceDiverged)
+	}
+	if _, err := RegenerateFromTrace(append(trace, trace[0]), &randomStrategy{length: 50}, &FFI{}); !errors.Is(err, rand.ErrTraceDiverged) {
+		t.Errorf("RegenerateFromTrace() with extra decisions = %v, want %v", err, rand.ErrTraceDiverged)
+	}
+	if rand.SharedRNG.ReplayError() != nil {
+		t.Errorf("RegenerateFromTrace() didn't restore rand.SharedRNG")
+	}
+}
+
+func TestLastDecisionTrace(t *testing.T) {
+	for _, record := range []bool{false, true} {
+		// Generation fails right after deciding the length, so the
+		// program never reaches encoding.
+		strategy := &randomStrategy{
+			fakeStrategy: fakeStrategy{maxPrograms: 1},
+			length:       10,
+			fail:         true,
+		}
+		cu := &Control{RecordDecisions: record}
+		if err := cu.Init(&FFI{}, nil, strategy); err != nil {
+			t.Fatalf("Init() unexpected error: %v", err)
+		}
+		if err := cu.RunFuzzer(); err != nil {
+			t.Fatalf("RunFuzzer() unexpected error: %v", err)
+		}
+
+		got := cu.LastDecisionTrace()
+		if !record {
+			if got != nil {
+				t.Errorf("LastDecisionTrace() = %v without RecordDecisions, want nil", got)
+			}
+			continue
+		}
+		if len(got) != 1 || got[0].Method != "RandRange" || got[0].Begin != 1 || got[0].End != 10 {
+			t.Errorf("LastDecisionTrace() = %v, want a single RandRange(1, 10)", got)
+		}
+	}
+}
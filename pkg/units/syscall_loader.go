@@ -0,0 +1,147 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	epb "buzzer/proto/ebpf_go_proto"
+	"errors"
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+var (
+	// ErrProgramRejected is returned by SyscallLoader.TestRun when the
+	// program doesn't pass the verifier, wrapped with its errno.
+	ErrProgramRejected = errors.New("Program rejected by the verifier")
+)
+
+// SyscallLoader implements Loader by calling BPF_PROG_LOAD directly, unlike
+// the ffi it can load programs of any type.
+type SyscallLoader struct {
+	// License is the license the programs are loaded under, "GPL" if
+	// empty, as a lot of helpers are only available to GPL programs.
+	License string
+
+	// LogSize is the size of the verifier log buffer, no log is requested
+	// if zero.
+	LogSize uint32
+}
+
+// Load submits `program` with the bpf syscall. Programs rejected by the
+// kernel are not an error, their errno is returned in the LoadResult.
+func (sl *SyscallLoader) Load(program *epb.Program, progType uint32) (*LoadResult, error) {
+	license := sl.License
+	if license == "" {
+		license = "GPL"
+	}
+	attr, err := NewProgLoadAttr(program, progType, license, sl.LogSize)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, _, errno := syscall.Syscall(sysBpf, BpfProgLoad, uintptr(attr.Pointer()), attr.Size())
+	runtime.KeepAlive(attr)
+
+	result := &LoadResult{
+		ProgramFd:   int(fd),
+		VerifierLog: attr.VerifierLog(),
+		Errno:       errno,
+	}
+	if errno != 0 {
+		result.ProgramFd = -1
+	}
+	return result, nil
+}
+
+// Unload closes the program fd held by `result`, if any.
+func (sl *SyscallLoader) Unload(result *LoadResult) {
+	if result.Accepted() {
+		syscall.Close(result.ProgramFd)
+	}
+}
+
+// TestRun loads `program` as a program of type `progType`, runs it with
+// BPF_PROG_TEST_RUN as described by `cfg` and unloads it, returning what it
+// returned and the output data. This makes it possible to check what a
+// program does with a controlled input, not just whether it is accepted.
+//
+// Not every program type supports test runs, the kernel returns ENOTSUPP
+// for the ones that don't.
+func (sl *SyscallLoader) TestRun(program *epb.Program, progType uint32, cfg TestRunConfig) (uint32, []byte, error) {
+	result, err := sl.Load(program, progType)
+	if err != nil {
+		return 0, nil, err
+	}
+	if !result.Accepted() {
+		return 0, nil, fmt.Errorf("%w: %v", ErrProgramRejected, result.Errno)
+	}
+	defer sl.Unload(result)
+
+	attr := NewProgTestRunAttr(result.ProgramFd, cfg)
+	_, _, errno := syscall.Syscall(sysBpf, BpfProgTestRun, uintptr(attr.Pointer()), attr.Size())
+	runtime.KeepAlive(attr)
+	if errno != 0 {
+		return 0, nil, fmt.Errorf("BPF_PROG_TEST_RUN failed: %w", errno)
+	}
+	return attr.Retval(), attr.Output(), nil
+}
+
+// Name returns the name of the syscall loader.
+func (sl *SyscallLoader) Name() string {
+	return "syscall"
+}
+
+// MockLoader is a Loader that never talks to a kernel, it returns Result (or
+// Err if set) for every program and remembers what it was asked to do. It
+// is meant for tests of code built on top of Loader.
+type MockLoader struct {
+	LoaderName string
+	Result     LoadResult
+	Err        error
+
+	// Loaded holds every program passed to Load, in order.
+	Loaded []*epb.Program
+
+	// Unloaded is the number of times Unload was called.
+	Unloaded int
+}
+
+// Load records `program` and returns a copy of Result, or Err if set.
+func (ml *MockLoader) Load(program *epb.Program, progType uint32) (*LoadResult, error) {
+	ml.Loaded = append(ml.Loaded, program)
+	if ml.Err != nil {
+		return nil, ml.Err
+	}
+	result := ml.Result
+	return &result, nil
+}
+
+// Unload records the call.
+func (ml *MockLoader) Unload(result *LoadResult) {
+	ml.Unloaded++
+}
+
+// TestRun records `program` like Load does and returns
+// ErrTestRunUnsupported, the mock has no kernel to run it in.
+func (ml *MockLoader) TestRun(program *epb.Program, progType uint32, cfg TestRunConfig) (uint32, []byte, error) {
+	ml.Loaded = append(ml.Loaded, program)
+	return 0, nil, ErrTestRunUnsupported
+}
+
+// Name returns LoaderName.
+func (ml *MockLoader) Name() string {
+	return ml.LoaderName
+}
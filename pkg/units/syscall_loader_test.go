@@ -0,0 +1,66 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	epb "buzzer/proto/ebpf_go_proto"
+	"errors"
+	"syscall"
+	"testing"
+)
+
+// Make sure all the loaders can be used wherever a Loader is expected.
+var _ Loader = &SyscallLoader{}
+var _ Loader = &MockLoader{}
+var _ Loader = &FFI{}
+
+func TestSyscallLoaderRejectsEmptyPrograms(t *testing.T) {
+	sl := &SyscallLoader{}
+	empty := &epb.Program{Functions: []*epb.Functions{{}}}
+	if _, err := sl.Load(empty, BpfProgTypeSocketFilter); err == nil {
+		t.Errorf("Load() with an empty program expected error, got nil")
+	}
+}
+
+func TestMockLoader(t *testing.T) {
+	ml := &MockLoader{
+		LoaderName: "mock",
+		Result:     LoadResult{ProgramFd: -1, VerifierLog: "invalid insn", Errno: syscall.EINVAL},
+	}
+	program := &epb.Program{}
+	results := RunDifferential(NewDifferentialProgram(program, BpfProgTypeSocketFilter), []Loader{ml})
+	if len(results) != 1 || results[0].Accepted || results[0].VerifierLog != "invalid insn" {
+		t.Errorf("RunDifferential() = %+v, want a single rejection with the mock log", results)
+	}
+	if len(ml.Loaded) != 1 || ml.Loaded[0] != program || ml.Unloaded != 1 {
+		t.Errorf("MockLoader recorded %d loads and %d unloads, want 1 and 1", len(ml.Loaded), ml.Unloaded)
+	}
+
+	ml.Err = errors.New("no kernel")
+	if _, err := ml.Load(program, BpfProgTypeSocketFilter); !errors.Is(err, ml.Err) {
+		t.Errorf("Load() error = %v, want %v", err, ml.Err)
+	}
+
+	if _, _, err := ml.TestRun(program, BpfProgTypeSocketFilter, TestRunConfig{}); !errors.Is(err, ErrTestRunUnsupported) {
+		t.Errorf("TestRun() error = %v, want %v", err, ErrTestRunUnsupported)
+	}
+}
+
+func TestFFITestRunUnsupported(t *testing.T) {
+	var loader Loader = &FFI{}
+	if _, _, err := loader.TestRun(&epb.Program{}, BpfProgTypeSocketFilter, TestRunConfig{}); !errors.Is(err, ErrTestRunUnsupported) {
+		t.Errorf("TestRun() error = %v, want %v", err, ErrTestRunUnsupported)
+	}
+}